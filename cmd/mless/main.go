@@ -3,11 +3,13 @@ package main
 import (
 	"flag"
 	"fmt"
-	"io"
 	"os"
 	"path/filepath"
+	"strings"
+	"time"
 
 	tea "github.com/charmbracelet/bubbletea"
+	"github.com/TimelordUK/mless/internal/spool"
 	"github.com/TimelordUK/mless/internal/ui"
 )
 
@@ -27,21 +29,23 @@ func isPiped() bool {
 	return (stat.Mode() & os.ModeCharDevice) == 0
 }
 
-// readStdinToTemp reads stdin to a temporary file and returns its path
-func readStdinToTemp() (string, error) {
-	tmpFile, err := os.CreateTemp("", "mless-stdin-*.log")
-	if err != nil {
-		return "", fmt.Errorf("failed to create temp file: %w", err)
+// parseDateFlag reads a --date value, "YYYY-MM-DD" for every file or
+// "file=YYYY-MM-DD" for one, into dates (keyed by absolute path, "" for every
+// file)
+func parseDateFlag(s string, dates map[string]time.Time) error {
+	file, value := "", s
+	if i := strings.LastIndex(s, "="); i >= 0 {
+		file, value = s[:i], s[i+1:]
+		if abs, err := filepath.Abs(file); err == nil {
+			file = abs
+		}
 	}
-	defer tmpFile.Close()
-
-	_, err = io.Copy(tmpFile, os.Stdin)
+	d, err := time.ParseInLocation("2006-01-02", value, time.Local)
 	if err != nil {
-		os.Remove(tmpFile.Name())
-		return "", fmt.Errorf("failed to read stdin: %w", err)
+		return fmt.Errorf("want YYYY-MM-DD or file=YYYY-MM-DD: %q", s)
 	}
-
-	return tmpFile.Name(), nil
+	dates[file] = d
+	return nil
 }
 
 func main() {
@@ -51,14 +55,26 @@ func main() {
 	sliceFlag := flag.String("S", "", "Slice range (e.g., 1000-5000, 100-$, .-500)")
 	timeFlag := flag.String("t", "", "Go to time (e.g., 14:00, 14:30:00)")
 	consolidateFlag := flag.Bool("C", false, "Consolidate multiple files into single view")
+	cmdFlag := flag.String("cmd", "", "Run a shell command and page its output live")
+	restartFlag := flag.Bool("restart", false, "With --cmd, re-run the command whenever it exits")
+	noIndexCacheFlag := flag.Bool("no-index-cache", false, "Don't read or write the on-disk line index cache")
+	baseDates := make(map[string]time.Time)
+	flag.Func("date", "Date of a file's first line, for timestamps without one ([file=]YYYY-MM-DD)", func(s string) error {
+		return parseDateFlag(s, baseDates)
+	})
 	flag.Usage = func() {
-		fmt.Fprintf(os.Stderr, "Usage: mless [-c] [-C] [-S range] [-t time] [file...]\n")
+		fmt.Fprintf(os.Stderr, "Usage: mless [-c] [-C] [-S range] [-t time] [--date [file=]date] [file...]\n")
 		fmt.Fprintf(os.Stderr, "       command | mless [-S range] [-t time]\n")
+		fmt.Fprintf(os.Stderr, "       mless --cmd 'command' [--restart]\n")
 		fmt.Fprintf(os.Stderr, "  -v\tPrint version and exit\n")
 		fmt.Fprintf(os.Stderr, "  -c\tCache file locally (useful for network files)\n")
 		fmt.Fprintf(os.Stderr, "  -C\tConsolidate multiple files into single view\n")
 		fmt.Fprintf(os.Stderr, "  -S\tSlice range (e.g., 1000-5000, 100-$)\n")
 		fmt.Fprintf(os.Stderr, "  -t\tGo to time (e.g., 14:00, 14:30:00)\n")
+		fmt.Fprintf(os.Stderr, "  --cmd\tRun a shell command and page its output live\n")
+		fmt.Fprintf(os.Stderr, "  --restart\tWith --cmd, re-run the command whenever it exits\n")
+		fmt.Fprintf(os.Stderr, "  --no-index-cache\tDon't read or write the on-disk line index cache\n")
+		fmt.Fprintf(os.Stderr, "  --date\tDate of the first line, for time-only timestamps (YYYY-MM-DD or file=YYYY-MM-DD)\n")
 		fmt.Fprintf(os.Stderr, "\nMultiple files: split view (max 2) or consolidated (-C)\n")
 	}
 	flag.Parse()
@@ -69,9 +85,12 @@ func main() {
 	}
 
 	var filePaths []string
-	var stdinTempFile string
+	var stdin *spool.Spool
 
-	if flag.NArg() >= 1 {
+	switch {
+	case *cmdFlag != "":
+		// Command output: no files or stdin needed
+	case flag.NArg() >= 1:
 		// Get absolute paths for all files
 		// Consolidated mode: no limit; split view: max 2 files
 		maxFiles := 2
@@ -86,18 +105,15 @@ func main() {
 			}
 			filePaths = append(filePaths, filePath)
 		}
-	} else if isPiped() {
-		// Read from stdin
+	case isPiped():
+		// Spool stdin in the background; the model owns and removes it
 		var err error
-		stdinTempFile, err = readStdinToTemp()
+		stdin, err = spool.New(os.Stdin)
 		if err != nil {
 			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
 			os.Exit(1)
 		}
-		filePaths = []string{stdinTempFile}
-		// Clean up temp file on exit
-		defer os.Remove(stdinTempFile)
-	} else {
+	default:
 		flag.Usage()
 		os.Exit(1)
 	}
@@ -113,10 +129,18 @@ func main() {
 		SliceRange:       *sliceFlag,
 		GotoTime:         *timeFlag,
 		ConsolidatePaths: consolidatePaths,
+		Stdin:            stdin,
+		Command:          *cmdFlag,
+		RestartCommand:   *restartFlag,
+		NoIndexCache:     *noIndexCacheFlag,
+		BaseDates:        baseDates,
 	}
 
 	model, err := ui.NewModelWithOptions(opts)
 	if err != nil {
+		if stdin != nil {
+			stdin.Close()
+		}
 		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
 		os.Exit(1)
 	}
@@ -7,7 +7,9 @@ require (
 	github.com/charmbracelet/bubbles v0.21.0
 	github.com/charmbracelet/bubbletea v1.3.10
 	github.com/charmbracelet/lipgloss v1.1.0
+	github.com/klauspost/compress v1.18.0
 	github.com/pelletier/go-toml/v2 v2.2.4
+	github.com/ulikunitz/xz v0.5.9
 	golang.org/x/exp v0.0.0-20251113190631-e25ba8c21ef6
 )
 
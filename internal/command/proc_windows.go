@@ -0,0 +1,20 @@
+//go:build windows
+
+package command
+
+import "os/exec"
+
+// shellCommand runs command through cmd.exe, so pipes and quoting work as typed
+func shellCommand(command string) *exec.Cmd {
+	return exec.Command("cmd", "/C", command)
+}
+
+// setProcessGroup is a no-op on Windows
+func setProcessGroup(cmd *exec.Cmd) {}
+
+// killProcess kills the command (child processes are not tracked on Windows)
+func killProcess(cmd *exec.Cmd) {
+	if cmd.Process != nil {
+		cmd.Process.Kill()
+	}
+}
@@ -0,0 +1,238 @@
+package command
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/TimelordUK/mless/internal/source"
+)
+
+// restartDelay is how long a restarting command waits after exiting, so one
+// that fails immediately doesn't spin
+const restartDelay = time.Second
+
+// Runner runs a shell command and writes its stdout and stderr, a line at a
+// time, into a temp file that a pane opens and follows like any other log.
+// Lines keep a record of which stream they came from so stderr can be tagged.
+type Runner struct {
+	command    string
+	restart    bool
+	outputPath string
+	output     *os.File
+
+	stdout *source.SourceInfo
+	stderr *source.SourceInfo
+
+	mu          sync.Mutex
+	lines       int   // complete lines written so far
+	stderrLines []int // line numbers (ascending) that came from stderr
+	running     bool
+	runs        int
+	lastErr     error // how the last run ended (nil = exit status 0)
+
+	ctx       context.Context
+	cancel    context.CancelFunc
+	wg        sync.WaitGroup
+	closeOnce sync.Once
+}
+
+// NewRunner creates the output file for command. Call Start to run it.
+// With restart set the command is run again each time it exits.
+func NewRunner(command string, restart bool) (*Runner, error) {
+	output, err := os.CreateTemp("", "mless-cmd-*.log")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create output file: %w", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Runner{
+		command:    command,
+		restart:    restart,
+		outputPath: output.Name(),
+		output:     output,
+		stdout:     &source.SourceInfo{Path: "stdout", Index: 0},
+		stderr:     &source.SourceInfo{Path: "stderr", Index: 1},
+		ctx:        ctx,
+		cancel:     cancel,
+	}, nil
+}
+
+// Start runs the command in the background
+func (r *Runner) Start() {
+	r.wg.Add(1)
+	go r.loop()
+}
+
+// loop runs the command, and keeps re-running it if restart is set
+func (r *Runner) loop() {
+	defer r.wg.Done()
+
+	for {
+		err := r.runOnce()
+
+		r.mu.Lock()
+		r.running = false
+		r.lastErr = err
+		r.mu.Unlock()
+
+		if !r.restart {
+			return
+		}
+		select {
+		case <-r.ctx.Done():
+			return
+		case <-time.After(restartDelay):
+		}
+	}
+}
+
+// runOnce starts the process and copies its output until both streams close
+func (r *Runner) runOnce() error {
+	if r.ctx.Err() != nil {
+		return r.ctx.Err()
+	}
+
+	cmd := shellCommand(r.command)
+	setProcessGroup(cmd)
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	stderr, err := cmd.StderrPipe()
+	if err != nil {
+		return err
+	}
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	r.running = true
+	r.runs++
+	r.mu.Unlock()
+
+	// Kill the whole process group on Close, so pipelines and shells that
+	// fork (sh -c 'a | b') don't outlive the pane. Anything that left the
+	// group (setsid, a daemon) may still hold the pipes open, so stop
+	// reading them too rather than wait for it to let go.
+	stop := make(chan struct{})
+	go func() {
+		select {
+		case <-r.ctx.Done():
+			killProcess(cmd)
+			stdout.Close()
+			stderr.Close()
+		case <-stop:
+		}
+	}()
+
+	var copies sync.WaitGroup
+	copies.Add(2)
+	go func() {
+		defer copies.Done()
+		r.copyLines(stdout, false)
+	}()
+	go func() {
+		defer copies.Done()
+		r.copyLines(stderr, true)
+	}()
+
+	// Both pipes must be drained before Wait closes them
+	copies.Wait()
+	err = cmd.Wait()
+	close(stop)
+	return err
+}
+
+// copyLines writes whole lines from one stream to the output file
+func (r *Runner) copyLines(rd io.Reader, isStderr bool) {
+	br := bufio.NewReaderSize(rd, 64*1024)
+	for {
+		line, err := br.ReadBytes('\n')
+		if len(line) > 0 {
+			if line[len(line)-1] != '\n' {
+				line = append(line, '\n')
+			}
+			r.writeLine(line, isStderr)
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+// writeLine appends one line and records where it came from. Whole lines go
+// in under the lock so stdout and stderr never interleave mid-line.
+func (r *Runner) writeLine(line []byte, isStderr bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, err := r.output.Write(line); err != nil {
+		return
+	}
+	if isStderr {
+		r.stderrLines = append(r.stderrLines, r.lines)
+	}
+	r.lines++
+}
+
+// LineSource reports which stream line idx of the output came from, for
+// source.FileSource.SetLineSource
+func (r *Runner) LineSource(idx int) *source.SourceInfo {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	i := sort.SearchInts(r.stderrLines, idx)
+	if i < len(r.stderrLines) && r.stderrLines[i] == idx {
+		return r.stderr
+	}
+	return r.stdout
+}
+
+// Status describes the process for the status bar: running, restarting, or
+// how it exited
+func (r *Runner) Status() string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	switch {
+	case r.running:
+		return "running"
+	case r.runs == 0 && r.lastErr == nil:
+		return "starting"
+	case r.restart && r.ctx.Err() == nil:
+		return fmt.Sprintf("restarting (%d runs)", r.runs)
+	case r.lastErr != nil:
+		return fmt.Sprintf("exited: %v", r.lastErr)
+	default:
+		return "exited"
+	}
+}
+
+// Command returns the command line being run
+func (r *Runner) Command() string {
+	return r.command
+}
+
+// OutputPath returns the path of the output file
+func (r *Runner) OutputPath() string {
+	return r.outputPath
+}
+
+// Close kills the process (if still running), waits for it, and removes the
+// output file. Safe to call more than once.
+func (r *Runner) Close() error {
+	r.closeOnce.Do(func() {
+		r.cancel()
+		r.wg.Wait()
+		r.output.Close()
+		os.Remove(r.outputPath)
+	})
+	return nil
+}
@@ -0,0 +1,128 @@
+//go:build !windows
+
+package command
+
+import (
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// waitFor polls cond until it holds or the test times out.
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func startRunner(t *testing.T, cmdline string, restart bool) *Runner {
+	t.Helper()
+	r, err := NewRunner(cmdline, restart)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.Start()
+	t.Cleanup(func() { r.Close() })
+	return r
+}
+
+func outputLines(t *testing.T, r *Runner) []string {
+	t.Helper()
+	b, err := os.ReadFile(r.OutputPath())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return strings.Split(strings.TrimSuffix(string(b), "\n"), "\n")
+}
+
+// TestRunnerTagsStderr checks stdout and stderr both land in the output and
+// that LineSource tells them apart.
+func TestRunnerTagsStderr(t *testing.T) {
+	r := startRunner(t, "echo out1; echo err1 >&2; sleep 0.1; echo out2; printf partial", false)
+	waitFor(t, "exit", func() bool { return r.Status() == "exited" })
+
+	lines := outputLines(t, r)
+	if len(lines) != 4 {
+		t.Fatalf("expected 4 lines, got %q", lines)
+	}
+	for i, line := range lines {
+		want := "stdout"
+		if line == "err1" {
+			want = "stderr"
+		}
+		if got := r.LineSource(i).Path; got != want {
+			t.Errorf("line %d %q tagged %s, want %s", i, line, got, want)
+		}
+	}
+	if lines[3] != "partial" {
+		t.Errorf("unterminated last line should be kept, got %q", lines[3])
+	}
+}
+
+func TestRunnerReportsExitStatus(t *testing.T) {
+	r := startRunner(t, "exit 3", false)
+	waitFor(t, "exit", func() bool { return strings.HasPrefix(r.Status(), "exited") })
+	if !strings.Contains(r.Status(), "3") {
+		t.Fatalf("status should carry the exit code, got %q", r.Status())
+	}
+}
+
+func TestRunnerRestarts(t *testing.T) {
+	r := startRunner(t, "echo tick", true)
+	waitFor(t, "second run", func() bool { return len(outputLines(t, r)) >= 2 })
+}
+
+// TestRunnerCloseKillsProcess verifies Close doesn't hang on a command that
+// would run forever, and removes the output file.
+func TestRunnerCloseKillsProcess(t *testing.T) {
+	r := startRunner(t, "while true; do echo line; sleep 0.05; done", false)
+	waitFor(t, "output", func() bool { return r.Status() == "running" && len(outputLines(t, r)) > 1 })
+
+	done := make(chan struct{})
+	go func() {
+		r.Close()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Close did not kill the command")
+	}
+	if _, err := os.Stat(r.OutputPath()); !os.IsNotExist(err) {
+		t.Fatalf("output file not removed: %v", err)
+	}
+}
+
+// TestRunnerCloseWithEscapedChild closes a command that left a child behind
+// in a session of its own, out of reach of the kill but holding the output
+// pipe open. Close mustn't wait for it.
+func TestRunnerCloseWithEscapedChild(t *testing.T) {
+	if _, err := exec.LookPath("setsid"); err != nil {
+		t.Skip("no setsid")
+	}
+	r := startRunner(t, "setsid sleep 30 & echo $!; sleep 30", false)
+	waitFor(t, "output", func() bool { return len(outputLines(t, r)) == 1 && outputLines(t, r)[0] != "" })
+	if pid, err := strconv.Atoi(outputLines(t, r)[0]); err == nil {
+		t.Cleanup(func() { syscall.Kill(pid, syscall.SIGKILL) })
+	}
+
+	done := make(chan struct{})
+	go func() {
+		r.Close()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Close waited for a child holding the output pipe")
+	}
+}
@@ -1,8 +1,12 @@
 package config
 
 import (
+	"fmt"
 	"os"
 	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
 
 	"github.com/pelletier/go-toml/v2"
 )
@@ -13,6 +17,15 @@ type Config struct {
 	LogLevels   LogLevelConfig    `toml:"log_levels"`
 	Keybindings KeybindingConfig  `toml:"keybindings"`
 	Display     DisplayConfig     `toml:"display"`
+	IndexCache  IndexCacheConfig  `toml:"index_cache"`
+	JSON        JSONConfig        `toml:"json"`
+	Logfmt      LogfmtConfig      `toml:"logfmt"`
+	Formats     []FormatConfig    `toml:"formats"`
+	Levels      []LevelConfig     `toml:"levels"`
+
+	// Dates given with --date that files' time-only and year-less timestamps
+	// are dated from, by absolute path ("" for every file)
+	BaseDates   map[string]time.Time `toml:"-"`
 }
 
 // ThemeConfig defines color schemes
@@ -64,6 +77,55 @@ type DisplayConfig struct {
 	ShowLineNumbers bool `toml:"show_line_numbers"`
 	TabWidth        int  `toml:"tab_width"`
 	WrapLines       bool `toml:"wrap_lines"`
+	Records         bool `toml:"records"` // Group continuation lines with the line they follow
+}
+
+// IndexCacheConfig controls the on-disk cache of line indexes for large files
+type IndexCacheConfig struct {
+	Enabled   bool `toml:"enabled"`
+	MaxSizeMB int  `toml:"max_size_mb"` // Oldest entries are evicted beyond this
+}
+
+// JSONConfig names the fields structured logs (one JSON object per line) keep
+// their level, time, message and logger in. The first key present is used.
+type JSONConfig struct {
+	LevelKeys   []string `toml:"level_keys"`
+	TimeKeys    []string `toml:"time_keys"`
+	MessageKeys []string `toml:"message_keys"`
+	LoggerKeys  []string `toml:"logger_keys"`
+	Formatted   bool     `toml:"formatted"` // Show "time LEVEL msg key=val" instead of the raw object (J toggles)
+}
+
+// LogfmtConfig names the fields logfmt lines (key=value pairs) keep their
+// level, time, message and logger in. The first key present is used.
+type LogfmtConfig struct {
+	LevelKeys   []string `toml:"level_keys"`
+	TimeKeys    []string `toml:"time_keys"`
+	MessageKeys []string `toml:"message_keys"`
+	LoggerKeys  []string `toml:"logger_keys"`
+}
+
+// LevelConfig defines a log level beyond the built-in six (trace, debug,
+// info, warn, error, fatal), or changes one of them when named after it
+type LevelConfig struct {
+	Name     string   `toml:"name"`
+	Short    string   `toml:"short"`    // Label in the status bar ("" = first three letters of the name)
+	Rank     int      `toml:"rank"`     // Severity: trace 10, debug 20, info 30, warn 40, error 50, fatal 60
+	Color    string   `toml:"color"`
+	Patterns []string `toml:"patterns"` // Words, as in [log_levels]
+	Regexes  []string `toml:"regexes"`  // Regexes on the start of a line, for positional rules like klog's ^I\d{4}
+	Values   []string `toml:"values"`   // JSON/logfmt level field values (the name is one)
+	Number   int      `toml:"number"`   // Lowest numeric level value that is this level, as pino/bunyan number them
+}
+
+// FormatConfig describes a log format the built-in patterns don't know, as a
+// regex whose named groups pick out the parts of a line. The profile that
+// matches most of a file's first lines is used for it.
+type FormatConfig struct {
+	Name       string            `toml:"name"`
+	Pattern    string            `toml:"pattern"`     // Groups: timestamp, level, logger, thread, message
+	TimeLayout string            `toml:"time_layout"` // Go layout of the timestamp group ("" = any known format)
+	Levels     map[string]string `toml:"levels"`      // Level group values to level names, e.g. I = "info"
 }
 
 // DefaultConfig returns a config with sensible defaults
@@ -108,6 +170,24 @@ func DefaultConfig() *Config {
 			ShowLineNumbers: true,
 			TabWidth:        4,
 			WrapLines:       false,
+			Records:         false,
+		},
+		IndexCache: IndexCacheConfig{
+			Enabled:   true,
+			MaxSizeMB: 512,
+		},
+		JSON: JSONConfig{
+			LevelKeys:   []string{"level", "lvl", "severity", "loglevel", "log.level"},
+			TimeKeys:    []string{"ts", "time", "timestamp", "@timestamp", "datetime"},
+			MessageKeys: []string{"msg", "message", "@message", "log"},
+			LoggerKeys:  []string{"logger", "logger_name", "name", "component"},
+			Formatted:   false,
+		},
+		Logfmt: LogfmtConfig{
+			LevelKeys:   []string{"level", "lvl", "severity", "loglevel"},
+			TimeKeys:    []string{"ts", "time", "timestamp", "t"},
+			MessageKeys: []string{"msg", "message"},
+			LoggerKeys:  []string{"logger", "component", "module"},
 		},
 	}
 }
@@ -134,6 +214,25 @@ func Load() (*Config, error) {
 		return nil, err
 	}
 
+	for _, f := range cfg.Formats {
+		if _, err := regexp.Compile(f.Pattern); err != nil {
+			return nil, fmt.Errorf("%s: format %q: %w", configPath, f.Name, err)
+		}
+	}
+	for _, l := range cfg.Levels {
+		if l.Name == "" {
+			return nil, fmt.Errorf("%s: level without a name", configPath)
+		}
+		if l.Rank <= 0 && !isBuiltinLevel(l.Name) {
+			return nil, fmt.Errorf("%s: level %q: needs a rank above 0", configPath, l.Name)
+		}
+		for _, expr := range l.Regexes {
+			if _, err := regexp.Compile(expr); err != nil {
+				return nil, fmt.Errorf("%s: level %q: %w", configPath, l.Name, err)
+			}
+		}
+	}
+
 	return cfg, nil
 }
 
@@ -158,6 +257,15 @@ func Save(cfg *Config) error {
 	return os.WriteFile(configPath, data, 0644)
 }
 
+// isBuiltinLevel reports whether name is one of the built-in levels
+func isBuiltinLevel(name string) bool {
+	switch strings.ToLower(name) {
+	case "trace", "debug", "info", "warn", "error", "fatal":
+		return true
+	}
+	return false
+}
+
 // getConfigPath returns the config file path
 func getConfigPath() string {
 	// Check XDG_CONFIG_HOME first
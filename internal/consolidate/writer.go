@@ -71,6 +71,17 @@ func NewWriterWithPrime(paths []string, primeLines int) (*Writer, error) {
 			return nil, fmt.Errorf("failed to open source %s: %w", path, err)
 		}
 
+		// Priming and tailing both work from the end of the file
+		if err := src.WaitIndexed(context.Background()); err != nil {
+			src.Close()
+			for _, sw := range sources {
+				sw.source.Close()
+			}
+			output.Close()
+			os.Remove(outputPath)
+			return nil, fmt.Errorf("failed to index source %s: %w", path, err)
+		}
+
 		sources = append(sources, &SourceWatcher{
 			source:   src,
 			name:     filepath.Base(path),
@@ -164,12 +175,17 @@ func (w *Writer) poll() {
 		}
 
 		// Check for new lines
-		newLines, err := sw.source.Refresh()
+		result, err := sw.source.Refresh()
 		if err != nil {
 			continue
 		}
 
-		if newLines > 0 {
+		// A rotated source starts over from its first line
+		if result.Rotated {
+			sw.position = 0
+		}
+
+		if result.NewLines > 0 {
 			w.writeNewLines(sw)
 		}
 	}
@@ -0,0 +1,476 @@
+package index
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"hash/crc32"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	mlessio "github.com/TimelordUK/mless/internal/io"
+	"github.com/TimelordUK/mless/pkg/logformat"
+)
+
+// fingerprintLen is how much of each end of the indexed content is hashed to
+// check a cache entry still describes the file
+const fingerprintLen = 4096
+
+const (
+	cacheMagic   = "MLIX"
+	cacheVersion = 3
+	cacheExt     = ".idx"
+)
+
+// Cache persists line indexes between runs, one file per indexed path, so
+// reopening a large log skips the newline scan. Entries are keyed by path and
+// validated against the file's size, mtime, inode and a fingerprint of the
+// indexed content; an entry for a file that has since grown is reused for the
+// prefix it covers. The least recently used entries are evicted to keep the
+// cache under its size limit.
+type Cache struct {
+	dir      string
+	maxBytes int64
+	minSize  int64  // files smaller than this are cheap to scan and aren't cached
+	skipDir  string // files under here (stdin spools, slices, -c copies) aren't cached
+}
+
+// DefaultCacheDir returns the index cache directory inside the user's cache
+// directory ($XDG_CACHE_HOME/mless/index on Linux)
+func DefaultCacheDir() (string, error) {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "mless", "index"), nil
+}
+
+// NewCache returns a cache stored in dir and bounded to maxBytes
+func NewCache(dir string, maxBytes int64) *Cache {
+	return &Cache{
+		dir:      dir,
+		maxBytes: maxBytes,
+		minSize:  asyncThreshold,
+		skipDir:  os.TempDir(),
+	}
+}
+
+// cacheEntry is the persisted form of a complete LineIndex
+type cacheEntry struct {
+	path       string
+	fileSize   int64 // size on disk (the compressed size for compressed files)
+	mtime      int64 // modification time in unix nanoseconds
+	dev, ino   uint64
+	dataSize   int64 // bytes indexed (the decompressed size for compressed files)
+	head, tail [sha256.Size]byte
+	levelKey   string
+	day        string // base date the samples' timestamps were dated from (see load)
+	offsets    *lineOffsets
+	levels     []uint8 // one per line, or none if levels weren't detected
+	samples    []Sample
+}
+
+// cacheable reports whether indexes of path are worth keeping
+func (c *Cache) cacheable(path string, size int64) bool {
+	if size < c.minSize {
+		return false
+	}
+	rel, err := filepath.Rel(c.skipDir, path)
+	return err != nil || strings.HasPrefix(rel, "..")
+}
+
+// entryPath returns the cache file for an (absolute) indexed path
+func (c *Cache) entryPath(path string) string {
+	sum := sha256.Sum256([]byte(path))
+	return filepath.Join(c.dir, hex.EncodeToString(sum[:16])+cacheExt)
+}
+
+// fingerprint hashes the first and last fingerprintLen bytes of file's first
+// size bytes
+func fingerprint(file mlessio.File, size int64) (head, tail [sha256.Size]byte, err error) {
+	n := int64(fingerprintLen)
+	if n > size {
+		n = size
+	}
+	b, err := file.ReadRange(0, n)
+	if err != nil {
+		return head, tail, err
+	}
+	head = sha256.Sum256(b)
+	if b, err = file.ReadRange(size-n, size); err != nil {
+		return head, tail, err
+	}
+	return head, sha256.Sum256(b), nil
+}
+
+// load returns the cache entry for file if it still describes the file or a
+// prefix of it, or nil. An entry whose levels were detected with other
+// patterns is no use. Samples dated from another base date (day, which
+// timestamps without a date of their own are given) are dropped so they get
+// taken again.
+func (c *Cache) load(file mlessio.File, levelKey, day string) *cacheEntry {
+	if c == nil {
+		return nil
+	}
+	path, err := filepath.Abs(file.Path())
+	if err != nil || !c.cacheable(path, file.Size()) {
+		return nil
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil
+	}
+	entryPath := c.entryPath(path)
+	data, err := os.ReadFile(entryPath)
+	if err != nil {
+		return nil
+	}
+	entry, err := decodeEntry(data)
+	if err != nil || entry.path != path {
+		return nil
+	}
+
+	dev, ino := fileID(info)
+	if entry.dev != dev || entry.ino != ino || entry.dataSize > file.Size() {
+		return nil
+	}
+	unchanged := entry.dataSize == file.Size() &&
+		entry.fileSize == info.Size() && entry.mtime == info.ModTime().UnixNano()
+	if !unchanged && entry.dataSize == file.Size() {
+		// Same length but touched since: could have been rewritten in place
+		return nil
+	}
+	head, tail, err := fingerprint(file, entry.dataSize)
+	if err != nil || head != entry.head || tail != entry.tail {
+		return nil
+	}
+
+	if entry.levelKey != levelKey {
+		return nil
+	}
+	if entry.day != day {
+		entry.samples = nil
+	}
+
+	// Mark as recently used for eviction
+	now := time.Now()
+	os.Chtimes(entryPath, now, now)
+	return entry
+}
+
+// cacheEntry returns an entry describing the first size bytes of the file, or
+// nil if there is no cache, the file isn't cacheable, or the cache already
+// has it. The caller holds saveMu.
+func (idx *LineIndex) cacheEntry(size int64) *cacheEntry {
+	c := idx.opts.Cache
+	if c == nil {
+		return nil
+	}
+	idx.mu.RLock()
+	offsets, levels, samples := idx.offsets.snapshot(), slices.Clip(idx.levels), idx.samples
+	idx.mu.RUnlock()
+	if size == idx.savedSize && len(samples) == idx.savedSamples {
+		return nil
+	}
+
+	path, err := filepath.Abs(idx.file.Path())
+	if err != nil || !c.cacheable(path, size) {
+		return nil
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil
+	}
+	head, tail, err := fingerprint(idx.file, size)
+	if err != nil {
+		return nil
+	}
+
+	idx.savedSize = size
+	idx.savedSamples = len(samples)
+
+	dev, ino := fileID(info)
+	return &cacheEntry{
+		path:     path,
+		fileSize: info.Size(),
+		mtime:    info.ModTime().UnixNano(),
+		dev:      dev,
+		ino:      ino,
+		dataSize: size,
+		head:     head,
+		tail:     tail,
+		levelKey: idx.opts.LevelKey,
+		day:      idx.baseDay(),
+		offsets:  offsets,
+		levels:   levels,
+		samples:  samples,
+	}
+}
+
+// save writes entry to the cache, then evicts old entries to bring the cache
+// back under its size limit. Errors are ignored: the cache is only an
+// optimisation.
+func (c *Cache) save(entry *cacheEntry) {
+	data := encodeEntry(entry)
+	if int64(len(data)) > c.maxBytes {
+		return
+	}
+	if err := os.MkdirAll(c.dir, 0o755); err != nil {
+		return
+	}
+
+	// Write to a temp file and rename, so readers never see a partial entry
+	tmp, err := os.CreateTemp(c.dir, "*.tmp")
+	if err != nil {
+		return
+	}
+	_, err = tmp.Write(data)
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), c.entryPath(entry.path))
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return
+	}
+
+	c.evict()
+}
+
+// evict removes the least recently used entries until the cache fits in
+// maxBytes
+func (c *Cache) evict() {
+	dirEntries, err := os.ReadDir(c.dir)
+	if err != nil {
+		return
+	}
+	var infos []os.FileInfo
+	var total int64
+	for _, e := range dirEntries {
+		if !strings.HasSuffix(e.Name(), cacheExt) {
+			continue
+		}
+		info, err := e.Info()
+		if err != nil {
+			continue
+		}
+		infos = append(infos, info)
+		total += info.Size()
+	}
+
+	sort.Slice(infos, func(i, j int) bool {
+		return infos[i].ModTime().Before(infos[j].ModTime())
+	})
+	for _, info := range infos {
+		if total <= c.maxBytes {
+			return
+		}
+		if os.Remove(filepath.Join(c.dir, info.Name())) == nil {
+			total -= info.Size()
+		}
+	}
+}
+
+// baseDay identifies the base date timestamps are dated from
+func (idx *LineIndex) baseDay() string {
+	return idx.tsParser.BaseDate().Format("2006-01-02")
+}
+
+// Entry layout: magic, version, header fields, offsets as varint deltas,
+// levels as runs, samples, then a CRC-32 of everything before it.
+func encodeEntry(e *cacheEntry) []byte {
+	b := make([]byte, 0, 256+e.offsets.len()*2+len(e.samples)*10)
+	b = append(b, cacheMagic...)
+	b = binary.AppendUvarint(b, cacheVersion)
+	b = appendString(b, e.path)
+	b = binary.AppendVarint(b, e.fileSize)
+	b = binary.AppendVarint(b, e.mtime)
+	b = binary.AppendUvarint(b, e.dev)
+	b = binary.AppendUvarint(b, e.ino)
+	b = binary.AppendVarint(b, e.dataSize)
+	b = append(b, e.head[:]...)
+	b = append(b, e.tail[:]...)
+	b = appendString(b, e.levelKey)
+	b = appendString(b, e.day)
+
+	b = binary.AppendUvarint(b, uint64(e.offsets.len()))
+	var prev int64
+	e.offsets.forEach(func(off int64) {
+		b = binary.AppendUvarint(b, uint64(off-prev))
+		prev = off
+	})
+
+	// Levels come in long runs: store each as the level and its length
+	var runs int
+	for i := range e.levels {
+		if i == 0 || e.levels[i] != e.levels[i-1] {
+			runs++
+		}
+	}
+	b = binary.AppendUvarint(b, uint64(runs))
+	for i := 0; i < len(e.levels); {
+		j := i + 1
+		for j < len(e.levels) && e.levels[j] == e.levels[i] {
+			j++
+		}
+		b = append(b, e.levels[i])
+		b = binary.AppendUvarint(b, uint64(j-i))
+		i = j
+	}
+
+	b = binary.AppendUvarint(b, uint64(len(e.samples)))
+	for _, s := range e.samples {
+		b = append(b, byte(s.Level))
+		if s.Timestamp == nil {
+			b = append(b, 0)
+			continue
+		}
+		b = append(b, 1)
+		b = binary.AppendVarint(b, s.Timestamp.UnixNano())
+		b = binary.AppendUvarint(b, uint64(s.TimeLine-s.Line))
+	}
+
+	return binary.LittleEndian.AppendUint32(b, crc32.ChecksumIEEE(b))
+}
+
+func appendString(b []byte, s string) []byte {
+	b = binary.AppendUvarint(b, uint64(len(s)))
+	return append(b, s...)
+}
+
+var errBadEntry = errors.New("corrupt index cache entry")
+
+func decodeEntry(data []byte) (*cacheEntry, error) {
+	if len(data) < len(cacheMagic)+4 || !bytes.HasPrefix(data, []byte(cacheMagic)) {
+		return nil, errBadEntry
+	}
+	body := data[:len(data)-4]
+	if crc32.ChecksumIEEE(body) != binary.LittleEndian.Uint32(data[len(body):]) {
+		return nil, errBadEntry
+	}
+
+	d := decoder{b: body[len(cacheMagic):]}
+	if d.uvarint() != cacheVersion {
+		return nil, errBadEntry
+	}
+	e := &cacheEntry{}
+	e.path = d.str()
+	e.fileSize = d.varint()
+	e.mtime = d.varint()
+	e.dev = d.uvarint()
+	e.ino = d.uvarint()
+	e.dataSize = d.varint()
+	copy(e.head[:], d.bytes(sha256.Size))
+	copy(e.tail[:], d.bytes(sha256.Size))
+	e.levelKey = d.str()
+	e.day = d.str()
+
+	n := d.count(1)
+	e.offsets = &lineOffsets{}
+	var off int64
+	for i := 0; i < n; i++ {
+		off += int64(d.uvarint())
+		e.offsets.add(off)
+	}
+
+	runs := d.count(2)
+	for i := 0; i < runs && d.err == nil; i++ {
+		level := d.bytes(1)
+		length := d.uvarint()
+		if len(level) < 1 || length > uint64(e.offsets.len()-len(e.levels)) {
+			d.fail()
+			break
+		}
+		e.levels = append(e.levels, slices.Repeat(level, int(length))...)
+	}
+
+	n = d.count(2)
+	e.samples = make([]Sample, 0, n)
+	for i := 0; i < n; i++ {
+		s := Sample{Line: i * sampleEvery, TimeLine: -1}
+		hdr := d.bytes(2)
+		if len(hdr) < 2 {
+			break
+		}
+		s.Level = logformat.LogLevel(hdr[0])
+		if hdr[1] != 0 {
+			t := time.Unix(0, d.varint())
+			s.Timestamp = &t
+			s.TimeLine = s.Line + int(d.uvarint())
+		}
+		e.samples = append(e.samples, s)
+	}
+
+	if d.err != nil || len(d.b) != 0 || e.offsets.len() == 0 || e.offsets.at(0) != 0 ||
+		(len(e.levels) != 0 && len(e.levels) != e.offsets.len()) {
+		return nil, errBadEntry
+	}
+	return e, nil
+}
+
+// decoder reads entry fields, remembering the first error so fields can be
+// read unchecked and the result validated once
+type decoder struct {
+	b   []byte
+	err error
+}
+
+func (d *decoder) uvarint() uint64 {
+	v, n := binary.Uvarint(d.b)
+	if n <= 0 {
+		d.fail()
+		return 0
+	}
+	d.b = d.b[n:]
+	return v
+}
+
+func (d *decoder) varint() int64 {
+	v, n := binary.Varint(d.b)
+	if n <= 0 {
+		d.fail()
+		return 0
+	}
+	d.b = d.b[n:]
+	return v
+}
+
+func (d *decoder) bytes(n int) []byte {
+	if n > len(d.b) {
+		d.fail()
+		return nil
+	}
+	v := d.b[:n]
+	d.b = d.b[n:]
+	return v
+}
+
+func (d *decoder) str() string {
+	return string(d.bytes(d.count(1)))
+}
+
+// count reads a length, rejecting ones that can't fit in the remaining data
+// given each element takes at least min bytes
+func (d *decoder) count(min int) int {
+	n := d.uvarint()
+	if n > uint64(len(d.b)/min) {
+		d.fail()
+		return 0
+	}
+	return int(n)
+}
+
+func (d *decoder) fail() {
+	if d.err == nil {
+		d.err = errBadEntry
+	}
+	d.b = nil
+}
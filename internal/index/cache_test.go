@@ -0,0 +1,244 @@
+package index
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	mlessio "github.com/TimelordUK/mless/internal/io"
+)
+
+// newTestCache returns a cache that accepts small files in temp dirs.
+func newTestCache(t *testing.T, maxBytes int64) *Cache {
+	c := NewCache(t.TempDir(), maxBytes)
+	c.minSize = 0
+	c.skipDir = filepath.Join(string(filepath.Separator), "no-such-dir")
+	return c
+}
+
+// openIndex opens path and indexes it through the cache, closing both (and so
+// saving the index) at cleanup or via the returned func.
+func openIndex(t *testing.T, path string, c *Cache) (*LineIndex, func()) {
+	t.Helper()
+	return openIndexWith(t, path, Options{Cache: c})
+}
+
+func openIndexWith(t *testing.T, path string, opts Options) (*LineIndex, func()) {
+	t.Helper()
+	f, err := mlessio.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	idx, err := NewLineIndexWithOptions(f, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := idx.Wait(t.Context()); err != nil {
+		t.Fatal(err)
+	}
+	closed := false
+	closeFn := func() {
+		if !closed {
+			closed = true
+			idx.Close()
+			f.Close()
+		}
+	}
+	t.Cleanup(closeFn)
+	return idx, closeFn
+}
+
+func checkLines(t *testing.T, idx *LineIndex, want []string) {
+	t.Helper()
+	if idx.LineCount() != len(want) {
+		t.Fatalf("got %d lines, want %d", idx.LineCount(), len(want))
+	}
+	for i, w := range want {
+		got, err := idx.GetLine(i)
+		if err != nil || string(got) != w {
+			t.Fatalf("line %d: got %q (%v), want %q", i, got, err, w)
+		}
+	}
+}
+
+func TestCacheReusedWhenUnchanged(t *testing.T) {
+	c := newTestCache(t, 1<<20)
+	lines := sampleLines(3000)
+	path := filepath.Join(t.TempDir(), "app.log")
+	writeLines(t, path, lines, "\n")
+
+	idx, closeFn := openIndex(t, path, c)
+	if idx.FromCache() {
+		t.Fatal("first open should scan")
+	}
+	closeFn()
+
+	idx, _ = openIndex(t, path, c)
+	if !idx.FromCache() {
+		t.Fatal("second open should load from the cache")
+	}
+	checkLines(t, idx, lines)
+	if got := len(idx.Samples()); got != (len(lines)+sampleEvery-1)/sampleEvery {
+		t.Fatalf("got %d samples", got)
+	}
+}
+
+// TestCacheExtendedWhenGrown covers a cached prefix ending both at and in the
+// middle of a line.
+func TestCacheExtendedWhenGrown(t *testing.T) {
+	for _, trailing := range []string{"\n", ""} {
+		c := newTestCache(t, 1<<20)
+		lines := sampleLines(3000)
+		path := filepath.Join(t.TempDir(), "app.log")
+		writeLines(t, path, lines[:2000], trailing)
+
+		_, closeFn := openIndex(t, path, c)
+		closeFn()
+
+		rest := strings.Join(lines[2000:], "\n") + "\n"
+		if trailing == "" {
+			rest = "\n" + rest
+		}
+		appendFile(t, path, rest)
+
+		idx, _ := openIndex(t, path, c)
+		if !idx.FromCache() {
+			t.Fatalf("trailing %q: grown file should extend the cached index", trailing)
+		}
+		checkLines(t, idx, lines)
+	}
+}
+
+// TestCacheKeepsLevels checks line levels are cached, and that an entry made
+// with other level patterns isn't used.
+func TestCacheKeepsLevels(t *testing.T) {
+	c := newTestCache(t, 1<<20)
+	path := filepath.Join(t.TempDir(), "app.log")
+	writeLines(t, path, levelLines(3000), "\n")
+
+	opts := detectOptions()
+	opts.Cache = c
+	opts.LevelKey = "default"
+	_, closeFn := openIndexWith(t, path, opts)
+	closeFn()
+
+	idx, closeFn := openIndexWith(t, path, opts)
+	if !idx.FromCache() {
+		t.Fatal("second open should load from the cache")
+	}
+	checkLevels(t, idx)
+	closeFn()
+
+	opts.LevelKey = "custom"
+	if idx, _ := openIndexWith(t, path, opts); idx.FromCache() {
+		t.Fatal("levels from other patterns should not be reused")
+	}
+}
+
+func TestCacheRejectsRewrittenFile(t *testing.T) {
+	c := newTestCache(t, 1<<20)
+	path := filepath.Join(t.TempDir(), "app.log")
+	writeLines(t, path, sampleLines(100), "\n")
+	_, closeFn := openIndex(t, path, c)
+	closeFn()
+
+	// Same size, different content and mtime
+	lines := sampleLines(100)
+	lines[50] = strings.Repeat("y", len(lines[50]))
+	writeLines(t, path, lines, "\n")
+	later := time.Now().Add(time.Minute)
+	os.Chtimes(path, later, later)
+
+	idx, _ := openIndex(t, path, c)
+	if idx.FromCache() {
+		t.Fatal("rewritten file should not use the cache")
+	}
+	checkLines(t, idx, lines)
+}
+
+func TestCacheIgnoresCorruptEntry(t *testing.T) {
+	c := newTestCache(t, 1<<20)
+	path := filepath.Join(t.TempDir(), "app.log")
+	lines := sampleLines(100)
+	writeLines(t, path, lines, "\n")
+	_, closeFn := openIndex(t, path, c)
+	closeFn()
+
+	entry := c.entryPath(mustAbs(t, path))
+	data, err := os.ReadFile(entry)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data[len(data)/2] ^= 0xff
+	os.WriteFile(entry, data, 0o644)
+
+	idx, _ := openIndex(t, path, c)
+	if idx.FromCache() {
+		t.Fatal("corrupt entry should be ignored")
+	}
+	checkLines(t, idx, lines)
+}
+
+func TestCacheEvictsLeastRecentlyUsed(t *testing.T) {
+	c := newTestCache(t, 1<<20)
+	dir := t.TempDir()
+	var paths []string
+	for i := 0; i < 3; i++ {
+		path := filepath.Join(dir, "app"+string(rune('a'+i))+".log")
+		writeLines(t, path, sampleLines(2000), "\n")
+		_, closeFn := openIndex(t, path, c)
+		closeFn()
+		paths = append(paths, path)
+
+		// Space out modification times so the LRU order is unambiguous
+		when := time.Now().Add(time.Duration(i-10) * time.Minute)
+		os.Chtimes(c.entryPath(mustAbs(t, path)), when, when)
+	}
+
+	// Shrink the budget to two entries
+	info, err := os.Stat(c.entryPath(mustAbs(t, paths[0])))
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.maxBytes = 2*info.Size() + info.Size()/2
+	c.evict()
+
+	if _, err := os.Stat(c.entryPath(mustAbs(t, paths[0]))); !os.IsNotExist(err) {
+		t.Fatalf("oldest entry should be evicted (stat err %v)", err)
+	}
+	for _, p := range paths[1:] {
+		if _, err := os.Stat(c.entryPath(mustAbs(t, p))); err != nil {
+			t.Fatalf("recent entry evicted: %v", err)
+		}
+	}
+}
+
+func writeLines(t *testing.T, path string, lines []string, trailing string) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte(strings.Join(lines, "\n")+trailing), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func appendFile(t *testing.T, path, content string) {
+	t.Helper()
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if _, err := f.WriteString(content); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func mustAbs(t *testing.T, path string) string {
+	t.Helper()
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return abs
+}
@@ -0,0 +1,18 @@
+//go:build !windows
+
+package index
+
+import (
+	"os"
+	"syscall"
+)
+
+// fileID returns the device and inode of a file, so a cache entry isn't
+// reused for a different file that now has the same path (e.g. after log
+// rotation)
+func fileID(info os.FileInfo) (dev, ino uint64) {
+	if st, ok := info.Sys().(*syscall.Stat_t); ok {
+		return uint64(st.Dev), uint64(st.Ino)
+	}
+	return 0, 0
+}
@@ -0,0 +1,11 @@
+//go:build windows
+
+package index
+
+import "os"
+
+// fileID is not available from os.FileInfo on Windows; cache entries are
+// validated by size, mtime and content fingerprint alone
+func fileID(info os.FileInfo) (dev, ino uint64) {
+	return 0, 0
+}
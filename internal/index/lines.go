@@ -2,45 +2,308 @@ package index
 
 import (
 	"bytes"
+	"context"
+	"runtime"
+	"slices"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	mlessio "github.com/TimelordUK/mless/internal/io"
 	"github.com/TimelordUK/mless/pkg/logformat"
 )
 
-// LineIndex stores byte offsets for each line in a file
+// Files at least this big are indexed in the background; smaller ones are
+// quick enough to scan before the first frame
+var asyncThreshold int64 = 16 << 20
+
+// indexChunkSize is the unit of work handed to each indexing goroutine
+var indexChunkSize int64 = 4 << 20
+
+// sampleEvery is the spacing of the lines whose timestamp and level are
+// recorded while indexing (and persisted with the index)
+const sampleEvery = 1024
+
+// sampleProbe is how many lines from a sampled line are tried for a
+// timestamp, so continuation lines (stack traces, wrapped messages) don't
+// leave gaps in the timestamp checkpoints
+const sampleProbe = 32
+
+// sampleBytes bounds how much of a line is read to find its level or
+// timestamp: they sit near the start, though in structured (JSON) logs they
+// may follow a long message
+const sampleBytes = 4096
+
+// Options configures how a LineIndex is built
+type Options struct {
+	Cache       *Cache                                  // persistent index cache (nil = always scan)
+	DetectLevel func(content []byte) logformat.LogLevel // classifies every line while indexing (nil = not classified)
+	Timestamps  *logformat.TimestampParser              // parses line timestamps (nil = the default formats)
+	LevelKey    string                                  // identifies DetectLevel's and Timestamps' settings, so cached levels and samples are only reused with the same ones
+}
+
+// Sample is recorded for every sampleEvery'th line: its level, and the first
+// timestamp found on it or the sampleProbe lines after it
+type Sample struct {
+	Line      int
+	Level     logformat.LogLevel
+	Timestamp *time.Time // nil if none was found
+	TimeLine  int        // line Timestamp was parsed from (-1 if none)
+}
+
+// LineIndex stores byte offsets for each line in a file.
+//
+// Large files are indexed in the background: chunks are scanned for newlines
+// by worker goroutines and merged in file order, so offsets only ever grow at
+// the end and everything already indexed can be read straight away.
 type LineIndex struct {
-	offsets    []int64      // byte offset of each line start
-	timestamps []*time.Time // parsed timestamp for each line (nil if not parsed)
-	file       *mlessio.MappedFile
-	tsParser   *logformat.TimestampParser
+	mu       sync.RWMutex // guards offsets, levels and samples while the indexer appends
+	offsets  lineOffsets  // byte offset of each line start
+	levels   []uint8      // level of each line, if opts.DetectLevel is set
+	samples  []Sample     // every sampleEvery'th line, in order
+	file     mlessio.File
+	tsParser *logformat.TimestampParser
+	opts     Options
+
+	timesMu sync.Mutex
+	times   lineTimes // timestamps parsed so far
+
+	headsMu sync.Mutex
+	heads   []*headSpan // which lines start records, classified on demand
+
+	// Background indexing state
+	indexed atomic.Int64 // bytes merged into offsets so far
+	total   int64        // bytes to index
+	done    chan struct{}
+	stopped chan struct{} // closed once run has also finished saving to the cache
+	cancel  context.CancelFunc
+	err     error // set before done is closed
+
+	// Persistent cache state
+	fromCache    bool       // offsets were loaded from the cache
+	saveMu       sync.Mutex // serializes saves and guards the fields below
+	savedSize    int64      // bytes covered by the cache entry on disk (-1 = none)
+	savedSamples int        // samples in the cache entry on disk
+}
+
+// BuildLineIndex scans the whole file and returns a complete line offset index
+func BuildLineIndex(file mlessio.File) (*LineIndex, error) {
+	idx := StartLineIndex(file)
+	if err := idx.Wait(context.Background()); err != nil {
+		return nil, err
+	}
+	return idx, nil
+}
+
+// NewLineIndex indexes small files immediately and starts large ones in the
+// background (see StartLineIndex)
+func NewLineIndex(file mlessio.File) (*LineIndex, error) {
+	return NewLineIndexWithOptions(file, Options{})
+}
+
+// NewLineIndexWithOptions is NewLineIndex with a persistent cache: a valid
+// cache entry replaces the scan, and one for a shorter version of the file
+// is extended from where it left off
+func NewLineIndexWithOptions(file mlessio.File, opts Options) (*LineIndex, error) {
+	idx := startLineIndex(file, opts)
+	if file.Size()-idx.indexed.Load() < asyncThreshold {
+		if err := idx.Wait(context.Background()); err != nil {
+			return nil, err
+		}
+	}
+	return idx, nil
 }
 
-// BuildLineIndex scans the file and builds a line offset index
-func BuildLineIndex(file *mlessio.MappedFile) (*LineIndex, error) {
+// StartLineIndex begins indexing file in the background and returns at once.
+// LineCount grows as chunks are merged; use Done, Progress and Wait to track
+// completion, and Cancel to stop early.
+func StartLineIndex(file mlessio.File) *LineIndex {
+	return startLineIndex(file, Options{})
+}
+
+func startLineIndex(file mlessio.File, opts Options) *LineIndex {
 	size := file.Size()
-	if size == 0 {
-		return &LineIndex{
-			offsets:  []int64{0},
-			file:     file,
-			tsParser: logformat.NewTimestampParser(),
-		}, nil
-	}
-
-	// Estimate initial capacity (assume ~100 bytes per line)
-	estimatedLines := int(size/100) + 1
-	offsets := make([]int64, 0, estimatedLines)
-	offsets = append(offsets, 0) // First line starts at 0
-
-	// Read in chunks to find newlines
-	const chunkSize = 64 * 1024 // 64KB chunks
-	buf := make([]byte, chunkSize)
-
-	var pos int64
-	for pos < size {
-		readSize := chunkSize
-		if pos+int64(readSize) > size {
-			readSize = int(size - pos)
+	ctx, cancel := context.WithCancel(context.Background())
+	tsParser := opts.Timestamps
+	if tsParser == nil {
+		tsParser = logformat.NewTimestampParser()
+	}
+	idx := &LineIndex{
+		file:      file,
+		tsParser:  tsParser,
+		opts:      opts,
+		total:     size,
+		done:      make(chan struct{}),
+		stopped:   make(chan struct{}),
+		cancel:    cancel,
+		savedSize: -1,
+	}
+
+	var from int64
+	if entry := opts.Cache.load(file, opts.LevelKey, idx.baseDay()); entry != nil {
+		idx.offsets = *entry.offsets
+		idx.levels = entry.levels
+		idx.samples = entry.samples
+		idx.fromCache = true
+		idx.savedSize = entry.dataSize
+		idx.savedSamples = len(entry.samples)
+		from = entry.dataSize
+	} else {
+		idx.offsets.add(0) // first line starts at 0
+	}
+	idx.indexed.Store(from)
+
+	go idx.run(ctx, from, size)
+	return idx
+}
+
+// chunkResult carries the line starts found in one chunk, and the levels of
+// the lines starting there
+type chunkResult struct {
+	offsets []int64
+	levels  []uint8
+	err     error
+}
+
+// run scans [from, size) in chunks on a pool of workers and merges the
+// results in order. Compressed files are read by a single worker: their reads
+// are serialised anyway, and sequential access keeps the decoder streaming.
+// Once the file is fully indexed it is saved to the cache, if one is set.
+func (idx *LineIndex) run(ctx context.Context, from, size int64) {
+	defer close(idx.stopped)
+	if entry := idx.finish(ctx, from, size); entry != nil {
+		idx.opts.Cache.save(entry)
+	}
+}
+
+// finish indexes [from, size) and, if that completes and the cache is out of
+// date, returns the entry to save. Everything that reads the file happens
+// before done is closed: after that the owner may refresh or close it.
+func (idx *LineIndex) finish(ctx context.Context, from, size int64) *cacheEntry {
+	defer close(idx.done)
+
+	if err := idx.scan(ctx, from, size); err != nil {
+		idx.err = err
+		return nil
+	}
+	idx.takeSamples(true, size)
+	idx.saveMu.Lock()
+	defer idx.saveMu.Unlock()
+	return idx.cacheEntry(size)
+}
+
+// scan merges the line starts in [from, size) onto offsets
+func (idx *LineIndex) scan(ctx context.Context, from, size int64) error {
+	if from > 0 && from < size {
+		// Resuming a cached index: from starts a line if the content before
+		// it ended with a newline
+		lastByte := make([]byte, 1)
+		if _, err := idx.file.ReadAt(lastByte, from-1); err != nil {
+			return err
+		}
+		idx.mu.Lock()
+		if lastByte[0] == '\n' {
+			idx.offsets.add(from)
+		} else {
+			idx.dropLastLevel()
+		}
+		idx.mu.Unlock()
+	}
+	// The first line of the scan isn't found by a worker
+	if err := idx.detectMissingLevels(size); err != nil {
+		return err
+	}
+
+	n := int((size - from + indexChunkSize - 1) / indexChunkSize)
+	workers := runtime.NumCPU()
+	if _, mapped := idx.file.(*mlessio.MappedFile); !mapped {
+		workers = 1
+	}
+	if workers > n {
+		workers = n
+	}
+
+	// Chunks are handed out in order; the semaphore bounds how far workers
+	// may run ahead of the merge so finished chunks don't pile up in memory
+	results := make([]chan chunkResult, n)
+	for k := range results {
+		results[k] = make(chan chunkResult, 1)
+	}
+	sem := make(chan struct{}, workers*2)
+	next := make(chan int)
+	go func() {
+		defer close(next)
+		for k := 0; k < n; k++ {
+			select {
+			case sem <- struct{}{}:
+			case <-ctx.Done():
+				return
+			}
+			next <- k
+		}
+	}()
+
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for k := range next {
+				start := from + int64(k)*indexChunkSize
+				end := start + indexChunkSize
+				if end > size {
+					end = size
+				}
+				if ctx.Err() != nil {
+					results[k] <- chunkResult{err: ctx.Err()}
+					continue
+				}
+				offsets, levels, err := scanChunk(idx.file, start, end, size, idx.opts.DetectLevel)
+				results[k] <- chunkResult{offsets: offsets, levels: levels, err: err}
+			}
+		}()
+	}
+
+	for k := 0; k < n; k++ {
+		var r chunkResult
+		select {
+		case r = <-results[k]:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		if r.err != nil {
+			idx.cancel()
+			return r.err
+		}
+
+		idx.mu.Lock()
+		idx.offsets.addAll(r.offsets)
+		idx.levels = append(idx.levels, r.levels...)
+		idx.mu.Unlock()
+
+		end := from + int64(k+1)*indexChunkSize
+		if end > size {
+			end = size
+		}
+		idx.indexed.Store(end)
+		<-sem
+		idx.takeSamples(false, size)
+	}
+	return nil
+}
+
+// scanLineStarts returns the offset after every newline in [start, end),
+// excluding one at the very end of the file
+func scanLineStarts(file mlessio.File, start, end, size int64) ([]int64, error) {
+	const bufSize = 64 * 1024 // 64KB reads
+	buf := make([]byte, bufSize)
+	var offsets []int64
+
+	for pos := start; pos < end; {
+		readSize := int64(bufSize)
+		if pos+readSize > end {
+			readSize = end - pos
 		}
 
 		n, err := file.ReadAt(buf[:readSize], pos)
@@ -52,46 +315,300 @@ func BuildLineIndex(file *mlessio.MappedFile) (*LineIndex, error) {
 		chunk := buf[:n]
 		offset := 0
 		for {
-			idx := bytes.IndexByte(chunk[offset:], '\n')
-			if idx == -1 {
+			i := bytes.IndexByte(chunk[offset:], '\n')
+			if i == -1 {
 				break
 			}
-			lineStart := pos + int64(offset) + int64(idx) + 1
+			lineStart := pos + int64(offset) + int64(i) + 1
 			if lineStart < size {
 				offsets = append(offsets, lineStart)
 			}
-			offset += idx + 1
+			offset += i + 1
 		}
 
 		pos += int64(n)
 	}
+	return offsets, nil
+}
+
+// scanChunk finds the line starts in [start, end) like scanLineStarts and,
+// if detect is set, the level of each line starting there. The chunk is read
+// in one piece, plus enough after it to see the start of its last line.
+func scanChunk(file mlessio.File, start, end, size int64, detect func([]byte) logformat.LogLevel) ([]int64, []uint8, error) {
+	if detect == nil {
+		offsets, err := scanLineStarts(file, start, end, size)
+		return offsets, nil, err
+	}
+
+	readEnd := min(end+sampleBytes, size)
+	buf := make([]byte, readEnd-start)
+	if _, err := file.ReadAt(buf, start); err != nil {
+		return nil, nil, err
+	}
+
+	var offsets []int64
+	var levels []uint8
+	chunk := buf[:end-start]
+	for pos := 0; ; {
+		i := bytes.IndexByte(chunk[pos:], '\n')
+		if i == -1 {
+			break
+		}
+		pos += i + 1
+		lineStart := start + int64(pos)
+		if lineStart >= size {
+			break
+		}
+		offsets = append(offsets, lineStart)
+		levels = append(levels, uint8(detect(linePrefix(buf[pos:]))))
+	}
+	return offsets, levels, nil
+}
+
+// linePrefix returns up to sampleBytes of the line b starts with, without its
+// line ending
+func linePrefix(b []byte) []byte {
+	if len(b) > sampleBytes {
+		b = b[:sampleBytes]
+	}
+	if i := bytes.IndexByte(b, '\n'); i >= 0 {
+		b = b[:i]
+	}
+	return bytes.TrimRight(b, "\r")
+}
+
+// dropLastLevel forgets the level of the last line, which has grown since it
+// was detected, so detectMissingLevels looks at it again. The slice is clipped
+// rather than overwritten in place: cache snapshots may share it. The caller
+// holds mu.
+func (idx *LineIndex) dropLastLevel() {
+	if n := len(idx.levels); n > 0 && n == idx.offsets.len() {
+		idx.levels = slices.Clip(idx.levels[:n-1])
+	}
+}
+
+// detectMissingLevels detects the levels of indexed lines that don't have
+// one yet: the first line of a scan, which no worker finds the start of, or
+// one that has grown since it was detected
+func (idx *LineIndex) detectMissingLevels(size int64) error {
+	if idx.opts.DetectLevel == nil {
+		return nil
+	}
+	for {
+		idx.mu.RLock()
+		line, n := len(idx.levels), idx.offsets.len()
+		var start int64
+		if line < n {
+			start = idx.offsets.at(line)
+		}
+		idx.mu.RUnlock()
+		if line >= n {
+			return nil
+		}
+
+		content, err := idx.file.ReadRange(start, min(start+sampleBytes, size))
+		if err != nil {
+			return err
+		}
+		level := uint8(idx.opts.DetectLevel(linePrefix(content)))
+
+		idx.mu.Lock()
+		idx.levels = append(idx.levels, level)
+		idx.mu.Unlock()
+	}
+}
+
+// takeSamples records a Sample for each sampleEvery'th line once the lines
+// it may probe for a timestamp are indexed; with final, the last line is
+// taken to end at size
+func (idx *LineIndex) takeSamples(final bool, size int64) {
+	for {
+		idx.mu.RLock()
+		line := len(idx.samples) * sampleEvery
+		n := idx.offsets.len()
+		probeEnd := line + sampleProbe
+		if line >= n || (!final && probeEnd >= n) {
+			idx.mu.RUnlock()
+			return
+		}
+		if probeEnd > n {
+			probeEnd = n
+		}
+		// Line bounds: bounds[i] to bounds[i+1] is line line+i
+		var bounds []int64
+		if probeEnd < n {
+			bounds = idx.offsets.slice(line, probeEnd+1)
+		} else {
+			bounds = append(idx.offsets.slice(line, n), size)
+		}
+		idx.mu.RUnlock()
+
+		sample := Sample{Line: line, TimeLine: -1}
+		for i := 0; i+1 < len(bounds); i++ {
+			content, err := idx.readPrefix(bounds[i], bounds[i+1])
+			if err != nil {
+				break
+			}
+			if i == 0 {
+				sample.Level, _ = idx.Level(line)
+			}
+			if ts := idx.parseTime(line+i, content); ts != nil {
+				sample.Timestamp = ts
+				sample.TimeLine = line + i
+				break
+			}
+		}
+
+		idx.mu.Lock()
+		idx.samples = append(idx.samples, sample)
+		idx.mu.Unlock()
+	}
+}
+
+// readPrefix returns up to sampleBytes of the line in [start, end), without
+// its line ending
+func (idx *LineIndex) readPrefix(start, end int64) ([]byte, error) {
+	if end-start > sampleBytes {
+		end = start + sampleBytes
+	}
+	content, err := idx.file.ReadRange(start, end)
+	if err != nil {
+		return nil, err
+	}
+	return bytes.TrimRight(content, "\r\n"), nil
+}
+
+// Level returns the level detected for a line while indexing. ok is false if
+// levels aren't being detected (Options.DetectLevel is nil) or the line isn't
+// indexed yet.
+func (idx *LineIndex) Level(lineNum int) (level logformat.LogLevel, ok bool) {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+	if lineNum < 0 || lineNum >= len(idx.levels) {
+		return logformat.LevelUnknown, false
+	}
+	return logformat.LogLevel(idx.levels[lineNum]), true
+}
+
+// Samples returns the timestamp and level samples taken so far
+func (idx *LineIndex) Samples() []Sample {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+	return append([]Sample(nil), idx.samples...)
+}
+
+// FromCache reports whether the index was loaded from the persistent cache
+// rather than built by scanning the file
+func (idx *LineIndex) FromCache() bool {
+	return idx.fromCache
+}
+
+// Done reports whether indexing has finished (or stopped on an error)
+func (idx *LineIndex) Done() bool {
+	select {
+	case <-idx.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// Progress returns the fraction of the file indexed so far (0 to 1)
+func (idx *LineIndex) Progress() float64 {
+	if idx.total == 0 || idx.Done() {
+		return 1
+	}
+	return float64(idx.indexed.Load()) / float64(idx.total)
+}
+
+// Wait blocks until indexing finishes or ctx is cancelled. It returns the
+// error indexing stopped on, if any.
+func (idx *LineIndex) Wait(ctx context.Context) error {
+	select {
+	case <-idx.done:
+		return idx.err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Cancel stops background indexing and waits for the workers to exit. Lines
+// indexed so far stay readable.
+func (idx *LineIndex) Cancel() {
+	idx.cancel()
+	<-idx.done
+}
+
+// Close stops background indexing and, if the index has grown since it was
+// last cached (e.g. while following), saves it. Call before closing the file.
+func (idx *LineIndex) Close() {
+	idx.Cancel()
+	<-idx.stopped
+	if !idx.complete() {
+		return
+	}
+
+	idx.saveMu.Lock()
+	entry := idx.cacheEntry(idx.file.Size())
+	idx.saveMu.Unlock()
+	if entry != nil {
+		idx.opts.Cache.save(entry)
+	}
+}
+
+// Discard stops background indexing without saving to the cache, for an
+// index of content the file no longer has (after a rotation, when saving
+// would file the old offsets under the new content)
+func (idx *LineIndex) Discard() {
+	idx.Cancel()
+	<-idx.stopped
+}
 
-	return &LineIndex{
-		offsets:  offsets,
-		file:     file,
-		tsParser: logformat.NewTimestampParser(),
-	}, nil
+// complete reports whether the whole file was indexed
+func (idx *LineIndex) complete() bool {
+	return idx.Done() && idx.err == nil
 }
 
-// LineCount returns the total number of lines
+// LineCount returns the number of lines indexed so far. Until indexing
+// completes, the line after the last merged newline is left out: where it
+// ends isn't known yet.
 func (idx *LineIndex) LineCount() int {
-	return len(idx.offsets)
+	idx.mu.RLock()
+	n := idx.offsets.len()
+	idx.mu.RUnlock()
+
+	if !idx.complete() {
+		return n - 1
+	}
+	return n
+}
+
+// lineBounds returns the byte range of a line, or ok=false if it isn't indexed
+func (idx *LineIndex) lineBounds(lineNum int) (start, end int64, ok bool) {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+
+	n := idx.offsets.len()
+	if lineNum < 0 || lineNum >= n {
+		return 0, 0, false
+	}
+	start = idx.offsets.at(lineNum)
+	if lineNum+1 < n {
+		return start, idx.offsets.next(lineNum, start), true
+	}
+	if !idx.complete() {
+		return 0, 0, false
+	}
+	return start, idx.file.Size(), true
 }
 
 // GetLine returns the content of line at given index (0-based)
 func (idx *LineIndex) GetLine(lineNum int) ([]byte, error) {
-	if lineNum < 0 || lineNum >= len(idx.offsets) {
+	start, end, ok := idx.lineBounds(lineNum)
+	if !ok {
 		return nil, nil
 	}
 
-	start := idx.offsets[lineNum]
-	var end int64
-	if lineNum+1 < len(idx.offsets) {
-		end = idx.offsets[lineNum+1]
-	} else {
-		end = idx.file.Size()
-	}
-
 	content, err := idx.file.ReadRange(start, end)
 	if err != nil {
 		return nil, err
@@ -104,14 +621,15 @@ func (idx *LineIndex) GetLine(lineNum int) ([]byte, error) {
 
 // GetLines returns a range of lines efficiently
 func (idx *LineIndex) GetLines(start, count int) ([][]byte, error) {
+	total := idx.LineCount()
 	if start < 0 {
 		start = 0
 	}
-	if start >= len(idx.offsets) {
+	if start >= total {
 		return nil, nil
 	}
-	if start+count > len(idx.offsets) {
-		count = len(idx.offsets) - start
+	if start+count > total {
+		count = total - start
 	}
 
 	lines := make([][]byte, count)
@@ -127,31 +645,42 @@ func (idx *LineIndex) GetLines(start, count int) ([][]byte, error) {
 
 // ByteOffset returns the byte offset of a line
 func (idx *LineIndex) ByteOffset(lineNum int) int64 {
-	if lineNum < 0 || lineNum >= len(idx.offsets) {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+
+	if lineNum < 0 || lineNum >= idx.offsets.len() {
 		return -1
 	}
-	return idx.offsets[lineNum]
+	return idx.offsets.at(lineNum)
 }
 
 // GetTimestamp returns the parsed timestamp for a line (lazy parsing)
 func (idx *LineIndex) GetTimestamp(lineNum int) *time.Time {
-	if lineNum < 0 || lineNum >= len(idx.offsets) {
+	if lineNum < 0 || lineNum >= idx.LineCount() {
 		return nil
 	}
 
-	// Initialize timestamps slice if needed
-	if idx.timestamps == nil {
-		idx.timestamps = make([]*time.Time, len(idx.offsets))
-	}
+	idx.timesMu.Lock()
+	defer idx.timesMu.Unlock()
 
-	// Extend if needed (for appended lines)
-	for len(idx.timestamps) < len(idx.offsets) {
-		idx.timestamps = append(idx.timestamps, nil)
+	// Return cached timestamp if already parsed
+	if ts, parsed := idx.times.get(lineNum); parsed {
+		return ts
 	}
 
-	// Return cached timestamp if already parsed
-	if idx.timestamps[lineNum] != nil {
-		return idx.timestamps[lineNum]
+	// Sampled lines were parsed while indexing (or loaded from the cache)
+	if lineNum%sampleEvery == 0 {
+		idx.mu.RLock()
+		k := lineNum / sampleEvery
+		var ts *time.Time
+		if k < len(idx.samples) && idx.samples[k].TimeLine == lineNum {
+			ts = idx.samples[k].Timestamp
+		}
+		idx.mu.RUnlock()
+		if ts != nil {
+			idx.times.set(lineNum, ts)
+			return ts
+		}
 	}
 
 	// Parse timestamp from line content
@@ -160,41 +689,11 @@ func (idx *LineIndex) GetTimestamp(lineNum int) *time.Time {
 		return nil
 	}
 
-	ts := idx.tsParser.Parse(content)
-	idx.timestamps[lineNum] = ts
+	ts := idx.parseTime(lineNum, content)
+	idx.times.set(lineNum, ts)
 	return ts
 }
 
-// FindLineAtTime finds the first line at or after the given time
-// Returns -1 if no such line exists
-func (idx *LineIndex) FindLineAtTime(target time.Time) int {
-	// Binary search would be better for large files, but for now linear scan
-	// from the end since we often look for recent times
-	for i := 0; i < len(idx.offsets); i++ {
-		ts := idx.GetTimestamp(i)
-		if ts != nil && !ts.Before(target) {
-			return i
-		}
-	}
-	return -1
-}
-
-// FindLineBeforeTime finds the last line before the given time
-func (idx *LineIndex) FindLineBeforeTime(target time.Time) int {
-	lastBefore := -1
-	for i := 0; i < len(idx.offsets); i++ {
-		ts := idx.GetTimestamp(i)
-		if ts != nil {
-			if ts.Before(target) {
-				lastBefore = i
-			} else {
-				break
-			}
-		}
-	}
-	return lastBefore
-}
-
 // FindNearestLineAtTime finds the line with timestamp closest to the given time
 func (idx *LineIndex) FindNearestLineAtTime(target time.Time) int {
 	lineAfter := idx.FindLineAtTime(target)
@@ -230,7 +729,8 @@ func (idx *LineIndex) FindNearestLineAtTime(target time.Time) int {
 	return lineAfter
 }
 
-// AppendNewLines indexes new content from oldSize to current file size
+// AppendNewLines indexes new content from oldSize to current file size. Only
+// valid once background indexing is done.
 func (idx *LineIndex) AppendNewLines(oldSize int64) error {
 	size := idx.file.Size()
 	if size <= oldSize {
@@ -245,48 +745,40 @@ func (idx *LineIndex) AppendNewLines(oldSize int64) error {
 		if err != nil {
 			return err
 		}
+		idx.mu.Lock()
 		if lastByte[0] == '\n' {
 			// Previous content ended with newline, so oldSize is start of new line
-			idx.offsets = append(idx.offsets, oldSize)
+			idx.offsets.add(oldSize)
+		} else {
+			idx.dropLastLevel()
 		}
-	} else if oldSize == 0 && len(idx.offsets) == 0 {
-		// Empty file getting first content
-		idx.offsets = append(idx.offsets, 0)
-	}
-
-	// Read in chunks from oldSize to end to find more newlines
-	const chunkSize = 64 * 1024
-	buf := make([]byte, chunkSize)
-
-	pos := oldSize
-	for pos < size {
-		readSize := chunkSize
-		if pos+int64(readSize) > size {
-			readSize = int(size - pos)
+		idx.mu.Unlock()
+	} else {
+		idx.mu.Lock()
+		if idx.offsets.len() == 0 {
+			// Empty file getting first content
+			idx.offsets.add(0)
 		}
+		idx.mu.Unlock()
+	}
+	if err := idx.detectMissingLevels(size); err != nil {
+		return err
+	}
 
-		n, err := idx.file.ReadAt(buf[:readSize], pos)
+	// Find more newlines from oldSize to end, a chunk at a time
+	for start := oldSize; start < size; start += indexChunkSize {
+		end := min(start+indexChunkSize, size)
+		offsets, levels, err := scanChunk(idx.file, start, end, size, idx.opts.DetectLevel)
 		if err != nil {
 			return err
 		}
 
-		// Find all newlines in this chunk
-		chunk := buf[:n]
-		offset := 0
-		for {
-			i := bytes.IndexByte(chunk[offset:], '\n')
-			if i == -1 {
-				break
-			}
-			lineStart := pos + int64(offset) + int64(i) + 1
-			if lineStart < size {
-				idx.offsets = append(idx.offsets, lineStart)
-			}
-			offset += i + 1
-		}
-
-		pos += int64(n)
+		idx.mu.Lock()
+		idx.offsets.addAll(offsets)
+		idx.levels = append(idx.levels, levels...)
+		idx.mu.Unlock()
 	}
 
+	idx.takeSamples(false, size)
 	return nil
 }
@@ -0,0 +1,181 @@
+package index
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	mlessio "github.com/TimelordUK/mless/internal/io"
+	"github.com/TimelordUK/mless/pkg/logformat"
+)
+
+func openTemp(t *testing.T, content string) mlessio.File {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "app.log")
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	f, err := mlessio.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { f.Close() })
+	return f
+}
+
+// withChunkSize makes indexing split even small files into many chunks.
+func withChunkSize(t *testing.T, size int64) {
+	old := indexChunkSize
+	indexChunkSize = size
+	t.Cleanup(func() { indexChunkSize = old })
+}
+
+func sampleLines(n int) []string {
+	lines := make([]string, n)
+	for i := range lines {
+		lines[i] = fmt.Sprintf("line %d %s", i, strings.Repeat("x", i%97))
+	}
+	return lines
+}
+
+// TestParallelIndexMatchesContent checks chunked, multi-worker indexing merges
+// offsets in order, with and without a trailing newline.
+func TestParallelIndexMatchesContent(t *testing.T) {
+	withChunkSize(t, 1000)
+	lines := sampleLines(5000)
+
+	for _, trailing := range []string{"\n", ""} {
+		idx, err := BuildLineIndex(openTemp(t, strings.Join(lines, "\n")+trailing))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if idx.LineCount() != len(lines) {
+			t.Fatalf("trailing %q: got %d lines, want %d", trailing, idx.LineCount(), len(lines))
+		}
+		for i, want := range lines {
+			got, err := idx.GetLine(i)
+			if err != nil || string(got) != want {
+				t.Fatalf("line %d: got %q (%v), want %q", i, got, err, want)
+			}
+		}
+	}
+}
+
+// TestIndexInProgressHidesOpenLine checks that before indexing finishes the
+// line whose end hasn't been found yet is not reported.
+func TestIndexInProgressHidesOpenLine(t *testing.T) {
+	f := openTemp(t, "one\ntwo\nthree")
+	idx := &LineIndex{file: f, done: make(chan struct{})}
+	idx.offsets.addAll([]int64{0, 4, 8})
+
+	if got := idx.LineCount(); got != 2 {
+		t.Fatalf("while indexing: got %d lines, want 2", got)
+	}
+	if line, _ := idx.GetLine(2); line != nil {
+		t.Fatalf("open line should not be readable yet, got %q", line)
+	}
+
+	close(idx.done)
+	if line, _ := idx.GetLine(2); string(line) != "three" {
+		t.Fatalf("after indexing: got %q", line)
+	}
+}
+
+func TestIndexCancel(t *testing.T) {
+	withChunkSize(t, 64)
+	idx := StartLineIndex(openTemp(t, strings.Join(sampleLines(20000), "\n")))
+	idx.Cancel()
+
+	if !idx.Done() {
+		t.Fatal("Cancel should wait for indexing to stop")
+	}
+	err := idx.Wait(context.Background())
+	if err == nil && idx.indexed.Load() < idx.total {
+		t.Fatal("a cancelled index should report why it stopped")
+	}
+	if err != nil && idx.LineCount() > 0 {
+		if line, _ := idx.GetLine(idx.LineCount()); line != nil {
+			t.Fatal("the open line of a cancelled index should stay hidden")
+		}
+	}
+}
+
+// TestSampleProbesForTimestamp checks a sampled line without a timestamp
+// takes the next one, including at the very end of the file.
+func TestSampleProbesForTimestamp(t *testing.T) {
+	idx, err := BuildLineIndex(openTemp(t, "header\n2024-01-15 10:30:45.123 INFO up\ntail"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	samples := idx.Samples()
+	if len(samples) != 1 || samples[0].Timestamp == nil || samples[0].TimeLine != 1 {
+		t.Fatalf("got samples %+v", samples)
+	}
+}
+
+// levelLines cycles through the levels, with some lines having none.
+func levelLines(n int) []string {
+	names := []string{"DEBUG", "INFO", "continued", "WARN", "ERROR"}
+	lines := make([]string, n)
+	for i := range lines {
+		lines[i] = fmt.Sprintf("2024-01-15 10:30:45 %s message %d %s", names[i%len(names)], i, strings.Repeat("x", i%61))
+	}
+	return lines
+}
+
+func detectOptions() Options {
+	return Options{DetectLevel: logformat.NewLevelDetector().Detect}
+}
+
+func checkLevels(t *testing.T, idx *LineIndex) {
+	t.Helper()
+	detect := detectOptions().DetectLevel
+	for i := 0; i < idx.LineCount(); i++ {
+		content, _ := idx.GetLine(i)
+		level, ok := idx.Level(i)
+		if !ok || level != detect(content) {
+			t.Fatalf("line %d %q: level %v (%v), want %v", i, content, level, ok, detect(content))
+		}
+	}
+}
+
+// TestLevelsDetectedWhileIndexing checks every line gets its level in the
+// indexing pass, across chunk boundaries and when appended lines extend the
+// last one.
+func TestLevelsDetectedWhileIndexing(t *testing.T) {
+	withChunkSize(t, 1000)
+	path := filepath.Join(t.TempDir(), "app.log")
+	if err := os.WriteFile(path, []byte(strings.Join(levelLines(3000), "\n")+"\nstill being writ"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	f, err := mlessio.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	idx, err := NewLineIndexWithOptions(f, detectOptions())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := idx.Wait(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	checkLevels(t, idx)
+
+	oldSize := f.Size()
+	appendFile(t, path, "ten: ERROR lost\nWARN next\n")
+	if _, err := f.Refresh(); err != nil {
+		t.Fatal(err)
+	}
+	if err := idx.AppendNewLines(oldSize); err != nil {
+		t.Fatal(err)
+	}
+	checkLevels(t, idx)
+	if level, _ := idx.Level(3000); level != logformat.LevelError {
+		t.Fatalf("grown last line: got %v, want error", level)
+	}
+}
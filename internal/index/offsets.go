@@ -0,0 +1,123 @@
+package index
+
+import (
+	"maps"
+	"math"
+)
+
+// offsetBlock is how many lines share one absolute base offset; reaching a
+// line sums at most offsetBlock-1 deltas
+const offsetBlock = 64
+
+// longDelta marks a line too long for its length to fit in a uint16; the
+// real length is kept in lineOffsets.long
+const longDelta = math.MaxUint16
+
+// lineOffsets stores line start offsets in a little over 2 bytes per line
+// instead of 8: each block of offsetBlock lines keeps the absolute offset of
+// its first line, and every other line the distance from the line before it
+// (that line's length). Lengths that don't fit in a uint16 go in a map.
+//
+// Appends never modify stored elements, so a snapshot taken under a lock
+// stays readable while more lines are appended.
+type lineOffsets struct {
+	bases  []int64       // offset of the first line of each block
+	deltas []uint16      // deltas[i] is offset(i) - offset(i-1); 0 at block starts
+	long   map[int]int64 // deltas[i] == longDelta: the real delta
+	last   int64         // offset of the last line
+}
+
+// len returns the number of offsets stored
+func (o *lineOffsets) len() int {
+	return len(o.deltas)
+}
+
+// add appends the offset of the next line, which must not be before the last
+func (o *lineOffsets) add(off int64) {
+	n := len(o.deltas)
+	switch d := off - o.last; {
+	case n%offsetBlock == 0:
+		o.bases = append(o.bases, off)
+		o.deltas = append(o.deltas, 0)
+	case d >= longDelta:
+		if o.long == nil {
+			o.long = make(map[int]int64)
+		}
+		o.long[n] = d
+		o.deltas = append(o.deltas, longDelta)
+	default:
+		o.deltas = append(o.deltas, uint16(d))
+	}
+	o.last = off
+}
+
+// addAll appends offsets in order
+func (o *lineOffsets) addAll(offs []int64) {
+	for _, off := range offs {
+		o.add(off)
+	}
+}
+
+// at returns the offset of line i
+func (o *lineOffsets) at(i int) int64 {
+	block := i / offsetBlock
+	off := o.bases[block]
+	for j := block*offsetBlock + 1; j <= i; j++ {
+		off += o.delta(j)
+	}
+	return off
+}
+
+// next returns the offset of line i+1 given off, the offset of line i
+func (o *lineOffsets) next(i int, off int64) int64 {
+	i++
+	if i%offsetBlock == 0 {
+		return o.bases[i/offsetBlock]
+	}
+	return off + o.delta(i)
+}
+
+func (o *lineOffsets) delta(i int) int64 {
+	if d := o.deltas[i]; d != longDelta {
+		return int64(d)
+	}
+	return o.long[i]
+}
+
+// slice returns the offsets of lines [from, to)
+func (o *lineOffsets) slice(from, to int) []int64 {
+	if from >= to {
+		return nil
+	}
+	offs := make([]int64, 0, to-from)
+	off := o.at(from)
+	offs = append(offs, off)
+	for i := from; i+1 < to; i++ {
+		off = o.next(i, off)
+		offs = append(offs, off)
+	}
+	return offs
+}
+
+// forEach calls fn with every offset in order
+func (o *lineOffsets) forEach(fn func(off int64)) {
+	var off int64
+	for i := range o.deltas {
+		if i%offsetBlock == 0 {
+			off = o.bases[i/offsetBlock]
+		} else {
+			off += o.delta(i)
+		}
+		fn(off)
+	}
+}
+
+// snapshot returns a copy that later appends to o don't affect
+func (o *lineOffsets) snapshot() *lineOffsets {
+	return &lineOffsets{
+		bases:  o.bases[:len(o.bases):len(o.bases)],
+		deltas: o.deltas[:len(o.deltas):len(o.deltas)],
+		long:   maps.Clone(o.long),
+		last:   o.last,
+	}
+}
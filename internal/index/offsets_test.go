@@ -0,0 +1,194 @@
+package index
+
+import (
+	"fmt"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	mlessio "github.com/TimelordUK/mless/internal/io"
+)
+
+// TestLineOffsetsMatchPlain compares the compact encoding against a plain
+// slice, with lines long enough to overflow a uint16 length.
+func TestLineOffsetsMatchPlain(t *testing.T) {
+	rng := rand.New(rand.NewSource(6))
+	var o lineOffsets
+	var want []int64
+	var off int64
+	for i := 0; i < 5000; i++ {
+		o.add(off)
+		want = append(want, off)
+		switch {
+		case i%500 == 0:
+			off += int64(longDelta) + rng.Int63n(1<<20)
+		case i%700 == 0:
+			off += longDelta // exactly the escape value
+		default:
+			off += 1 + rng.Int63n(300)
+		}
+	}
+	snap := o.snapshot()
+	o.add(off) // later appends must not show through the snapshot
+
+	if snap.len() != len(want) {
+		t.Fatalf("len: got %d, want %d", snap.len(), len(want))
+	}
+	for i, w := range want {
+		if got := snap.at(i); got != w {
+			t.Fatalf("at(%d) = %d, want %d", i, got, w)
+		}
+		if i+1 < len(want) {
+			if got := snap.next(i, w); got != want[i+1] {
+				t.Fatalf("next(%d) = %d, want %d", i, got, want[i+1])
+			}
+		}
+	}
+
+	i := 0
+	snap.forEach(func(off int64) {
+		if off != want[i] {
+			t.Fatalf("forEach %d: got %d, want %d", i, off, want[i])
+		}
+		i++
+	})
+
+	got := snap.slice(60, 200)
+	for k, off := range got {
+		if off != want[60+k] {
+			t.Fatalf("slice %d: got %d, want %d", k, off, want[60+k])
+		}
+	}
+}
+
+// TestLineTimesZones checks cached timestamps keep their zones, and that
+// "unparsed" and "no timestamp" stay distinct.
+func TestLineTimesZones(t *testing.T) {
+	var lt lineTimes
+	utc := time.Date(2024, 1, 15, 10, 30, 45, 123e6, time.UTC)
+	est := time.Date(2024, 1, 15, 5, 30, 45, 0, time.FixedZone("", -5*3600))
+
+	lt.set(3, &utc)
+	lt.set(4, nil)
+	lt.set(timeChunk+1, &est)
+
+	if ts, parsed := lt.get(3); !parsed || !ts.Equal(utc) || ts.Location() != time.UTC {
+		t.Fatalf("line 3: got %v %v", ts, parsed)
+	}
+	if ts, parsed := lt.get(4); !parsed || ts != nil {
+		t.Fatalf("line 4 should be parsed with no timestamp, got %v %v", ts, parsed)
+	}
+	if _, parsed := lt.get(5); parsed {
+		t.Fatal("line 5 was never parsed")
+	}
+	if _, parsed := lt.get(10 * timeChunk); parsed {
+		t.Fatal("line in an untouched chunk was never parsed")
+	}
+	ts, parsed := lt.get(timeChunk + 1)
+	if !parsed || !ts.Equal(est) {
+		t.Fatalf("second chunk: got %v %v", ts, parsed)
+	}
+	if _, offset := ts.Zone(); offset != -5*3600 {
+		t.Fatalf("zone offset lost: %v", ts)
+	}
+	if lt.zone[0] != nil {
+		t.Fatal("a chunk with only the first zone shouldn't store zone indexes")
+	}
+}
+
+// writeLargeLog writes n lines shaped like large.log.
+func writeLargeLog(b *testing.B, n int) string {
+	b.Helper()
+	levels := []string{"DBG", "INF", "WRN", "ERR"}
+	components := []string{"Scheduler", "FileProcessor", "Metrics", "AuthService"}
+	var sb strings.Builder
+	start := time.Date(2025, 11, 21, 17, 36, 15, 0, time.UTC)
+	for i := 0; i < n; i++ {
+		ts := start.Add(time.Duration(i) * 37 * time.Millisecond)
+		fmt.Fprintf(&sb, "%s [%s] %s: request %d completed in %dms\n",
+			ts.Format("2006-01-02 15:04:05.000"), levels[i%4], components[i%3], i, i%250)
+	}
+	path := filepath.Join(b.TempDir(), "large.log")
+	if err := os.WriteFile(path, []byte(sb.String()), 0o644); err != nil {
+		b.Fatal(err)
+	}
+	return path
+}
+
+func heapInUse() uint64 {
+	runtime.GC()
+	var ms runtime.MemStats
+	runtime.ReadMemStats(&ms)
+	return ms.HeapAlloc
+}
+
+// BenchmarkIndexMemory reports heap bytes per line for the offsets plus a
+// timestamp for every line, in the compact form and in the plain []int64 and
+// []*time.Time form it replaced.
+func BenchmarkIndexMemory(b *testing.B) {
+	const n = 1_000_000
+	path := writeLargeLog(b, n)
+	f, err := mlessio.Open(path)
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer f.Close()
+
+	b.Run("compact", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			before := heapInUse()
+			idx, err := BuildLineIndex(f)
+			if err != nil {
+				b.Fatal(err)
+			}
+			for line := 0; line < idx.LineCount(); line++ {
+				idx.GetTimestamp(line)
+			}
+			b.ReportMetric(float64(heapInUse()-before)/n, "B/line")
+			runtime.KeepAlive(idx)
+		}
+	})
+
+	b.Run("plain", func(b *testing.B) {
+		idx, err := BuildLineIndex(f)
+		if err != nil {
+			b.Fatal(err)
+		}
+		for i := 0; i < b.N; i++ {
+			before := heapInUse()
+			offsets := make([]int64, 0, n)
+			idx.offsets.forEach(func(off int64) { offsets = append(offsets, off) })
+			timestamps := make([]*time.Time, len(offsets))
+			for line := range timestamps {
+				timestamps[line] = idx.lineTimestamp(line)
+			}
+			b.ReportMetric(float64(heapInUse()-before)/n, "B/line")
+			runtime.KeepAlive(offsets)
+			runtime.KeepAlive(timestamps)
+		}
+	})
+}
+
+// BenchmarkGetLine measures random line reads through the compact offsets.
+func BenchmarkGetLine(b *testing.B) {
+	path := writeLargeLog(b, 200_000)
+	f, err := mlessio.Open(path)
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer f.Close()
+	idx, err := BuildLineIndex(f)
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	rng := rand.New(rand.NewSource(7))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		idx.GetLine(rng.Intn(idx.LineCount()))
+	}
+}
@@ -0,0 +1,126 @@
+package index
+
+import "github.com/TimelordUK/mless/pkg/logformat"
+
+// recordSpan is how many lines' head flags are worked out together, and how
+// long a run of continuation lines may get before it is split: a span with
+// no head line at all starts a new record at its first line, so a file
+// without timestamps or levels doesn't become one giant record.
+const recordSpan = 4096
+
+// headSpan holds the head flags of one recordSpan of lines
+type headSpan struct {
+	bits [recordSpan / 64]uint64
+	n    int // lines classified so far (the span may still be growing)
+	any  bool
+}
+
+// A record is a log line with a timestamp or level (its head) plus the
+// continuation lines after it: stack trace frames, wrapped messages,
+// pretty-printed payloads. Heads are classified lazily, a span at a time.
+
+// isHead reports whether line has a timestamp or a level of its own
+func (idx *LineIndex) isHead(line int) bool {
+	span := idx.headSpan(line)
+	k := line % recordSpan
+	return span.bits[k/64]&(1<<(k%64)) != 0
+}
+
+// headSpan returns the span containing line, classifying any of its lines
+// that weren't before
+func (idx *LineIndex) headSpan(line int) *headSpan {
+	idx.headsMu.Lock()
+	defer idx.headsMu.Unlock()
+
+	s := line / recordSpan
+	for len(idx.heads) <= s {
+		idx.heads = append(idx.heads, &headSpan{})
+	}
+	span := idx.heads[s]
+	if line%recordSpan < span.n {
+		return span
+	}
+
+	first := s * recordSpan
+	end := first + recordSpan
+	count := idx.LineCount()
+	if end > count {
+		end = count
+	}
+	for l := first + span.n; l < end; l++ {
+		k := l - first
+		if idx.classify(l) {
+			span.bits[k/64] |= 1 << (k % 64)
+		} else {
+			span.bits[k/64] &^= 1 << (k % 64)
+		}
+	}
+	span.n = end - first
+	if end == count && span.n > 0 {
+		// The last line may still be being written: look at it again next time
+		span.n--
+	}
+	span.any = false
+	for _, w := range span.bits {
+		if w != 0 {
+			span.any = true
+			break
+		}
+	}
+	return span
+}
+
+// classify reports whether line starts a record. Levels were detected while
+// indexing, so they are checked before parsing a timestamp.
+func (idx *LineIndex) classify(line int) bool {
+	if level, _ := idx.Level(line); level != logformat.LevelUnknown {
+		return true
+	}
+	start, end, ok := idx.lineBounds(line)
+	if !ok {
+		return false
+	}
+	content, err := idx.readPrefix(start, end)
+	if err != nil {
+		return false
+	}
+	return idx.tsParser.Parse(content) != nil
+}
+
+// isRecordStart reports whether a record begins at line
+func (idx *LineIndex) isRecordStart(line int) bool {
+	if line == 0 || idx.isHead(line) {
+		return true
+	}
+	if line%recordSpan != 0 {
+		return false
+	}
+	// A whole span of continuation lines before this one: split here
+	return !idx.headSpan(line - 1).any
+}
+
+// RecordStart returns the first line of the record containing line
+func (idx *LineIndex) RecordStart(line int) int {
+	for line > 0 && !idx.isRecordStart(line) {
+		line--
+	}
+	return line
+}
+
+// RecordEnd returns the line after the last line of the record containing
+// line. While the file is growing the last record may still gain lines.
+func (idx *LineIndex) RecordEnd(line int) int {
+	count := idx.LineCount()
+	end := line + 1
+	for end < count && !idx.isRecordStart(end) {
+		end++
+	}
+	return end
+}
+
+// RecordLevel returns the level of the first line of the record containing
+// line, which its continuation lines share
+func (idx *LineIndex) RecordLevel(line int) logformat.LogLevel {
+	level, _ := idx.Level(idx.RecordStart(line))
+	return level
+}
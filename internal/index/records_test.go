@@ -0,0 +1,71 @@
+package index
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/TimelordUK/mless/pkg/logformat"
+)
+
+func recordIndex(t *testing.T, lines []string) *LineIndex {
+	t.Helper()
+	idx, err := NewLineIndexWithOptions(openTemp(t, strings.Join(lines, "\n")+"\n"), detectOptions())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return idx
+}
+
+func TestRecordGrouping(t *testing.T) {
+	idx := recordIndex(t, []string{
+		"preamble without a level",
+		"2024-01-15 10:00:00 INFO starting",
+		"2024-01-15 10:00:01 ERROR request failed",
+		"java.lang.IllegalStateException: boom",
+		"    at com.example.Main.run(Main.java:10)",
+		"    at com.example.Main.main(Main.java:3)",
+		"2024-01-15 10:00:02 message with only a timestamp",
+		"  {",
+		"  }",
+		"WARN no timestamp but a level",
+	})
+
+	records := [][2]int{{0, 1}, {1, 2}, {2, 6}, {6, 9}, {9, 10}}
+	for _, r := range records {
+		for line := r[0]; line < r[1]; line++ {
+			if start, end := idx.RecordStart(line), idx.RecordEnd(line); start != r[0] || end != r[1] {
+				t.Errorf("line %d: record [%d, %d), want [%d, %d)", line, start, end, r[0], r[1])
+			}
+		}
+	}
+
+	for _, line := range []int{2, 3, 5} {
+		if got := idx.RecordLevel(line); got != logformat.LevelError {
+			t.Errorf("RecordLevel(%d) = %v, want error", line, got)
+		}
+	}
+	if got := idx.RecordLevel(8); got != logformat.LevelUnknown {
+		t.Errorf("RecordLevel(8) = %v, want unknown", got)
+	}
+}
+
+// TestRecordSpanSplit checks a long run of continuation lines is cut into
+// records of at most two spans instead of one giant record.
+func TestRecordSpanSplit(t *testing.T) {
+	lines := []string{"2024-01-15 10:00:00 ERROR dump follows"}
+	for i := 1; i < 3*recordSpan; i++ {
+		lines = append(lines, fmt.Sprintf("    payload %d", i))
+	}
+	idx := recordIndex(t, lines)
+
+	if end := idx.RecordEnd(0); end != 2*recordSpan {
+		t.Fatalf("first record ends at %d, want %d", end, 2*recordSpan)
+	}
+	if start := idx.RecordStart(2*recordSpan + 5); start != 2*recordSpan {
+		t.Fatalf("split record starts at %d, want %d", start, 2*recordSpan)
+	}
+	if end := idx.RecordEnd(2 * recordSpan); end != len(lines) {
+		t.Fatalf("last record ends at %d, want %d", end, len(lines))
+	}
+}
@@ -0,0 +1,71 @@
+package index
+
+import (
+	"time"
+
+	"github.com/TimelordUK/mless/pkg/logformat"
+)
+
+// rolloverSlack is how far a timestamp whose date was inferred may fall
+// behind the sampled one before it before the clock is taken to have wrapped:
+// past midnight for time-only timestamps, past New Year for year-less ones
+const rolloverSlack = time.Hour
+
+// rolloverLookback bounds how many samples back a line looks for a timestamp
+// to date itself against
+const rolloverLookback = 64
+
+// parseTime parses the timestamp of line, whose content is given. The parser
+// dates timestamps without a date (or year) on the file's base date, the
+// date of its first line; they are then moved on past every midnight (New
+// Year) the log has wrapped through since, going by the sampled timestamp
+// before line, which was dated the same way.
+func (idx *LineIndex) parseTime(line int, content []byte) *time.Time {
+	ts, inferred := idx.tsParser.ParseInferred(content)
+	if ts == nil || inferred == logformat.InferredNone {
+		return ts
+	}
+	if prev := idx.sampledTimeBefore(line); prev != nil {
+		t := unwrap(*ts, inferred, *prev)
+		ts = &t
+	}
+	return ts
+}
+
+// sampledTimeBefore returns the timestamp of the last sample taken from a
+// line before line, or nil if there is none within rolloverLookback samples
+func (idx *LineIndex) sampledTimeBefore(line int) *time.Time {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+
+	k := min(line/sampleEvery, len(idx.samples)-1)
+	for j := k; j >= 0 && j > k-rolloverLookback; j-- {
+		if s := idx.samples[j]; s.Timestamp != nil && s.TimeLine < line {
+			return s.Timestamp
+		}
+	}
+	return nil
+}
+
+// unwrap moves ts on by whole days (years, for InferredYear) until it is no
+// more than rolloverSlack before prev
+func unwrap(ts time.Time, inferred logformat.Inferred, prev time.Time) time.Time {
+	floor := prev.Add(-rolloverSlack)
+	if !ts.Before(floor) {
+		return ts
+	}
+	if inferred == logformat.InferredYear {
+		if years := floor.Year() - ts.Year() - 1; years > 0 {
+			ts = ts.AddDate(years, 0, 0)
+		}
+		for ts.Before(floor) {
+			ts = ts.AddDate(1, 0, 0)
+		}
+		return ts
+	}
+	ts = ts.AddDate(0, 0, int(floor.Sub(ts)/(24*time.Hour)))
+	for ts.Before(floor) {
+		ts = ts.AddDate(0, 0, 1)
+	}
+	return ts
+}
@@ -0,0 +1,67 @@
+package index
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/TimelordUK/mless/pkg/logformat"
+)
+
+// TestRolloverAcrossSamples checks time-only timestamps are moved on a day
+// each time the clock wraps past midnight, however many samples later, and
+// that time search sees the dates so worked out.
+func TestRolloverAcrossSamples(t *testing.T) {
+	withChunkSize(t, 4096)
+	start := time.Date(2024, 3, 9, 22, 0, 0, 0, time.Local)
+	const n = 6000
+	step := 30 * time.Second // 50 hours: over two midnights
+	lines := make([]string, n)
+	for i := range lines {
+		lines[i] = fmt.Sprintf("%s INFO tick %d", start.Add(time.Duration(i)*step).Format("15:04:05"), i)
+	}
+
+	timestamps := logformat.NewTimestampParser()
+	timestamps.SetBaseDate(start)
+	idx, err := NewLineIndexWithOptions(openTemp(t, strings.Join(lines, "\n")+"\n"), Options{Timestamps: timestamps})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer idx.Close()
+	if err := idx.Wait(t.Context()); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, i := range []int{0, 239, 240, 1023, 1024, 3119, 3120, 3121, n - 1} {
+		want := start.Add(time.Duration(i) * step)
+		if got := idx.GetTimestamp(i); got == nil || !got.Equal(want) {
+			t.Fatalf("line %d: got %v, want %v", i, got, want)
+		}
+	}
+	target := time.Date(2024, 3, 11, 0, 0, 0, 0, time.Local)
+	if got, want := idx.FindLineAtTime(target), int(target.Sub(start)/step); got != want {
+		t.Fatalf("FindLineAtTime(%v) = %d, want %d", target, got, want)
+	}
+}
+
+func TestUnwrap(t *testing.T) {
+	at := func(y int, mo time.Month, d, h int) time.Time {
+		return time.Date(y, mo, d, h, 0, 0, 0, time.Local)
+	}
+	tests := []struct {
+		ts, prev, want time.Time
+		inferred       logformat.Inferred
+	}{
+		{at(2024, 3, 9, 23), at(2024, 3, 9, 22), at(2024, 3, 9, 23), logformat.InferredDate},
+		{at(2024, 3, 9, 22), at(2024, 3, 9, 23), at(2024, 3, 9, 22), logformat.InferredDate}, // within the slack
+		{at(2024, 3, 9, 1), at(2024, 3, 9, 23), at(2024, 3, 10, 1), logformat.InferredDate},
+		{at(2024, 3, 9, 1), at(2024, 3, 12, 23), at(2024, 3, 13, 1), logformat.InferredDate},
+		{at(2024, 1, 1, 0), at(2024, 12, 31, 23), at(2025, 1, 1, 0), logformat.InferredYear},
+	}
+	for _, tt := range tests {
+		if got := unwrap(tt.ts, tt.inferred, tt.prev); !got.Equal(tt.want) {
+			t.Errorf("unwrap(%v, %v, %v) = %v, want %v", tt.ts, tt.inferred, tt.prev, got, tt.want)
+		}
+	}
+}
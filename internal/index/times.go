@@ -0,0 +1,103 @@
+package index
+
+import (
+	"math"
+	"time"
+)
+
+// timeChunk is how many lines' timestamps are allocated together; only
+// chunks containing a parsed line take memory
+const timeChunk = 4096
+
+const (
+	// tsUnparsed is the zero value: the line hasn't been parsed yet. (A
+	// timestamp of exactly the epoch is indistinguishable, and just gets
+	// parsed again.)
+	tsUnparsed int64 = 0
+	// tsNone records that the line has no timestamp
+	tsNone int64 = math.MinInt64
+)
+
+// maxZones bounds the location palette so zone indexes fit in a byte
+const maxZones = math.MaxUint8 + 1
+
+// lineTimes caches parsed line timestamps as unix nanoseconds: 8 bytes per
+// line, in the chunks that have been touched, instead of a pointer and a
+// heap-allocated time.Time per line.
+//
+// Locations (so times display in the log's own zone) come from a small
+// palette. Nearly every file uses one zone, so per-line zone indexes are
+// only allocated for chunks where a second one shows up.
+type lineTimes struct {
+	ns    [][]int64 // per chunk, nil until a line in it is parsed
+	zone  [][]uint8 // per chunk, nil while every timestamp in it is in zones[0]
+	zones []zone
+}
+
+type zone struct {
+	name   string
+	offset int
+	loc    *time.Location
+}
+
+// get returns the cached timestamp of line i; parsed is false if it hasn't
+// been parsed yet
+func (t *lineTimes) get(i int) (ts *time.Time, parsed bool) {
+	c, k := i/timeChunk, i%timeChunk
+	if c >= len(t.ns) || t.ns[c] == nil {
+		return nil, false
+	}
+	switch v := t.ns[c][k]; v {
+	case tsUnparsed:
+		return nil, false
+	case tsNone:
+		return nil, true
+	default:
+		z := 0
+		if t.zone[c] != nil {
+			z = int(t.zone[c][k])
+		}
+		tm := time.Unix(0, v).In(t.zones[z].loc)
+		return &tm, true
+	}
+}
+
+// set caches the timestamp of line i (nil: the line has none)
+func (t *lineTimes) set(i int, ts *time.Time) {
+	c, k := i/timeChunk, i%timeChunk
+	for len(t.ns) <= c {
+		t.ns = append(t.ns, nil)
+		t.zone = append(t.zone, nil)
+	}
+	if t.ns[c] == nil {
+		t.ns[c] = make([]int64, timeChunk)
+	}
+
+	if ts == nil {
+		t.ns[c][k] = tsNone
+		return
+	}
+	t.ns[c][k] = ts.UnixNano()
+	if z := t.zoneIndex(ts); z != 0 || t.zone[c] != nil {
+		if t.zone[c] == nil {
+			t.zone[c] = make([]uint8, timeChunk)
+		}
+		t.zone[c][k] = uint8(z)
+	}
+}
+
+// zoneIndex returns the palette index of ts's zone, adding it if there's
+// room (past that, times are shown in the first zone)
+func (t *lineTimes) zoneIndex(ts *time.Time) int {
+	name, offset := ts.Zone()
+	for i, z := range t.zones {
+		if z.name == name && z.offset == offset {
+			return i
+		}
+	}
+	if len(t.zones) == maxZones {
+		return 0
+	}
+	t.zones = append(t.zones, zone{name: name, offset: offset, loc: ts.Location()})
+	return len(t.zones) - 1
+}
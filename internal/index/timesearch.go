@@ -0,0 +1,105 @@
+package index
+
+import (
+	"sort"
+	"time"
+)
+
+// timeJitter is how far timestamps may run backwards (lines from concurrent
+// threads, buffered writers) without throwing off the binary search
+var timeJitter = time.Second
+
+// unorderedFraction is the share of checkpoints that may step back by more
+// than timeJitter before a file is treated as unordered, so one stray old
+// timestamp doesn't disable the search
+const unorderedFraction = 0.01
+
+// checkpoints returns the lines of the sampled timestamps and the running
+// maximum of those timestamps, which is ordered even where the log jitters.
+// ordered is false when too many timestamps go backwards for a search on
+// them to be meaningful.
+func (idx *LineIndex) checkpoints() (lines []int, maxTs []time.Time, ordered bool) {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+
+	backwards := 0
+	for _, s := range idx.samples {
+		if s.Timestamp == nil {
+			continue
+		}
+		ts := *s.Timestamp
+		if n := len(maxTs); n > 0 {
+			if ts.Before(maxTs[n-1].Add(-timeJitter)) {
+				backwards++
+			}
+			if ts.Before(maxTs[n-1]) {
+				ts = maxTs[n-1]
+			}
+		}
+		lines = append(lines, s.TimeLine)
+		maxTs = append(maxTs, ts)
+	}
+	return lines, maxTs, float64(backwards) <= unorderedFraction*float64(len(maxTs))
+}
+
+// FindLineAtTime finds the first line at or after the given time.
+// Returns -1 if no such line exists.
+//
+// The timestamp checkpoints taken while indexing narrow the search to the
+// stretch of lines around target, which is then scanned; files whose
+// timestamps aren't in order are scanned from the start.
+func (idx *LineIndex) FindLineAtTime(target time.Time) int {
+	lines, maxTs, ordered := idx.checkpoints()
+	if !ordered {
+		return idx.scanForTime(0, target)
+	}
+
+	// Every line before a checkpoint whose running maximum is more than
+	// timeJitter short of target is itself before target
+	bound := target.Add(-timeJitter)
+	i := sort.Search(len(maxTs), func(i int) bool {
+		return !maxTs[i].Before(bound)
+	})
+	start := 0
+	if i > 0 {
+		start = lines[i-1]
+	}
+	return idx.scanForTime(start, target)
+}
+
+// FindLineBeforeTime finds the last line before the given time: the last
+// line with a timestamp ahead of the first one at or after it
+func (idx *LineIndex) FindLineBeforeTime(target time.Time) int {
+	end := idx.FindLineAtTime(target)
+	if end < 0 {
+		end = idx.LineCount()
+	}
+	for i := end - 1; i >= 0; i-- {
+		if idx.lineTimestamp(i) != nil {
+			return i
+		}
+	}
+	return -1
+}
+
+// scanForTime returns the first line from start on with a timestamp at or
+// after target, or -1
+func (idx *LineIndex) scanForTime(start int, target time.Time) int {
+	count := idx.LineCount()
+	for i := start; i < count; i++ {
+		if ts := idx.lineTimestamp(i); ts != nil && !ts.Before(target) {
+			return i
+		}
+	}
+	return -1
+}
+
+// lineTimestamp parses a line's timestamp without caching it (searches touch
+// few lines, and caching would allocate a slot for every line)
+func (idx *LineIndex) lineTimestamp(lineNum int) *time.Time {
+	content, err := idx.GetLine(lineNum)
+	if err != nil || content == nil {
+		return nil
+	}
+	return idx.parseTime(lineNum, content)
+}
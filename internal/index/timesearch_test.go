@@ -0,0 +1,184 @@
+package index
+
+import (
+	"fmt"
+	"math/rand"
+	"strings"
+	"testing"
+	"time"
+
+	mlessio "github.com/TimelordUK/mless/internal/io"
+	"github.com/TimelordUK/mless/pkg/logformat"
+)
+
+var logStart = time.Date(2024, 1, 15, 10, 0, 0, 0, time.UTC)
+
+// timedLog builds n lines about 10ms apart (at may move them), shifted by up
+// to ±jitter, with continuation lines and occasional stack traces longer than
+// sampleProbe.
+func timedLog(n int, jitter time.Duration, at func(i int, base time.Time) *time.Time) []string {
+	rng := rand.New(rand.NewSource(3))
+	var lines []string
+	for i := 0; len(lines) < n; i++ {
+		base := logStart.Add(time.Duration(i) * 10 * time.Millisecond)
+		if jitter > 0 {
+			base = base.Add(time.Duration(rng.Int63n(int64(2*jitter))) - jitter)
+		}
+		ts := at(i, base)
+		switch {
+		case ts == nil || i%7 == 0:
+			lines = append(lines, fmt.Sprintf("    continuation of %d", i))
+		case i%997 == 0:
+			for k := 0; k < 3*sampleProbe; k++ {
+				lines = append(lines, fmt.Sprintf("    at frame%d (Main.java:%d)", k, i))
+			}
+		default:
+			lines = append(lines, fmt.Sprintf("%s INFO request %d", ts.Format("2006-01-02 15:04:05.000"), i))
+		}
+	}
+	return lines[:n]
+}
+
+// linearAtTime is the reference: the first line with a timestamp at or after
+// target.
+func linearAtTime(stamps []*time.Time, target time.Time) int {
+	for i, ts := range stamps {
+		if ts != nil && !ts.Before(target) {
+			return i
+		}
+	}
+	return -1
+}
+
+func linearBeforeTime(stamps []*time.Time, target time.Time) int {
+	last := -1
+	for i, ts := range stamps {
+		if ts != nil {
+			if !ts.Before(target) {
+				break
+			}
+			last = i
+		}
+	}
+	return last
+}
+
+func checkTimeSearch(t *testing.T, lines []string, targets int, wantOrdered bool) {
+	t.Helper()
+	idx, err := BuildLineIndex(openTemp(t, strings.Join(lines, "\n")+"\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, ordered := idx.checkpoints(); ordered != wantOrdered {
+		t.Fatalf("ordered = %v, want %v", ordered, wantOrdered)
+	}
+
+	p := logformat.NewTimestampParser()
+	stamps := make([]*time.Time, len(lines))
+	for i, l := range lines {
+		stamps[i] = p.Parse([]byte(l))
+	}
+
+	rng := rand.New(rand.NewSource(4))
+	span := time.Duration(len(lines)) * 10 * time.Millisecond
+	for k := 0; k < targets; k++ {
+		target := logStart.Add(time.Duration(rng.Int63n(int64(span+2*time.Minute))) - time.Minute)
+		if got, want := idx.FindLineAtTime(target), linearAtTime(stamps, target); got != want {
+			t.Fatalf("FindLineAtTime(%v) = %d, want %d", target, got, want)
+		}
+		if got, want := idx.FindLineBeforeTime(target), linearBeforeTime(stamps, target); got != want {
+			t.Fatalf("FindLineBeforeTime(%v) = %d, want %d", target, got, want)
+		}
+	}
+}
+
+func TestTimeSearchOrdered(t *testing.T) {
+	lines := timedLog(50000, 0, func(i int, base time.Time) *time.Time { return &base })
+	checkTimeSearch(t, lines, 100, true)
+}
+
+// TestTimeSearchJitter covers timestamps a few hundred ms out of order, plus
+// one stray timestamp from long ago, which shouldn't disable the search.
+func TestTimeSearchJitter(t *testing.T) {
+	lines := timedLog(200*sampleEvery, timeJitter/3, func(i int, base time.Time) *time.Time { return &base })
+	stray := logStart.AddDate(-1, 0, 0).Format("2006-01-02 15:04:05.000")
+	lines[50*sampleEvery] = stray + " WARN clock reset"
+	checkTimeSearch(t, lines, 100, true)
+}
+
+// TestTimeSearchUnordered checks shuffled timestamps fall back to a scan.
+func TestTimeSearchUnordered(t *testing.T) {
+	rng := rand.New(rand.NewSource(5))
+	perm := rng.Perm(5000)
+	lines := timedLog(5000, 0, func(i int, base time.Time) *time.Time {
+		ts := logStart.Add(time.Duration(perm[i]) * 10 * time.Millisecond)
+		return &ts
+	})
+	checkTimeSearch(t, lines, 30, false)
+}
+
+// TestTimestampFormats checks each built-in format is read, including after a
+// line in another format has pinned that one.
+func TestTimestampFormats(t *testing.T) {
+	plus1 := time.FixedZone("", 3600)
+	base := time.Date(2024, 1, 15, 0, 0, 0, 0, time.Local)
+	tests := []struct {
+		line string
+		want time.Time
+	}{
+		{"2024-01-15T10:30:45.123Z INFO iso", time.Date(2024, 1, 15, 10, 30, 45, 123e6, time.UTC)},
+		{"2024-01-15T10:30:45.123456789+01:00 INFO nanos", time.Date(2024, 1, 15, 10, 30, 45, 123456789, plus1)},
+		{"2024-01-15T10:30:45+0100 INFO compact zone", time.Date(2024, 1, 15, 10, 30, 45, 0, plus1)},
+		{"2024-01-15 10:30:45.123 INFO space", time.Date(2024, 1, 15, 10, 30, 45, 123e6, time.UTC)},
+		{"2024-01-15 10:30:45,123 INFO log4j", time.Date(2024, 1, 15, 10, 30, 45, 123e6, time.UTC)},
+		{"2024-01-15 10:30:45.123456 INFO micros", time.Date(2024, 1, 15, 10, 30, 45, 123456e3, time.UTC)},
+		{"[2024-01-15 10:30:45] INFO bracket", time.Date(2024, 1, 15, 10, 30, 45, 0, time.UTC)},
+		{"Jan 15 10:30:45 host app: syslog", time.Date(2024, 1, 15, 10, 30, 45, 0, time.Local)},
+		{"Jan  5 10:30:45 host app: padded day", time.Date(2024, 1, 5, 10, 30, 45, 0, time.Local)},
+		{`127.0.0.1 - - [15/Jan/2024:10:30:45 +0100] "GET / HTTP/1.1" 200`, time.Date(2024, 1, 15, 10, 30, 45, 0, plus1)},
+		{"1705315845 INFO epoch", time.Unix(1705315845, 0)},
+		{"1705315845.250 INFO fractional epoch", time.Unix(1705315845, 250e6)},
+		{"1705315845123 INFO epoch ms", time.UnixMilli(1705315845123)},
+		{"10:30:45,5 INFO time only", time.Date(2024, 1, 15, 10, 30, 45, 5e8, time.Local)},
+		{"2024-01-15 10:30:45.123 INFO iso again", time.Date(2024, 1, 15, 10, 30, 45, 123e6, time.UTC)},
+	}
+	timestamps := logformat.NewTimestampParser()
+	timestamps.SetBaseDate(base)
+	for _, tt := range tests {
+		if got := timestamps.Parse([]byte(tt.line)); got == nil || !got.Equal(tt.want) {
+			t.Errorf("%q: got %v, want %v", tt.line, got, tt.want)
+		}
+	}
+
+	for _, line := range []string{
+		"2024-13-15 10:30:45 bad month",
+		"2024-02-30 10:30:45 bad day",
+		"2024-01-15 25:30:45 bad hour",
+		"    at Main.run(Main.java:42)",
+	} {
+		if ts := timestamps.Parse([]byte(line)); ts != nil {
+			t.Errorf("%q: got %v, want none", line, ts)
+		}
+	}
+}
+
+// BenchmarkGetTimestamp measures reading the timestamp of random lines, as
+// time search does.
+func BenchmarkGetTimestamp(b *testing.B) {
+	path := writeLargeLog(b, 200_000)
+	f, err := mlessio.Open(path)
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer f.Close()
+	idx, err := BuildLineIndex(f)
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	rng := rand.New(rand.NewSource(7))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		idx.GetTimestamp(rng.Intn(idx.LineCount()))
+	}
+}
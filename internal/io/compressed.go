@@ -0,0 +1,552 @@
+package io
+
+import (
+	"bufio"
+	"bytes"
+	"compress/bzip2"
+	"compress/flate"
+	"container/list"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"sync"
+	"sync/atomic"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/ulikunitz/xz"
+)
+
+// Compression identifies a compressed container format
+type Compression int
+
+const (
+	CompressionNone Compression = iota
+	CompressionGzip
+	CompressionZstd
+	CompressionBzip2
+	CompressionXz
+)
+
+func (c Compression) String() string {
+	switch c {
+	case CompressionGzip:
+		return "gzip"
+	case CompressionZstd:
+		return "zstd"
+	case CompressionBzip2:
+		return "bzip2"
+	case CompressionXz:
+		return "xz"
+	default:
+		return "none"
+	}
+}
+
+// DetectCompression identifies a format from the leading bytes of a file
+func DetectCompression(head []byte) Compression {
+	switch {
+	case bytes.HasPrefix(head, []byte{0x1f, 0x8b}):
+		return CompressionGzip
+	case bytes.HasPrefix(head, []byte{0x28, 0xb5, 0x2f, 0xfd}):
+		return CompressionZstd
+	case bytes.HasPrefix(head, []byte("BZh")):
+		return CompressionBzip2
+	case bytes.HasPrefix(head, []byte{0xfd, '7', 'z', 'X', 'Z', 0x00}):
+		return CompressionXz
+	default:
+		return CompressionNone
+	}
+}
+
+// checkpointSpan is how much output separates gzip seek points. Each one keeps
+// a 32KB window, so this trades memory (~0.4% of the decompressed size) for
+// the worst-case decode needed to reach an arbitrary offset.
+var checkpointSpan int64 = 8 << 20
+
+const (
+	// blockSize is the unit decompressed output is cached in
+	blockSize = 256 << 10
+	// cachedBlocks bounds the decompressed block cache (8MB)
+	cachedBlocks = 32
+	// maxSkip is how far ahead we decode from an open stream rather than
+	// restarting from a closer seek point
+	maxSkip = 4 << 20
+)
+
+// CompressedFile gives random access to the decompressed content of a gzip,
+// zstd, bzip2 or xz file without inflating it to disk.
+//
+// Opening learns the decompressed size and builds seek points: any deflate
+// block for gzip (see indexGzip), frame starts for zstd, and block starts for
+// bzip2 and xz (see frames.go). That takes one pass decoding the file, except
+// for xz, whose index lists its blocks. Reads are served from a small cache
+// of decompressed blocks; a miss resumes decoding from the open stream when
+// the target is just ahead of it, and from the nearest seek point otherwise.
+// A zstd file written as one frame, or an xz file as one block (as
+// single-threaded xz writes it), has a single seek point, so jumping
+// backwards in it beyond the cache means decoding from the beginning (see
+// Sequential).
+type CompressedFile struct {
+	path       string
+	kind       Compression
+	size       atomic.Int64 // decompressed size
+	sequential atomic.Bool  // see Sequential
+
+	mu     sync.Mutex // guards the file and its decoding: reads share one decoder
+	src    *os.File
+	info   os.FileInfo // identity of the compressed file, for Refresh
+	points []seekPoint
+	stream *stream
+	cache  *blockCache
+
+	refreshMu  sync.Mutex // guards Refresh's re-index of a changed file
+	reindexing bool
+	reindexed  *indexedFile
+	reindexErr error
+	closed     bool
+}
+
+// indexedFile is the file opened and indexed, ready to read from
+type indexedFile struct {
+	src    *os.File
+	info   os.FileInfo
+	points []seekPoint
+	size   int64
+}
+
+// stream is an open decoder positioned somewhere in the output
+type stream struct {
+	r     io.Reader
+	close func()
+	pos   int64 // uncompressed offset of the next byte r yields
+	point int   // seek point the stream (or its current member) started at
+}
+
+// OpenCompressed opens a compressed file of the given kind
+func OpenCompressed(path string, kind Compression) (*CompressedFile, error) {
+	c := &CompressedFile{path: path, kind: kind}
+	f, err := c.open()
+	if err != nil {
+		return nil, err
+	}
+	c.install(f)
+	return c, nil
+}
+
+// open opens the file at c.path and indexes it
+func (c *CompressedFile) open() (*indexedFile, error) {
+	src, err := os.Open(c.path)
+	if err != nil {
+		return nil, err
+	}
+	info, err := src.Stat()
+	if err != nil {
+		src.Close()
+		return nil, err
+	}
+
+	points, size, err := c.index(src, info.Size())
+	if err != nil {
+		src.Close()
+		return nil, fmt.Errorf("%s: %s: %w", c.path, c.kind, err)
+	}
+	return &indexedFile{src: src, info: info, points: points, size: size}, nil
+}
+
+// install switches reads over to f
+func (c *CompressedFile) install(f *indexedFile) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.closeStream()
+	if c.src != nil {
+		c.src.Close()
+	}
+	c.src = f.src
+	c.info = f.info
+	c.points = f.points
+	c.cache = newBlockCache(cachedBlocks)
+	c.size.Store(f.size)
+	c.sequential.Store(len(f.points) == 1 && f.size > cachedBlocks*blockSize)
+}
+
+// index makes the opening pass over the compressed data
+func (c *CompressedFile) index(src *os.File, rawSize int64) ([]seekPoint, int64, error) {
+	switch c.kind {
+	case CompressionGzip:
+		return indexGzip(io.NewSectionReader(src, 0, rawSize), checkpointSpan)
+	case CompressionZstd:
+		starts, err := zstdFrames(src, rawSize)
+		if err != nil {
+			return nil, 0, err
+		}
+		return c.indexFrames(src, rawSize, starts)
+	case CompressionBzip2:
+		return indexBzip2(src, rawSize)
+	case CompressionXz:
+		return indexXz(src, rawSize)
+	}
+	return nil, 0, fmt.Errorf("unsupported compression %s", c.kind)
+}
+
+// indexFrames decodes each zstd frame on its own to learn where its output
+// starts, and makes the starts seek points, no closer than checkpointSpan
+func (c *CompressedFile) indexFrames(src io.ReaderAt, rawSize int64, starts []int64) ([]seekPoint, int64, error) {
+	points := []seekPoint{{memberStart: true}}
+	var out, last int64
+	for i, start := range starts {
+		if i > 0 && out-last >= checkpointSpan {
+			points = append(points, seekPoint{out: out, bit: start * 8, memberStart: true})
+			last = out
+		}
+		end := rawSize
+		if i+1 < len(starts) {
+			end = starts[i+1]
+		}
+		n, err := c.decodedSize(io.NewSectionReader(src, start, end-start))
+		if err != nil {
+			return nil, 0, err
+		}
+		out += n
+	}
+	return points, out, nil
+}
+
+// decodedSize decodes r to the end and returns how many bytes it gave
+func (c *CompressedFile) decodedSize(r io.Reader) (int64, error) {
+	dec, closeDec, err := c.decoder(r)
+	if err != nil {
+		return 0, err
+	}
+	defer closeDec()
+	return io.Copy(io.Discard, dec)
+}
+
+// decoder wraps r in a decoder for c.kind (gzip streams are opened per seek
+// point instead, see openStream)
+func (c *CompressedFile) decoder(r io.Reader) (io.Reader, func(), error) {
+	br := bufio.NewReaderSize(r, 64<<10)
+	switch c.kind {
+	case CompressionZstd:
+		d, err := zstd.NewReader(br, zstd.WithDecoderConcurrency(1))
+		if err != nil {
+			return nil, nil, err
+		}
+		return d, d.Close, nil
+	case CompressionBzip2:
+		return bzip2.NewReader(br), func() {}, nil
+	case CompressionXz:
+		d, err := xz.NewReader(br)
+		if err != nil {
+			return nil, nil, err
+		}
+		return d, func() {}, nil
+	default:
+		return nil, nil, fmt.Errorf("unsupported compression %s", c.kind)
+	}
+}
+
+// openStream starts decoding at seek point i
+func (c *CompressedFile) openStream(i int) error {
+	c.closeStream()
+	p := c.points[i]
+	end := c.info.Size()
+	if p.end > 0 {
+		end = (p.end + 7) / 8
+	}
+	raw := io.NewSectionReader(c.src, p.bit/8, end-p.bit/8)
+
+	if c.kind != CompressionGzip {
+		var in io.Reader = raw
+		if p.tail != nil {
+			in = newBitSection(bufio.NewReaderSize(raw, 64<<10), p.bit, p.end, p.tail)
+		}
+		if p.prefix != nil {
+			in = io.MultiReader(bytes.NewReader(p.prefix), in)
+		}
+		dec, closeDec, err := c.decoder(in)
+		if err != nil {
+			return err
+		}
+		c.stream = &stream{r: dec, close: closeDec, pos: p.out, point: i}
+		return nil
+	}
+
+	in := bufio.NewReaderSize(raw, 64<<10)
+	if p.bit%8 == 0 {
+		// flate is quicker, and its byte alignment is the file's here
+		fr := flate.NewReaderDict(in, p.window)
+		c.stream = &stream{r: fr, close: func() { fr.Close() }, pos: p.out, point: i}
+		return nil
+	}
+	gr, err := resumeGzip(in, p)
+	if err != nil {
+		return err
+	}
+	c.stream = &stream{r: gr, close: func() {}, pos: p.out, point: i}
+	return nil
+}
+
+func (c *CompressedFile) closeStream() {
+	if c.stream != nil {
+		c.stream.close()
+		c.stream = nil
+	}
+}
+
+// nextMember moves a stream that hit the end of one gzip member, or of the
+// bzip2 or xz stream it started in, onto the next. Returns false if there is
+// none.
+func (c *CompressedFile) nextMember() (bool, error) {
+	for i := c.stream.point + 1; i < len(c.points); i++ {
+		p := c.points[i]
+		if p.out > c.stream.pos {
+			break
+		}
+		if p.memberStart && p.out == c.stream.pos {
+			return true, c.openStream(i)
+		}
+	}
+	return false, nil
+}
+
+// fill reads exactly len(buf) bytes from the stream
+func (c *CompressedFile) fill(buf []byte) error {
+	for len(buf) > 0 {
+		n, err := c.stream.r.Read(buf)
+		c.stream.pos += int64(n)
+		buf = buf[n:]
+		if err == io.EOF && len(buf) > 0 {
+			more, merr := c.nextMember()
+			if merr != nil {
+				return merr
+			}
+			if more {
+				continue
+			}
+		}
+		if len(buf) == 0 {
+			return nil
+		}
+		if err == io.EOF {
+			return io.ErrUnexpectedEOF
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// seek positions the stream at off, reusing it when that is cheaper than
+// restarting from the nearest seek point
+func (c *CompressedFile) seek(off int64) error {
+	i := sort.Search(len(c.points), func(i int) bool { return c.points[i].out > off }) - 1
+	if i < 0 {
+		i = 0
+	}
+
+	s := c.stream
+	reuse := s != nil && s.pos <= off && (s.pos >= c.points[i].out || off-s.pos <= maxSkip)
+	if !reuse {
+		if err := c.openStream(i); err != nil {
+			return err
+		}
+	}
+
+	var scratch [32 << 10]byte
+	for skip := off - c.stream.pos; skip > 0; skip = off - c.stream.pos {
+		n := int64(len(scratch))
+		if skip < n {
+			n = skip
+		}
+		if err := c.fill(scratch[:n]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// block returns cached block i, decoding it on a miss
+func (c *CompressedFile) block(i int64) ([]byte, error) {
+	if b, ok := c.cache.get(i); ok {
+		return b, nil
+	}
+
+	start := i * blockSize
+	n := c.size.Load() - start
+	if n > blockSize {
+		n = blockSize
+	}
+	if err := c.seek(start); err != nil {
+		c.closeStream()
+		return nil, err
+	}
+	buf := make([]byte, n)
+	if err := c.fill(buf); err != nil {
+		c.closeStream()
+		return nil, err
+	}
+	c.cache.put(i, buf)
+	return buf, nil
+}
+
+// ReadAt reads decompressed bytes at offset
+func (c *CompressedFile) ReadAt(p []byte, off int64) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	n := 0
+	for n < len(p) {
+		pos := off + int64(n)
+		if pos >= c.size.Load() {
+			return n, io.EOF
+		}
+		b, err := c.block(pos / blockSize)
+		if err != nil {
+			return n, err
+		}
+		n += copy(p[n:], b[pos%blockSize:])
+	}
+	return n, nil
+}
+
+// ReadRange reads decompressed bytes from start to end
+func (c *CompressedFile) ReadRange(start, end int64) ([]byte, error) {
+	if size := c.size.Load(); end > size {
+		end = size
+	}
+	if start >= end {
+		return nil, nil
+	}
+
+	buf := make([]byte, end-start)
+	if _, err := c.ReadAt(buf, start); err != nil {
+		return nil, err
+	}
+	return buf, nil
+}
+
+// Size returns the decompressed size
+func (c *CompressedFile) Size() int64 {
+	return c.size.Load()
+}
+
+// Sequential reports whether the file has only the one seek point at its
+// start and is too big for the block cache, so that reading back through it
+// decodes it again from the top
+func (c *CompressedFile) Sequential() bool {
+	return c.sequential.Load()
+}
+
+// Path returns the file path
+func (c *CompressedFile) Path() string {
+	return c.path
+}
+
+// Compression returns the file's format
+func (c *CompressedFile) Compression() Compression {
+	return c.kind
+}
+
+// Err always returns nil; decode errors surface from the read that hit them
+func (c *CompressedFile) Err() error {
+	return nil
+}
+
+// Refresh re-opens the file if it was replaced or rewritten. Compressed logs
+// aren't appended to in place, so any change is treated as a rotation.
+// Indexing the new file means decoding all of it, so that runs in the
+// background: Refresh reports Unchanged until a later call finds it done.
+func (c *CompressedFile) Refresh() (Change, error) {
+	c.refreshMu.Lock()
+	defer c.refreshMu.Unlock()
+	if c.reindexing {
+		return Unchanged, nil
+	}
+	if f, err := c.reindexed, c.reindexErr; f != nil || err != nil {
+		c.reindexed, c.reindexErr = nil, nil
+		if err != nil {
+			return Unchanged, err
+		}
+		c.install(f)
+		return Rotated, nil
+	}
+
+	info, err := os.Stat(c.path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return Unchanged, nil
+		}
+		return Unchanged, err
+	}
+	if os.SameFile(info, c.info) && info.Size() == c.info.Size() && info.ModTime().Equal(c.info.ModTime()) {
+		return Unchanged, nil
+	}
+
+	c.reindexing = true
+	go func() {
+		f, err := c.open()
+		c.refreshMu.Lock()
+		defer c.refreshMu.Unlock()
+		c.reindexing = false
+		if c.closed {
+			if f != nil {
+				f.src.Close()
+			}
+			return
+		}
+		c.reindexed, c.reindexErr = f, err
+	}()
+	return Unchanged, nil
+}
+
+// Close releases the decoder and the underlying file
+func (c *CompressedFile) Close() error {
+	c.refreshMu.Lock()
+	c.closed = true
+	if c.reindexed != nil {
+		c.reindexed.src.Close()
+		c.reindexed = nil
+	}
+	c.refreshMu.Unlock()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.closeStream()
+	return c.src.Close()
+}
+
+// blockCache is a small LRU of decompressed blocks
+type blockCache struct {
+	max    int
+	order  *list.List // front = most recently used
+	blocks map[int64]*list.Element
+}
+
+type cachedBlock struct {
+	index int64
+	data  []byte
+}
+
+func newBlockCache(max int) *blockCache {
+	return &blockCache{max: max, order: list.New(), blocks: make(map[int64]*list.Element)}
+}
+
+func (b *blockCache) get(i int64) ([]byte, bool) {
+	e, ok := b.blocks[i]
+	if !ok {
+		return nil, false
+	}
+	b.order.MoveToFront(e)
+	return e.Value.(*cachedBlock).data, true
+}
+
+func (b *blockCache) put(i int64, data []byte) {
+	b.blocks[i] = b.order.PushFront(&cachedBlock{index: i, data: data})
+	if b.order.Len() > b.max {
+		oldest := b.order.Back()
+		b.order.Remove(oldest)
+		delete(b.blocks, oldest.Value.(*cachedBlock).index)
+	}
+}
@@ -0,0 +1,387 @@
+package io
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"math/rand"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/ulikunitz/xz"
+)
+
+// sampleLog generates n log-like lines: repetitive enough to compress well,
+// varied enough to produce dynamic Huffman blocks and long back-references.
+func sampleLog(n int) []byte {
+	rng := rand.New(rand.NewSource(1))
+	levels := []string{"INFO", "DEBUG", "WARN", "ERROR"}
+	var b bytes.Buffer
+	for i := 0; i < n; i++ {
+		fmt.Fprintf(&b, "2024-01-15 10:%02d:%02d.%03d %-5s [worker-%d] request %d took %dms\n",
+			i/3600%60, i/60%60, i%1000, levels[rng.Intn(len(levels))], rng.Intn(16), i, rng.Intn(5000))
+	}
+	return b.Bytes()
+}
+
+// mixedLog is sampleLog with incompressible chunks spliced in, so a deflate
+// stream of it switches between Huffman and stored blocks. Stored blocks are
+// byte-aligned in the file, which a decoder resumed mid-byte must respect.
+func mixedLog(n int) []byte {
+	rng := rand.New(rand.NewSource(3))
+	text := sampleLog(n)
+	var b bytes.Buffer
+	for len(text) > 0 {
+		k := min(len(text), 150<<10+rng.Intn(100<<10))
+		b.Write(text[:k])
+		text = text[k:]
+		noise := make([]byte, 40<<10+rng.Intn(40<<10))
+		rng.Read(noise)
+		b.Write(noise)
+	}
+	return b.Bytes()
+}
+
+func gzipBytes(t *testing.T, data []byte, level int) []byte {
+	t.Helper()
+	var b bytes.Buffer
+	w, err := gzip.NewWriterLevel(&b, level)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Write(data)
+	w.Close()
+	return b.Bytes()
+}
+
+// openCompressed writes raw to a temp file and opens it through Open.
+func openCompressed(t *testing.T, raw []byte, want Compression) *CompressedFile {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "app.log.z")
+	if err := os.WriteFile(path, raw, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	f, err := Open(path)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	t.Cleanup(func() { f.Close() })
+
+	c, ok := f.(*CompressedFile)
+	if !ok {
+		t.Fatalf("Open returned %T, want *CompressedFile", f)
+	}
+	if c.Compression() != want {
+		t.Fatalf("detected %v, want %v", c.Compression(), want)
+	}
+	return c
+}
+
+// checkRandomReads compares reads at random offsets (forwards and backwards,
+// crossing blocks and seek points) against the original bytes.
+func checkRandomReads(t *testing.T, f File, want []byte) {
+	t.Helper()
+	if f.Size() != int64(len(want)) {
+		t.Fatalf("size: got %d, want %d", f.Size(), len(want))
+	}
+
+	rng := rand.New(rand.NewSource(2))
+	for i := 0; i < 200; i++ {
+		off := rng.Int63n(int64(len(want)))
+		end := off + rng.Int63n(3*blockSize)
+		if end > int64(len(want)) {
+			end = int64(len(want))
+		}
+		got, err := f.ReadRange(off, end)
+		if err != nil {
+			t.Fatalf("ReadRange(%d, %d): %v", off, end, err)
+		}
+		if !bytes.Equal(got, want[off:end]) {
+			t.Fatalf("ReadRange(%d, %d) returned wrong bytes", off, end)
+		}
+	}
+}
+
+// checkSeekPoints starts decoding afresh from each of c's seek points and
+// compares what comes out with the original bytes.
+func checkSeekPoints(t *testing.T, c *CompressedFile, want []byte) {
+	t.Helper()
+	for i, p := range c.points {
+		c.cache = newBlockCache(cachedBlocks)
+		c.closeStream()
+		end := min(p.out+blockSize, int64(len(want)))
+		got, err := c.ReadRange(p.out, end)
+		if err != nil || !bytes.Equal(got, want[p.out:end]) {
+			t.Fatalf("resuming from seek point %d (bit %d): %v", i, p.bit, err)
+		}
+	}
+}
+
+// withSpan shrinks the gzip checkpoint span so small test files get many
+// mid-member seek points.
+func withSpan(t *testing.T, span int64) {
+	old := checkpointSpan
+	checkpointSpan = span
+	t.Cleanup(func() { checkpointSpan = old })
+}
+
+func TestGzipRandomAccess(t *testing.T) {
+	withSpan(t, 100<<10)
+	data := sampleLog(60000) // ~4MB
+
+	for _, level := range []int{gzip.NoCompression, gzip.BestSpeed, gzip.DefaultCompression, gzip.BestCompression, gzip.HuffmanOnly} {
+		t.Run(fmt.Sprintf("level%d", level), func(t *testing.T) {
+			c := openCompressed(t, gzipBytes(t, data, level), CompressionGzip)
+			if level != gzip.NoCompression && len(c.points) < 10 {
+				t.Fatalf("expected mid-member seek points, got %d", len(c.points))
+			}
+			checkRandomReads(t, c, data)
+		})
+	}
+
+	// Stored blocks between Huffman ones, resumed from every seek point
+	t.Run("mixed", func(t *testing.T) {
+		data := mixedLog(60000)
+		c := openCompressed(t, gzipBytes(t, data, gzip.DefaultCompression), CompressionGzip)
+		checkSeekPoints(t, c, data)
+		checkRandomReads(t, c, data)
+	})
+}
+
+// TestGzipMultiMember covers concatenated members (gzip a b > ab.gz, and logs
+// appended to with gzip >>), including an empty one.
+func TestGzipMultiMember(t *testing.T) {
+	withSpan(t, 100<<10)
+	data := sampleLog(30000)
+	parts := [][]byte{data[:len(data)/3], nil, data[len(data)/3:]}
+
+	var raw []byte
+	for _, p := range parts {
+		raw = append(raw, gzipBytes(t, p, gzip.DefaultCompression)...)
+	}
+	c := openCompressed(t, raw, CompressionGzip)
+	checkRandomReads(t, c, data)
+}
+
+func TestZstdRandomAccess(t *testing.T) {
+	data := sampleLog(30000)
+	var b bytes.Buffer
+	w, err := zstd.NewWriter(&b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Write(data)
+	w.Close()
+
+	checkRandomReads(t, openCompressed(t, b.Bytes(), CompressionZstd), data)
+}
+
+// TestZstdFrames covers a file written as many frames (pzstd, or a
+// logger compressing each chunk it flushes), with a skippable frame among
+// them: each frame start can be a seek point.
+func TestZstdFrames(t *testing.T) {
+	withSpan(t, 100<<10)
+	data := sampleLog(30000)
+	enc, err := zstd.NewWriter(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var raw []byte
+	for i, rest := 0, data; len(rest) > 0; i++ {
+		n := min(len(rest), 64<<10)
+		raw = enc.EncodeAll(rest[:n], raw)
+		rest = rest[n:]
+		if i%3 == 1 {
+			raw = append(raw, 0x5e, 0x2a, 0x4d, 0x18, 3, 0, 0, 0, 'a', 'b', 'c')
+		}
+	}
+
+	c := openCompressed(t, raw, CompressionZstd)
+	if len(c.points) < 10 {
+		t.Fatalf("expected a seek point every couple of frames, got %d", len(c.points))
+	}
+	checkSeekPoints(t, c, data)
+	checkRandomReads(t, c, data)
+}
+
+// bzip2Bytes compresses data with the bzip2 command at the given block size
+// (in 100KB units).
+func bzip2Bytes(t *testing.T, data []byte, level int) []byte {
+	t.Helper()
+	bzip2, err := exec.LookPath("bzip2")
+	if err != nil {
+		t.Skip("no bzip2 to write test files with")
+	}
+	cmd := exec.Command(bzip2, "-c", fmt.Sprintf("-%d", level))
+	cmd.Stdin = bytes.NewReader(data)
+	out, err := cmd.Output()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return out
+}
+
+// TestBzip2Blocks covers bzip2's bit-aligned blocks, each of which is a seek
+// point, in one stream and across concatenated streams (pbzip2, lbzip2, or
+// bzip2 a b > ab.bz2), including an empty one.
+func TestBzip2Blocks(t *testing.T) {
+	data := sampleLog(20000)
+
+	t.Run("single", func(t *testing.T) {
+		c := openCompressed(t, bzip2Bytes(t, data, 1), CompressionBzip2)
+		if want := len(data)/(100<<10) + 1; len(c.points) < want {
+			t.Fatalf("expected a seek point per block, got %d", len(c.points))
+		}
+		checkSeekPoints(t, c, data)
+		checkRandomReads(t, c, data)
+	})
+
+	t.Run("streams", func(t *testing.T) {
+		var raw []byte
+		for i, rest := 0, data; len(rest) > 0; i++ {
+			n := min(len(rest), 250<<10)
+			raw = append(raw, bzip2Bytes(t, rest[:n], 1)...)
+			rest = rest[n:]
+			if i == 1 {
+				raw = append(raw, bzip2Bytes(t, nil, 1)...)
+			}
+		}
+		c := openCompressed(t, raw, CompressionBzip2)
+		checkSeekPoints(t, c, data)
+		checkRandomReads(t, c, data)
+	})
+}
+
+func TestXzRandomAccess(t *testing.T) {
+	data := sampleLog(10000)
+	var b bytes.Buffer
+	w, err := xz.NewWriter(&b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Write(data)
+	w.Close()
+
+	checkRandomReads(t, openCompressed(t, b.Bytes(), CompressionXz), data)
+}
+
+// TestXzBlocks covers xz files of many blocks (xz -T, pixz), whose index
+// makes each block a seek point, across concatenated streams with padding
+// between them.
+func TestXzBlocks(t *testing.T) {
+	data := sampleLog(20000)
+	var raw []byte
+	for i, rest := 0, data; len(rest) > 0; i++ {
+		n := min(len(rest), 600<<10)
+		var b bytes.Buffer
+		w, err := xz.WriterConfig{BlockSize: 100 << 10}.NewWriter(&b)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Write(rest[:n])
+		w.Close()
+		raw = append(raw, b.Bytes()...)
+		if i == 0 {
+			raw = append(raw, 0, 0, 0, 0, 0, 0, 0, 0)
+		}
+		rest = rest[n:]
+	}
+
+	c := openCompressed(t, raw, CompressionXz)
+	if want := len(data) / (100 << 10); len(c.points) < want {
+		t.Fatalf("expected a seek point per block, got %d", len(c.points))
+	}
+	checkSeekPoints(t, c, data)
+	checkRandomReads(t, c, data)
+}
+
+// TestReadHead checks the head of a file is read the same whether it is
+// plain, compressed or shorter than asked for.
+func TestReadHead(t *testing.T) {
+	data := sampleLog(5000)
+	var zst bytes.Buffer
+	zw, _ := zstd.NewWriter(&zst)
+	zw.Write(data)
+	zw.Close()
+
+	dir := t.TempDir()
+	for name, raw := range map[string][]byte{
+		"plain": data,
+		"gzip":  gzipBytes(t, data, gzip.DefaultCompression),
+		"zstd":  zst.Bytes(),
+	} {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, raw, 0o644); err != nil {
+			t.Fatal(err)
+		}
+		for _, n := range []int{1000, len(data) + 10} {
+			got, err := ReadHead(path, n)
+			if err != nil {
+				t.Fatalf("%s: ReadHead(%d): %v", name, n, err)
+			}
+			want := data[:min(n, len(data))]
+			if !bytes.Equal(got, want) {
+				t.Fatalf("%s: ReadHead(%d) returned %d wrong bytes", name, n, len(got))
+			}
+		}
+	}
+}
+
+// TestOpenPlainFile verifies uncompressed files are still memory-mapped.
+func TestOpenPlainFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "app.log")
+	writeFile(t, path, "plain\n")
+	f, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if _, ok := f.(*MappedFile); !ok {
+		t.Fatalf("Open returned %T, want *MappedFile", f)
+	}
+}
+
+// TestCompressedRefreshOnReplace checks a replaced file is picked up, indexed
+// in the background while reads of the old content carry on.
+func TestCompressedRefreshOnReplace(t *testing.T) {
+	c := openCompressed(t, gzipBytes(t, []byte("old\n"), gzip.DefaultCompression), CompressionGzip)
+
+	newer := []byte("replaced content\n")
+	if err := os.WriteFile(c.Path(), gzipBytes(t, newer, gzip.DefaultCompression), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		for {
+			select {
+			case <-done:
+				return
+			default:
+				c.ReadRange(0, c.Size())
+			}
+		}
+	}()
+
+	deadline := time.Now().Add(5 * time.Second)
+	change, err := c.Refresh()
+	for err == nil && change == Unchanged && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+		change, err = c.Refresh()
+	}
+	if err != nil || change != Rotated {
+		t.Fatalf("Refresh after replace: change=%v err=%v", change, err)
+	}
+	got, err := c.ReadRange(0, c.Size())
+	if err != nil || !bytes.Equal(got, newer) {
+		t.Fatalf("read after refresh: %q %v", got, err)
+	}
+	if change, _ := c.Refresh(); change != Unchanged {
+		t.Fatalf("second Refresh: %v", change)
+	}
+}
@@ -0,0 +1,100 @@
+package io
+
+import (
+	"bufio"
+	"compress/bzip2"
+	"compress/gzip"
+	"io"
+	"os"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/ulikunitz/xz"
+)
+
+// File is random-access read access to a log's bytes, whether they are mapped
+// straight from disk or decoded from a compressed file
+type File interface {
+	ReadAt(p []byte, off int64) (int, error)
+	ReadRange(start, end int64) ([]byte, error)
+	Size() int64
+	Path() string
+	Refresh() (Change, error)
+	Err() error
+	Close() error
+}
+
+// Open opens path for reading. Compressed files (gzip, zstd, bzip2, xz) are
+// detected by their magic bytes and decoded transparently; everything else is
+// memory-mapped.
+func Open(path string) (File, error) {
+	kind, err := sniffCompression(path)
+	if err != nil {
+		return nil, err
+	}
+	if kind != CompressionNone {
+		return OpenCompressed(path, kind)
+	}
+
+	m, err := OpenMapped(path)
+	if err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// sniffCompression reads the first few bytes of path and identifies its format
+func sniffCompression(path string) (Compression, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return CompressionNone, err
+	}
+	defer f.Close()
+
+	head := make([]byte, 6)
+	n, err := io.ReadFull(f, head)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return CompressionNone, err
+	}
+	return DetectCompression(head[:n]), nil
+}
+
+// ReadHead returns up to n bytes from the start of path's content, decoding
+// it if compressed. Unlike Open it reads no further than it has to, so it is
+// cheap even for large compressed files.
+func ReadHead(path string, n int) ([]byte, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	br := bufio.NewReader(f)
+	magic, _ := br.Peek(6)
+	var r io.Reader = br
+	switch DetectCompression(magic) {
+	case CompressionGzip:
+		if r, err = gzip.NewReader(br); err != nil {
+			return nil, err
+		}
+	case CompressionZstd:
+		d, err := zstd.NewReader(br, zstd.WithDecoderConcurrency(1))
+		if err != nil {
+			return nil, err
+		}
+		defer d.Close()
+		r = d
+	case CompressionBzip2:
+		r = bzip2.NewReader(br)
+	case CompressionXz:
+		if r, err = xz.NewReader(br); err != nil {
+			return nil, err
+		}
+	}
+
+	buf := make([]byte, n)
+	m, err := io.ReadFull(r, buf)
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		err = nil
+	}
+	return buf[:m], err
+}
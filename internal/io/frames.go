@@ -0,0 +1,433 @@
+package io
+
+import (
+	"bufio"
+	"bytes"
+	"compress/bzip2"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"io"
+	"math/bits"
+)
+
+// Seek points for the formats other than gzip come from their own
+// boundaries. zstd files are often a series of independent frames (pzstd
+// writes one per chunk), and the stock decoder can start at any of them. A
+// bzip2 stream is a series of blocks that each decode on their own, and the
+// end of an xz stream holds an index giving every block's compressed and
+// decompressed size, so both can start at any block if the decoder is handed
+// a stream header first (see seekPoint.prefix). A zstd frame's blocks share
+// history, so a file written as one frame still has only one seek point.
+
+const (
+	zstdMagic          = 0xfd2fb528
+	zstdSkippableMagic = 0x184d2a50 // low 4 bits are free
+)
+
+var errCorruptZstd = errors.New("corrupt zstd frame")
+
+// zstdFrames walks the frame headers of a zstd file, skipping block payloads,
+// and returns where each frame starts
+func zstdFrames(r io.ReaderAt, size int64) ([]int64, error) {
+	var starts []int64
+	var hdr [8]byte
+	for off := int64(0); off < size; {
+		if _, err := r.ReadAt(hdr[:], off); err != nil {
+			return nil, unexpectedEOF(err)
+		}
+		magic := binary.LittleEndian.Uint32(hdr[:4])
+		if magic&^0xf == zstdSkippableMagic {
+			off += 8 + int64(binary.LittleEndian.Uint32(hdr[4:8]))
+			continue
+		}
+		if magic != zstdMagic {
+			return nil, errCorruptZstd
+		}
+		starts = append(starts, off)
+
+		// Frame header: descriptor, then window, dictionary ID and content
+		// size fields whose widths the descriptor gives
+		desc := hdr[4]
+		n := int64(5)
+		if desc&0x20 == 0 { // not single segment: window descriptor
+			n++
+		}
+		n += [4]int64{0, 1, 2, 4}[desc&3]
+		fcs := [4]int64{0, 2, 4, 8}[desc>>6]
+		if fcs == 0 && desc&0x20 != 0 {
+			fcs = 1
+		}
+		off += n + fcs
+
+		for {
+			if _, err := r.ReadAt(hdr[:3], off); err != nil {
+				return nil, unexpectedEOF(err)
+			}
+			b := uint32(hdr[0]) | uint32(hdr[1])<<8 | uint32(hdr[2])<<16
+			last, kind, bsize := b&1 == 1, b>>1&3, int64(b>>3)
+			off += 3
+			switch kind {
+			case 0, 2: // raw, compressed
+				off += bsize
+			case 1: // RLE: one byte, repeated
+				off++
+			default:
+				return nil, errCorruptZstd
+			}
+			if last {
+				break
+			}
+		}
+		if desc&0x04 != 0 { // content checksum
+			off += 4
+		}
+	}
+	if len(starts) == 0 {
+		return nil, errCorruptZstd
+	}
+	return starts, nil
+}
+
+// unexpectedEOF turns running out of file part way through a frame into
+// io.ErrUnexpectedEOF
+func unexpectedEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+const (
+	bzip2BlockMagic = 0x314159265359 // BCD digits of pi
+	bzip2EndMagic   = 0x177245385090 // and of sqrt(pi)
+)
+
+var errCorruptBzip2 = errors.New("corrupt bzip2 stream")
+
+// byteCounter counts the bytes a decoder has taken. The bzip2 decoder reads
+// one byte at a time, and no more than it needs, from an io.ByteReader.
+type byteCounter struct {
+	r *bufio.Reader
+	n int64
+}
+
+func (b *byteCounter) Read(p []byte) (int, error) {
+	n, err := b.r.Read(p)
+	b.n += int64(n)
+	return n, err
+}
+
+func (b *byteCounter) ReadByte() (byte, error) {
+	c, err := b.r.ReadByte()
+	if err == nil {
+		b.n++
+	}
+	return c, err
+}
+
+// indexBzip2 decodes a bzip2 file once and makes every block a seek point.
+// Blocks are bit-aligned and carry no length, but the decoder reads a whole
+// block before yielding any of it, and reads nothing past its end until that
+// output is used up, so a read that takes input begins a block whose end
+// lies in the last byte taken.
+func indexBzip2(src io.ReaderAt, rawSize int64) ([]seekPoint, int64, error) {
+	in := &byteCounter{r: bufio.NewReaderSize(io.NewSectionReader(src, 0, rawSize), 64<<10)}
+	dec := bzip2.NewReader(in)
+
+	var points []seekPoint
+	var crcs []uint32 // of the current stream's blocks, for its closing CRC
+	var header []byte
+	var out, next, stream int64 // next block, or if that was the last, next stream
+	buf := make([]byte, 64<<10)
+	for {
+		took := in.n
+		n, err := dec.Read(buf)
+		if n > 0 && in.n != took {
+			if len(crcs) == 0 {
+				var serr error
+				if next, header, serr = bzip2StreamAt(src, stream); serr != nil {
+					return nil, 0, serr
+				}
+			}
+			crc, berr := bzip2Block(src, next)
+			if berr != nil {
+				return nil, 0, berr
+			}
+			points = append(points, seekPoint{out: out, bit: next, prefix: header, memberStart: len(crcs) == 0})
+			crcs = append(crcs, crc)
+
+			end, last, berr := bzip2BlockEnd(src, in.n)
+			if berr != nil {
+				return nil, 0, berr
+			}
+			next = end
+			if last {
+				closeBzip2Stream(points[len(points)-len(crcs):], crcs, end)
+				crcs = crcs[:0]
+				stream = (end + 80 + 7) / 8 // past the marker and the stream CRC
+			}
+		}
+		out += int64(n)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, 0, err
+		}
+	}
+	if len(points) == 0 {
+		return []seekPoint{{memberStart: true}}, 0, nil
+	}
+	return points, out, nil
+}
+
+// bzip2StreamAt returns where the first block of the stream at byte off
+// starts, skipping streams with no blocks, and the stream's header
+func bzip2StreamAt(src io.ReaderAt, off int64) (int64, []byte, error) {
+	for {
+		header := make([]byte, 4)
+		if _, err := src.ReadAt(header, off); err != nil {
+			return 0, nil, unexpectedEOF(err)
+		}
+		if !bytes.HasPrefix(header, []byte("BZh")) {
+			return 0, nil, errCorruptBzip2
+		}
+		magic, err := readBits(src, off*8+32, 48)
+		if err != nil {
+			return 0, nil, err
+		}
+		if magic == bzip2BlockMagic {
+			return off*8 + 32, header, nil
+		}
+		if magic != bzip2EndMagic {
+			return 0, nil, errCorruptBzip2
+		}
+		off = (off*8 + 32 + 80 + 7) / 8
+	}
+}
+
+// bzip2Block checks a block starts at bit and returns its CRC
+func bzip2Block(src io.ReaderAt, bit int64) (uint32, error) {
+	magic, err := readBits(src, bit, 48)
+	if err != nil {
+		return 0, err
+	}
+	if magic != bzip2BlockMagic {
+		return 0, errCorruptBzip2
+	}
+	crc, err := readBits(src, bit+48, 32)
+	return uint32(crc), err
+}
+
+// bzip2BlockEnd finds the block or end-of-stream marker that follows a block
+// the decoder finished reading after taking n bytes, and reports which it is
+func bzip2BlockEnd(src io.ReaderAt, n int64) (int64, bool, error) {
+	for bit := n*8 - 7; bit <= n*8; bit++ {
+		magic, err := readBits(src, bit, 48)
+		if err != nil {
+			return 0, false, err
+		}
+		if magic == bzip2BlockMagic || magic == bzip2EndMagic {
+			return bit, magic == bzip2EndMagic, nil
+		}
+	}
+	return 0, false, errCorruptBzip2
+}
+
+// closeBzip2Stream ends the seek points of a stream's blocks at its
+// end-of-stream marker. A stream started part way through needs a marker of
+// its own: its CRC combines the CRCs of just the blocks it holds.
+func closeBzip2Stream(points []seekPoint, crcs []uint32, end int64) {
+	combined := make([]uint32, len(crcs)+1) // of the blocks before each
+	for i, crc := range crcs {
+		combined[i+1] = bits.RotateLeft32(combined[i], 1) ^ crc
+	}
+	all := combined[len(crcs)]
+	for i := range points {
+		crc := all ^ bits.RotateLeft32(combined[i], len(crcs)-i)
+		tail := binary.BigEndian.AppendUint64(nil, bzip2EndMagic<<16)[:6]
+		points[i].end = end
+		points[i].tail = binary.BigEndian.AppendUint32(tail, crc)
+	}
+}
+
+// readBits reads n (<= 56) bits at a bit offset, most significant first as
+// bzip2 packs them
+func readBits(src io.ReaderAt, bit int64, n uint) (uint64, error) {
+	var b [8]byte
+	if k, err := src.ReadAt(b[:], bit/8); err != nil && (err != io.EOF || uint(k)*8 < uint(bit%8)+n) {
+		return 0, unexpectedEOF(err)
+	}
+	return binary.BigEndian.Uint64(b[:]) << (bit % 8) >> (64 - n), nil
+}
+
+// bitSection hands a decoder the bits of a file from one offset to another as
+// though they started on a byte boundary, then tail, padding the last byte
+// with zeros. It lets bzip2 decode from a block part way into a stream.
+type bitSection struct {
+	r    io.ByteReader
+	skip uint  // bits of the first byte before the section
+	left int64 // bits of the section still to read
+	tail []byte
+	acc  uint64 // bits read but not yet returned, in the low n
+	n    uint
+}
+
+func newBitSection(r io.ByteReader, bit, end int64, tail []byte) *bitSection {
+	return &bitSection{r: r, skip: uint(bit % 8), left: end - bit, tail: tail}
+}
+
+func (s *bitSection) Read(p []byte) (int, error) {
+	for i := range p {
+		for s.n < 8 && (s.left > 0 || len(s.tail) > 0) {
+			var b byte
+			k := uint(8)
+			if s.left > 0 {
+				c, err := s.r.ReadByte()
+				if err != nil {
+					return i, unexpectedEOF(err)
+				}
+				b = c & (0xff >> s.skip)
+				k -= s.skip
+				s.skip = 0
+				if int64(k) > s.left {
+					b >>= k - uint(s.left)
+					k = uint(s.left)
+				}
+				s.left -= int64(k)
+			} else {
+				b, s.tail = s.tail[0], s.tail[1:]
+			}
+			s.acc = s.acc<<k | uint64(b)
+			s.n += k
+		}
+		if s.n == 0 {
+			if i == 0 {
+				return 0, io.EOF
+			}
+			return i, nil
+		}
+		if s.n < 8 {
+			s.acc <<= 8 - s.n
+			s.n = 8
+		}
+		s.n -= 8
+		p[i] = byte(s.acc >> s.n)
+	}
+	return len(p), nil
+}
+
+var (
+	xzMagic       = []byte{0xfd, '7', 'z', 'X', 'Z', 0x00}
+	errCorruptXz  = errors.New("corrupt xz stream")
+	xzFooterMagic = []byte("YZ")
+)
+
+// xzStream is an xz stream's header and the block sizes its index lists
+type xzStream struct {
+	header     []byte
+	start      int64 // of the stream's first block
+	indexStart int64
+	blocks     [][2]int64 // compressed (padded) and decompressed size
+}
+
+// indexXz reads the index at the end of each stream in an xz file and makes
+// every block a seek point. Nothing is decoded: the sizes are all there.
+func indexXz(src io.ReaderAt, rawSize int64) ([]seekPoint, int64, error) {
+	var streams []xzStream
+	for end := rawSize; end > 0; {
+		s, start, err := readXzStream(src, end)
+		if err != nil {
+			return nil, 0, err
+		}
+		if s != nil {
+			streams = append([]xzStream{*s}, streams...)
+		}
+		end = start
+	}
+
+	var points []seekPoint
+	var out int64
+	for _, s := range streams {
+		off := s.start
+		for i, b := range s.blocks {
+			points = append(points, seekPoint{out: out, bit: off * 8, prefix: s.header, end: s.indexStart * 8, memberStart: i == 0})
+			off += b[0]
+			out += b[1]
+		}
+	}
+	if len(points) == 0 {
+		return []seekPoint{{memberStart: true}}, 0, nil
+	}
+	return points, out, nil
+}
+
+// readXzStream reads the stream that ends at end, returning where it starts.
+// Zero padding between streams comes back as no stream.
+func readXzStream(src io.ReaderAt, end int64) (*xzStream, int64, error) {
+	var footer [12]byte
+	if end < int64(len(footer)) {
+		return nil, 0, errCorruptXz
+	}
+	if _, err := src.ReadAt(footer[:], end-12); err != nil {
+		return nil, 0, unexpectedEOF(err)
+	}
+	if binary.LittleEndian.Uint32(footer[8:]) == 0 {
+		return nil, end - 4, nil
+	}
+	if !bytes.Equal(footer[10:], xzFooterMagic) || crc32.ChecksumIEEE(footer[4:10]) != binary.LittleEndian.Uint32(footer[:4]) {
+		return nil, 0, errCorruptXz
+	}
+
+	// Index: indicator, record count, records, padding, CRC32
+	indexStart := end - 12 - (int64(binary.LittleEndian.Uint32(footer[4:8]))+1)*4
+	if indexStart < 12 {
+		return nil, 0, errCorruptXz
+	}
+	index := make([]byte, end-12-indexStart)
+	if _, err := src.ReadAt(index, indexStart); err != nil {
+		return nil, 0, unexpectedEOF(err)
+	}
+	body := index[:len(index)-4]
+	if index[0] != 0 || crc32.ChecksumIEEE(body) != binary.LittleEndian.Uint32(index[len(body):]) {
+		return nil, 0, errCorruptXz
+	}
+	body = body[1:]
+	uvarint := func() int64 {
+		v, n := binary.Uvarint(body)
+		if n <= 0 || v > 1<<62 {
+			body = nil
+			return -1
+		}
+		body = body[n:]
+		return int64(v)
+	}
+
+	s := &xzStream{indexStart: indexStart}
+	count := uvarint()
+	var size int64
+	for i := int64(0); i < count; i++ {
+		unpadded, decoded := uvarint(), uvarint()
+		if decoded < 0 || unpadded < 0 {
+			return nil, 0, errCorruptXz
+		}
+		padded := (unpadded + 3) &^ 3
+		s.blocks = append(s.blocks, [2]int64{padded, decoded})
+		size += padded
+	}
+	if count < 0 || size > indexStart-12 {
+		return nil, 0, errCorruptXz
+	}
+
+	start := indexStart - size - 12
+	s.header = make([]byte, 12)
+	if _, err := src.ReadAt(s.header, start); err != nil {
+		return nil, 0, unexpectedEOF(err)
+	}
+	if !bytes.HasPrefix(s.header, xzMagic) || !bytes.Equal(s.header[6:8], footer[8:10]) {
+		return nil, 0, errCorruptXz
+	}
+	s.start = start + 12
+	return s, start, nil
+}
@@ -0,0 +1,649 @@
+package io
+
+import (
+	"bufio"
+	"errors"
+	"io"
+)
+
+// The stdlib gzip reader can only decode from the start of a member, which
+// makes the middle of a multi-GB .gz unreachable without inflating everything
+// before it. indexGzip walks the file once with the small DEFLATE decoder
+// below and records seek points: the exact bit where a deflate block starts,
+// plus the 32KB of output preceding it. The same decoder can resume from such
+// a point (see gzipReader), so a read anywhere in the file costs at most one
+// checkpoint span of decoding (the zlib "zran" trick).
+
+const (
+	windowSize  = 1 << 15 // DEFLATE back-reference window
+	windowMask  = windowSize - 1
+	maxCodeBits = 15
+	fastBits    = 9 // Huffman codes up to this length decode with one lookup
+)
+
+var errCorrupt = errors.New("corrupt gzip stream")
+
+var (
+	lengthBase  = [29]uint16{3, 4, 5, 6, 7, 8, 9, 10, 11, 13, 15, 17, 19, 23, 27, 31, 35, 43, 51, 59, 67, 83, 99, 115, 131, 163, 195, 227, 258}
+	lengthExtra = [29]uint8{0, 0, 0, 0, 0, 0, 0, 0, 1, 1, 1, 1, 2, 2, 2, 2, 3, 3, 3, 3, 4, 4, 4, 4, 5, 5, 5, 5, 0}
+	distBase    = [30]uint16{1, 2, 3, 4, 5, 7, 9, 13, 17, 25, 33, 49, 65, 97, 129, 193, 257, 385, 513, 769, 1025, 1537, 2049, 3073, 4097, 6145, 8193, 12289, 16385, 24577}
+	distExtra   = [30]uint8{0, 0, 0, 0, 1, 1, 2, 2, 3, 3, 4, 4, 5, 5, 6, 6, 7, 7, 8, 8, 9, 9, 10, 10, 11, 11, 12, 12, 13, 13}
+
+	// Order in which code length code lengths are sent in a dynamic block header
+	codeLengthOrder = [19]uint8{16, 17, 18, 0, 8, 7, 9, 6, 10, 5, 11, 4, 12, 3, 13, 2, 14, 1, 15}
+)
+
+// bitReader reads a DEFLATE stream LSB-first and tracks its exact bit position
+type bitReader struct {
+	r     io.ByteReader
+	pos   int64 // bytes consumed from r
+	bits  uint64
+	nbits uint
+}
+
+// fill buffers at least n bits
+func (b *bitReader) fill(n uint) error {
+	for b.nbits < n {
+		c, err := b.r.ReadByte()
+		if err != nil {
+			if err == io.EOF {
+				return io.ErrUnexpectedEOF
+			}
+			return err
+		}
+		b.bits |= uint64(c) << b.nbits
+		b.nbits += 8
+		b.pos++
+	}
+	return nil
+}
+
+// take consumes n bits (n <= 32)
+func (b *bitReader) take(n uint) (uint32, error) {
+	if err := b.fill(n); err != nil {
+		return 0, err
+	}
+	v := uint32(b.bits & (1<<n - 1))
+	b.bits >>= n
+	b.nbits -= n
+	return v, nil
+}
+
+// bitOffset returns the absolute position of the next unread bit
+func (b *bitReader) bitOffset() int64 {
+	return b.pos*8 - int64(b.nbits)
+}
+
+// alignByte drops bits up to the next byte boundary
+func (b *bitReader) alignByte() {
+	drop := b.nbits % 8
+	b.bits >>= drop
+	b.nbits -= drop
+}
+
+// huffman is a canonical Huffman decoding table
+type huffman struct {
+	count  [maxCodeBits + 1]uint16
+	symbol []uint16
+	fast   [1 << fastBits]uint16 // symbol<<4 | length; 0 if the code is longer
+}
+
+// build constructs the table from per-symbol code lengths (0 = unused)
+func (h *huffman) build(lengths []uint8) error {
+	h.count = [maxCodeBits + 1]uint16{}
+	for _, l := range lengths {
+		h.count[l]++
+	}
+	h.count[0] = 0
+
+	// Reject over-subscribed code sets
+	left := 1
+	for l := 1; l <= maxCodeBits; l++ {
+		left <<= 1
+		left -= int(h.count[l])
+		if left < 0 {
+			return errCorrupt
+		}
+	}
+
+	var offs [maxCodeBits + 1]uint16
+	for l := 1; l < maxCodeBits; l++ {
+		offs[l+1] = offs[l] + h.count[l]
+	}
+	if cap(h.symbol) < len(lengths) {
+		h.symbol = make([]uint16, len(lengths))
+	}
+	h.symbol = h.symbol[:len(lengths)]
+	for sym, l := range lengths {
+		if l != 0 {
+			h.symbol[offs[l]] = uint16(sym)
+			offs[l]++
+		}
+	}
+
+	// Short codes get a direct lookup keyed by the next fastBits input bits.
+	// Codes arrive MSB-first inside an LSB-first stream, hence the reversal.
+	h.fast = [1 << fastBits]uint16{}
+	code, idx := 0, 0
+	for l := 1; l <= fastBits; l++ {
+		for i := 0; i < int(h.count[l]); i++ {
+			entry := h.symbol[idx]<<4 | uint16(l)
+			for f := reverseBits(code, l); f < 1<<fastBits; f += 1 << l {
+				h.fast[f] = entry
+			}
+			code++
+			idx++
+		}
+		code <<= 1
+	}
+	return nil
+}
+
+// reverseBits reverses the low n bits of v
+func reverseBits(v, n int) int {
+	r := 0
+	for i := 0; i < n; i++ {
+		r = r<<1 | v&1
+		v >>= 1
+	}
+	return r
+}
+
+// decode reads one symbol using table h
+func (b *bitReader) decode(h *huffman) (int, error) {
+	if b.nbits < fastBits {
+		// Near the end of the input there may be fewer bits than a lookup
+		// needs; the entry is still usable if its code fits what we have.
+		b.fill(fastBits)
+	}
+	if e := h.fast[b.bits&(1<<fastBits-1)]; e != 0 && uint(e&15) <= b.nbits {
+		l := uint(e & 15)
+		b.bits >>= l
+		b.nbits -= l
+		return int(e >> 4), nil
+	}
+
+	// Long code: walk the canonical code one bit at a time
+	code, first, index := 0, 0, 0
+	for l := 1; l <= maxCodeBits; l++ {
+		bit, err := b.take(1)
+		if err != nil {
+			return 0, err
+		}
+		code |= int(bit)
+		count := int(h.count[l])
+		if code-count < first {
+			return int(h.symbol[index+code-first]), nil
+		}
+		index += count
+		first += count
+		first <<= 1
+		code <<= 1
+	}
+	return 0, errCorrupt
+}
+
+// seekPoint is a place the decoder can be restarted from
+type seekPoint struct {
+	out         int64  // uncompressed offset of the first byte decoded from here
+	bit         int64  // compressed bit offset where decoding resumes
+	window      []byte // output preceding out, for back-references (gzip only)
+	memberStart bool   // start of a gzip member, bzip2 or xz stream (or of the whole file)
+
+	// A bzip2 or xz block is decoded as a stream of its own: the header of
+	// the stream it is in goes first, and decoding stops at the end of that
+	// stream's blocks (bit end), where bzip2 adds tail, a marker to close it
+	prefix []byte
+	end    int64
+	tail   []byte
+}
+
+// inflater decodes DEFLATE a block, or part of one, at a time. Indexing only
+// needs block boundaries and the trailing window, so it throws the output
+// away; a gzipReader resuming from a seek point collects it in sink.
+type inflater struct {
+	br        *bitReader
+	win       [windowSize]byte
+	wpos      int
+	out       int64 // total output across members
+	memberOut int64 // output of the current member
+	sink      []byte
+
+	// The block being decoded
+	final      bool
+	lit, dist  *huffman // nil for a stored block
+	storedLeft int      // bytes still to copy from a stored block
+	copyLen    int      // back-reference still being copied
+	copyDist   int
+
+	lencode, distcode   huffman
+	fixedLen, fixedDist huffman
+	fixedBuilt          bool
+	lengths             [320]uint8
+}
+
+func (d *inflater) put(c byte) {
+	d.win[d.wpos&windowMask] = c
+	d.wpos++
+	d.out++
+	d.memberOut++
+	if d.sink != nil {
+		d.sink = append(d.sink, c)
+	}
+}
+
+// snapshot returns the output window preceding the current position
+func (d *inflater) snapshot() []byte {
+	n := int64(windowSize)
+	if d.memberOut < n {
+		n = d.memberOut
+	}
+	buf := make([]byte, n)
+	for i := range buf {
+		buf[i] = d.win[(d.wpos-int(n)+i)&windowMask]
+	}
+	return buf
+}
+
+// block decodes one DEFLATE block and reports whether it was the last
+func (d *inflater) block() (bool, error) {
+	if err := d.begin(); err != nil {
+		return false, err
+	}
+	_, err := d.run(0)
+	return d.final, err
+}
+
+// begin reads a block header, leaving the block ready for run
+func (d *inflater) begin() error {
+	hdr, err := d.br.take(3)
+	if err != nil {
+		return err
+	}
+	d.final = hdr&1 == 1
+
+	switch hdr >> 1 {
+	case 0:
+		d.lit, d.dist = nil, nil
+		return d.stored()
+	case 1:
+		if !d.fixedBuilt {
+			d.buildFixed()
+		}
+		d.lit, d.dist = &d.fixedLen, &d.fixedDist
+	case 2:
+		if err := d.dynamic(); err != nil {
+			return err
+		}
+		d.lit, d.dist = &d.lencode, &d.distcode
+	default:
+		return errCorrupt
+	}
+	return nil
+}
+
+// stored reads a stored block's length; its bytes follow byte-aligned
+func (d *inflater) stored() error {
+	d.br.alignByte()
+	n, err := d.br.take(16)
+	if err != nil {
+		return err
+	}
+	complement, err := d.br.take(16)
+	if err != nil {
+		return err
+	}
+	if n != ^complement&0xffff {
+		return errCorrupt
+	}
+	d.storedLeft = int(n)
+	return nil
+}
+
+func (d *inflater) buildFixed() {
+	var l [288]uint8
+	for i := range l {
+		switch {
+		case i < 144:
+			l[i] = 8
+		case i < 256:
+			l[i] = 9
+		case i < 280:
+			l[i] = 7
+		default:
+			l[i] = 8
+		}
+	}
+	d.fixedLen.build(l[:])
+	var dl [30]uint8
+	for i := range dl {
+		dl[i] = 5
+	}
+	d.fixedDist.build(dl[:])
+	d.fixedBuilt = true
+}
+
+func (d *inflater) dynamic() error {
+	br := d.br
+	v, err := br.take(14)
+	if err != nil {
+		return err
+	}
+	nlen := int(v&31) + 257
+	ndist := int(v>>5&31) + 1
+	ncode := int(v>>10) + 4
+	if nlen > 286 || ndist > 30 {
+		return errCorrupt
+	}
+
+	lengths := d.lengths[:]
+	for i := range lengths {
+		lengths[i] = 0
+	}
+	for i := 0; i < ncode; i++ {
+		l, err := br.take(3)
+		if err != nil {
+			return err
+		}
+		lengths[codeLengthOrder[i]] = uint8(l)
+	}
+	if err := d.lencode.build(lengths[:19]); err != nil {
+		return err
+	}
+
+	for index := 0; index < nlen+ndist; {
+		sym, err := br.decode(&d.lencode)
+		if err != nil {
+			return err
+		}
+		if sym < 16 {
+			lengths[index] = uint8(sym)
+			index++
+			continue
+		}
+
+		var repeat uint8
+		var n uint32
+		switch sym {
+		case 16:
+			if index == 0 {
+				return errCorrupt
+			}
+			repeat = lengths[index-1]
+			n, err = br.take(2)
+			n += 3
+		case 17:
+			n, err = br.take(3)
+			n += 3
+		default:
+			n, err = br.take(7)
+			n += 11
+		}
+		if err != nil {
+			return err
+		}
+		if index+int(n) > nlen+ndist {
+			return errCorrupt
+		}
+		for ; n > 0; n-- {
+			lengths[index] = repeat
+			index++
+		}
+	}
+	if lengths[256] == 0 {
+		return errCorrupt
+	}
+
+	if err := d.lencode.build(lengths[:nlen]); err != nil {
+		return err
+	}
+	return d.distcode.build(lengths[nlen : nlen+ndist])
+}
+
+// run decodes the current block until it ends, reporting true, or until
+// limit bytes have been output (limit <= 0: no limit)
+func (d *inflater) run(limit int) (bool, error) {
+	br := d.br
+	stop := d.out + int64(limit)
+	if limit <= 0 {
+		stop = 1<<63 - 1
+	}
+
+	if d.lit == nil {
+		for ; d.storedLeft > 0 && d.out < stop; d.storedLeft-- {
+			c, err := br.take(8)
+			if err != nil {
+				return false, err
+			}
+			d.put(byte(c))
+		}
+		return d.storedLeft == 0, nil
+	}
+
+	for d.out < stop {
+		if d.copyLen > 0 {
+			d.put(d.win[(d.wpos-d.copyDist)&windowMask])
+			d.copyLen--
+			continue
+		}
+
+		sym, err := br.decode(d.lit)
+		if err != nil {
+			return false, err
+		}
+		if sym < 256 {
+			d.put(byte(sym))
+			continue
+		}
+		if sym == 256 {
+			return true, nil
+		}
+
+		sym -= 257
+		if sym >= len(lengthBase) {
+			return false, errCorrupt
+		}
+		extra, err := br.take(uint(lengthExtra[sym]))
+		if err != nil {
+			return false, err
+		}
+		length := int(lengthBase[sym]) + int(extra)
+
+		dsym, err := br.decode(d.dist)
+		if err != nil {
+			return false, err
+		}
+		if dsym >= len(distBase) {
+			return false, errCorrupt
+		}
+		extra, err = br.take(uint(distExtra[dsym]))
+		if err != nil {
+			return false, err
+		}
+		dist := int(distBase[dsym]) + int(extra)
+		if int64(dist) > d.memberOut {
+			return false, errCorrupt
+		}
+		d.copyLen, d.copyDist = length, dist
+	}
+	return false, nil
+}
+
+// skipBytes consumes n whole bytes
+func (b *bitReader) skipBytes(n int) error {
+	for ; n > 0; n-- {
+		if _, err := b.take(8); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// skipString consumes a zero-terminated header field
+func (b *bitReader) skipString() error {
+	for {
+		c, err := b.take(8)
+		if err != nil {
+			return err
+		}
+		if c == 0 {
+			return nil
+		}
+	}
+}
+
+// gzipHeader parses a member header, leaving br at the start of the deflate
+// data. Returns io.EOF if there is no further member.
+func gzipHeader(br *bitReader) error {
+	id, err := br.take(16)
+	if err != nil {
+		if br.nbits == 0 {
+			return io.EOF
+		}
+		return err
+	}
+	if id != 0x8b1f {
+		// Trailing padding or garbage after the last member
+		return io.EOF
+	}
+
+	v, err := br.take(16)
+	if err != nil {
+		return err
+	}
+	method, flags := v&0xff, v>>8
+	if method != 8 {
+		return errCorrupt
+	}
+	// MTIME, XFL, OS
+	if err := br.skipBytes(6); err != nil {
+		return err
+	}
+	if flags&0x04 != 0 { // FEXTRA
+		n, err := br.take(16)
+		if err != nil {
+			return err
+		}
+		if err := br.skipBytes(int(n)); err != nil {
+			return err
+		}
+	}
+	if flags&0x08 != 0 { // FNAME
+		if err := br.skipString(); err != nil {
+			return err
+		}
+	}
+	if flags&0x10 != 0 { // FCOMMENT
+		if err := br.skipString(); err != nil {
+			return err
+		}
+	}
+	if flags&0x02 != 0 { // FHCRC
+		if err := br.skipBytes(2); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// indexGzip walks every member of a gzip stream, returning seek points no more
+// than span bytes of output apart and the total decompressed size.
+func indexGzip(r io.Reader, span int64) ([]seekPoint, int64, error) {
+	br := &bitReader{r: bufio.NewReaderSize(r, 64<<10)}
+	d := &inflater{br: br}
+	var points []seekPoint
+
+	for {
+		if err := gzipHeader(br); err != nil {
+			if err == io.EOF && len(points) > 0 {
+				return points, d.out, nil
+			}
+			if err == io.EOF {
+				err = errCorrupt
+			}
+			return nil, 0, err
+		}
+
+		d.memberOut = 0
+		points = append(points, seekPoint{out: d.out, bit: br.bitOffset(), memberStart: true})
+		last := d.out
+		for {
+			if d.out-last >= span {
+				points = append(points, seekPoint{out: d.out, bit: br.bitOffset(), window: d.snapshot()})
+				last = d.out
+			}
+			final, err := d.block()
+			if err != nil {
+				return nil, 0, err
+			}
+			if final {
+				break
+			}
+		}
+
+		// Trailer: CRC32 and ISIZE (size mod 2^32)
+		br.alignByte()
+		if _, err := br.take(32); err != nil {
+			return nil, 0, err
+		}
+		isize, err := br.take(32)
+		if err != nil {
+			return nil, 0, err
+		}
+		if isize != uint32(d.memberOut) {
+			return nil, 0, errCorrupt
+		}
+	}
+}
+
+// gzipReader decodes one gzip member from a seek point. flate.NewReaderDict
+// can only start on a byte boundary of its input: handing it the file shifted
+// to a mid-byte block start gets the Huffman blocks right but misplaces every
+// later stored block, which is aligned to the file's bytes, not the shifted
+// ones. The inflater tracks the real bit position, so it aligns them right.
+type gzipReader struct {
+	d       *inflater
+	inBlock bool
+	done    bool // the member's final block has been decoded
+}
+
+// resumeGzip starts decoding at seek point p, r being positioned at the byte
+// holding its first bit
+func resumeGzip(r io.ByteReader, p seekPoint) (*gzipReader, error) {
+	br := &bitReader{r: r}
+	if _, err := br.take(uint(p.bit % 8)); err != nil {
+		return nil, err
+	}
+	d := &inflater{br: br}
+	d.wpos = copy(d.win[:], p.window)
+	d.memberOut = int64(len(p.window))
+	return &gzipReader{d: d}, nil
+}
+
+func (g *gzipReader) Read(p []byte) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+	d := g.d
+	for !g.done {
+		if !g.inBlock {
+			if err := d.begin(); err != nil {
+				return 0, err
+			}
+			g.inBlock = true
+		}
+		// Decode straight into p: run stops once it is full
+		d.sink = p[:0]
+		ended, err := d.run(len(p))
+		n := len(d.sink)
+		d.sink = nil
+		if ended {
+			g.inBlock = false
+			g.done = d.final
+		}
+		if n > 0 || err != nil {
+			return n, err
+		}
+	}
+	return 0, io.EOF
+}
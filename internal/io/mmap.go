@@ -1,44 +1,199 @@
 package io
 
 import (
+	"bytes"
+	"errors"
 	"os"
+	"runtime/debug"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"golang.org/x/exp/mmap"
 )
 
+// headSize is how many leading bytes we fingerprint to spot a file that was
+// truncated and re-grown past its old size between two refreshes
+const headSize = 512
+
+// shrinkCheckInterval throttles the stat we do before touching the mapping, so
+// a burst of reads (one render, one filter pass) costs at most one syscall
+const shrinkCheckInterval = 250 * time.Millisecond
+
+// ErrTruncated is returned for reads past the end of a file that shrank while
+// mapped. The file needs a Refresh before its old offsets mean anything again.
+var ErrTruncated = errors.New("file truncated while open")
+
+// errFault marks a read that hit a page no longer backed by the file
+var errFault = errors.New("mmap fault")
+
+// Change describes what Refresh found when it re-checked the file on disk
+type Change int
+
+const (
+	Unchanged Change = iota
+	Grown            // bytes were appended; existing offsets are still valid
+	Rotated          // truncated or replaced; existing offsets are invalid
+)
+
 // MappedFile provides memory-mapped read access to a file
 type MappedFile struct {
-	reader *mmap.ReaderAt
-	size   int64
-	path   string
+	reader  *mmap.ReaderAt
+	size    int64
+	path    string
+	info    os.FileInfo // identity of the mapped file (inode), for rotation checks
+	modTime time.Time
+	head    []byte // first headSize bytes at last refresh
+
+	// Truncation guard. Touching mapped pages beyond a shrunken file's end
+	// raises SIGBUS, so once a shrink is seen we stop using the mapping and
+	// fall back to pread until the next Refresh re-maps the file. Indexer
+	// workers read concurrently, so the fallback is published before degraded
+	// is set: a reader that sees degraded also sees the file to pread from.
+	lastCheck  atomic.Int64 // unix nanos of the last shrink check
+	degraded   atomic.Bool
+	fallback   atomic.Pointer[os.File] // opened lazily for pread once degraded
+	fallbackMu sync.Mutex              // serialises degrade and closeFallback
 }
 
 // OpenMapped opens a file with memory mapping
 func OpenMapped(path string) (*MappedFile, error) {
-	reader, err := mmap.Open(path)
-	if err != nil {
+	m := &MappedFile{path: path}
+	if err := m.mapFile(); err != nil {
 		return nil, err
 	}
+	return m, nil
+}
+
+// mapFile (re)maps the file at m.path and records its identity
+func (m *MappedFile) mapFile() error {
+	reader, err := mmap.Open(m.path)
+	if err != nil {
+		return err
+	}
 
 	// Get file size
-	info, err := os.Stat(path)
+	info, err := os.Stat(m.path)
 	if err != nil {
 		reader.Close()
-		return nil, err
+		return err
 	}
 
-	return &MappedFile{
-		reader: reader,
-		size:   info.Size(),
-		path:   path,
-	}, nil
+	if m.reader != nil {
+		m.reader.Close()
+	}
+	m.closeFallback()
+	m.reader = reader
+	m.size = info.Size()
+	m.info = info
+	m.modTime = info.ModTime()
+	m.head = m.readHead(m.size)
+	return nil
 }
 
-// ReadAt reads len(p) bytes at offset
+// ReadAt reads len(p) bytes at offset. Reads go through the mapping unless the
+// file has shrunk underneath it, in which case they fall back to pread and
+// report ErrTruncated for anything past the new end.
 func (m *MappedFile) ReadAt(p []byte, off int64) (int, error) {
+	if !m.degraded.Load() {
+		m.checkShrink()
+	}
+	if !m.degraded.Load() {
+		n, err := m.mappedReadAt(p, off)
+		if err != errFault {
+			return n, err
+		}
+		// The pages vanished between checks: the file must have shrunk
+		m.degrade()
+	}
+	return m.preadAt(p, off)
+}
+
+// mappedReadAt reads through the mapping, turning a SIGBUS on a page that is
+// no longer backed by the file into errFault instead of killing the process.
+func (m *MappedFile) mappedReadAt(p []byte, off int64) (n int, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			if _, ok := r.(interface{ Addr() uintptr }); !ok {
+				panic(r)
+			}
+			n, err = 0, errFault
+		}
+	}()
+	defer debug.SetPanicOnFault(debug.SetPanicOnFault(true))
 	return m.reader.ReadAt(p, off)
 }
 
+// checkShrink stats the file (at most once per shrinkCheckInterval) and stops
+// using the mapping if the file we mapped is now smaller than the mapping.
+func (m *MappedFile) checkShrink() {
+	now := time.Now().UnixNano()
+	if now-m.lastCheck.Load() < int64(shrinkCheckInterval) {
+		return
+	}
+	m.lastCheck.Store(now)
+
+	info, err := os.Stat(m.path)
+	if err != nil || !os.SameFile(info, m.info) {
+		// Renamed away or replaced: our inode is untouched, the mapping is fine
+		return
+	}
+	if info.Size() < m.size {
+		m.degrade()
+	}
+}
+
+// degrade switches reads from the mapping to pread on the same file
+func (m *MappedFile) degrade() {
+	m.fallbackMu.Lock()
+	defer m.fallbackMu.Unlock()
+	if m.degraded.Load() {
+		return
+	}
+	if f, err := os.Open(m.path); err == nil {
+		if info, err := f.Stat(); err == nil && os.SameFile(info, m.info) {
+			m.fallback.Store(f)
+		} else {
+			f.Close()
+		}
+	}
+	// Degraded even without a fallback: every read is then ErrTruncated
+	m.degraded.Store(true)
+}
+
+// preadAt serves a read after the file shrank
+func (m *MappedFile) preadAt(p []byte, off int64) (int, error) {
+	f := m.fallback.Load()
+	if f == nil {
+		return 0, ErrTruncated
+	}
+	n, err := f.ReadAt(p, off)
+	if n < len(p) {
+		return n, ErrTruncated
+	}
+	return n, err
+}
+
+// closeFallback drops the pread fallback and re-enables the mapping
+func (m *MappedFile) closeFallback() {
+	m.fallbackMu.Lock()
+	defer m.fallbackMu.Unlock()
+	m.degraded.Store(false)
+	if f := m.fallback.Swap(nil); f != nil {
+		f.Close()
+	}
+	m.lastCheck.Store(time.Now().UnixNano())
+}
+
+// Err reports ErrTruncated while the file is known to have shrunk under its
+// mapping (until a Refresh re-maps it), and nil otherwise
+func (m *MappedFile) Err() error {
+	if m.degraded.Load() {
+		return ErrTruncated
+	}
+	return nil
+}
+
 // Size returns the file size
 func (m *MappedFile) Size() int64 {
 	return m.size
@@ -51,32 +206,85 @@ func (m *MappedFile) Path() string {
 
 // Close closes the memory mapping
 func (m *MappedFile) Close() error {
+	m.closeFallback()
 	return m.reader.Close()
 }
 
-// Refresh re-opens the file if it has grown, returns true if size changed
-func (m *MappedFile) Refresh() (bool, error) {
+// Refresh re-checks the file on disk and re-maps it if it changed.
+//
+// Growth is the common case (follow mode). Rotation is detected three ways:
+// the path now names a different inode (rename-and-recreate), the file got
+// smaller (copytruncate), or the leading bytes changed (truncated and
+// re-grown past the old size between two polls).
+func (m *MappedFile) Refresh() (Change, error) {
 	info, err := os.Stat(m.path)
 	if err != nil {
-		return false, err
+		if os.IsNotExist(err) {
+			// Mid-rotation: the old file was renamed away and the new one
+			// isn't there yet. Keep serving the old mapping until it appears.
+			return Unchanged, nil
+		}
+		return Unchanged, err
 	}
 
 	newSize := info.Size()
-	if newSize <= m.size {
-		return false, nil
+	switch {
+	case !os.SameFile(info, m.info), newSize < m.size:
+		return m.remap(Rotated)
+	case newSize == m.size:
+		if info.ModTime().Equal(m.modTime) {
+			return Unchanged, nil
+		}
+		// Same size but rewritten: only a rotation if the content moved
+		m.modTime = info.ModTime()
+		if !m.headMatches(newSize) {
+			return m.remap(Rotated)
+		}
+		return Unchanged, nil
+	default:
+		if !m.headMatches(newSize) {
+			return m.remap(Rotated)
+		}
+		return m.remap(Grown)
 	}
+}
 
-	// File has grown, re-open it
-	m.reader.Close()
+// remap re-opens the mapping and reports change on success
+func (m *MappedFile) remap(change Change) (Change, error) {
+	if err := m.mapFile(); err != nil {
+		return Unchanged, err
+	}
+	return change, nil
+}
 
-	reader, err := mmap.Open(m.path)
+// headMatches reports whether the file still starts with the bytes we saw
+func (m *MappedFile) headMatches(size int64) bool {
+	if len(m.head) == 0 {
+		return true
+	}
+	return bytes.HasPrefix(m.readHead(size), m.head)
+}
+
+// readHead reads up to headSize leading bytes straight from disk. It avoids the
+// mapping on purpose: after a truncation the old pages may no longer be backed.
+func (m *MappedFile) readHead(size int64) []byte {
+	n := int64(headSize)
+	if size < n {
+		n = size
+	}
+	if n <= 0 {
+		return nil
+	}
+
+	f, err := os.Open(m.path)
 	if err != nil {
-		return false, err
+		return nil
 	}
+	defer f.Close()
 
-	m.reader = reader
-	m.size = newSize
-	return true, nil
+	buf := make([]byte, n)
+	read, _ := f.ReadAt(buf, 0)
+	return buf[:read]
 }
 
 // PreviousSize returns the size before last refresh (for incremental indexing)
@@ -94,7 +302,7 @@ func (m *MappedFile) ReadRange(start, end int64) ([]byte, error) {
 	}
 
 	buf := make([]byte, end-start)
-	_, err := m.reader.ReadAt(buf, start)
+	_, err := m.ReadAt(buf, start)
 	if err != nil {
 		return nil, err
 	}
@@ -0,0 +1,218 @@
+package io
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// writeFile writes content to path, failing the test on error.
+func writeFile(t *testing.T, path, content string) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatalf("write %s: %v", path, err)
+	}
+}
+
+// appendFile appends content to path, failing the test on error.
+func appendFile(t *testing.T, path, content string) {
+	t.Helper()
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil {
+		t.Fatalf("open %s: %v", path, err)
+	}
+	defer f.Close()
+	if _, err := f.WriteString(content); err != nil {
+		t.Fatalf("append %s: %v", path, err)
+	}
+}
+
+func openMapped(t *testing.T, path string) *MappedFile {
+	t.Helper()
+	m, err := OpenMapped(path)
+	if err != nil {
+		t.Fatalf("OpenMapped: %v", err)
+	}
+	t.Cleanup(func() { m.Close() })
+	return m
+}
+
+func refresh(t *testing.T, m *MappedFile) Change {
+	t.Helper()
+	change, err := m.Refresh()
+	if err != nil {
+		t.Fatalf("Refresh: %v", err)
+	}
+	return change
+}
+
+// TestRefreshGrowth covers plain appends (follow mode) and the no-op case.
+func TestRefreshGrowth(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "app.log")
+	writeFile(t, path, "one\n")
+	m := openMapped(t, path)
+
+	if got := refresh(t, m); got != Unchanged {
+		t.Fatalf("untouched file: got %v, want Unchanged", got)
+	}
+
+	appendFile(t, path, "two\n")
+	if got := refresh(t, m); got != Grown {
+		t.Fatalf("appended file: got %v, want Grown", got)
+	}
+	if m.Size() != int64(len("one\ntwo\n")) {
+		t.Fatalf("size not updated after growth: %d", m.Size())
+	}
+}
+
+// TestRefreshCopyTruncate covers logrotate's copytruncate: the same inode
+// shrinks to zero and starts filling again.
+func TestRefreshCopyTruncate(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "app.log")
+	writeFile(t, path, "old line one\nold line two\n")
+	m := openMapped(t, path)
+
+	if err := os.Truncate(path, 0); err != nil {
+		t.Fatal(err)
+	}
+	appendFile(t, path, "new\n")
+	if got := refresh(t, m); got != Rotated {
+		t.Fatalf("truncated file: got %v, want Rotated", got)
+	}
+
+	got, err := m.ReadRange(0, m.Size())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != "new\n" {
+		t.Fatalf("expected new content after rotation, got %q", got)
+	}
+}
+
+// TestRefreshTruncateAndRegrow covers a truncation missed between polls: the
+// file is bigger than before, but its leading bytes changed.
+func TestRefreshTruncateAndRegrow(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "app.log")
+	writeFile(t, path, "aaaa\n")
+	m := openMapped(t, path)
+
+	writeFile(t, path, "bbbbbbbbbb\n")
+	if got := refresh(t, m); got != Rotated {
+		t.Fatalf("rewritten file: got %v, want Rotated", got)
+	}
+}
+
+// TestRefreshRenameAndRecreate covers rotation by rename: the path now names a
+// different file, and while it is missing we keep serving the old mapping.
+func TestRefreshRenameAndRecreate(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "app.log")
+	writeFile(t, path, "before rotation\n")
+	m := openMapped(t, path)
+
+	if err := os.Rename(path, filepath.Join(dir, "app.log.1")); err != nil {
+		t.Fatal(err)
+	}
+	if got := refresh(t, m); got != Unchanged {
+		t.Fatalf("missing path mid-rotation: got %v, want Unchanged", got)
+	}
+
+	writeFile(t, path, "after rotation, longer than before\n")
+	if got := refresh(t, m); got != Rotated {
+		t.Fatalf("recreated file: got %v, want Rotated", got)
+	}
+}
+
+// truncatedMapping maps a multi-page file and then truncates it on disk, which
+// leaves the tail of the mapping backed by nothing (reads there raise SIGBUS).
+func truncatedMapping(t *testing.T) *MappedFile {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "app.log")
+	line := strings.Repeat("x", 99) + "\n"
+	writeFile(t, path, strings.Repeat(line, 200)) // ~20KB, several pages
+	m := openMapped(t, path)
+
+	if err := os.Truncate(path, 100); err != nil {
+		t.Fatal(err)
+	}
+	return m
+}
+
+// TestReadAfterTruncateFaultPath reads past the new end before the shrink check
+// has had a chance to run, so the read really touches an unbacked page. The
+// fault must come back as ErrTruncated instead of crashing the process.
+func TestReadAfterTruncateFaultPath(t *testing.T) {
+	m := truncatedMapping(t)
+	m.lastCheck.Store(time.Now().UnixNano()) // skip the pre-read stat
+
+	buf := make([]byte, 100)
+	if _, err := m.ReadAt(buf, 10_000); !errors.Is(err, ErrTruncated) {
+		t.Fatalf("read past new end: got %v, want ErrTruncated", err)
+	}
+	if !errors.Is(m.Err(), ErrTruncated) {
+		t.Fatalf("Err() should report the truncation, got %v", m.Err())
+	}
+
+	// Bytes that still exist are served by the pread fallback
+	n, err := m.ReadAt(buf[:50], 0)
+	if err != nil || n != 50 || buf[0] != 'x' {
+		t.Fatalf("read inside new end: n=%d err=%v", n, err)
+	}
+}
+
+// TestReadAfterTruncateStatPath covers the shrink being spotted by the stat
+// before the mapping is touched at all.
+func TestReadAfterTruncateStatPath(t *testing.T) {
+	m := truncatedMapping(t)
+	m.lastCheck.Store(0) // force the pre-read stat
+
+	if _, err := m.ReadRange(0, m.Size()); !errors.Is(err, ErrTruncated) {
+		t.Fatalf("ReadRange over the old size: got %v, want ErrTruncated", err)
+	}
+}
+
+// TestRefreshRecoversFromTruncation verifies a Refresh after the truncation
+// re-maps the file and clears the error.
+func TestRefreshRecoversFromTruncation(t *testing.T) {
+	m := truncatedMapping(t)
+	m.lastCheck.Store(time.Now().UnixNano())
+	m.ReadAt(make([]byte, 10), 10_000)
+
+	if got := refresh(t, m); got != Rotated {
+		t.Fatalf("Refresh after truncation: got %v, want Rotated", got)
+	}
+	if m.Err() != nil {
+		t.Fatalf("Err() should clear after re-mapping, got %v", m.Err())
+	}
+	got, err := m.ReadRange(0, m.Size())
+	if err != nil || len(got) != 100 {
+		t.Fatalf("read after recovery: len=%d err=%v", len(got), err)
+	}
+}
+
+// TestConcurrentReadsAfterTruncate has several readers (like the indexer's
+// workers) fault on the truncated tail at once. None may see the mapping
+// given up without the pread fallback in place (go test -race also checks
+// how the fallback is handed over).
+func TestConcurrentReadsAfterTruncate(t *testing.T) {
+	m := truncatedMapping(t)
+	m.lastCheck.Store(time.Now().UnixNano())
+
+	errs := make(chan error, 8)
+	for range 8 {
+		go func() {
+			buf := make([]byte, 100)
+			m.ReadAt(buf, 10_000)
+			_, err := m.ReadAt(buf[:50], 0)
+			errs <- err
+		}()
+	}
+	for range 8 {
+		if err := <-errs; err != nil {
+			t.Fatalf("read inside new end after the fault: %v", err)
+		}
+	}
+}
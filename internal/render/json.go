@@ -0,0 +1,75 @@
+package render
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/TimelordUK/mless/internal/config"
+	"github.com/TimelordUK/mless/internal/source"
+	"github.com/TimelordUK/mless/pkg/logformat"
+	"github.com/charmbracelet/lipgloss"
+)
+
+// JSONRenderer shows JSON log lines as "time LEVEL [logger] msg key=val ...",
+// coloured by level, instead of the raw object. Other lines are rendered as
+// by LogfmtRenderer.
+type JSONRenderer struct {
+	format    *logformat.JSONFormat
+	fallback  *LogfmtRenderer
+	timeStyle lipgloss.Style
+	keyStyle  lipgloss.Style
+}
+
+// NewJSONRenderer creates a renderer for JSON logs with config
+func NewJSONRenderer(cfg *config.Config) *JSONRenderer {
+	return &JSONRenderer{
+		format:    logformat.NewJSONFormat(&cfg.JSON),
+		fallback:  NewLogfmtRenderer(cfg),
+		timeStyle: lipgloss.NewStyle().Foreground(lipgloss.Color(cfg.Theme.LineNumbers)),
+		keyStyle:  lipgloss.NewStyle().Foreground(lipgloss.Color(cfg.Theme.LineNumbers)),
+	}
+}
+
+// Render formats a JSON log line, or falls back to level colouring
+func (r *JSONRenderer) Render(line *source.Line) string {
+	rec, ok := r.format.Parse(line.Content)
+	if !ok {
+		return r.fallback.Render(line)
+	}
+	style := r.fallback.fallback.style(rec.Level)
+
+	var parts []string
+	if rec.Time != nil {
+		parts = append(parts, r.timeStyle.Render(rec.Time.Format("2006-01-02 15:04:05.000")))
+	}
+	if rec.Level != source.LevelUnknown {
+		parts = append(parts, style.Bold(true).Render(fmt.Sprintf("%-5s", rec.Level.Name())))
+	}
+	if rec.Logger != "" {
+		parts = append(parts, r.keyStyle.Render("["+rec.Logger+"]"))
+	}
+	if rec.Message != "" {
+		parts = append(parts, style.Render(oneLine(rec.Message)))
+	}
+	for _, f := range rec.Fields {
+		parts = append(parts, r.keyStyle.Render(f.Key+"=")+quoteValue(f.Value))
+	}
+	return strings.Join(parts, " ")
+}
+
+// quoteValue quotes a field value that would otherwise run into the next
+// one. Nested objects and arrays are shown as written.
+func quoteValue(v string) string {
+	if strings.HasPrefix(v, "{") || strings.HasPrefix(v, "[") {
+		return v
+	}
+	if v == "" || strings.ContainsAny(v, " \t\n\"=") {
+		return fmt.Sprintf("%q", v)
+	}
+	return v
+}
+
+// oneLine keeps a message with embedded line breaks on its row
+func oneLine(s string) string {
+	return strings.NewReplacer("\r\n", "\\n", "\n", "\\n", "\r", "\\r").Replace(s)
+}
@@ -0,0 +1,59 @@
+package render
+
+import (
+	"strings"
+
+	"github.com/TimelordUK/mless/internal/config"
+	"github.com/TimelordUK/mless/internal/source"
+	"github.com/TimelordUK/mless/pkg/logformat"
+	"github.com/charmbracelet/lipgloss"
+)
+
+// LogfmtRenderer colours logfmt lines field by field: keys dimmed, values in
+// the line's level colour, the level itself in bold. The text is shown as
+// written. Other lines are rendered as by LogLevelRenderer.
+type LogfmtRenderer struct {
+	format   *logformat.LogfmtFormat
+	fallback *LogLevelRenderer
+	keyStyle lipgloss.Style
+}
+
+// NewLogfmtRenderer creates a renderer for logfmt logs with config
+func NewLogfmtRenderer(cfg *config.Config) *LogfmtRenderer {
+	return &LogfmtRenderer{
+		format:   logformat.NewLogfmtFormat(&cfg.Logfmt),
+		fallback: NewLogLevelRenderer(cfg),
+		keyStyle: lipgloss.NewStyle().Foreground(lipgloss.Color(cfg.Theme.LineNumbers)),
+	}
+}
+
+// Render colours a logfmt line's keys and values, or falls back to level
+// colouring
+func (r *LogfmtRenderer) Render(line *source.Line) string {
+	pairs, level, ok := r.format.Pairs(line.Content)
+	if !ok {
+		return r.fallback.Render(line)
+	}
+	if level == source.LevelUnknown {
+		level = line.Level
+	}
+	style := r.fallback.style(level)
+
+	var sb strings.Builder
+	pos := 0
+	for _, p := range pairs {
+		sb.Write(line.Content[pos:p.Start])
+		sb.WriteString(r.keyStyle.Render(p.Key + "="))
+		switch p.Role {
+		case logformat.RoleLevel:
+			sb.WriteString(style.Bold(true).Render(p.Raw))
+		case logformat.RoleTime, logformat.RoleLogger:
+			sb.WriteString(r.keyStyle.Render(p.Raw))
+		default:
+			sb.WriteString(style.Render(p.Raw))
+		}
+		pos = p.End
+	}
+	sb.Write(line.Content[pos:])
+	return sb.String()
+}
@@ -18,18 +18,18 @@ type LogLevelRenderer struct {
 	styles   map[source.LogLevel]lipgloss.Style
 }
 
-// NewLogLevelRenderer creates a renderer with config
+// NewLogLevelRenderer creates a renderer with config, colouring lines by the
+// registered levels
 func NewLogLevelRenderer(cfg *config.Config) *LogLevelRenderer {
-	detector := logformat.NewLevelDetector(&cfg.LogLevels)
+	detector := logformat.NewLevelDetector()
+	detector.SetJSON(logformat.NewJSONFormat(&cfg.JSON))
+	detector.SetLogfmt(logformat.NewLogfmtFormat(&cfg.Logfmt))
 
 	styles := map[source.LogLevel]lipgloss.Style{
 		source.LevelUnknown: lipgloss.NewStyle(),
-		source.LevelTrace:   lipgloss.NewStyle().Foreground(lipgloss.Color(cfg.Theme.Levels.Trace)),
-		source.LevelDebug:   lipgloss.NewStyle().Foreground(lipgloss.Color(cfg.Theme.Levels.Debug)),
-		source.LevelInfo:    lipgloss.NewStyle().Foreground(lipgloss.Color(cfg.Theme.Levels.Info)),
-		source.LevelWarn:    lipgloss.NewStyle().Foreground(lipgloss.Color(cfg.Theme.Levels.Warn)),
-		source.LevelError:   lipgloss.NewStyle().Foreground(lipgloss.Color(cfg.Theme.Levels.Error)),
-		source.LevelFatal:   lipgloss.NewStyle().Foreground(lipgloss.Color(cfg.Theme.Levels.Fatal)),
+	}
+	for _, def := range logformat.Registered().All() {
+		styles[def.Level] = lipgloss.NewStyle().Foreground(lipgloss.Color(def.Color))
 	}
 
 	return &LogLevelRenderer{
@@ -40,14 +40,21 @@ func NewLogLevelRenderer(cfg *config.Config) *LogLevelRenderer {
 
 // Render applies log level styling to a line
 func (r *LogLevelRenderer) Render(line *source.Line) string {
-	// Detect level if not already set
+	// Detect level if the source didn't
 	level := line.Level
-	if level == source.LevelUnknown {
+	if level == source.LevelUnknown && !line.LevelKnown {
 		level = r.detector.Detect(line.Content)
 	}
 
-	style := r.styles[level]
-	return style.Render(string(line.Content))
+	return r.style(level).Render(string(line.Content))
+}
+
+// style returns the style lines of level are shown in
+func (r *LogLevelRenderer) style(level source.LogLevel) lipgloss.Style {
+	if style, ok := r.styles[level]; ok {
+		return style
+	}
+	return r.styles[source.LevelUnknown]
 }
 
 // PlainRenderer renders without styling
@@ -17,6 +17,7 @@ type Info struct {
 	EndLine    int        // End line (0-based, exclusive)
 	StartTime  *time.Time // If time-based slice
 	EndTime    *time.Time
+	BaseDate   time.Time  // Date timestamps without one are dated from
 	Parent     *Info      // For nested slices
 }
 
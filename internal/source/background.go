@@ -0,0 +1,185 @@
+package source
+
+import (
+	"context"
+	"maps"
+	"sort"
+	"sync"
+)
+
+// Over big sources, the index is rebuilt in the background so that changing
+// a filter (typing in the live filter, say) doesn't hold everything up. The
+// rebuild works on a copy of the filters taken when it starts and publishes
+// what it has found every so often, so the provider shows the first matches
+// straight away; changing the filters again cancels it and starts another.
+//
+// The copy has its own level filter, matchers and rule stack, so editing
+// them doesn't touch the rebuild. It shares the source, which must be safe
+// for concurrent use (FileSource is), and the detector and filter funcs,
+// which must be too.
+
+// defaultAsyncLines is the size of source, in lines, above which the index
+// is rebuilt in the background
+const defaultAsyncLines = 200_000
+
+// progressEvery is how many lines a background rebuild looks at between
+// publishing what it has found
+const progressEvery = 8192
+
+// filterBuild is a rebuild of the index under way in the background
+type filterBuild struct {
+	cancel  context.CancelFunc
+	stopped chan struct{} // closed when the goroutine returns
+
+	mu        sync.Mutex
+	index     []int // matching lines found so far
+	collected int   // how many of them the provider has taken in
+	done      int   // lines before this have all been looked at
+	total     int
+	result    *FilteredProvider // the finished copy, nil until then
+}
+
+// SetAsyncLines has the index rebuilt in the background when the source has
+// more than n lines (0: never)
+func (f *FilteredProvider) SetAsyncLines(n int) {
+	f.asyncLines = n
+}
+
+// Building reports whether the index is being rebuilt in the background,
+// starting the rebuild if the filters have changed
+func (f *FilteredProvider) Building() bool {
+	f.rebuildIndex()
+	return f.build != nil
+}
+
+// Progress reports how far a background rebuild has got: the lines looked
+// at so far, of total. Both are 0 if there's no rebuild under way.
+func (f *FilteredProvider) Progress() (done, total int) {
+	if f.build == nil {
+		return 0, 0
+	}
+	f.build.mu.Lock()
+	defer f.build.mu.Unlock()
+	return f.build.done, f.build.total
+}
+
+// startBuild rebuilds the index in the background, over a copy of the
+// filters as they are now
+func (f *FilteredProvider) startBuild(total int) {
+	f.cancelBuild()
+
+	c := f.filterCopy()
+
+	f.resetIndex()
+	f.from, _ = f.windowRange(total)
+	ctx, cancel := context.WithCancel(context.Background())
+	b := &filterBuild{cancel: cancel, stopped: make(chan struct{}), total: total}
+	f.build = b
+
+	c.progress = func(done int) bool {
+		b.publish(c.filteredIndices, done)
+		return ctx.Err() == nil
+	}
+	go func() {
+		defer close(b.stopped)
+		c.scanTo(total)
+		if ctx.Err() != nil {
+			return
+		}
+		c.progress = nil
+		b.mu.Lock()
+		b.index, b.done, b.result = c.filteredIndices, total, c
+		b.mu.Unlock()
+	}()
+}
+
+// filterCopy returns a provider with the same filters as f, and none of its
+// state, for a background rebuild to filter with
+func (f *FilteredProvider) filterCopy() *FilteredProvider {
+	c := &FilteredProvider{
+		source:       f.source,
+		detector:     f.detector,
+		levelFilter:  maps.Clone(f.levelFilter),
+		textFilter:   f.textFilter.clone(),
+		sourceFilter: f.sourceFilter,
+		fieldFilter:  f.fieldFilter,
+		exprFilter:   f.exprFilter,
+		since:        f.since,
+		until:        f.until,
+		rules:        cloneRules(f.rules),
+	}
+	c.resetIndex()
+	return c
+}
+
+// publish makes the matches found so far, looking at lines up to done,
+// visible to the provider
+func (b *filterBuild) publish(index []int, done int) {
+	b.mu.Lock()
+	b.index, b.done = index, done
+	b.mu.Unlock()
+}
+
+// collect takes in what the background rebuild has found: all of it, if it
+// has finished
+func (f *FilteredProvider) collect() {
+	b := f.build
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if r := b.result; r != nil {
+		f.filteredIndices = r.filteredIndices
+		f.ruleCounts = r.ruleCounts
+		f.tailStart, f.tailKept, f.tailCounts = r.tailStart, r.tailKept, r.tailCounts
+		f.scanned = r.scanned
+		f.build = nil
+		f.version++
+
+		// A :last window may have moved on while it ran
+		switch start := f.from; {
+		case start > f.tailStart:
+			f.dirty = true
+		case start > r.from:
+			f.dropBefore(r.from, start)
+		default:
+			f.from = r.from
+		}
+		return
+	}
+	if len(b.index) != b.collected {
+		// Leaving out what the time window has moved past since it started
+		b.collected = len(b.index)
+		k := sort.SearchInts(b.index, f.from)
+		f.filteredIndices = b.index[k:]
+		f.version++
+	}
+}
+
+// cancelBuild stops the background rebuild, if any
+func (f *FilteredProvider) cancelBuild() {
+	b := f.build
+	if b == nil {
+		return
+	}
+	b.cancel()
+	f.build = nil
+}
+
+// Stop cancels the background rebuild, if any, and waits for it to stop, so
+// that the source can be closed
+func (f *FilteredProvider) Stop() {
+	if b := f.build; b != nil {
+		f.cancelBuild()
+		<-b.stopped
+	}
+}
+
+// reportProgress tells a background rebuild that the lines before i have
+// been looked at, every progressEvery lines, and reports whether it's been
+// cancelled
+func (f *FilteredProvider) reportProgress(i int) bool {
+	if f.progress == nil || i < f.nextReport {
+		return true
+	}
+	f.nextReport = i + progressEvery
+	return f.progress(i)
+}
@@ -0,0 +1,535 @@
+package source
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/TimelordUK/mless/pkg/logformat"
+)
+
+// A filter expression tests the fields of a line:
+//
+//	level>=warn && logger=Database && latency>200ms && !msg~"retry"
+//
+// Comparisons are field op value, with op one of = != < <= > >= ~ (RE2
+// match) and !~; a field on its own tests that the line has it. They combine
+// with &&, || and !, and group with parentheses. level compares by rank,
+// time by timestamp (a time of day on its own is on the line's date, and a
+// time without a zone is in the line's zone, as its timestamp was read), and
+// other fields as numbers or durations when both sides are (a bare number
+// compared with a duration is milliseconds), and as text otherwise (= and
+// != ignoring case).
+
+// FieldFunc returns the value of the field name of a line's content: a JSON
+// or logfmt key, a group of the file's format profile, message or logger.
+// ok is false if the line has no such field.
+type FieldFunc func(content []byte, name string) (value string, ok bool)
+
+// Expr is a compiled filter expression
+type Expr struct {
+	src  string
+	root exprNode
+}
+
+// ExprError is a filter expression that doesn't parse: what's wrong, and
+// where (a byte offset into the expression)
+type ExprError struct {
+	Pos int
+	Msg string
+}
+
+func (e *ExprError) Error() string {
+	return fmt.Sprintf("col %d: %s", e.Pos+1, e.Msg)
+}
+
+// Column returns where the error is as a column (in runes) of src
+func (e *ExprError) Column(src string) int {
+	if e.Pos > len(src) {
+		return utf8.RuneCountInString(src)
+	}
+	return utf8.RuneCountInString(src[:e.Pos])
+}
+
+// ParseExpr compiles a filter expression. The error is an *ExprError.
+func ParseExpr(src string) (*Expr, error) {
+	p := &exprParser{src: src}
+	p.next()
+	root, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if p.tok.kind != tokEOF {
+		return nil, p.unexpected("")
+	}
+	return &Expr{src: src, root: root}, nil
+}
+
+// String returns the expression as written
+func (e *Expr) String() string {
+	return e.src
+}
+
+// Match reports whether line passes the expression, with fields giving the
+// values of fields other than level and time
+func (e *Expr) Match(line *Line, fields FieldFunc) bool {
+	return e.root.eval(line, fields)
+}
+
+// UsesTime reports whether the expression looks at line timestamps, which
+// Match takes from Line.Timestamp
+func (e *Expr) UsesTime() bool {
+	return usesTime(e.root)
+}
+
+func usesTime(n exprNode) bool {
+	switch n := n.(type) {
+	case *andNode:
+		return usesTime(n.left) || usesTime(n.right)
+	case *orNode:
+		return usesTime(n.left) || usesTime(n.right)
+	case *notNode:
+		return usesTime(n.expr)
+	case *cmpNode:
+		return n.field == "time"
+	}
+	return false
+}
+
+type exprNode interface {
+	eval(line *Line, fields FieldFunc) bool
+}
+
+type andNode struct{ left, right exprNode }
+type orNode struct{ left, right exprNode }
+type notNode struct{ expr exprNode }
+
+func (n *andNode) eval(line *Line, fields FieldFunc) bool {
+	return n.left.eval(line, fields) && n.right.eval(line, fields)
+}
+
+func (n *orNode) eval(line *Line, fields FieldFunc) bool {
+	return n.left.eval(line, fields) || n.right.eval(line, fields)
+}
+
+func (n *notNode) eval(line *Line, fields FieldFunc) bool {
+	return !n.expr.eval(line, fields)
+}
+
+// cmpNode compares a field with a value, or tests the field is there if op
+// is ""
+type cmpNode struct {
+	field string
+	op    string
+	value string
+
+	re     *regexp.Regexp // for ~ and !~
+	rank   int            // for level
+	at     time.Time      // for time
+	clock  bool           // at is a time of day
+	zoned  bool           // at was written with a zone; otherwise it's in the line's
+	number float64        // value as a number, if isNum
+	isNum  bool
+	isDur  bool // value is a duration, and number is it in nanoseconds
+}
+
+func (n *cmpNode) eval(line *Line, fields FieldFunc) bool {
+	switch n.field {
+	case "level":
+		if n.op == "" {
+			return line.Level != LevelUnknown
+		}
+		return compare(n.op, float64(line.Level.Rank()), float64(n.rank))
+	case "time":
+		if line.Timestamp == nil {
+			return n.op == "!="
+		}
+		if n.op == "" {
+			return true
+		}
+		ts, at := *line.Timestamp, n.at
+		switch {
+		case n.clock:
+			y, m, d := ts.Date()
+			at = time.Date(y, m, d, at.Hour(), at.Minute(), at.Second(), at.Nanosecond(), ts.Location())
+		case !n.zoned:
+			at = time.Date(at.Year(), at.Month(), at.Day(), at.Hour(), at.Minute(), at.Second(), at.Nanosecond(), ts.Location())
+		}
+		return compare(n.op, float64(ts.Sub(at)), 0)
+	}
+
+	v, ok := fields(line.Content, n.field)
+	switch n.op {
+	case "":
+		return ok
+	case "!=", "!~":
+		if !ok {
+			return true
+		}
+	}
+	if !ok {
+		return false
+	}
+
+	switch n.op {
+	case "~":
+		return n.re.MatchString(v)
+	case "!~":
+		return !n.re.MatchString(v)
+	}
+	if n.isDur {
+		if d, err := time.ParseDuration(v); err == nil {
+			return compare(n.op, float64(d), n.number)
+		}
+		if ms, err := strconv.ParseFloat(v, 64); err == nil {
+			return compare(n.op, ms*float64(time.Millisecond), n.number)
+		}
+		return false
+	}
+	if n.isNum {
+		if f, err := strconv.ParseFloat(v, 64); err == nil {
+			return compare(n.op, f, n.number)
+		}
+	}
+	switch n.op {
+	case "=":
+		return strings.EqualFold(v, n.value)
+	case "!=":
+		return !strings.EqualFold(v, n.value)
+	}
+	return compare(n.op, float64(strings.Compare(v, n.value)), 0)
+}
+
+// compare applies op to a and b
+func compare(op string, a, b float64) bool {
+	switch op {
+	case "=":
+		return a == b
+	case "!=":
+		return a != b
+	case "<":
+		return a < b
+	case "<=":
+		return a <= b
+	case ">":
+		return a > b
+	case ">=":
+		return a >= b
+	}
+	return false
+}
+
+// timeLayouts are the ways a time can be written in an expression
+var timeLayouts = []struct {
+	layout string
+	clock  bool
+}{
+	{time.RFC3339Nano, false},
+	{"2006-01-02T15:04:05", false},
+	{"2006-01-02 15:04:05", false},
+	{"2006-01-02 15:04", false},
+	{"2006-01-02", false},
+	{"15:04:05", true},
+	{"15:04", true},
+}
+
+type tokKind int
+
+const (
+	tokEOF tokKind = iota
+	tokField
+	tokValue
+	tokString
+	tokOp
+	tokAnd
+	tokOr
+	tokNot
+	tokLParen
+	tokRParen
+	tokBad // text says what's wrong
+)
+
+type exprToken struct {
+	kind tokKind
+	text string
+	pos  int
+}
+
+func (t exprToken) String() string {
+	switch t.kind {
+	case tokEOF:
+		return "end of expression"
+	case tokString:
+		return strconv.Quote(t.text)
+	}
+	return "'" + t.text + "'"
+}
+
+// exprParser is a recursive descent parser over tokens read on demand; after
+// an operator, the next token is read as a value rather than a field
+type exprParser struct {
+	src string
+	pos int
+	tok exprToken
+}
+
+func (p *exprParser) errorf(pos int, format string, args ...any) error {
+	return &ExprError{Pos: pos, Msg: fmt.Sprintf(format, args...)}
+}
+
+// unexpected is the error for the current token where want was expected
+// ("" to not say)
+func (p *exprParser) unexpected(want string) error {
+	switch {
+	case p.tok.kind == tokBad:
+		return p.errorf(p.tok.pos, "%s", p.tok.text)
+	case want == "":
+		return p.errorf(p.tok.pos, "unexpected %s", p.tok)
+	case p.tok.kind == tokEOF:
+		return p.errorf(p.tok.pos, "expected %s", want)
+	}
+	return p.errorf(p.tok.pos, "expected %s but found %s", want, p.tok)
+}
+
+// next reads the next token as a field name, operator or punctuation
+func (p *exprParser) next() {
+	for p.pos < len(p.src) && (p.src[p.pos] == ' ' || p.src[p.pos] == '\t') {
+		p.pos++
+	}
+	start := p.pos
+	if p.pos >= len(p.src) {
+		p.tok = exprToken{kind: tokEOF, pos: start}
+		return
+	}
+	two := ""
+	if p.pos+1 < len(p.src) {
+		two = p.src[p.pos : p.pos+2]
+	}
+	switch two {
+	case "&&":
+		p.pos += 2
+		p.tok = exprToken{tokAnd, two, start}
+		return
+	case "||":
+		p.pos += 2
+		p.tok = exprToken{tokOr, two, start}
+		return
+	case "!=", "!~", "<=", ">=":
+		p.pos += 2
+		p.tok = exprToken{tokOp, two, start}
+		return
+	}
+	switch c := p.src[p.pos]; c {
+	case '(':
+		p.pos++
+		p.tok = exprToken{tokLParen, "(", start}
+	case ')':
+		p.pos++
+		p.tok = exprToken{tokRParen, ")", start}
+	case '!':
+		p.pos++
+		p.tok = exprToken{tokNot, "!", start}
+	case '=', '<', '>', '~':
+		p.pos++
+		if c == '=' && p.pos < len(p.src) && p.src[p.pos] == '=' {
+			p.pos++ // == is =
+		}
+		p.tok = exprToken{tokOp, string(c), start}
+	case '"':
+		p.readString()
+	default:
+		for p.pos < len(p.src) {
+			r, size := utf8.DecodeRuneInString(p.src[p.pos:])
+			if !unicode.IsLetter(r) && !unicode.IsDigit(r) && !strings.ContainsRune("_.-@$", r) {
+				break
+			}
+			p.pos += size
+		}
+		if p.pos == start {
+			r, size := utf8.DecodeRuneInString(p.src[p.pos:])
+			p.pos += size
+			p.tok = exprToken{tokBad, fmt.Sprintf("unexpected '%c'", r), start}
+			return
+		}
+		p.tok = exprToken{tokField, p.src[start:p.pos], start}
+	}
+}
+
+// nextValue reads the next token as a value: a quoted string, or everything
+// up to a space, parenthesis, && or ||
+func (p *exprParser) nextValue() {
+	for p.pos < len(p.src) && (p.src[p.pos] == ' ' || p.src[p.pos] == '\t') {
+		p.pos++
+	}
+	start := p.pos
+	if p.pos < len(p.src) && p.src[p.pos] == '"' {
+		p.readString()
+		return
+	}
+	for p.pos < len(p.src) {
+		c := p.src[p.pos]
+		if c == ' ' || c == '\t' || c == '(' || c == ')' ||
+			strings.HasPrefix(p.src[p.pos:], "&&") || strings.HasPrefix(p.src[p.pos:], "||") {
+			break
+		}
+		p.pos++
+	}
+	if p.pos == start {
+		p.tok = exprToken{tokBad, "expected a value", start}
+		return
+	}
+	p.tok = exprToken{tokValue, p.src[start:p.pos], start}
+}
+
+// readString reads a double-quoted string with Go escapes
+func (p *exprParser) readString() {
+	start := p.pos
+	p.pos++
+	for p.pos < len(p.src) && p.src[p.pos] != '"' {
+		if p.src[p.pos] == '\\' {
+			p.pos++
+		}
+		p.pos++
+	}
+	if p.pos >= len(p.src) {
+		p.pos = len(p.src)
+		p.tok = exprToken{tokBad, "unterminated string", start}
+		return
+	}
+	p.pos++
+	s, err := strconv.Unquote(p.src[start:p.pos])
+	if err != nil {
+		p.tok = exprToken{tokBad, "bad escape in string", start}
+		return
+	}
+	p.tok = exprToken{tokString, s, start}
+}
+
+func (p *exprParser) parseOr() (exprNode, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.tok.kind == tokOr {
+		p.next()
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = &orNode{left, right}
+	}
+	return left, nil
+}
+
+func (p *exprParser) parseAnd() (exprNode, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for p.tok.kind == tokAnd {
+		p.next()
+		right, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		left = &andNode{left, right}
+	}
+	return left, nil
+}
+
+func (p *exprParser) parseUnary() (exprNode, error) {
+	switch p.tok.kind {
+	case tokNot:
+		p.next()
+		expr, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return &notNode{expr}, nil
+	case tokLParen:
+		open := p.tok.pos
+		p.next()
+		expr, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if p.tok.kind == tokEOF {
+			return nil, p.errorf(open, "unclosed (")
+		}
+		if p.tok.kind != tokRParen {
+			return nil, p.unexpected("&&, || or )")
+		}
+		p.next()
+		return expr, nil
+	case tokField:
+		return p.parseCmp()
+	}
+	return nil, p.unexpected("a field")
+}
+
+func (p *exprParser) parseCmp() (exprNode, error) {
+	n := &cmpNode{field: strings.ToLower(p.tok.text)}
+	switch n.field {
+	case "level", "time":
+	default:
+		n.field = p.tok.text // other fields keep their case
+	}
+	p.next()
+	if p.tok.kind != tokOp {
+		return n, nil
+	}
+	n.op = p.tok.text
+
+	p.nextValue()
+	valuePos := p.tok.pos
+	if p.tok.kind != tokValue && p.tok.kind != tokString {
+		return nil, p.unexpected("a value")
+	}
+	n.value = p.tok.text
+	p.next()
+
+	switch {
+	case n.op == "~" || n.op == "!~":
+		re, err := regexp.Compile(n.value)
+		if err != nil {
+			return nil, p.errorf(valuePos, "regex: %s", regexError(err))
+		}
+		n.re = re
+	case n.field == "level":
+		levels := logformat.Registered()
+		level, ok := levels.Lookup(n.value)
+		if !ok {
+			level = levels.FromName(n.value)
+		}
+		if level == LevelUnknown {
+			return nil, p.errorf(valuePos, "unknown level %q", n.value)
+		}
+		n.rank = level.Rank()
+	case n.field == "time":
+		for _, l := range timeLayouts {
+			if t, err := time.ParseInLocation(l.layout, n.value, time.UTC); err == nil {
+				n.at, n.clock, n.zoned = t, l.clock, l.layout == time.RFC3339Nano
+				return n, nil
+			}
+		}
+		return nil, p.errorf(valuePos, "can't read %q as a time (2006-01-02 15:04:05, 15:04, ...)", n.value)
+	default:
+		if d, err := time.ParseDuration(n.value); err == nil && !isNumber(n.value) {
+			n.number, n.isDur = float64(d), true
+		} else if f, err := strconv.ParseFloat(n.value, 64); err == nil && !math.IsNaN(f) {
+			n.number, n.isNum = f, true
+		}
+	}
+	return n, nil
+}
+
+// isNumber reports whether s is a plain number ("0" parses as a duration)
+func isNumber(s string) bool {
+	_, err := strconv.ParseFloat(s, 64)
+	return err == nil
+}
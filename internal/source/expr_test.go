@@ -0,0 +1,216 @@
+package source
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testFields reads fields from content written as space-separated k=v pairs
+func testFields(content []byte, name string) (string, bool) {
+	for _, kv := range strings.Fields(string(content)) {
+		if k, v, ok := strings.Cut(kv, "="); ok && k == name {
+			return v, true
+		}
+	}
+	return "", false
+}
+
+// matchLine parses expr and matches it against line
+func matchLine(t *testing.T, expr string, line *Line) bool {
+	t.Helper()
+	e, err := ParseExpr(expr)
+	if err != nil {
+		t.Fatalf("%s: %v", expr, err)
+	}
+	return e.Match(line, testFields)
+}
+
+// matchExpr parses expr and matches it against a line of content
+func matchExpr(t *testing.T, expr, content string) bool {
+	t.Helper()
+	return matchLine(t, expr, &Line{Content: []byte(content)})
+}
+
+func TestExprPrecedence(t *testing.T) {
+	for _, c := range []struct {
+		expr, line string
+		want       bool
+	}{
+		// && binds tighter than ||
+		{`a=1 || b=1 && c=1`, "a=1 b=0 c=0", true},
+		{`a=1 || b=1 && c=1`, "a=0 b=1 c=0", false},
+		{`(a=1 || b=1) && c=1`, "a=1 b=0 c=0", false},
+		{`a=1 && b=1 || c=1`, "a=0 b=0 c=1", true},
+		// ! binds tighter than both
+		{`!a=1 && b=1`, "a=0 b=1", true},
+		{`!a=1 && b=1`, "a=1 b=1", false},
+		{`!(a=1 && b=1)`, "a=1 b=0", true},
+		{`!!a=1`, "a=1", true},
+		{`!a`, "b=1", true},
+		// Left to right within a level
+		{`a=1 && b=1 && c=1`, "a=1 b=1 c=0", false},
+		{`a=1 || b=1 || c=1`, "a=0 b=0 c=1", true},
+		// A field on its own tests it's there
+		{`a && !b`, "a=0", true},
+		{`a && !b`, "a=0 b=0", false},
+		// = and == are the same, and text compares ignoring case
+		{`name==Bob`, "name=bob", true},
+		{`name!=bob`, "name=BOB", false},
+		{`name!=bob`, "other=1", true},
+		{`name<bob`, "name=alice", true},
+	} {
+		if got := matchExpr(t, c.expr, c.line); got != c.want {
+			t.Errorf("%s on %q: got %v, want %v", c.expr, c.line, got, c.want)
+		}
+	}
+}
+
+func TestExprRegex(t *testing.T) {
+	for _, c := range []struct {
+		expr, line string
+		want       bool
+	}{
+		{`msg~retry`, "msg=will_retry", true},
+		{`msg~"^re(try|do)$"`, "msg=redo", true},
+		{`msg~"^re(try|do)$"`, "msg=redone", false},
+		{`msg~RETRY`, "msg=retry", false}, // case matters, unlike =
+		{`msg~"(?i)RETRY"`, "msg=retry", true},
+		{`msg!~retry`, "msg=failed", true},
+		{`msg!~retry`, "msg=retry", false},
+		{`msg~retry`, "other=retry", false},
+		{`msg!~retry`, "other=retry", true}, // no msg, so it doesn't match
+		{`msg~"a b"`, "msg=a", false},
+		{`path~"\\.go$"`, "path=main.go", true},
+	} {
+		if got := matchExpr(t, c.expr, c.line); got != c.want {
+			t.Errorf("%s on %q: got %v, want %v", c.expr, c.line, got, c.want)
+		}
+	}
+}
+
+func TestExprNumbersAndDurations(t *testing.T) {
+	for _, c := range []struct {
+		expr, line string
+		want       bool
+	}{
+		{`latency>200ms`, "latency=250ms", true},
+		{`latency>200ms`, "latency=150ms", false},
+		{`latency>200ms`, "latency=1.2s", true},
+		{`latency>=1m`, "latency=59s", false},
+		{`latency>=1m30s`, "latency=1m30s", true},
+		{`latency<1s`, "latency=999", true}, // bare numbers are milliseconds
+		{`latency<1s`, "latency=1001", false},
+		{`latency<1s`, "latency=slow", false},
+		{`latency=500us`, "latency=0.5ms", true},
+		// Plain numbers compare as numbers, not text
+		{`status>=500`, "status=502", true},
+		{`status>=500`, "status=60", false},
+		{`status=0`, "status=0.0", true},
+		{`ratio<1.5`, "ratio=1.25", true},
+		{`status>=500`, "status=n/a", true}, // not a number, so compared as text
+	} {
+		if got := matchExpr(t, c.expr, c.line); got != c.want {
+			t.Errorf("%s on %q: got %v, want %v", c.expr, c.line, got, c.want)
+		}
+	}
+}
+
+// TestExprTimeZone checks that a time written without a zone is taken in
+// the line's zone, as a timestamp without one was read in, and that one
+// with a zone is compared as written.
+func TestExprTimeZone(t *testing.T) {
+	ts := time.Date(2024, 1, 15, 10, 30, 0, 0, time.FixedZone("CET", 3600))
+	line := &Line{Timestamp: &ts}
+	for _, c := range []struct {
+		expr string
+		want bool
+	}{
+		{`time>"2024-01-15 10:00"`, true},
+		{`time<"2024-01-15 10:00"`, false},
+		{`time>2024-01-15T10:00:00Z`, false},
+		{`time<2024-01-15T10:00:00+02:00`, false},
+	} {
+		if got := matchLine(t, c.expr, line); got != c.want {
+			t.Errorf("%s at 10:30 CET: got %v, want %v", c.expr, got, c.want)
+		}
+	}
+}
+
+func TestExprLevelAndTime(t *testing.T) {
+	ts := time.Date(2024, 1, 15, 10, 30, 0, 0, time.UTC)
+	line := &Line{Level: LevelWarn, Timestamp: &ts}
+	bare := &Line{}
+	for _, c := range []struct {
+		expr       string
+		want, bare bool
+	}{
+		{`level>=warn`, true, false},
+		{`level>warn`, false, false},
+		{`LEVEL=Warning`, true, false},
+		{`level`, true, false},
+		{`time>=10:00 && time<11:00`, true, false},
+		{`time<"2024-01-15 10:30:01"`, true, false},
+		{`time>2024-01-16`, false, false},
+		{`time!=10:30`, false, true},
+	} {
+		e, err := ParseExpr(c.expr)
+		if err != nil {
+			t.Fatalf("%s: %v", c.expr, err)
+		}
+		if got := e.Match(line, testFields); got != c.want {
+			t.Errorf("%s: got %v, want %v", c.expr, got, c.want)
+		}
+		if got := e.Match(bare, testFields); got != c.bare {
+			t.Errorf("%s on a line without level or time: got %v, want %v", c.expr, got, c.bare)
+		}
+		if e.UsesTime() != strings.Contains(c.expr, "time") {
+			t.Errorf("%s: UsesTime %v", c.expr, e.UsesTime())
+		}
+	}
+}
+
+// TestExprErrors checks where parse errors point, as the column the ?
+// prompt's caret goes under.
+func TestExprErrors(t *testing.T) {
+	for _, c := range []struct {
+		expr string
+		col  int
+		msg  string
+	}{
+		{``, 0, "expected a field"},
+		{`!`, 1, "expected a field"},
+		{`a=`, 2, "expected a value"},
+		{`a= && b`, 3, "expected a value"},
+		{`a=1 ||`, 6, "expected a field"},
+		{`a=1 && || b`, 7, "expected a field but found '||'"},
+		{`()`, 1, "expected a field but found ')'"},
+		{`(a=1`, 0, "unclosed ("},
+		{`((a=1) || b`, 0, "unclosed ("},
+		{`a=1)`, 3, "unexpected ')'"},
+		{`a=1 b=2`, 4, "unexpected 'b'"},
+		{`a=1 # b`, 4, "unexpected '#'"},
+		{`"a"=1`, 0, `expected a field but found "a"`},
+		{`msg~"["`, 4, "regex: missing closing ]"},
+		{`msg="x\q"`, 4, "bad escape"},
+		{`msg="open`, 4, "unterminated string"},
+		{`level=loud`, 6, "unknown level"},
+		{`time>noon`, 5, "can't read"},
+		{`nom="été" && #`, 13, "unexpected '#'"}, // columns count runes
+	} {
+		_, err := ParseExpr(c.expr)
+		var e *ExprError
+		if !errors.As(err, &e) {
+			t.Fatalf("%s: error %v", c.expr, err)
+		}
+		if e.Column(c.expr) != c.col || !strings.Contains(e.Msg, c.msg) {
+			t.Errorf("%s: col %d %q, want col %d %q", c.expr, e.Column(c.expr), e.Msg, c.col, c.msg)
+		}
+	}
+
+	_, err := ParseExpr(`a=1 b`)
+	if err == nil || err.Error() != "col 5: unexpected 'b'" {
+		t.Fatalf("error text: %v", err)
+	}
+}
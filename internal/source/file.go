@@ -1,27 +1,53 @@
 package source
 
 import (
+	"context"
+	"sync"
 	"time"
 
 	"github.com/TimelordUK/mless/internal/index"
 	mlessio "github.com/TimelordUK/mless/internal/io"
 )
 
-// FileSource provides lines from a single file
+// FileSource provides lines from a single file. It is safe for concurrent
+// use, so that a filter can be rebuilt in the background (see
+// FilteredProvider) while the file is refreshed.
 type FileSource struct {
-	file      *mlessio.MappedFile
+	// mu is held to read lines, and exclusively by Refresh, which may remap
+	// the file or replace the index
+	mu sync.RWMutex
+
+	file      mlessio.File
 	lineIndex *index.LineIndex
 	path      string
+	opts      index.Options
+
+	// lineSource, if set, tags each line with where it came from (e.g. the
+	// stdout/stderr stream of a command)
+	lineSource func(idx int) *SourceInfo
+
+	// records groups continuation lines with the line that starts them
+	records bool
+
+	// reported is the line count Refresh last reported, which background
+	// indexing (of a rotated file, say) may have got past since
+	reported int
 }
 
 // NewFileSource creates a new file source
 func NewFileSource(path string) (*FileSource, error) {
-	file, err := mlessio.OpenMapped(path)
+	return NewFileSourceWithOptions(path, index.Options{})
+}
+
+// NewFileSourceWithOptions creates a new file source whose line index is
+// built with opts (e.g. to use the persistent index cache)
+func NewFileSourceWithOptions(path string, opts index.Options) (*FileSource, error) {
+	file, err := mlessio.Open(path)
 	if err != nil {
 		return nil, err
 	}
 
-	lineIndex, err := index.BuildLineIndex(file)
+	lineIndex, err := index.NewLineIndexWithOptions(file, opts)
 	if err != nil {
 		file.Close()
 		return nil, err
@@ -31,16 +57,23 @@ func NewFileSource(path string) (*FileSource, error) {
 		file:      file,
 		lineIndex: lineIndex,
 		path:      path,
+		opts:      opts,
+		reported:  lineIndex.LineCount(),
 	}, nil
 }
 
 // LineCount returns total number of lines
 func (s *FileSource) LineCount() int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
 	return s.lineIndex.LineCount()
 }
 
 // GetLine returns line at index
 func (s *FileSource) GetLine(idx int) (*Line, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
 	content, err := s.lineIndex.GetLine(idx)
 	if err != nil {
 		return nil, err
@@ -49,15 +82,21 @@ func (s *FileSource) GetLine(idx int) (*Line, error) {
 		return nil, nil
 	}
 
+	level, known := s.lineLevel(idx)
 	return &Line{
 		Content:       content,
-		Level:         LevelUnknown,
+		Level:         level,
+		LevelKnown:    known,
+		Source:        s.sourceOf(idx),
 		OriginalIndex: idx,
 	}, nil
 }
 
 // GetLines returns a range of lines
 func (s *FileSource) GetLines(start, count int) ([]*Line, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
 	rawLines, err := s.lineIndex.GetLines(start, count)
 	if err != nil {
 		return nil, err
@@ -65,64 +104,238 @@ func (s *FileSource) GetLines(start, count int) ([]*Line, error) {
 
 	lines := make([]*Line, len(rawLines))
 	for i, content := range rawLines {
+		level, known := s.lineLevel(start + i)
 		lines[i] = &Line{
 			Content:       content,
-			Level:         LevelUnknown,
+			Level:         level,
+			LevelKnown:    known,
+			Source:        s.sourceOf(start + i),
 			OriginalIndex: start + i,
 		}
 	}
 	return lines, nil
 }
 
-// Close closes the file source
+// SetLineSource installs a function that reports where each line came from;
+// its result is attached to lines as Line.Source
+func (s *FileSource) SetLineSource(fn func(idx int) *SourceInfo) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.lineSource = fn
+}
+
+func (s *FileSource) sourceOf(idx int) *SourceInfo {
+	if s.lineSource == nil {
+		return nil
+	}
+	return s.lineSource(idx)
+}
+
+// SetRecords turns multi-line record grouping on or off. With it on,
+// continuation lines take the level and timestamp of their record's first
+// line, and filters that understand records keep or drop them together.
+func (s *FileSource) SetRecords(on bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.records = on
+}
+
+// RecordsEnabled reports whether lines are grouped into records
+func (s *FileSource) RecordsEnabled() bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.records
+}
+
+// RecordBounds returns the lines [start, end) of the record containing line;
+// just the line itself when records are off
+func (s *FileSource) RecordBounds(line int) (start, end int) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if !s.records {
+		return line, line + 1
+	}
+	return s.lineIndex.RecordStart(line), s.lineIndex.RecordEnd(line)
+}
+
+// lineLevel returns the level detected for a line while indexing; known is
+// false if it wasn't, leaving detection to the caller. With records on, a
+// continuation line takes its record's level.
+func (s *FileSource) lineLevel(idx int) (level LogLevel, known bool) {
+	level, known = s.lineIndex.Level(idx)
+	if known && level == LevelUnknown && s.records {
+		level = s.lineIndex.RecordLevel(idx)
+	}
+	return level, known
+}
+
+// Indexing reports whether the line index is still being built in the
+// background (LineCount grows until it finishes)
+func (s *FileSource) Indexing() bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return !s.lineIndex.Done()
+}
+
+// IndexProgress returns how much of the file has been indexed (0 to 1)
+func (s *FileSource) IndexProgress() float64 {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.lineIndex.Progress()
+}
+
+// WaitIndexed blocks until the line index is complete or ctx is cancelled
+func (s *FileSource) WaitIndexed(ctx context.Context) error {
+	s.mu.RLock()
+	lineIndex := s.lineIndex
+	s.mu.RUnlock()
+	return lineIndex.Wait(ctx)
+}
+
+// IndexFromCache reports whether the line index was loaded from the
+// persistent index cache instead of scanning the file
+func (s *FileSource) IndexFromCache() bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.lineIndex.FromCache()
+}
+
+// Samples returns the timestamps and levels sampled while indexing
+func (s *FileSource) Samples() []index.Sample {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.lineIndex.Samples()
+}
+
+// Close stops any background indexing, updates the index cache if the file
+// grew while open, and closes the file source
 func (s *FileSource) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.lineIndex.Close()
 	return s.file.Close()
 }
 
+// Err reports a persistent read problem with the underlying file (currently
+// only truncation under the mapping), or nil if reads are healthy
+func (s *FileSource) Err() error {
+	return s.file.Err()
+}
+
 // Path returns the file path
 func (s *FileSource) Path() string {
 	return s.path
 }
 
-// Refresh checks if file has grown and indexes new lines
-func (s *FileSource) Refresh() (int, error) {
+// Compression reports the format the file is decompressed from, if any
+func (s *FileSource) Compression() mlessio.Compression {
+	if c, ok := s.file.(*mlessio.CompressedFile); ok {
+		return c.Compression()
+	}
+	return mlessio.CompressionNone
+}
+
+// Sequential reports whether the file is compressed as one frame or block
+// that only decodes from the top, which makes jumping back through it slow
+func (s *FileSource) Sequential() bool {
+	c, ok := s.file.(*mlessio.CompressedFile)
+	return ok && c.Sequential()
+}
+
+// RefreshResult reports what a Refresh found
+type RefreshResult struct {
+	NewLines int  // lines added since the last refresh (after a rotation, those indexed so far)
+	Grown    bool // content was appended, maybe only to the last line
+	Rotated  bool // file was truncated or replaced and is being re-indexed
+}
+
+// Refresh checks if the file has grown or rotated and re-indexes accordingly.
+// A big file is re-indexed in the background after a rotation; later calls
+// report its lines as they're found.
+func (s *FileSource) Refresh() (RefreshResult, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// The file is looked at again once the index is complete, at first and
+	// after a rotation; until then, what's been indexed is new
+	if !s.lineIndex.Done() {
+		return s.newLines(), nil
+	}
+
 	oldSize := s.file.Size()
-	oldLineCount := s.lineIndex.LineCount()
 
-	changed, err := s.file.Refresh()
+	change, err := s.file.Refresh()
 	if err != nil {
-		return 0, err
+		return RefreshResult{}, err
 	}
 
-	if !changed {
-		return 0, nil
-	}
+	switch change {
+	case mlessio.Grown:
+		// Index new lines
+		if err := s.lineIndex.AppendNewLines(oldSize); err != nil {
+			return RefreshResult{}, err
+		}
+		result := s.newLines()
+		result.Grown = true
+		return result, nil
 
-	// Index new lines
-	if err := s.lineIndex.AppendNewLines(oldSize); err != nil {
-		return 0, err
+	case mlessio.Rotated:
+		// Old offsets point into content that no longer exists; start over
+		s.lineIndex.Discard()
+		lineIndex, err := index.NewLineIndexWithOptions(s.file, s.opts)
+		if err != nil {
+			return RefreshResult{}, err
+		}
+		s.lineIndex, s.reported = lineIndex, 0
+		return RefreshResult{NewLines: s.newLines().NewLines, Rotated: true}, nil
 	}
 
-	newLines := s.lineIndex.LineCount() - oldLineCount
-	return newLines, nil
+	// Background indexing may have finished since the last call
+	return s.newLines(), nil
+}
+
+// newLines reports the lines indexed since Refresh last reported
+func (s *FileSource) newLines() RefreshResult {
+	count := s.lineIndex.LineCount()
+	result := RefreshResult{NewLines: count - s.reported, Grown: count > s.reported}
+	s.reported = count
+	return result
 }
 
-// GetTimestamp returns the timestamp for a line
+// GetTimestamp returns the timestamp for a line. With records on, a
+// continuation line has its record's timestamp.
 func (s *FileSource) GetTimestamp(lineNum int) *time.Time {
-	return s.lineIndex.GetTimestamp(lineNum)
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	ts := s.lineIndex.GetTimestamp(lineNum)
+	if ts == nil && s.records {
+		if start := s.lineIndex.RecordStart(lineNum); start != lineNum {
+			ts = s.lineIndex.GetTimestamp(start)
+		}
+	}
+	return ts
 }
 
 // FindLineAtTime finds the first line at or after the given time
 func (s *FileSource) FindLineAtTime(target time.Time) int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
 	return s.lineIndex.FindLineAtTime(target)
 }
 
 // FindLineBeforeTime finds the last line before the given time
 func (s *FileSource) FindLineBeforeTime(target time.Time) int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
 	return s.lineIndex.FindLineBeforeTime(target)
 }
 
 // FindNearestLineAtTime finds the line with timestamp closest to the given time
 func (s *FileSource) FindNearestLineAtTime(target time.Time) int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
 	return s.lineIndex.FindNearestLineAtTime(target)
 }
@@ -0,0 +1,64 @@
+package source
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// TestRefreshAfterRotation replaces a followed file with one big enough to
+// be re-indexed in the background. Refresh reports the rotation straight
+// away, and the rest of the new lines as the index finds them.
+func TestRefreshAfterRotation(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "app.log")
+	if err := os.WriteFile(path, []byte("first\nsecond\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	src, err := NewFileSource(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer src.Close()
+
+	const lines = 400_000 // over 16MB, the size indexed in the background
+	var big bytes.Buffer
+	for i := 0; i < lines; i++ {
+		fmt.Fprintf(&big, "10:00:00 INFO rotated line %07d of the replacement file\n", i)
+	}
+	if err := os.Rename(path, path+".1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, big.Bytes(), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	result, err := src.Refresh()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !result.Rotated {
+		t.Fatal("the replaced file should be reported as rotated")
+	}
+	total := result.NewLines
+	deadline := time.Now().Add(10 * time.Second)
+	for total < lines {
+		if time.Now().After(deadline) {
+			t.Fatalf("%d new lines reported, want %d", total, lines)
+		}
+		time.Sleep(time.Millisecond)
+		result, err := src.Refresh()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if result.Rotated {
+			t.Fatal("rotation reported twice")
+		}
+		total += result.NewLines
+	}
+	if total != lines || src.LineCount() != lines {
+		t.Fatalf("%d new lines reported, %d indexed, want %d", total, src.LineCount(), lines)
+	}
+}
@@ -1,10 +1,31 @@
 package source
 
-import "bytes"
+import (
+	"sort"
+	"time"
 
-// LevelDetectFunc detects log level from content
+	"github.com/TimelordUK/mless/pkg/logformat"
+)
+
+// LevelDetectFunc detects log level from content. Like the filter funcs
+// below, it may be called from a background rebuild while the UI uses it
+// too, so it must be safe for concurrent use.
 type LevelDetectFunc func(content []byte) LogLevel
 
+// FieldMatchFunc reports whether a structured line's fields pass a filter
+// (safe for concurrent use)
+type FieldMatchFunc func(content []byte) bool
+
+// TimeSearcher is implemented by sources that can find lines by timestamp
+type TimeSearcher interface {
+	// FindLineAtTime returns the first line at or after t, or -1 if none
+	FindLineAtTime(t time.Time) int
+}
+
+// LineMatchFunc reports whether a line, its level and timestamp included,
+// passes a filter (safe for concurrent use)
+type LineMatchFunc func(line *Line) bool
+
 // FilteredProvider wraps a LineProvider and filters by log level
 type FilteredProvider struct {
 	source   LineProvider
@@ -13,12 +34,67 @@ type FilteredProvider struct {
 	// Level filter: if set, only show lines with these levels
 	levelFilter map[LogLevel]bool
 
-	// Text filter: substring match
-	textFilter []byte
+	// Text filter: substring or regex match
+	textFilter *Matcher
+
+	// Source filter: if set, only show lines whose Source.Path matches
+	// (e.g. "stderr" for a command's error stream)
+	sourceFilter string
+
+	// Field filter: if set, only show lines it accepts; fieldDesc is how
+	// it was written (e.g. "user=42")
+	fieldFilter FieldMatchFunc
+	fieldDesc   string
+
+	// Expression filter: if set, only show lines it accepts; exprDesc is
+	// the expression as written
+	exprFilter LineMatchFunc
+	exprDesc   string
+
+	// Time window: if either is set, only show lines from the first at or
+	// after since up to the first at or after until (lines without a
+	// timestamp go with the one before). Needs a TimeSearcher source.
+	since, until *time.Time
+
+	// Rule stack: include and exclude rules applied in order after the
+	// other filters, and how many lines each matched at the last rebuild
+	rules      []Rule
+	ruleCounts []int
+	ruleHits   []bool // which rules match the line being looked at
 
 	// Cached filtered indices (original line numbers that pass filter)
 	filteredIndices []int
 	dirty           bool
+
+	// Context: lines shown before and after each match, grep -B/-A style.
+	// contextIndices are the lines shown then, matches and context,
+	// derived from filteredIndices whenever version moves on.
+	before, after  int
+	contextIndices []int
+	version        int
+	contextVersion int
+
+	// Lines added to the source since the index was built, which it takes
+	// in without starting over (see MarkGrown)
+	grown bool
+
+	// Where the index's last look at the source ended: the last line (with
+	// records on, the last record), from tailStart, may still grow, so it's
+	// looked at again with the new lines. tailKept and tailCounts are the
+	// length of filteredIndices and the rule counts from before it.
+	tailStart  int
+	tailKept   int
+	tailCounts []int
+	scanned    int // source lines the index has looked at
+	from       int // the first line it looked at: the time window's start
+
+	// Background rebuilds (see background.go): sources of more than
+	// asyncLines lines are filtered in the background by build. A copy
+	// doing the filtering there reports through progress, every so often.
+	asyncLines int
+	build      *filterBuild
+	progress   func(done int) bool
+	nextReport int
 }
 
 // NewFilteredProvider creates a filtered provider
@@ -28,6 +104,7 @@ func NewFilteredProvider(source LineProvider, detector LevelDetectFunc) *Filtere
 		detector:    detector,
 		levelFilter: make(map[LogLevel]bool),
 		dirty:       true,
+		asyncLines:  defaultAsyncLines,
 	}
 }
 
@@ -53,14 +130,14 @@ func (f *FilteredProvider) SetOnlyLevel(level LogLevel) {
 	f.dirty = true
 }
 
-// SetLevelAndAbove sets filter to show this level and all higher severity
+// SetLevelAndAbove sets filter to show this level and all higher severity,
+// going by the registered levels' ranks
 func (f *FilteredProvider) SetLevelAndAbove(level LogLevel) {
 	f.levelFilter = make(map[LogLevel]bool)
-	// Levels are ordered: Unknown=0, Trace=1, Debug=2, Info=3, Warn=4, Error=5, Fatal=6
-	allLevels := []LogLevel{LevelTrace, LevelDebug, LevelInfo, LevelWarn, LevelError, LevelFatal}
-	for _, l := range allLevels {
-		if l >= level {
-			f.levelFilter[l] = true
+	rank := level.Rank()
+	for _, def := range logformat.Registered().All() {
+		if def.Rank >= rank {
+			f.levelFilter[def.Level] = true
 		}
 	}
 	f.dirty = true
@@ -72,13 +149,12 @@ func (f *FilteredProvider) ClearFilter() {
 	f.dirty = true
 }
 
-// SetTextFilter sets the text substring filter
-func (f *FilteredProvider) SetTextFilter(text string) {
-	if text == "" {
-		f.textFilter = nil
-	} else {
-		f.textFilter = []byte(text)
+// SetTextFilter sets the text filter (nil or an empty term = none)
+func (f *FilteredProvider) SetTextFilter(m *Matcher) {
+	if m != nil && m.Term() == "" {
+		m = nil
 	}
+	f.textFilter = m
 	f.dirty = true
 }
 
@@ -88,14 +164,209 @@ func (f *FilteredProvider) ClearTextFilter() {
 	f.dirty = true
 }
 
-// GetTextFilter returns the current text filter
-func (f *FilteredProvider) GetTextFilter() string {
-	return string(f.textFilter)
+// GetTextFilter returns the current text filter (nil if none)
+func (f *FilteredProvider) GetTextFilter() *Matcher {
+	return f.textFilter
 }
 
 // HasTextFilter returns true if a text filter is active
 func (f *FilteredProvider) HasTextFilter() bool {
-	return len(f.textFilter) > 0
+	return f.textFilter != nil
+}
+
+// SetSourceFilter shows only lines tagged with the given Source.Path ("" = all)
+func (f *FilteredProvider) SetSourceFilter(path string) {
+	f.sourceFilter = path
+	f.dirty = true
+}
+
+// GetSourceFilter returns the current source filter ("" if none)
+func (f *FilteredProvider) GetSourceFilter() string {
+	return f.sourceFilter
+}
+
+// SetFieldFilter shows only lines match accepts; desc describes the filter
+// for display. A nil match removes it.
+func (f *FilteredProvider) SetFieldFilter(desc string, match FieldMatchFunc) {
+	if match == nil {
+		desc = ""
+	}
+	f.fieldFilter = match
+	f.fieldDesc = desc
+	f.dirty = true
+}
+
+// GetFieldFilter returns the description of the field filter ("" if none)
+func (f *FilteredProvider) GetFieldFilter() string {
+	return f.fieldDesc
+}
+
+// SetExprFilter shows only lines match accepts; desc is the expression it
+// was compiled from. A nil match removes it.
+func (f *FilteredProvider) SetExprFilter(desc string, match LineMatchFunc) {
+	if match == nil {
+		desc = ""
+	}
+	f.exprFilter = match
+	f.exprDesc = desc
+	f.dirty = true
+}
+
+// GetExprFilter returns the expression of the expression filter ("" if
+// none)
+func (f *FilteredProvider) GetExprFilter() string {
+	return f.exprDesc
+}
+
+// SetTimeWindow shows only lines timestamped in [since, until); either may
+// be nil for no bound. A window whose start only moves on (a :last window
+// following a file) isn't a new filter: the matches it has moved past are
+// dropped, and the index is otherwise kept, to take in new lines as they
+// arrive (see MarkGrown).
+func (f *FilteredProvider) SetTimeWindow(since, until *time.Time) {
+	slides := !f.dirty && f.since != nil && since != nil && !since.Before(*f.since) && sameTime(until, f.until)
+	f.since, f.until = since, until
+	if !slides || !f.wantsIndex() {
+		f.dirty = true
+		return
+	}
+
+	start, _ := f.windowRange(f.source.LineCount())
+	switch {
+	case start <= f.from:
+	case f.build != nil:
+		// The background rebuild leaves them out when it's collected
+		f.from = start
+	case start <= f.tailStart:
+		f.dropBefore(f.from, start)
+	default:
+		// Moved past everything looked at: the window is new lines only
+		f.dirty = true
+	}
+}
+
+// sameTime reports whether a and b are the same bound (nil for none)
+func sameTime(a, b *time.Time) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return a.Equal(*b)
+}
+
+// dropBefore drops the matches from lines [from, start), which the time
+// window has moved past, from an index whose tail starts at or after start
+func (f *FilteredProvider) dropBefore(from, start int) {
+	if len(f.rules) > 0 {
+		f.uncountRules(from, start)
+	}
+	k := sort.SearchInts(f.filteredIndices, start)
+	f.filteredIndices = f.filteredIndices[k:]
+	f.tailKept -= k
+	f.from = start
+	f.version++
+}
+
+// uncountRules takes lines [start, end) out of the rule counts, looking at
+// them again to find which rules they matched
+func (f *FilteredProvider) uncountRules(start, end int) {
+	counts, tailCounts := f.ruleCounts, f.tailCounts
+	index, tailStart, tailKept := f.filteredIndices, f.tailStart, f.tailKept
+	f.ruleCounts, f.tailCounts, f.filteredIndices = make([]int, len(f.rules)), nil, nil
+	if rp, ok := f.source.(RecordProvider); ok && rp.RecordsEnabled() {
+		f.scanRecords(rp, start, end)
+	} else {
+		f.scanLines(start, end)
+	}
+	for i, n := range f.ruleCounts {
+		counts[i] -= n
+		tailCounts[i] -= n
+	}
+	f.ruleCounts, f.tailCounts = counts, tailCounts
+	f.filteredIndices, f.tailStart, f.tailKept = index, tailStart, tailKept
+}
+
+// GetTimeWindow returns the time window's bounds (nil for none)
+func (f *FilteredProvider) GetTimeWindow() (since, until *time.Time) {
+	return f.since, f.until
+}
+
+// windowRange returns the lines [start, end) of the source the time window
+// covers: all total of them if there's no window or the source can't
+// search by time
+func (f *FilteredProvider) windowRange(total int) (start, end int) {
+	ts, ok := f.source.(TimeSearcher)
+	if !ok {
+		return 0, total
+	}
+	start, end = 0, total
+	if f.since != nil {
+		if start = ts.FindLineAtTime(*f.since); start < 0 {
+			start = total
+		}
+	}
+	if f.until != nil {
+		if end = ts.FindLineAtTime(*f.until); end < 0 {
+			end = total
+		}
+	}
+	return start, max(start, end)
+}
+
+// SetContext shows before lines before and after lines after each match,
+// like grep -B and -A (0 and 0 for none)
+func (f *FilteredProvider) SetContext(before, after int) {
+	f.before, f.after = max(before, 0), max(after, 0)
+	f.contextVersion = -1
+}
+
+// GetContext returns the number of context lines before and after matches
+func (f *FilteredProvider) GetContext() (before, after int) {
+	return f.before, f.after
+}
+
+// MatchCount returns how many lines pass the filters, context lines not
+// included
+func (f *FilteredProvider) MatchCount() int {
+	f.rebuildIndex()
+	if !f.IsFiltered() {
+		return f.source.LineCount()
+	}
+	return len(f.filteredIndices)
+}
+
+// shown returns the original line numbers of the lines shown: the matches,
+// and with context on, the lines around them
+func (f *FilteredProvider) shown() []int {
+	if f.before == 0 && f.after == 0 {
+		return f.filteredIndices
+	}
+	if f.contextVersion != f.version {
+		f.buildContext()
+	}
+	return f.contextIndices
+}
+
+// buildContext works out contextIndices from the matches: each match and
+// the lines around it, groups that overlap or touch running together
+func (f *FilteredProvider) buildContext() {
+	total := f.source.LineCount()
+	shown := f.contextIndices[:0]
+	next := 0 // the first line not yet shown
+	for _, m := range f.filteredIndices {
+		for i := max(m-f.before, next); i <= min(m+f.after, total-1); i++ {
+			shown = append(shown, i)
+		}
+		next = max(next, m+f.after+1)
+	}
+	f.contextIndices = shown
+	f.contextVersion = f.version
+}
+
+// isMatch reports whether original line i passed the filters (rather than
+// being shown as context)
+func (f *FilteredProvider) isMatch(i int) bool {
+	k := sort.SearchInts(f.filteredIndices, i)
+	return k < len(f.filteredIndices) && f.filteredIndices[k] == i
 }
 
 // MarkDirty marks the filter index as needing rebuild
@@ -103,9 +374,19 @@ func (f *FilteredProvider) MarkDirty() {
 	f.dirty = true
 }
 
+// MarkGrown notes that the source has more lines at the end, the lines
+// before them being unchanged (as when a followed file grows). Unless the
+// filters have changed too, the index is brought up to date by looking at
+// just the new lines, and the last old one, which may have been partly
+// written.
+func (f *FilteredProvider) MarkGrown() {
+	f.grown = true
+}
+
 // IsFiltered returns true if any filter is active
 func (f *FilteredProvider) IsFiltered() bool {
-	return len(f.levelFilter) > 0 || len(f.textFilter) > 0
+	return len(f.levelFilter) > 0 || f.textFilter != nil || f.sourceFilter != "" || f.fieldFilter != nil ||
+		f.exprFilter != nil || f.since != nil || f.until != nil || f.hasRules()
 }
 
 // GetActiveFilters returns the active level filters
@@ -113,85 +394,245 @@ func (f *FilteredProvider) GetActiveFilters() map[LogLevel]bool {
 	return f.levelFilter
 }
 
-// rebuildIndex rebuilds the filtered index if dirty
+// rebuildIndex rebuilds the filtered index if dirty (in the background for
+// a big source), or adds the source's new lines to it if it has grown
 func (f *FilteredProvider) rebuildIndex() {
-	if !f.dirty {
-		return
+	if f.build != nil {
+		f.collect()
 	}
+	switch {
+	case f.dirty:
+		f.dirty, f.grown = false, false
+		total := f.source.LineCount()
+		if start, end := f.windowRange(total); f.asyncLines > 0 && end-start > f.asyncLines && f.wantsIndex() {
+			f.startBuild(total)
+			return
+		}
+		f.cancelBuild()
+		f.resetIndex()
+		f.scanTo(total)
+	case f.grown && f.build == nil:
+		// New lines arriving during a background rebuild wait for it
+		f.grown = false
+		f.scanTo(f.source.LineCount())
+	}
+}
 
+// wantsIndex reports whether there's a filter to build an index for. With
+// no filter, the source is used directly, unless there are rules (all off)
+// to count matches for.
+func (f *FilteredProvider) wantsIndex() bool {
+	return f.IsFiltered() || len(f.rules) > 0
+}
+
+// resetIndex empties the index, to be built again from the top
+func (f *FilteredProvider) resetIndex() {
 	f.filteredIndices = nil
+	f.resetRules()
+	f.tailStart, f.tailKept, f.tailCounts, f.scanned = 0, 0, nil, 0
+	f.nextReport = 0
+	f.version++
+}
 
-	// If no filter, don't build index (use source directly)
-	if len(f.levelFilter) == 0 && len(f.textFilter) == 0 {
-		f.dirty = false
+// scanTo brings the index up to date with the source's first total lines,
+// looking at the lines after the last scan and the tail it left off at
+func (f *FilteredProvider) scanTo(total int) {
+	if !f.wantsIndex() {
 		return
 	}
 
-	// Build filtered index
-	total := f.source.LineCount()
-	for i := 0; i < total; i++ {
+	if total < f.scanned {
+		// Shrunk rather than grown: start over
+		f.resetIndex()
+	}
+
+	// Undo the tail, to look at it again
+	f.filteredIndices = f.filteredIndices[:f.tailKept]
+	copy(f.ruleCounts, f.tailCounts)
+
+	start, end := f.windowRange(total)
+	if f.scanned == 0 {
+		f.from = start
+	}
+	start = max(start, f.tailStart)
+	f.markTail(start) // in case there's nothing to look at
+
+	// Records are kept or dropped whole
+	if rp, ok := f.source.(RecordProvider); ok && rp.RecordsEnabled() {
+		f.scanRecords(rp, start, end)
+	} else {
+		f.scanLines(start, end)
+	}
+	f.scanned = total
+	f.version++
+}
+
+// markTail notes that the tail, which the next scan looks at again, starts
+// at line i
+func (f *FilteredProvider) markTail(i int) {
+	f.tailStart = i
+	f.tailKept = len(f.filteredIndices)
+	f.tailCounts = append(f.tailCounts[:0], f.ruleCounts...)
+}
+
+// scanLines adds the lines of [start, end) that pass to the index
+func (f *FilteredProvider) scanLines(start, end int) {
+	for i := start; i < end; i++ {
+		if !f.reportProgress(i) {
+			return
+		}
+		if i == end-1 {
+			f.markTail(i)
+		}
 		line, err := f.source.GetLine(i)
 		if err != nil {
 			continue
 		}
 
-		// Check text filter first (most common case)
-		if len(f.textFilter) > 0 {
-			if !bytes.Contains(line.Content, f.textFilter) {
+		// Check text filter (most common case)
+		if f.textFilter != nil {
+			if !f.textFilter.Match(line.Content) {
 				continue
 			}
 		}
 
-		// Check level filter if active
-		if len(f.levelFilter) > 0 {
-			// Detect level if not already set
-			level := line.Level
-			if level == LevelUnknown && f.detector != nil {
-				level = f.detector(line.Content)
-			}
+		if !f.headMatches(line) {
+			continue
+		}
 
-			// Check if level passes filter
-			if !f.levelFilter[level] {
+		// The rule stack, every rule at once
+		if len(f.rules) > 0 {
+			f.matchRules(line.Content)
+			if !f.rulesPass() {
 				continue
 			}
 		}
 
 		f.filteredIndices = append(f.filteredIndices, i)
 	}
+}
 
-	f.dirty = false
+// scanRecords adds the records from line start up to line end to the index
+// a record at a time: the source, level and field filters look at the
+// record's first line, the text filter and each rule match if any of its
+// lines matches, and a record that passes shows all of its lines
+func (f *FilteredProvider) scanRecords(rp RecordProvider, start, total int) {
+	for start < total {
+		if !f.reportProgress(start) {
+			return
+		}
+		_, end := rp.RecordBounds(start)
+		if end >= total {
+			// The last record can gain continuation lines
+			end = total
+			f.markTail(start)
+		}
+
+		if f.recordMatches(start, end) {
+			for i := start; i < end; i++ {
+				f.filteredIndices = append(f.filteredIndices, i)
+			}
+		}
+		start = end
+	}
+}
+
+// recordMatches reports whether the record of lines [start, end) passes
+func (f *FilteredProvider) recordMatches(start, end int) bool {
+	head, err := f.source.GetLine(start)
+	if err != nil || head == nil || !f.headMatches(head) {
+		return false
+	}
+	text := f.textFilter == nil
+	for i := start; i < end && (!text || len(f.rules) > 0); i++ {
+		line, err := f.source.GetLine(i)
+		if err != nil || line == nil {
+			continue
+		}
+		if !text && f.textFilter.Match(line.Content) {
+			text = true
+		}
+		if len(f.rules) > 0 {
+			f.matchRules(line.Content)
+		}
+	}
+	if !text {
+		// Leave the rule hits for the next record clear
+		for i := range f.ruleHits {
+			f.ruleHits[i] = false
+		}
+		return false
+	}
+	return len(f.rules) == 0 || f.rulesPass()
+}
+
+// headMatches checks the source, level, field and expression filters
+// against line
+func (f *FilteredProvider) headMatches(line *Line) bool {
+	// Check source filter
+	if f.sourceFilter != "" {
+		if line.Source == nil || line.Source.Path != f.sourceFilter {
+			return false
+		}
+	}
+
+	// Detect level if the source didn't and a filter needs it
+	if line.Level == LevelUnknown && !line.LevelKnown && f.detector != nil &&
+		(len(f.levelFilter) > 0 || f.exprFilter != nil) {
+		detected := *line
+		detected.Level = f.detector(line.Content)
+		line = &detected
+	}
+
+	// Check level filter if active
+	if len(f.levelFilter) > 0 && !f.levelFilter[line.Level] {
+		return false
+	}
+
+	if f.fieldFilter != nil && !f.fieldFilter(line.Content) {
+		return false
+	}
+	if f.exprFilter != nil && !f.exprFilter(line) {
+		return false
+	}
+	return true
 }
 
 // LineCount returns total number of filtered lines
 func (f *FilteredProvider) LineCount() int {
 	f.rebuildIndex()
 
-	if len(f.levelFilter) == 0 && len(f.textFilter) == 0 {
+	if !f.IsFiltered() {
 		return f.source.LineCount()
 	}
-	return len(f.filteredIndices)
+	return len(f.shown())
 }
 
 // GetLine returns line at filtered index
 func (f *FilteredProvider) GetLine(index int) (*Line, error) {
 	f.rebuildIndex()
 
-	if len(f.levelFilter) == 0 && len(f.textFilter) == 0 {
+	if !f.IsFiltered() {
 		return f.source.GetLine(index)
 	}
 
-	if index < 0 || index >= len(f.filteredIndices) {
+	shown := f.shown()
+	if index < 0 || index >= len(shown) {
 		return nil, nil
 	}
 
-	originalIndex := f.filteredIndices[index]
+	originalIndex := shown[index]
 	line, err := f.source.GetLine(originalIndex)
-	if err != nil {
+	if err != nil || line == nil {
 		return nil, err
 	}
 
 	// Store original index for display
 	line.OriginalIndex = originalIndex
+	if f.before > 0 || f.after > 0 {
+		line.Context = !f.isMatch(originalIndex)
+		line.Break = index > 0 && shown[index-1] != originalIndex-1
+	}
 	return line, nil
 }
 
@@ -199,12 +640,12 @@ func (f *FilteredProvider) GetLine(index int) (*Line, error) {
 func (f *FilteredProvider) GetLines(start, count int) ([]*Line, error) {
 	f.rebuildIndex()
 
-	if len(f.levelFilter) == 0 && len(f.textFilter) == 0 {
+	if !f.IsFiltered() {
 		return f.source.GetLines(start, count)
 	}
 
 	var lines []*Line
-	for i := start; i < start+count && i < len(f.filteredIndices); i++ {
+	for i := start; i < start+count && i < len(f.shown()); i++ {
 		line, err := f.GetLine(i)
 		if err != nil {
 			return lines, err
@@ -220,19 +661,22 @@ func (f *FilteredProvider) GetLines(start, count int) ([]*Line, error) {
 func (f *FilteredProvider) OriginalLineNumber(filteredIndex int) int {
 	f.rebuildIndex()
 
-	if len(f.levelFilter) == 0 {
+	if !f.IsFiltered() {
 		return filteredIndex
 	}
 
-	if filteredIndex < 0 || filteredIndex >= len(f.filteredIndices) {
+	shown := f.shown()
+	if filteredIndex < 0 || filteredIndex >= len(shown) {
 		return -1
 	}
-	return f.filteredIndices[filteredIndex]
+	return shown[filteredIndex]
 }
 
 // FilteredIndexFor returns the filtered index for an original line number.
 // If the exact line is filtered out, returns the nearest filtered index at or after.
-// Returns -1 if no matching filtered line exists.
+// Returns -1 if no matching filtered line exists. While the index is rebuilt
+// in the background this doesn't wait: it's the nearest of the matches found
+// so far, which may change as more are found.
 func (f *FilteredProvider) FilteredIndexFor(originalLine int) int {
 	f.rebuildIndex()
 
@@ -241,26 +685,27 @@ func (f *FilteredProvider) FilteredIndexFor(originalLine int) int {
 		return originalLine
 	}
 
-	if len(f.filteredIndices) == 0 {
+	shown := f.shown()
+	if len(shown) == 0 {
 		return -1
 	}
 
 	// Binary search for originalLine or nearest at/after
-	low, high := 0, len(f.filteredIndices)
+	low, high := 0, len(shown)
 	for low < high {
 		mid := (low + high) / 2
-		if f.filteredIndices[mid] < originalLine {
+		if shown[mid] < originalLine {
 			low = mid + 1
 		} else {
 			high = mid
 		}
 	}
 
-	// low is now the first index where filteredIndices[low] >= originalLine
-	if low < len(f.filteredIndices) {
+	// low is now the first index where shown[low] >= originalLine
+	if low < len(shown) {
 		return low
 	}
 
 	// All filtered lines are before originalLine, return last one
-	return len(f.filteredIndices) - 1
+	return len(shown) - 1
 }
@@ -0,0 +1,145 @@
+package source
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"regexp/syntax"
+	"unicode"
+)
+
+// MatchOptions says how a search or filter term is matched
+type MatchOptions struct {
+	Regex     bool // the term is an RE2 regex rather than a substring
+	SmartCase bool // a term without upper case letters ignores case
+}
+
+// Matcher matches line content against a search or filter term
+type Matcher struct {
+	term       string
+	opts       MatchOptions
+	ignoreCase bool
+	literal    []byte         // substring to look for, when matching case
+	re         *regexp.Regexp // otherwise the compiled term
+}
+
+// NewMatcher compiles term as opts say. The error is the regex's if it
+// doesn't compile, worded for the status bar.
+func NewMatcher(term string, opts MatchOptions) (*Matcher, error) {
+	m := &Matcher{term: term, opts: opts}
+	if opts.Regex {
+		parsed, err := syntax.Parse(term, syntax.Perl)
+		if err != nil {
+			return nil, fmt.Errorf("regex: %s", regexError(err))
+		}
+		m.ignoreCase = opts.SmartCase && !hasUpper(parsed)
+	} else {
+		m.ignoreCase = opts.SmartCase && !containsUpper(term)
+	}
+
+	expr := term
+	if !opts.Regex {
+		if !m.ignoreCase {
+			m.literal = []byte(term)
+			return m, nil
+		}
+		expr = regexp.QuoteMeta(term)
+	}
+	if m.ignoreCase {
+		expr = "(?i)" + expr
+	}
+	re, err := regexp.Compile(expr)
+	if err != nil {
+		return nil, fmt.Errorf("regex: %s", regexError(err))
+	}
+	m.re = re
+	return m, nil
+}
+
+// Match reports whether content matches the term
+func (m *Matcher) Match(content []byte) bool {
+	if m.re == nil {
+		return bytes.Contains(content, m.literal)
+	}
+	return m.re.Match(content)
+}
+
+// clone returns a copy of m for a background rebuild to match with. The
+// compiled regex is safe to share; the rest is copied.
+func (m *Matcher) clone() *Matcher {
+	if m == nil {
+		return nil
+	}
+	c := *m
+	c.literal = bytes.Clone(m.literal)
+	return &c
+}
+
+// Term returns the term as written
+func (m *Matcher) Term() string {
+	return m.term
+}
+
+// Options returns how the term is matched
+func (m *Matcher) Options() MatchOptions {
+	return m.opts
+}
+
+// IgnoresCase reports whether the term matches regardless of case
+func (m *Matcher) IgnoresCase() bool {
+	return m.ignoreCase
+}
+
+// Label describes the term for the status bar, shortened to max bytes if
+// max > 0: "text" for a substring, /text/ for a regex, followed by i if
+// case is ignored
+func (m *Matcher) Label(max int) string {
+	term := m.term
+	if max > 0 && len(term) > max {
+		term = term[:max] + "..."
+	}
+	s := `"` + term + `"`
+	if m.opts.Regex {
+		s = "/" + term + "/"
+	}
+	if m.ignoreCase {
+		s += "i"
+	}
+	return s
+}
+
+// regexError returns the message of a regex error without the expression,
+// which the prompt already shows
+func regexError(err error) string {
+	if e, ok := err.(*syntax.Error); ok {
+		return string(e.Code)
+	}
+	return err.Error()
+}
+
+// hasUpper reports whether any literal in re has an upper case letter, so
+// escapes such as \S or \W don't turn smart-case off
+func hasUpper(re *syntax.Regexp) bool {
+	if re.Op == syntax.OpLiteral {
+		for _, r := range re.Rune {
+			if unicode.IsUpper(r) {
+				return true
+			}
+		}
+	}
+	for _, sub := range re.Sub {
+		if hasUpper(sub) {
+			return true
+		}
+	}
+	return false
+}
+
+func containsUpper(s string) bool {
+	for _, r := range s {
+		if unicode.IsUpper(r) {
+			return true
+		}
+	}
+	return false
+}
@@ -32,6 +32,9 @@ type Line struct {
 	Level     LogLevel
 	Source    *SourceInfo
 	OriginalIndex int // line number in original file
+	LevelKnown bool // Level was detected by the source (LevelUnknown then means the line has none)
+	Context bool // shown as context around a filter match, not a match itself
+	Break bool // the line shown before it isn't the one before it in the file
 }
 
 // LineProvider is the core abstraction for accessing lines
@@ -47,6 +50,17 @@ type LineProvider interface {
 	GetLines(start, count int) ([]*Line, error)
 }
 
+// RecordProvider is implemented by sources that can group lines into
+// multi-line records: a timestamped or levelled line plus the continuation
+// lines after it (stack traces, wrapped or pretty-printed payloads)
+type RecordProvider interface {
+	// RecordsEnabled reports whether lines should be treated as records
+	RecordsEnabled() bool
+
+	// RecordBounds returns the lines [start, end) of the record containing line
+	RecordBounds(line int) (start, end int)
+}
+
 // FilePosition represents a position in a source file
 type FilePosition struct {
 	Path       string
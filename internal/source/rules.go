@@ -0,0 +1,128 @@
+package source
+
+// Rule is one rule of a filter stack: lines its matcher matches are shown
+// (include) or hidden (exclude)
+type Rule struct {
+	Matcher *Matcher
+	Exclude bool
+	Enabled bool
+}
+
+// Rules returns the rule stack, in order
+func (f *FilteredProvider) Rules() []Rule {
+	return append([]Rule(nil), f.rules...)
+}
+
+// RuleCounts returns how many lines each rule matches, of those the level,
+// text, source and field filters let through (whether the rule is enabled
+// or not)
+func (f *FilteredProvider) RuleCounts() []int {
+	f.rebuildIndex()
+	return append([]int(nil), f.ruleCounts...)
+}
+
+// AddRule appends r to the rule stack
+func (f *FilteredProvider) AddRule(r Rule) {
+	f.rules = append(f.rules, r)
+	f.dirty = true
+}
+
+// SetRule replaces rule i
+func (f *FilteredProvider) SetRule(i int, r Rule) {
+	if i >= 0 && i < len(f.rules) {
+		f.rules[i] = r
+		f.dirty = true
+	}
+}
+
+// RemoveRule removes rule i
+func (f *FilteredProvider) RemoveRule(i int) {
+	if i >= 0 && i < len(f.rules) {
+		f.rules = append(f.rules[:i], f.rules[i+1:]...)
+		f.dirty = true
+	}
+}
+
+// MoveRule moves rule i to position j
+func (f *FilteredProvider) MoveRule(i, j int) {
+	if i < 0 || i >= len(f.rules) || j < 0 || j >= len(f.rules) || i == j {
+		return
+	}
+	r := f.rules[i]
+	f.rules = append(f.rules[:i], f.rules[i+1:]...)
+	f.rules = append(f.rules[:j], append([]Rule{r}, f.rules[j:]...)...)
+	f.dirty = true
+}
+
+// ToggleRule turns rule i on or off
+func (f *FilteredProvider) ToggleRule(i int) {
+	if i >= 0 && i < len(f.rules) {
+		f.rules[i].Enabled = !f.rules[i].Enabled
+		f.dirty = true
+	}
+}
+
+// cloneRules copies the rule stack, matchers and all, for a background
+// rebuild to work on while the stack is edited
+func cloneRules(rules []Rule) []Rule {
+	c := make([]Rule, len(rules))
+	for i, r := range rules {
+		c[i] = Rule{Matcher: r.Matcher.clone(), Exclude: r.Exclude, Enabled: r.Enabled}
+	}
+	return c
+}
+
+// hasRules reports whether any rule is on
+func (f *FilteredProvider) hasRules() bool {
+	for _, r := range f.rules {
+		if r.Enabled {
+			return true
+		}
+	}
+	return false
+}
+
+// resetRules gets the rule counts and hits ready for a rebuild
+func (f *FilteredProvider) resetRules() {
+	f.ruleCounts = make([]int, len(f.rules))
+	if cap(f.ruleHits) < len(f.rules) {
+		f.ruleHits = make([]bool, len(f.rules))
+	}
+	f.ruleHits = f.ruleHits[:len(f.rules)]
+}
+
+// matchRules notes in ruleHits which rules match content, adding to those
+// already noted (a record matches a rule if any of its lines does)
+func (f *FilteredProvider) matchRules(content []byte) {
+	for i := range f.rules {
+		if !f.ruleHits[i] && f.rules[i].Matcher.Match(content) {
+			f.ruleHits[i] = true
+		}
+	}
+}
+
+// rulesPass counts the rules noted in ruleHits, clears them, and decides
+// whether the line or record they were noted for is shown: the last enabled
+// rule that matched decides. One no rule matched is hidden if the first
+// enabled rule is an include (the stack picks lines out) and shown if it's
+// an exclude (the stack hides lines, then maybe keeps some back).
+func (f *FilteredProvider) rulesPass() bool {
+	keep := true
+	for _, r := range f.rules {
+		if r.Enabled {
+			keep = r.Exclude
+			break
+		}
+	}
+	for i, hit := range f.ruleHits {
+		if !hit {
+			continue
+		}
+		f.ruleCounts[i]++
+		f.ruleHits[i] = false
+		if f.rules[i].Enabled {
+			keep = !f.rules[i].Exclude
+		}
+	}
+	return keep
+}
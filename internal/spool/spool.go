@@ -0,0 +1,115 @@
+package spool
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"sync/atomic"
+)
+
+// Spool copies a stream (usually stdin) into a temp file in the background,
+// so the file can be opened and followed while the stream is still open.
+// `kubectl logs -f ... | mless` never reaches EOF, so waiting for it is not an
+// option.
+type Spool struct {
+	input      io.Reader
+	outputPath string
+	output     *os.File
+
+	mu     sync.Mutex // serializes writes against Close
+	closed bool
+	done   atomic.Bool
+	err    atomic.Value // error that ended the copy, if any
+}
+
+// New creates the backing temp file for input. Call Run (in a goroutine) to
+// start copying.
+func New(input io.Reader) (*Spool, error) {
+	output, err := os.CreateTemp("", "mless-stdin-*.log")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create temp file: %w", err)
+	}
+	return &Spool{
+		input:      input,
+		outputPath: output.Name(),
+		output:     output,
+	}, nil
+}
+
+// Run copies input to the backing file until EOF, a read error, or Close -
+// should be called in a goroutine
+func (s *Spool) Run() {
+	defer s.done.Store(true)
+
+	buf := make([]byte, 64*1024)
+	for {
+		n, err := s.input.Read(buf)
+		if n > 0 {
+			if werr := s.write(buf[:n]); werr != nil {
+				s.setErr(werr)
+				return
+			}
+		}
+		if err == io.EOF {
+			return
+		}
+		if err != nil {
+			s.setErr(fmt.Errorf("failed to read input: %w", err))
+			return
+		}
+	}
+}
+
+// write appends p to the backing file. Writes go straight to the file, so a
+// reader refreshing its mapping sees them without a Sync.
+func (s *Spool) write(p []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return errClosed
+	}
+	_, err := s.output.Write(p)
+	return err
+}
+
+var errClosed = errors.New("spool closed")
+
+func (s *Spool) setErr(err error) {
+	if err != errClosed {
+		s.err.Store(err)
+	}
+}
+
+// OutputPath returns the path of the backing file
+func (s *Spool) OutputPath() string {
+	return s.outputPath
+}
+
+// Done reports whether the input has been fully copied (or failed)
+func (s *Spool) Done() bool {
+	return s.done.Load()
+}
+
+// Err returns the error that stopped the copy early, or nil
+func (s *Spool) Err() error {
+	if err, ok := s.err.Load().(error); ok {
+		return err
+	}
+	return nil
+}
+
+// Close stops copying and removes the backing file. A Run blocked reading
+// input stays blocked until the next read returns, then exits.
+func (s *Spool) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return nil
+	}
+	s.closed = true
+
+	s.output.Close()
+	return os.Remove(s.outputPath)
+}
@@ -0,0 +1,81 @@
+package spool
+
+import (
+	"io"
+	"os"
+	"testing"
+	"time"
+)
+
+// waitFor polls cond until it holds or the test times out.
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func fileContent(t *testing.T, path string) string {
+	t.Helper()
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(b)
+}
+
+// TestSpoolStreamsBeforeEOF verifies data is visible in the backing file while
+// the pipe is still open, and that EOF is reported once it closes.
+func TestSpoolStreamsBeforeEOF(t *testing.T) {
+	r, w := io.Pipe()
+	s, err := New(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	go s.Run()
+
+	w.Write([]byte("first line\n"))
+	waitFor(t, "first line", func() bool { return fileContent(t, s.OutputPath()) == "first line\n" })
+	if s.Done() {
+		t.Fatal("Done before the pipe closed")
+	}
+
+	w.Write([]byte("second line\n"))
+	w.Close()
+	waitFor(t, "EOF", s.Done)
+	if got := fileContent(t, s.OutputPath()); got != "first line\nsecond line\n" {
+		t.Fatalf("spooled content: %q", got)
+	}
+	if s.Err() != nil {
+		t.Fatalf("clean EOF reported error: %v", s.Err())
+	}
+}
+
+func TestSpoolCloseRemovesFile(t *testing.T) {
+	r, w := io.Pipe()
+	defer w.Close()
+	s, err := New(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go s.Run()
+
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(s.OutputPath()); !os.IsNotExist(err) {
+		t.Fatalf("backing file still present after Close: %v", err)
+	}
+
+	// A write arriving after Close ends the copy without recording an error
+	w.Write([]byte("late\n"))
+	waitFor(t, "Run to exit", s.Done)
+	if s.Err() != nil {
+		t.Fatalf("write after Close reported error: %v", s.Err())
+	}
+}
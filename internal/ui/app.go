@@ -1,20 +1,27 @@
 package ui
 
 import (
+	"context"
 	"fmt"
 	"io"
 	"os"
 	"os/exec"
+	"path/filepath"
 	"runtime"
 	"strings"
 	"time"
 
+	"github.com/charmbracelet/bubbles/spinner"
 	"github.com/charmbracelet/bubbles/textinput"
 	tea "github.com/charmbracelet/bubbletea"
 	"github.com/charmbracelet/lipgloss"
 	"github.com/TimelordUK/mless/internal/config"
 	"github.com/TimelordUK/mless/internal/consolidate"
+	"github.com/TimelordUK/mless/internal/index"
+	mlessio "github.com/TimelordUK/mless/internal/io"
 	"github.com/TimelordUK/mless/internal/source"
+	"github.com/TimelordUK/mless/internal/spool"
+	"github.com/TimelordUK/mless/pkg/logformat"
 )
 
 // tickMsg is sent periodically in follow mode
@@ -25,9 +32,14 @@ type ModelOptions struct {
 	Filepath         string
 	Filepaths        []string // Multiple files for split view
 	CacheFile        bool
-	SliceRange       string   // e.g., "1000-5000"
-	GotoTime         string   // e.g., "14:00"
-	ConsolidatePaths []string // Files to consolidate (nil = normal mode)
+	SliceRange       string       // e.g., "1000-5000"
+	GotoTime         string       // e.g., "14:00"
+	ConsolidatePaths []string     // Files to consolidate (nil = normal mode)
+	Stdin            *spool.Spool // Piped input being spooled (nil if not reading stdin)
+	Command          string       // Shell command whose output to page (empty = none)
+	RestartCommand   bool         // Re-run Command whenever it exits
+	NoIndexCache     bool         // Don't read or write the persistent index cache
+	BaseDates        map[string]time.Time // --date: dates by absolute path ("" = every file)
 }
 
 // Mode represents the current UI mode
@@ -43,10 +55,14 @@ const (
 	ModeMarkSet  // Waiting for mark character (ma-mz)
 	ModeMarkJump // Waiting for mark character ('a-'z)
 	ModeHelp
-	ModeFileInfo // Showing file info (ctrl+g)
-	ModeSplitCmd // Waiting for split command (v, s, w, q, etc.)
-	ModeYank     // Waiting for yank target (y for line, number, or 'a for mark)
-	ModeVisual   // Visual selection mode
+	ModeFileInfo  // Showing file info (ctrl+g)
+	ModeSplitCmd  // Waiting for split command (v, s, w, q, etc.)
+	ModeYank      // Waiting for yank target (y for line, number, or 'a for mark)
+	ModeVisual    // Visual selection mode
+	ModeIndexWait // Waiting for background indexing to finish (esc cancels)
+	ModeRules     // Filter rule panel (|)
+	ModeRuleEdit  // Typing a rule's term in the rule panel
+	ModePeek      // Peeking at the unfiltered lines around the current one (p)
 )
 
 // SplitDirection represents the split layout direction
@@ -68,6 +84,21 @@ type Model struct {
 	searchInput textinput.Model
 	config      *config.Config
 
+	// How / and ? match their terms, toggled in the prompt (ctrl+r regex,
+	// ctrl+s smart-case), and why the term being typed doesn't compile and
+	// where, for a ?= expression (a column of the input, -1 if none)
+	matchOpts   source.MatchOptions
+	promptErr   string
+	promptCaret int
+
+	// Filter rule panel (|): the selected rule, and the rule being edited
+	// (-1 while adding one) and whether it's an exclude. While it's edited
+	// matchOpts are the rule's; searchOpts are put back after.
+	ruleCursor  int
+	ruleEdit    int
+	ruleExclude bool
+	searchOpts  source.MatchOptions
+
 	mode   Mode
 	width  int
 	height int
@@ -84,6 +115,19 @@ type Model struct {
 
 	// Consolidated mode
 	consolidatedWriter *consolidate.Writer // nil if not consolidating
+
+	// Piped input
+	stdin *spool.Spool // nil unless reading stdin
+
+	// Background indexing
+	indexTicking bool               // index tick is running
+	spinner      spinner.Model      // shown while waiting on the indexer
+	waitCancel   context.CancelFunc // cancels the current wait
+	waitAction   func()             // runs once the wait completes
+	waitGen      int                // identifies the current wait
+
+	// Background filter rebuilds
+	filterTicking bool // filter tick is running
 }
 
 // NewModel creates a new application model
@@ -97,6 +141,11 @@ func NewModelWithOptions(opts ModelOptions) (*Model, error) {
 	if err != nil {
 		return nil, err
 	}
+	if opts.NoIndexCache {
+		cfg.IndexCache.Enabled = false
+	}
+	cfg.BaseDates = opts.BaseDates
+	logformat.SetLevels(logformat.NewLevels(cfg))
 
 	var panes []*Pane
 	var writer *consolidate.Writer
@@ -121,6 +170,24 @@ func NewModelWithOptions(opts ModelOptions) (*Model, error) {
 		// Enable follow mode for consolidated view
 		pane.SetFollowing(true)
 		panes = []*Pane{pane}
+	} else if opts.Command != "" {
+		// Command mode: page the process output live
+		pane, err := NewCommandPane(opts.Command, opts.RestartCommand, cfg)
+		if err != nil {
+			return nil, fmt.Errorf("failed to run command: %w", err)
+		}
+		panes = []*Pane{pane}
+	} else if opts.Stdin != nil {
+		// Piped mode: the pipe may never close, so show what has arrived so
+		// far and follow the spool file as it grows
+		go opts.Stdin.Run()
+
+		pane, err := NewPane(opts.Stdin.OutputPath(), cfg, false)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open stdin: %w", err)
+		}
+		pane.SetFollowing(true)
+		panes = []*Pane{pane}
 	} else {
 		// Normal mode: build list of files to open
 		var files []string
@@ -146,6 +213,17 @@ func NewModelWithOptions(opts ModelOptions) (*Model, error) {
 		}
 	}
 
+	// Initial slice and time navigation need the whole file indexed
+	if (opts.SliceRange != "" || opts.GotoTime != "") && len(panes) > 0 {
+		if err := panes[0].Source().WaitIndexed(context.Background()); err != nil {
+			for _, p := range panes {
+				p.Close()
+			}
+			return nil, err
+		}
+		panes[0].SyncIndex()
+	}
+
 	// Apply initial slice to first pane if specified
 	if opts.SliceRange != "" && len(panes) > 0 {
 		if err := panes[0].ParseAndSlice(opts.SliceRange); err != nil {
@@ -178,9 +256,23 @@ func NewModelWithOptions(opts ModelOptions) (*Model, error) {
 		config:             cfg,
 		mode:               ModeNormal,
 		consolidatedWriter: writer,
+		stdin:              opts.Stdin,
+		message:            sequentialWarning(panes),
+		spinner:            spinner.New(spinner.WithSpinner(spinner.Dot)),
 	}, nil
 }
 
+// sequentialWarning warns about any compressed file that can only be decoded
+// from the top, where every jump backwards past the cache starts over
+func sequentialWarning(panes []*Pane) string {
+	for _, p := range panes {
+		if p.Source().Sequential() {
+			return fmt.Sprintf("%s is one %s frame/block: jumping back decodes from the start", filepath.Base(p.sourcePath), p.Source().Compression())
+		}
+	}
+	return ""
+}
+
 // tab returns the currently active tab.
 func (m *Model) tab() *Tab {
 	return m.tabs[m.activeTab]
@@ -223,6 +315,21 @@ func (m *Model) openTab(path string) error {
 	return nil
 }
 
+// openCommandTab runs cmdline and shows its output in a new tab.
+func (m *Model) openCommandTab(cmdline string, restart bool) (tea.Cmd, error) {
+	if len(m.tabs) >= maxTabs {
+		return nil, fmt.Errorf("max %d tabs open", maxTabs)
+	}
+	pane, err := NewCommandPane(cmdline, restart, m.config)
+	if err != nil {
+		return nil, err
+	}
+	m.tabs = append(m.tabs, newTab([]*Pane{pane}, SplitNone, m.config))
+	m.activeTab = len(m.tabs) - 1
+	m.layoutTabs()
+	return m.tickCmd(), nil
+}
+
 // closeTab closes the active tab and frees its panes (cannot close the last).
 func (m *Model) closeTab() {
 	if len(m.tabs) <= 1 {
@@ -278,11 +385,14 @@ func copyFile(src, dst string) error {
 
 // Init implements tea.Model
 func (m *Model) Init() tea.Cmd {
+	// Pick up lines as large files are indexed in the background
+	cmds := []tea.Cmd{m.startIndexTick()}
+
 	// Start tick if any pane is in follow mode (e.g., consolidated mode)
 	if m.currentPane().IsFollowing() {
-		return m.tickCmd()
+		cmds = append(cmds, m.tickCmd())
 	}
-	return nil
+	return tea.Batch(cmds...)
 }
 
 // Update implements tea.Model
@@ -293,7 +403,9 @@ func (m *Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 		// app actually receives, so if a chord never appears here it's being
 		// trapped upstream (terminal/multiplexer), not by mless.
 		m.lastKey = msg.String()
-		return m.handleKey(msg)
+		model, cmd := m.handleKey(msg)
+		// A key that changed a filter may have started a background rebuild
+		return model, tea.Batch(cmd, m.startFilterTick())
 
 	case tea.WindowSizeMsg:
 		m.width = msg.Width
@@ -303,8 +415,26 @@ func (m *Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 
 	case tickMsg:
 		if m.currentPane().IsFollowing() {
-			m.currentPane().CheckForNewLines()
-			return m, m.tickCmd()
+			m.checkForNewLines(m.currentPane())
+			return m, tea.Batch(m.tickCmd(), m.startFilterTick())
+		}
+		return m, nil
+
+	case indexTickMsg:
+		return m, m.handleIndexTick()
+
+	case indexWaitDoneMsg:
+		// What was waiting may have been a filter change
+		return m, tea.Batch(m.handleIndexWaitDone(msg), m.startFilterTick())
+
+	case filterTickMsg:
+		return m, m.handleFilterTick()
+
+	case spinner.TickMsg:
+		if m.mode == ModeIndexWait {
+			var cmd tea.Cmd
+			m.spinner, cmd = m.spinner.Update(msg)
+			return m, cmd
 		}
 		return m, nil
 	}
@@ -364,6 +494,18 @@ func (m *Model) handleKey(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
 	if m.mode == ModeVisual {
 		return m.handleVisualKey(msg)
 	}
+	if m.mode == ModeIndexWait {
+		return m.handleIndexWaitKey(msg)
+	}
+	if m.mode == ModeRules {
+		return m.handleRulesKey(msg)
+	}
+	if m.mode == ModeRuleEdit {
+		return m.handleRuleEditKey(msg)
+	}
+	if m.mode == ModePeek {
+		return m.handlePeekKey(msg)
+	}
 
 	// Normal mode
 	pane := m.currentPane()
@@ -399,6 +541,9 @@ func (m *Model) handleKey(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
 			pane.FilteredSource().ClearTextFilter()
 			pane.SetFilterTerm("")
 		}
+		if pane.FilteredSource().GetExprFilter() != "" {
+			pane.SetExpr("")
+		}
 		if pane.SearchTerm() != "" {
 			pane.ClearSearch()
 		}
@@ -423,6 +568,12 @@ func (m *Model) handleKey(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
 		pane.ToggleWrap()
 	case "z": // Expand/collapse the current line in place
 		pane.ToggleExpandCurrentLine()
+	case "J": // Show JSON log lines formatted or as written
+		if pane.ToggleJSONView() {
+			m.message = "JSON formatted"
+		} else {
+			m.message = "JSON raw"
+		}
 
 	case "ctrl+d", "ctrl+f":
 		pane.Viewport().PageDown()
@@ -437,21 +588,25 @@ func (m *Model) handleKey(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
 	case "g", "home":
 		pane.Viewport().GotoTop()
 	case "G", "end":
-		// Refresh file to pick up any new content, then go to bottom
-		pane.Source().Refresh()
-		pane.FilteredSource().MarkDirty()
-		pane.Viewport().GotoBottom()
+		// The bottom isn't known until indexing finishes
+		return m, m.whenIndexed(func() {
+			// Refresh file to pick up any new content, then go to bottom
+			m.checkForNewLines(pane)
+			pane.Viewport().GotoBottom()
+		})
 
 	case "/":
 		m.mode = ModeSearch
 		m.searchInput.SetValue("")
+		m.searchInput.Placeholder = "Search (ctrl+r regex, ctrl+s smart-case)..."
 		m.searchInput.Focus()
+		m.promptErr = ""
 		return m, textinput.Blink
 
 	case ":":
 		m.mode = ModeGoto
 		m.searchInput.SetValue("")
-		m.searchInput.Placeholder = "Line number, or tabnew <file> / tabclose"
+		m.searchInput.Placeholder = "Line number, tabnew <file> / tabclose, r !command"
 		m.searchInput.Focus()
 		return m, textinput.Blink
 
@@ -465,10 +620,19 @@ func (m *Model) handleKey(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
 	case "?":
 		m.mode = ModeFilter
 		m.searchInput.SetValue("")
-		m.searchInput.Placeholder = "Filter..."
+		m.searchInput.Placeholder = "Filter (ctrl+r regex, ctrl+s smart-case, =expression)..."
 		m.searchInput.Focus()
+		m.promptErr = ""
+		m.promptCaret = -1
 		return m, textinput.Blink
 
+	case "|":
+		m.openRules()
+	case "C":
+		m.cycleContext()
+	case "p":
+		m.peek()
+
 	case "n":
 		pane.NextSearchResult()
 	case "N":
@@ -531,10 +695,7 @@ func (m *Model) handleKey(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
 
 		// Jump back to the same original line in unfiltered view
 		if originalLine >= 0 {
-			filteredIdx := pane.FilteredSource().FilteredIndexFor(originalLine)
-			if filteredIdx >= 0 {
-				pane.Viewport().GotoLine(filteredIdx)
-			}
+			pane.GotoOriginal(originalLine, false)
 		}
 
 	case "R": // Revert slice or resync from source
@@ -623,30 +784,78 @@ func (m *Model) handleKey(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
 func (m *Model) handleSearchKey(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
 	switch msg.String() {
 	case "enter":
-		m.currentPane().PerformSearch(m.searchInput.Value())
+		if err := m.currentPane().PerformSearch(m.searchInput.Value(), m.matchOpts); err != nil {
+			m.message = err.Error()
+		}
 		m.mode = ModeNormal
 		m.searchInput.Blur()
+		m.searchInput.Placeholder = "Search..."
 		return m, nil
 
 	case "esc":
 		m.mode = ModeNormal
 		m.searchInput.Blur()
+		m.searchInput.Placeholder = "Search..."
+		return m, nil
+
+	case "ctrl+r", "ctrl+s":
+		m.toggleMatchOption(msg.String())
+		m.checkPrompt()
 		return m, nil
 	}
 
 	var cmd tea.Cmd
 	m.searchInput, cmd = m.searchInput.Update(msg)
+	m.checkPrompt()
 	return m, cmd
 }
 
+// toggleMatchOption flips the regex (ctrl+r) or smart-case (ctrl+s) option
+// of the / and ? prompts
+func (m *Model) toggleMatchOption(key string) {
+	if key == "ctrl+r" {
+		m.matchOpts.Regex = !m.matchOpts.Regex
+	} else {
+		m.matchOpts.SmartCase = !m.matchOpts.SmartCase
+	}
+}
+
+// checkPrompt notes why the term being typed doesn't compile, if it doesn't
+func (m *Model) checkPrompt() {
+	m.promptErr = ""
+	if _, err := source.NewMatcher(m.searchInput.Value(), m.matchOpts); err != nil {
+		m.promptErr = err.Error()
+	}
+}
+
+// matchInfo shows the / and ? prompts' match options, and why the term
+// doesn't compile if it doesn't
+func (m *Model) matchInfo() string {
+	var opts []string
+	if m.matchOpts.Regex {
+		opts = append(opts, "regex")
+	}
+	if m.matchOpts.SmartCase {
+		opts = append(opts, "smart-case")
+	}
+	info := ""
+	if len(opts) > 0 {
+		info = " [" + strings.Join(opts, " ") + "]"
+	}
+	if m.promptErr != "" && m.promptCaret < 0 {
+		info += "  " + m.promptErr
+	}
+	return info
+}
+
 func (m *Model) handleGotoKey(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
 	switch msg.String() {
 	case "enter":
-		m.runCommand(m.searchInput.Value())
+		cmd := m.runCommand(m.searchInput.Value())
 		m.mode = ModeNormal
 		m.searchInput.Blur()
 		m.searchInput.Placeholder = "Search..."
-		return m, nil
+		return m, cmd
 
 	case "esc":
 		m.mode = ModeNormal
@@ -660,16 +869,19 @@ func (m *Model) handleGotoKey(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
 	return m, cmd
 }
 
-// runCommand interprets the ":" command line: a bare number is a goto-line, and
-// tab verbs (tabnew/tabe, tabclose/tabc) manage tabs.
-func (m *Model) runCommand(input string) {
+// runCommand interprets the ":" command line: a bare number is a goto-line,
+// tab verbs (tabnew/tabe, tabclose/tabc) manage tabs, r/R !cmd page a
+// command's output in a new tab, stream picks stdout/stderr lines, where
+// filters structured lines by field, since/until/last set a time window,
+// and set changes view options.
+func (m *Model) runCommand(input string) tea.Cmd {
 	val := strings.TrimSpace(input)
 	if val == "" {
-		return
+		return nil
 	}
 
 	verb := val
-	if i := strings.IndexByte(val, ' '); i >= 0 {
+	if i := strings.IndexAny(val, " !"); i >= 0 {
 		verb = val[:i]
 	}
 
@@ -679,38 +891,172 @@ func (m *Model) runCommand(input string) {
 		path := strings.TrimSpace(val[len(verb):])
 		if path == "" {
 			m.message = "usage: tabnew <file>"
-			return
+			return nil
 		}
 		if err := m.openTab(path); err != nil {
 			m.message = err.Error()
 		}
+		return m.startIndexTick()
 	case "tabclose", "tabc":
 		m.closeTab()
+	case "r", "R":
+		// :r !cmd runs once, :R !cmd restarts the command whenever it exits
+		arg := strings.TrimSpace(val[len(verb):])
+		if !strings.HasPrefix(arg, "!") || strings.TrimSpace(arg[1:]) == "" {
+			m.message = "usage: " + verb + " !command"
+			return nil
+		}
+		cmd, err := m.openCommandTab(strings.TrimSpace(arg[1:]), verb == "R")
+		if err != nil {
+			m.message = err.Error()
+		}
+		return cmd
+	case "stream":
+		m.setStreamFilter(strings.TrimSpace(val[len(verb):]))
+	case "level":
+		m.setLevelFilter(strings.TrimSpace(val[len(verb):]))
+	case "where":
+		pane := m.currentPane()
+		if err := pane.SetWhere(val[len(verb):]); err != nil {
+			m.message = err.Error()
+			return nil
+		}
+		pane.Viewport().GotoTop()
+	case "since", "until", "last":
+		return m.setTimeWindow(verb, strings.TrimSpace(val[len(verb):]))
+	case "set", "se":
+		m.setOption(strings.TrimSpace(val[len(verb):]))
 	default:
 		var lineNum int
 		if _, err := fmt.Sscanf(val, "%d", &lineNum); err == nil && lineNum > 0 {
 			m.currentPane().Viewport().GotoLine(lineNum - 1) // Convert to 0-based
 		}
 	}
+	return nil
+}
+
+// setTimeWindow handles :since, :until and :last, which need timestamps
+// from the whole file, so wait for indexing. No argument removes the bound
+// (or for :last, the window).
+func (m *Model) setTimeWindow(verb, arg string) tea.Cmd {
+	pane := m.currentPane()
+	return m.whenIndexed(func() {
+		var err error
+		m.refilter(pane, func() {
+			switch verb {
+			case "since":
+				err = pane.SetSince(arg)
+			case "until":
+				err = pane.SetUntil(arg)
+			case "last":
+				err = pane.SetLast(arg)
+			}
+		})
+		if err != nil {
+			m.message = err.Error()
+		}
+	})
+}
+
+// setStreamFilter narrows a command pane to its stdout or stderr lines.
+func (m *Model) setStreamFilter(stream string) {
+	pane := m.currentPane()
+	switch stream {
+	case "stdout", "stderr":
+		if pane.Runner() == nil {
+			m.message = "not a command pane"
+			return
+		}
+		pane.FilteredSource().SetSourceFilter(stream)
+	case "all", "":
+		pane.FilteredSource().SetSourceFilter("")
+	default:
+		m.message = "usage: stream stdout|stderr|all"
+		return
+	}
+	pane.Viewport().GotoTop()
+}
+
+// setLevelFilter handles :level: "name" toggles a level, "name+" shows it
+// and everything more severe, and no name clears the level filter. Levels
+// are the registered ones, built-in or from config.
+func (m *Model) setLevelFilter(arg string) {
+	pane := m.currentPane()
+	if arg == "" {
+		pane.FilteredSource().ClearFilter()
+		pane.Viewport().GotoTop()
+		return
+	}
+	name, andAbove := strings.CutSuffix(arg, "+")
+	level, ok := logformat.Registered().Lookup(name)
+	if !ok {
+		m.message = fmt.Sprintf("unknown level %q", name)
+		return
+	}
+	if andAbove {
+		pane.FilteredSource().SetLevelAndAbove(level)
+	} else {
+		pane.FilteredSource().ToggleLevel(level)
+	}
+	pane.Viewport().GotoTop()
+}
+
+// setOption handles :set. Options are vim-style: "records" turns one on,
+// "norecords" off and "records!" toggles it.
+func (m *Model) setOption(opt string) {
+	pane := m.currentPane()
+	if arg, ok := strings.CutPrefix(opt, "context"); ok && (arg == "" || arg[0] == ' ' || arg[0] == '=') {
+		m.setContextOption(strings.TrimSpace(strings.TrimPrefix(arg, "=")))
+		return
+	}
+	switch opt {
+	case "records", "norecords", "records!", "invrecords":
+		on := opt == "records"
+		if opt == "records!" || opt == "invrecords" {
+			on = !pane.Records()
+		}
+		// Split panes may share the source, and with it the setting
+		for _, p := range m.tab().panes {
+			if p.Source() == pane.Source() {
+				p.SetRecords(on)
+			}
+		}
+		if on {
+			m.message = "records on"
+		} else {
+			m.message = "records off"
+		}
+	case "nocontext":
+		m.setContextOption("0")
+	case "":
+		m.message = "usage: set [no]records | context N"
+	default:
+		m.message = "unknown option: " + opt
+	}
 }
 
 func (m *Model) handleGotoTimeKey(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
 	switch msg.String() {
 	case "enter":
-		result := m.currentPane().GotoTime(m.searchInput.Value())
-		if result.Found && result.Actual != nil {
-			m.message = fmt.Sprintf("Target %s -> %s",
-				result.Target.Format("15:04:05"),
-				result.Actual.Format("2006-01-02 15:04:05"))
-		} else if result.Target != nil {
-			m.message = fmt.Sprintf("No line found near %s", result.Target.Format("2006-01-02 15:04:05"))
-		} else {
-			m.message = "Invalid time format"
-		}
+		input := m.searchInput.Value()
+		pane := m.currentPane()
 		m.mode = ModeNormal
 		m.searchInput.Blur()
 		m.searchInput.Placeholder = "Search..."
-		return m, nil
+
+		// Time search scans the whole file, so wait for indexing first
+		return m, m.whenIndexed(func() {
+			result := pane.GotoTime(input)
+			if result.Found && result.Actual != nil {
+				m.message = fmt.Sprintf("Target %s -> %s",
+					result.Target.Format("15:04:05"),
+					result.Actual.Format("2006-01-02 15:04:05"))
+			} else if result.Target != nil {
+				m.message = fmt.Sprintf("No line found near %s", result.Target.Format("2006-01-02 15:04:05"))
+			} else {
+				m.message = "Invalid time format"
+			}
+		})
 
 	case "esc":
 		m.mode = ModeNormal
@@ -729,8 +1075,13 @@ func (m *Model) handleFilterKey(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
 	pane := m.currentPane()
 	switch msg.String() {
 	case "enter":
-		// Keep filter and return to normal mode
-		pane.SetFilterTerm(m.searchInput.Value())
+		// Keep filter and return to normal mode; a regex that doesn't
+		// compile leaves the last one that did
+		if m.promptErr != "" {
+			m.message = m.promptErr
+		} else {
+			pane.SetFilterTerm(m.searchInput.Value())
+		}
 		m.mode = ModeNormal
 		m.searchInput.Blur()
 		m.searchInput.Placeholder = "Search..."
@@ -739,25 +1090,59 @@ func (m *Model) handleFilterKey(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
 	case "esc":
 		// Cancel filter and clear
 		pane.FilteredSource().ClearTextFilter()
+		pane.SetExpr("")
 		pane.SetFilterTerm("")
 		pane.Viewport().GotoTop()
 		m.mode = ModeNormal
 		m.searchInput.Blur()
 		m.searchInput.Placeholder = "Search..."
 		return m, nil
+
+	case "ctrl+r", "ctrl+s":
+		m.toggleMatchOption(msg.String())
+		m.applyLiveFilter(pane)
+		return m, nil
 	}
 
 	// Update input and apply filter live
 	var cmd tea.Cmd
 	m.searchInput, cmd = m.searchInput.Update(msg)
-
-	// Apply filter immediately (live filtering)
-	pane.FilteredSource().SetTextFilter(m.searchInput.Value())
-	pane.Viewport().GotoTop()
+	m.applyLiveFilter(pane)
 
 	return m, cmd
 }
 
+// applyLiveFilter filters pane by the term being typed, or by a filter
+// expression if it starts with "=". A regex or expression that doesn't
+// compile (yet) leaves the last one that did in place, and the prompt says
+// why.
+func (m *Model) applyLiveFilter(pane *Pane) {
+	m.promptCaret = -1
+	if src, ok := strings.CutPrefix(m.searchInput.Value(), "="); ok {
+		m.promptErr = ""
+		if err := pane.SetExpr(src); err != nil {
+			m.promptErr = err.Error()
+			if e, ok := err.(*source.ExprError); ok {
+				m.promptErr = e.Msg
+				m.promptCaret = 1 + e.Column(src)
+			}
+			return
+		}
+		pane.FilteredSource().SetTextFilter(nil)
+		pane.Viewport().GotoTop()
+		return
+	}
+
+	m.checkPrompt()
+	if m.promptErr != "" {
+		return
+	}
+	matcher, _ := source.NewMatcher(m.searchInput.Value(), m.matchOpts)
+	pane.SetExpr("")
+	pane.FilteredSource().SetTextFilter(matcher)
+	pane.Viewport().GotoTop()
+}
+
 
 func (m *Model) handleSliceKey(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
 	switch msg.String() {
@@ -895,11 +1280,25 @@ func (m *Model) handleYankKey(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
 	return m, nil
 }
 
-// yankLines yanks N lines from current position to clipboard
+// yankLines yanks N lines from current position to clipboard. With records
+// on it yanks N whole records, starting with the one the current line is in.
 func (m *Model) yankLines(count int) {
 	pane := m.currentPane()
 	startFiltered := pane.Viewport().CurrentLine()
 
+	if pane.Records() {
+		start := pane.FilteredSource().OriginalLineNumber(startFiltered)
+		if start < 0 {
+			return
+		}
+		start, end := pane.Source().RecordBounds(start)
+		for i := 1; i < count && end < pane.Source().LineCount(); i++ {
+			_, end = pane.Source().RecordBounds(end)
+		}
+		m.yankRange(start, end-1)
+		return
+	}
+
 	var lines []string
 	for i := 0; i < count; i++ {
 		line, err := pane.FilteredSource().GetLine(startFiltered + i)
@@ -909,15 +1308,7 @@ func (m *Model) yankLines(count int) {
 		lines = append(lines, string(line.Content))
 	}
 
-	if len(lines) > 0 {
-		text := strings.Join(lines, "\n")
-		m.copyToClipboard(text)
-		if len(lines) == 1 {
-			m.message = "1 line yanked"
-		} else {
-			m.message = fmt.Sprintf("%d lines yanked", len(lines))
-		}
-	}
+	m.yankText(lines)
 }
 
 // yankToMark yanks from current line to mark
@@ -940,6 +1331,14 @@ func (m *Model) yankToMark(markChar rune) {
 		startOriginal, endOriginal = endOriginal, startOriginal
 	}
 
+	m.yankRange(pane.RecordRange(startOriginal, endOriginal))
+}
+
+// yankRange yanks the lines of the filtered view whose original line numbers
+// fall in [startOriginal, endOriginal]
+func (m *Model) yankRange(startOriginal, endOriginal int) {
+	pane := m.currentPane()
+
 	// Collect lines from filtered view that fall in this range
 	var lines []string
 	for i := 0; i < pane.FilteredSource().LineCount(); i++ {
@@ -953,6 +1352,11 @@ func (m *Model) yankToMark(markChar rune) {
 		}
 	}
 
+	m.yankText(lines)
+}
+
+// yankText copies lines to the clipboard and reports how many were yanked
+func (m *Model) yankText(lines []string) {
 	if len(lines) > 0 {
 		text := strings.Join(lines, "\n")
 		m.copyToClipboard(text)
@@ -1102,7 +1506,8 @@ func (m *Model) visualMoveUp(pane *Pane, count int) {
 	}
 }
 
-// yankVisualSelection yanks the lines in the visual selection to clipboard
+// yankVisualSelection yanks the lines in the visual selection to clipboard.
+// With records on the selection covers whole records.
 func (m *Model) yankVisualSelection() {
 	pane := m.currentPane()
 	startOrig, endOrig := pane.GetVisualSelectionRange()
@@ -1110,28 +1515,7 @@ func (m *Model) yankVisualSelection() {
 		return
 	}
 
-	// Collect lines from filtered view that fall in this range
-	var lines []string
-	for i := 0; i < pane.FilteredSource().LineCount(); i++ {
-		line, err := pane.FilteredSource().GetLine(i)
-		if err != nil || line == nil {
-			continue
-		}
-		origIdx := pane.FilteredSource().OriginalLineNumber(i)
-		if origIdx >= startOrig && origIdx <= endOrig {
-			lines = append(lines, string(line.Content))
-		}
-	}
-
-	if len(lines) > 0 {
-		text := strings.Join(lines, "\n")
-		m.copyToClipboard(text)
-		if len(lines) == 1 {
-			m.message = "1 line yanked"
-		} else {
-			m.message = fmt.Sprintf("%d lines yanked", len(lines))
-		}
-	}
+	m.yankRange(pane.RecordRange(startOrig, endOrig))
 }
 
 // truncateOrPad ensures a string is exactly the given visible width (ANSI-aware)
@@ -1222,8 +1606,13 @@ func (m *Model) View() string {
 		builder.WriteString("\n")
 	}
 
-	// Render the active tab's content area (single pane, zoom, or split).
-	builder.WriteString(m.tab().renderContent())
+	// Render the active tab's content area (single pane, zoom, or split),
+	// with the rule panel over its bottom while it's open.
+	if m.mode == ModeRules || m.mode == ModeRuleEdit {
+		builder.WriteString(overlayBottom(m.tab().renderContent(), m.renderRules()))
+	} else {
+		builder.WriteString(m.tab().renderContent())
+	}
 
 	pane := m.currentPane()
 
@@ -1236,15 +1625,22 @@ func (m *Model) View() string {
 	var status string
 	switch m.mode {
 	case ModeSearch:
-		status = "/" + m.searchInput.View()
+		status = "/" + m.searchInput.View() + m.matchInfo()
 	case ModeGoto:
 		status = ":" + m.searchInput.View()
 	case ModeGotoTime:
 		status = "t:" + m.searchInput.View()
 	case ModeFilter:
-		status = "?" + m.searchInput.View()
+		status = "?" + m.searchInput.View() + m.matchInfo() + m.filterProgress()
 	case ModeSlice:
 		status = "S:" + m.searchInput.View()
+	case ModeRules, ModeRuleEdit:
+		status = m.rulesStatus()
+	case ModePeek:
+		status = m.peekStatus()
+	case ModeIndexWait:
+		status = fmt.Sprintf(" %s Indexing %s… %.0f%%  esc:cancel",
+			m.spinner.View(), pane.Filename(), pane.Source().IndexProgress()*100)
 	case ModeVisual:
 		start, end := pane.GetVisualSelectionRange()
 		lineCount := 0
@@ -1270,8 +1666,11 @@ func (m *Model) View() string {
 		percent := fmt.Sprintf("%.0f%%", pane.Viewport().PercentScrolled())
 
 		searchInfo := ""
-		if pane.SearchTerm() != "" {
+		if search := pane.Search(); search != nil {
 			searchInfo = fmt.Sprintf(" [%d matches]", len(pane.SearchResults()))
+			if search.Options().Regex || search.IgnoresCase() {
+				searchInfo = fmt.Sprintf(" [%d matches %s]", len(pane.SearchResults()), search.Label(15))
+			}
 		}
 
 		// Show active filters
@@ -1281,31 +1680,54 @@ func (m *Model) View() string {
 
 			// Level filters
 			filters := pane.FilteredSource().GetActiveFilters()
-			levelNames := map[source.LogLevel]string{
-				source.LevelTrace: "TRC",
-				source.LevelDebug: "DBG",
-				source.LevelInfo:  "INF",
-				source.LevelWarn:  "WRN",
-				source.LevelError: "ERR",
-				source.LevelFatal: "FTL",
-			}
 			var levels []string
-			for level, active := range filters {
-				if active {
-					levels = append(levels, levelNames[level])
+			for _, def := range logformat.Registered().BySeverity() {
+				if filters[def.Level] {
+					levels = append(levels, def.Short)
 				}
 			}
 			if len(levels) > 0 {
 				parts = append(parts, strings.Join(levels, ","))
 			}
 
+			// Stream filter (command panes)
+			if stream := pane.FilteredSource().GetSourceFilter(); stream != "" {
+				parts = append(parts, stream)
+			}
+
+			// Field filter
+			if where := pane.FilteredSource().GetFieldFilter(); where != "" {
+				parts = append(parts, where)
+			}
+
+			// Time window
+			if window := pane.TimeWindow(); window != "" {
+				parts = append(parts, window)
+			}
+
+			// Rule stack
+			if n := enabledRules(pane.FilteredSource()); n == 1 {
+				parts = append(parts, "1 rule")
+			} else if n > 1 {
+				parts = append(parts, fmt.Sprintf("%d rules", n))
+			}
+
+			// Expression filter
+			if expr := pane.FilteredSource().GetExprFilter(); expr != "" {
+				if len(expr) > 30 {
+					expr = expr[:30] + "..."
+				}
+				parts = append(parts, "="+expr)
+			}
+
 			// Text filter
 			if pane.FilteredSource().HasTextFilter() {
-				text := pane.FilteredSource().GetTextFilter()
-				if len(text) > 15 {
-					text = text[:15] + "..."
-				}
-				parts = append(parts, "\""+text+"\"")
+				parts = append(parts, pane.FilteredSource().GetTextFilter().Label(15))
+			}
+
+			// Context lines
+			if ctx := contextInfo(pane.FilteredSource().GetContext()); ctx != "" {
+				parts = append(parts, ctx)
 			}
 
 			if len(parts) > 0 {
@@ -1333,6 +1755,21 @@ func (m *Model) View() string {
 			followInfo = " [following]"
 		}
 
+		// Background indexing progress
+		if pane.Source().Indexing() {
+			followInfo += fmt.Sprintf(" [indexing %.0f%%]", pane.Source().IndexProgress()*100)
+		}
+
+		// Background filter progress
+		if done, total := pane.FilteredSource().Progress(); total > 0 {
+			followInfo += fmt.Sprintf(" [filtering %.0f%%]", float64(done)*100/float64(total))
+		}
+
+		// Record grouping indicator
+		if pane.Records() {
+			followInfo += " [records]"
+		}
+
 		// Zoom indicator (only meaningful in a split)
 		if m.tab().zoomed && len(m.tab().panes) > 1 {
 			followInfo += " [zoom]"
@@ -1344,6 +1781,23 @@ func (m *Model) View() string {
 			consolidatedInfo = fmt.Sprintf(" [consolidated: %d files]", m.consolidatedWriter.SourceCount())
 		}
 
+		// Pipe indicator (stdin still streaming or finished, or the state of
+		// the process behind a command pane)
+		pipeInfo := ""
+		if r := pane.Runner(); r != nil {
+			pipeInfo = fmt.Sprintf(" [%s]", r.Status())
+		}
+		if m.stdin != nil && pane.sourcePath == m.stdin.OutputPath() {
+			switch {
+			case m.stdin.Err() != nil:
+				pipeInfo = fmt.Sprintf(" [pipe error: %v]", m.stdin.Err())
+			case m.stdin.Done():
+				pipeInfo = " [EOF]"
+			default:
+				pipeInfo = " [pipe open]"
+			}
+		}
+
 		// Get timestamp for current line
 		timeInfo := ""
 		currentLine := pane.Viewport().CurrentLine()
@@ -1356,9 +1810,12 @@ func (m *Model) View() string {
 		if m.message != "" {
 			msgInfo = fmt.Sprintf(" [%s]", m.message)
 		}
+		if err := pane.Source().Err(); err != nil {
+			msgInfo += fmt.Sprintf(" [error: %v]", err)
+		}
 
-		status = fmt.Sprintf(" %s%s%s%s  %s%s  %s%s%s%s",
-			pane.Filename(), sliceInfo, followInfo, consolidatedInfo, lineInfo, timeInfo, percent, searchInfo, filterInfo, msgInfo)
+		status = fmt.Sprintf(" %s%s%s%s%s  %s%s  %s%s%s%s",
+			pane.Filename(), sliceInfo, followInfo, consolidatedInfo, pipeInfo, lineInfo, timeInfo, percent, searchInfo, filterInfo, msgInfo)
 	}
 
 	// Debug indicator: last key received + active pane. Lets us confirm whether
@@ -1376,7 +1833,13 @@ func (m *Model) View() string {
 	builder.WriteString(statusStyle.Render(status))
 	builder.WriteString("\n")
 
-	// Help line
+	// Help line, or a caret under what's wrong with a ?= expression
+	if m.mode == ModeFilter && m.promptErr != "" && m.promptCaret >= 0 {
+		caretStyle := lipgloss.NewStyle().Foreground(lipgloss.Color("196"))
+		offset := lipgloss.Width(dbg) + 1 + lipgloss.Width(m.searchInput.Prompt) + m.promptCaret
+		builder.WriteString(caretStyle.Render(strings.Repeat(" ", offset) + "^ " + m.promptErr))
+		return builder.String()
+	}
 	helpStyle := lipgloss.NewStyle().Foreground(lipgloss.Color("240"))
 	help := "j/k:scroll  /:search  ?:filter  t/d/i/w/e:level  T/D/I/W/E:lvl+  0:clear  q:quit"
 	builder.WriteString(helpStyle.Render(help))
@@ -1425,11 +1888,58 @@ func (m *Model) renderFileInfo() string {
 	b.WriteString(valueStyle.Render(pane.sourcePath))
 	b.WriteString("\n")
 
+	// Compression
+	if c := pane.Source().Compression(); c != mlessio.CompressionNone {
+		b.WriteString(labelStyle.Render("  Format:    "))
+		how := " (decompressed on the fly)"
+		if pane.Source().Sequential() {
+			how = " (decompressed on the fly, from the top: one frame/block, so jumping back is slow)"
+		}
+		b.WriteString(valueStyle.Render(c.String() + how))
+		b.WriteString("\n")
+	}
+
+	// Format profile, if any are configured
+	if len(m.config.Formats) > 0 {
+		b.WriteString(labelStyle.Render("  Profile:   "))
+		if pane.profile != nil {
+			b.WriteString(valueStyle.Render(pane.profile.Name))
+		} else {
+			b.WriteString(valueStyle.Render("none matched"))
+		}
+		b.WriteString("\n")
+	}
+
+	// Date that timestamps without one are dated from
+	baseDate, dateFrom := pane.BaseDate()
+	b.WriteString(labelStyle.Render("  Base date: "))
+	b.WriteString(valueStyle.Render(fmt.Sprintf("%s (from %s)", baseDate.Format("2006-01-02"), dateFrom)))
+	b.WriteString("\n")
+
 	// Line count
 	b.WriteString(labelStyle.Render("  Lines:     "))
 	b.WriteString(valueStyle.Render(fmt.Sprintf("%d", pane.Source().LineCount())))
 	b.WriteString("\n")
 
+	// Index
+	b.WriteString(labelStyle.Render("  Index:     "))
+	switch {
+	case pane.Source().Indexing():
+		b.WriteString(valueStyle.Render(fmt.Sprintf("building (%.0f%%)", pane.Source().IndexProgress()*100)))
+	case pane.Source().IndexFromCache():
+		b.WriteString(valueStyle.Render("loaded from cache"))
+	default:
+		b.WriteString(valueStyle.Render("built"))
+	}
+	b.WriteString("\n")
+
+	// Level mix, estimated from the lines sampled while indexing
+	if levels := sampledLevels(pane.Source().Samples()); levels != "" {
+		b.WriteString(labelStyle.Render("  Levels:    "))
+		b.WriteString(valueStyle.Render(levels + " (sampled)"))
+		b.WriteString("\n")
+	}
+
 	// Current position
 	currentLine := pane.Viewport().CurrentLine() + 1
 	totalLines := pane.Source().LineCount()
@@ -1477,6 +1987,26 @@ func (m *Model) renderFileInfo() string {
 	return b.String()
 }
 
+// sampledLevels summarises the share of each level among sampled lines,
+// most severe first, e.g. "ERR 2% WRN 11% INF 80%"
+func sampledLevels(samples []index.Sample) string {
+	if len(samples) == 0 {
+		return ""
+	}
+	counts := make(map[source.LogLevel]int)
+	for _, s := range samples {
+		counts[s.Level]++
+	}
+
+	var parts []string
+	for _, def := range logformat.Registered().BySeverity() {
+		if c := counts[def.Level]; c > 0 {
+			parts = append(parts, fmt.Sprintf("%s %.0f%%", def.Short, float64(c)*100/float64(len(samples))))
+		}
+	}
+	return strings.Join(parts, " ")
+}
+
 // renderHelp renders the help screen
 func (m *Model) renderHelp() string {
 	titleStyle := lipgloss.NewStyle().Bold(true).Foreground(lipgloss.Color("214"))
@@ -1503,12 +2033,21 @@ func (m *Model) renderHelp() string {
 			"/pattern        Search for pattern",
 			"n/N             Next/prev search result",
 			"?pattern        Filter lines (fzf-style)",
+			"?=expr          Filter by fields: level>=warn && latency>200ms",
+			"|               Filter rules: include/exclude stack",
+			"C               Cycle context lines around matches: 0/2/5/10",
+			"p               Peek at the unfiltered lines around the current one",
+			":since/:until T Show lines from/before time T (HH:MM[:SS])",
+			":last 15m       Show the last 15m of the log (slides in follow)",
+			"ctrl+r (in /?)  Toggle regex (RE2)",
+			"ctrl+s (in /?)  Toggle smart-case",
 			"esc             Clear search/filter",
 		}},
 		{"Log Levels", []string{
 			"t/d/i/w/e       Toggle trace/debug/info/warn/error",
 			"alt+f           Toggle fatal",
 			"T/D/I/W/E       Show level and above",
+			":level NAME     Toggle any level, e.g. notice (NAME+: and above)",
 			"0               Clear all level filters",
 		}},
 		{"Marks", []string{
@@ -1536,6 +2075,7 @@ func (m *Model) renderHelp() string {
 			"^               Reset horizontal scroll",
 			"Z               Toggle line wrap (whole view)",
 			"z               Expand current line in place",
+			"J               Format JSON lines (time LEVEL msg key=val)",
 		}},
 		{"Split Views", []string{
 			"ctrl+w/ctrl+x   Split leader (ctrl+x if ctrl+w is trapped)",
@@ -1558,6 +2098,13 @@ func (m *Model) renderHelp() string {
 			"<leader> 1-9    Jump to tab 1-9",
 			"<leader> n/p    Next / previous tab",
 		}},
+		{"Commands", []string{
+			":r !cmd         Page a command's output live in a new tab",
+			":R !cmd         Same, restarting the command when it exits",
+			":stream stderr  Show only stderr (stdout / all)",
+			":where k=v      JSON/logfmt lines with field k = v (k!=v, k; :where clears)",
+			":set records    Filter, search, slice and yank whole records (norecords)",
+		}},
 		{"Other", []string{
 			"F               Toggle follow mode",
 			"l               Show line numbers",
@@ -1611,5 +2158,12 @@ func (m *Model) Close() error {
 		}
 	}
 
+	// Stop spooling stdin (removes temp file)
+	if m.stdin != nil {
+		if stdinErr := m.stdin.Close(); stdinErr != nil && err == nil {
+			err = stdinErr
+		}
+	}
+
 	return err
 }
@@ -0,0 +1,106 @@
+package ui
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// Context lines show the lines around each filter match, dimmed, grep -B/-A
+// style (:set context N, or C to cycle), and peeking (p) shows the whole
+// file around the current line for a moment without losing the filter.
+
+// contextSteps are the context sizes C cycles through
+var contextSteps = []int{0, 2, 5, 10}
+
+// setContextOption handles :set context: "N" shows N lines before and
+// after each match, "B,A" B before and A after, and no count shows the
+// current setting
+func (m *Model) setContextOption(arg string) {
+	pane := m.currentPane()
+	filter := pane.FilteredSource()
+	if arg == "" {
+		before, after := filter.GetContext()
+		m.message = fmt.Sprintf("context %d,%d", before, after)
+		return
+	}
+	b, a, pair := strings.Cut(arg, ",")
+	before, err1 := strconv.Atoi(strings.TrimSpace(b))
+	after, err2 := before, error(nil)
+	if pair {
+		after, err2 = strconv.Atoi(strings.TrimSpace(a))
+	}
+	if err1 != nil || err2 != nil || before < 0 || after < 0 {
+		m.message = "usage: set context N (or BEFORE,AFTER)"
+		return
+	}
+	m.refilter(pane, func() { filter.SetContext(before, after) })
+}
+
+// cycleContext moves the context on to the next of contextSteps, before and
+// after alike
+func (m *Model) cycleContext() {
+	pane := m.currentPane()
+	filter := pane.FilteredSource()
+	before, after := filter.GetContext()
+	next := contextSteps[0]
+	for _, n := range contextSteps {
+		if n > max(before, after) {
+			next = n
+			break
+		}
+	}
+	m.refilter(pane, func() { filter.SetContext(next, next) })
+	if next == 0 {
+		m.message = "context off"
+	} else {
+		m.message = fmt.Sprintf("context %d", next)
+	}
+}
+
+// contextInfo describes the context setting for the status bar ("" if off)
+func contextInfo(before, after int) string {
+	switch {
+	case before == 0 && after == 0:
+		return ""
+	case before == after:
+		return fmt.Sprintf("ctx %d", before)
+	}
+	return fmt.Sprintf("ctx %d,%d", before, after)
+}
+
+// peek shows the whole file around the current line (see Pane.StartPeek)
+func (m *Model) peek() {
+	if m.currentPane().StartPeek() {
+		m.mode = ModePeek
+	} else {
+		m.message = "nothing filtered to peek past"
+	}
+}
+
+func (m *Model) handlePeekKey(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+	pane := m.currentPane()
+	switch msg.String() {
+	case "p", "esc", "q":
+		pane.EndPeek()
+		m.mode = ModeNormal
+	case "j", "down":
+		pane.Viewport().ScrollDown(1)
+	case "k", "up":
+		pane.Viewport().ScrollUp(1)
+	case "f", "pgdown", " ", "ctrl+d", "ctrl+f":
+		pane.Viewport().PageDown()
+	case "b", "pgup", "ctrl+u", "ctrl+b":
+		pane.Viewport().PageUp()
+	}
+	return m, nil
+}
+
+// peekStatus is the status bar while peeking
+func (m *Model) peekStatus() string {
+	pane := m.currentPane()
+	return fmt.Sprintf(" PEEK %s  L%d/%d, unfiltered  j/k:scroll  p/esc:back to the filter",
+		pane.Filename(), pane.Viewport().CurrentLine()+1, pane.Source().LineCount())
+}
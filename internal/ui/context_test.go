@@ -0,0 +1,92 @@
+package ui
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	tea "github.com/charmbracelet/bubbletea"
+
+	"github.com/TimelordUK/mless/internal/source"
+)
+
+// TestContextLines filters to ERROR with context lines around the matches,
+// checks which lines show, which are context and where the -- separators
+// go, then peeks at the unfiltered file and comes back.
+func TestContextLines(t *testing.T) {
+	var content []string
+	for i := 0; i < 15; i++ {
+		level := "INFO"
+		if i == 3 || i == 11 {
+			level = "ERROR"
+		}
+		content = append(content, fmt.Sprintf("10:00:%02d %s line %d", i, level, i))
+	}
+	m := newTabModel(t, content...)
+	defer m.Close()
+	pane := m.currentPane()
+	filter := pane.FilteredSource()
+	matcher, _ := source.NewMatcher("ERROR", source.MatchOptions{})
+	filter.SetTextFilter(matcher)
+
+	check := func(what string, want []int, context []int, breaks []int) {
+		t.Helper()
+		var got, gotContext, gotBreaks []int
+		for i := 0; i < filter.LineCount(); i++ {
+			line, _ := filter.GetLine(i)
+			got = append(got, line.OriginalIndex)
+			if line.Context {
+				gotContext = append(gotContext, line.OriginalIndex)
+			}
+			if line.Break {
+				gotBreaks = append(gotBreaks, line.OriginalIndex)
+			}
+		}
+		if fmt.Sprint(got, gotContext, gotBreaks) != fmt.Sprint(want, context, breaks) {
+			t.Fatalf("%s: shown %v, context %v, breaks %v; want %v, %v, %v",
+				what, got, gotContext, gotBreaks, want, context, breaks)
+		}
+	}
+
+	check("no context", []int{3, 11}, nil, nil)
+
+	m.runCommand("set context 1")
+	check(":set context 1", []int{2, 3, 4, 10, 11, 12}, []int{2, 4, 10, 12}, []int{10})
+	if filter.MatchCount() != 2 {
+		t.Fatalf("%d matches, want 2", filter.MatchCount())
+	}
+	view := m.View()
+	if !strings.Contains(view, "\n--") || !strings.Contains(view, "ctx 1") {
+		t.Fatal("groups should be separated by -- and the status bar show the context")
+	}
+
+	m.runCommand("set context=2,0")
+	check(":set context=2,0", []int{1, 2, 3, 9, 10, 11}, []int{1, 2, 9, 10}, []int{9})
+
+	// Groups that meet run together
+	m.runCommand("set context 4")
+	if filter.LineCount() != 15 || strings.Contains(m.View(), "\n--") {
+		t.Fatalf("context 4 should show all 15 lines in one group, got %d", filter.LineCount())
+	}
+
+	m.handleKey(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune{'C'}})
+	if before, after := filter.GetContext(); before != 5 || after != 5 {
+		t.Fatalf("C after 4 should go to 5, got %d,%d", before, after)
+	}
+	m.handleKey(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune{'C'}})
+	m.handleKey(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune{'C'}})
+	check("C back to 0", []int{3, 11}, nil, nil)
+
+	// Peek at the first match's neighbourhood, then go back
+	m.handleKey(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune{'p'}})
+	if m.mode != ModePeek || pane.Viewport().HighlightedLine() != 3 {
+		t.Fatalf("p should peek at line 3 (mode %v, highlight %d)", m.mode, pane.Viewport().HighlightedLine())
+	}
+	if view := m.View(); !strings.Contains(view, "INFO line 2") || !strings.Contains(view, "PEEK") {
+		t.Fatal("peeking should show the unfiltered lines")
+	}
+	m.handleKey(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune{'p'}})
+	if m.mode != ModeNormal || filter.LineCount() != 2 || strings.Contains(m.View(), "INFO line 2") {
+		t.Fatal("p again should go back to the filtered view where it was")
+	}
+}
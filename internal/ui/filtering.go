@@ -0,0 +1,92 @@
+package ui
+
+import (
+	"fmt"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// Filters over big files are rebuilt in the background (see
+// source.FilteredProvider). While that runs the UI ticks, like it does for
+// indexing, to show the matches found so far and how far it has got.
+
+// filterTickMsg is sent periodically while any pane's filter is rebuilding
+type filterTickMsg time.Time
+
+// filterTickCmd returns a command that sends a filter tick after a short
+// delay
+func (m *Model) filterTickCmd() tea.Cmd {
+	return tea.Tick(100*time.Millisecond, func(t time.Time) tea.Msg {
+		return filterTickMsg(t)
+	})
+}
+
+// anyFiltering reports whether any pane in any tab is rebuilding its filter
+func (m *Model) anyFiltering() bool {
+	for _, t := range m.tabs {
+		for _, p := range t.panes {
+			if p.filteredSource.Building() {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// startFilterTick starts the filter tick if a filter is rebuilding and the
+// tick isn't already running
+func (m *Model) startFilterTick() tea.Cmd {
+	if m.filterTicking || !m.anyFiltering() {
+		return nil
+	}
+	m.filterTicking = true
+	return m.filterTickCmd()
+}
+
+// handleFilterTick keeps ticking, and so redrawing, until every rebuild is
+// finished, following up jumps made while they run
+func (m *Model) handleFilterTick() tea.Cmd {
+	filtering := m.anyFiltering()
+	for _, t := range m.tabs {
+		for _, p := range t.panes {
+			p.Settle()
+		}
+	}
+	if filtering {
+		return m.filterTickCmd()
+	}
+	m.filterTicking = false
+	return nil
+}
+
+// checkForNewLines refreshes pane's file, noting a rotation. Splits sharing
+// the file take in what changed too, going to the bottom if following.
+func (m *Model) checkForNewLines(pane *Pane) {
+	result, err := pane.refresh()
+	if err != nil {
+		return
+	}
+	for _, p := range m.tab().panes {
+		if p != pane && p.source == pane.source {
+			p.takeRefresh(result, p.following)
+		}
+	}
+	if result.Rotated {
+		m.message = "file rotated"
+	}
+}
+
+// filterProgress describes the current pane's filter for the ? prompt: how
+// many lines match, and while it's rebuilding how far it has got
+func (m *Model) filterProgress() string {
+	filter := m.currentPane().FilteredSource()
+	if !filter.IsFiltered() {
+		return ""
+	}
+	info := fmt.Sprintf("  %d matches", filter.MatchCount())
+	if done, total := filter.Progress(); total > 0 {
+		info += fmt.Sprintf("… %.0f%%", float64(done)*100/float64(total))
+	}
+	return info
+}
@@ -0,0 +1,256 @@
+package ui
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+
+	"github.com/TimelordUK/mless/internal/source"
+)
+
+// TestBackgroundFilter types into the live filter over a file big enough to
+// be filtered in the background, and checks each keystroke's rebuild gives
+// way to the next, the result is what filtering in the foreground gives, and
+// a jump made meanwhile ends up on the line once the rebuild gets that far.
+func TestBackgroundFilter(t *testing.T) {
+	const lines = 60000
+	content := make([]string, lines)
+	for i := range content {
+		content[i] = fmt.Sprintf("10:00:00 INFO request %d user=%d", i, i%7)
+	}
+	m := newTabModel(t, content...)
+	defer m.Close()
+	pane := m.currentPane()
+	if err := pane.Source().WaitIndexed(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	pane.SyncIndex()
+	filter := pane.FilteredSource()
+	filter.SetAsyncLines(1000)
+
+	waitBuilt := func() {
+		t.Helper()
+		deadline := time.Now().Add(10 * time.Second)
+		for filter.Building() {
+			if time.Now().After(deadline) {
+				t.Fatal("filter never finished rebuilding")
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}
+
+	m.handleKey(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune{'?'}})
+	for _, r := range "user=3" {
+		m.handleKey(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune{r}})
+		m.View() // each keystroke's rebuild starts as the screen is drawn
+	}
+
+	// Jumping to a line doesn't wait for the rebuild: it goes to the nearest
+	// match found so far, and on to the line itself as the ticks come in
+	pane.GotoOriginal(30000, true)
+	deadline := time.Now().Add(10 * time.Second)
+	for m.handleFilterTick() != nil {
+		if time.Now().After(deadline) {
+			t.Fatal("filter never finished rebuilding")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if got := filter.OriginalLineNumber(pane.Viewport().CurrentLine()); got != 30005 {
+		t.Fatalf("line 30000 lands on %d, want 30005", got)
+	}
+	if got := pane.Viewport().HighlightedLine(); got != 30005 {
+		t.Fatalf("line %d highlighted, want 30005", got)
+	}
+
+	waitBuilt()
+	if got := filter.LineCount(); got != lines/7 {
+		t.Fatalf("%d matches, want %d", got, lines/7)
+	}
+	for i := 0; i < filter.LineCount(); i += 997 {
+		if n := filter.OriginalLineNumber(i); n%7 != 3 {
+			t.Fatalf("match %d is line %d", i, n)
+		}
+	}
+	if status := m.View(); !strings.Contains(status, fmt.Sprintf("%d matches", lines/7)) {
+		t.Fatal("the prompt should show the match count")
+	}
+
+	// The same filter in the foreground
+	want := filter.LineCount()
+	filter.SetAsyncLines(0)
+	filter.MarkDirty()
+	if got := filter.LineCount(); got != want {
+		t.Fatalf("in the foreground %d matches, in the background %d", got, want)
+	}
+}
+
+// TestFollowDuringBackgroundFilter grows a followed file while its filter
+// is rebuilding in the background, in this pane and in a split sharing the
+// file. The file is refreshed under the rebuilds (go test -race checks
+// that), the new lines come in to both once they're done, and a rotation
+// meanwhile is noticed.
+func TestFollowDuringBackgroundFilter(t *testing.T) {
+	const lines = 60000
+	content := make([]string, lines)
+	for i := range content {
+		content[i] = fmt.Sprintf("10:00:00 INFO request %d user=%d", i, i%7)
+	}
+	m := newTabModel(t, content...)
+	defer m.Close()
+	pane := m.currentPane()
+	if err := pane.Source().WaitIndexed(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	pane.SyncIndex()
+	m.tab().splitHorizontal()
+	split := m.tab().panes[1]
+
+	matcher, _ := source.NewMatcher("user=3", source.MatchOptions{})
+	for _, p := range []*Pane{pane, split} {
+		p.FilteredSource().SetAsyncLines(1000)
+		p.FilteredSource().SetTextFilter(matcher)
+		p.FilteredSource().LineCount()
+	}
+	for round := 0; round < 10; round++ {
+		f, err := os.OpenFile(pane.Source().Path(), os.O_APPEND|os.O_WRONLY, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for k := 0; k < 70; k++ {
+			fmt.Fprintf(f, "10:00:01 INFO later user=%d\n", k%7)
+		}
+		f.Close()
+		m.checkForNewLines(pane)
+	}
+
+	waitBuilt := func() {
+		t.Helper()
+		deadline := time.Now().Add(10 * time.Second)
+		for m.anyFiltering() {
+			if time.Now().After(deadline) {
+				t.Fatal("filters never finished rebuilding")
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}
+	waitBuilt()
+	for i, p := range []*Pane{pane, split} {
+		if got, want := p.FilteredSource().LineCount(), lines/7+100; got != want {
+			t.Fatalf("pane %d: %d matches, want %d", i, got, want)
+		}
+	}
+
+	// Rotated while rebuilding
+	pane.FilteredSource().MarkDirty()
+	pane.FilteredSource().LineCount()
+	rotated := make([]string, 2000)
+	for i := range rotated {
+		rotated[i] = fmt.Sprintf("10:00:02 INFO rotated %d user=%d", i, i%7)
+	}
+	if err := os.WriteFile(pane.Source().Path(), []byte(strings.Join(rotated, "\n")+"\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	m.message = ""
+	m.checkForNewLines(pane)
+	if m.message != "file rotated" {
+		t.Fatalf("message %q, want the rotation noted", m.message)
+	}
+	waitBuilt()
+	if got, want := pane.FilteredSource().LineCount(), (len(rotated)+3)/7; got != want {
+		t.Fatalf("%d matches after the rotation, want %d", got, want)
+	}
+}
+
+// TestClosingSplitStopsFilter closes a split that shares its file with the
+// pane left open while the split's filter rebuilds in the background: the
+// rebuild stops, rather than reading on from a file the other pane may
+// close.
+func TestClosingSplitStopsFilter(t *testing.T) {
+	content := make([]string, 60000)
+	for i := range content {
+		content[i] = fmt.Sprintf("10:00:00 INFO request %d user=%d", i, i%7)
+	}
+	m := newTabModel(t, content...)
+	defer m.Close()
+	pane := m.currentPane()
+	if err := pane.Source().WaitIndexed(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	pane.SyncIndex()
+	m.tab().splitHorizontal()
+	m.tab().setActivePane(1)
+	split := m.currentPane()
+	filter := split.FilteredSource()
+	filter.SetAsyncLines(1000)
+	matcher, _ := source.NewMatcher("user=3", source.MatchOptions{})
+	filter.SetTextFilter(matcher)
+	filter.LineCount()
+
+	m.tab().closeCurrentPane()
+	if len(m.tab().panes) != 1 || filter.Building() {
+		t.Fatal("closing the split should stop its filter")
+	}
+}
+
+// TestReadingDuringBackgroundFilter reads lines, edits the rules and grows
+// the file while a rebuild runs in the background over a copy of the rules
+// (go test -race checks the two don't share anything unguarded), and ends up
+// where filtering in the foreground does.
+func TestReadingDuringBackgroundFilter(t *testing.T) {
+	const lines = 60000
+	content := make([]string, lines)
+	for i := range content {
+		content[i] = fmt.Sprintf("10:00:00 INFO request %d user=%d", i, i%7)
+	}
+	m := newTabModel(t, content...)
+	defer m.Close()
+	pane := m.currentPane()
+	if err := pane.Source().WaitIndexed(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	pane.SyncIndex()
+	filter := pane.FilteredSource()
+	filter.SetAsyncLines(1000)
+
+	include, _ := source.NewMatcher("user=[35]", source.MatchOptions{Regex: true})
+	exclude, _ := source.NewMatcher("request 1", source.MatchOptions{})
+	filter.AddRule(source.Rule{Matcher: include, Enabled: true})
+	filter.AddRule(source.Rule{Matcher: exclude, Exclude: true, Enabled: true})
+	for round := 0; filter.Building() || round < 2; round++ {
+		if round < 2 {
+			filter.ToggleRule(1)
+			f, err := os.OpenFile(pane.Source().Path(), os.O_APPEND|os.O_WRONLY, 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			fmt.Fprintf(f, "10:00:01 INFO later user=3\n")
+			f.Close()
+			m.checkForNewLines(pane)
+		}
+		for i := 0; i < 50; i++ {
+			if n := filter.LineCount(); n > 0 {
+				if _, err := filter.GetLine(i % n); err != nil {
+					t.Fatal(err)
+				}
+			}
+			pane.Source().LineCount()
+			pane.Source().GetLine(i * 997)
+		}
+		m.View()
+		if round > 10000 {
+			t.Fatal("filter never finished rebuilding")
+		}
+	}
+
+	want := filter.LineCount()
+	filter.SetAsyncLines(0)
+	filter.MarkDirty()
+	if got := filter.LineCount(); got != want {
+		t.Fatalf("in the foreground %d matches, in the background %d", got, want)
+	}
+}
@@ -0,0 +1,129 @@
+package ui
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// Large files are indexed in the background (see index.StartLineIndex). While
+// that runs the UI ticks quickly to pick up new lines, and commands that need
+// the whole file (G, time navigation) wait behind a cancelable spinner.
+
+// indexTickMsg is sent periodically while any pane's file is still indexing
+type indexTickMsg time.Time
+
+// indexWaitDoneMsg reports that a wait started by whenIndexed has ended; err
+// is non-nil if it was cancelled. gen ties it to the wait that started it.
+type indexWaitDoneMsg struct {
+	gen int
+	err error
+}
+
+// indexTickCmd returns a command that sends an index tick after a short delay
+func (m *Model) indexTickCmd() tea.Cmd {
+	return tea.Tick(100*time.Millisecond, func(t time.Time) tea.Msg {
+		return indexTickMsg(t)
+	})
+}
+
+// anyIndexing reports whether any pane in any tab is still being indexed
+func (m *Model) anyIndexing() bool {
+	for _, t := range m.tabs {
+		for _, p := range t.panes {
+			if p.source.Indexing() {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// startIndexTick starts the index tick if something is indexing and the tick
+// isn't already running
+func (m *Model) startIndexTick() tea.Cmd {
+	if m.indexTicking || !m.anyIndexing() {
+		return nil
+	}
+	m.indexTicking = true
+	return m.indexTickCmd()
+}
+
+// handleIndexTick lets every pane pick up newly indexed lines, and keeps
+// ticking until all indexing is finished
+func (m *Model) handleIndexTick() tea.Cmd {
+	for _, t := range m.tabs {
+		for _, p := range t.panes {
+			p.SyncIndex()
+		}
+	}
+	if m.anyIndexing() {
+		return m.indexTickCmd()
+	}
+	m.indexTicking = false
+	return nil
+}
+
+// whenIndexed runs action straight away if the current pane's file is fully
+// indexed. Otherwise it shows a spinner until indexing finishes and runs
+// action then; esc gives up waiting (indexing carries on).
+func (m *Model) whenIndexed(action func()) tea.Cmd {
+	pane := m.currentPane()
+	if !pane.Source().Indexing() {
+		action()
+		return nil
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	m.waitGen++
+	gen := m.waitGen
+	m.mode = ModeIndexWait
+	m.waitCancel = cancel
+	m.waitAction = func() {
+		pane.SyncIndex()
+		action()
+	}
+
+	src := pane.Source()
+	wait := func() tea.Msg {
+		return indexWaitDoneMsg{gen: gen, err: src.WaitIndexed(ctx)}
+	}
+	return tea.Batch(m.spinner.Tick, wait)
+}
+
+// handleIndexWaitKey handles keys while waiting on the indexer: esc cancels
+func (m *Model) handleIndexWaitKey(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+	switch msg.String() {
+	case "esc", "ctrl+c", "q":
+		m.endIndexWait()
+		m.message = "cancelled"
+	}
+	return m, nil
+}
+
+// handleIndexWaitDone runs the pending action once indexing has finished
+func (m *Model) handleIndexWaitDone(msg indexWaitDoneMsg) tea.Cmd {
+	if m.mode != ModeIndexWait || msg.gen != m.waitGen {
+		return nil // cancelled, or superseded by a later wait
+	}
+	action := m.waitAction
+	m.endIndexWait()
+	if msg.err != nil {
+		m.message = fmt.Sprintf("indexing failed: %v", msg.err)
+		return nil
+	}
+	action()
+	return nil
+}
+
+// endIndexWait leaves the wait mode and releases the waiting goroutine
+func (m *Model) endIndexWait() {
+	if m.waitCancel != nil {
+		m.waitCancel()
+	}
+	m.waitCancel = nil
+	m.waitAction = nil
+	m.mode = ModeNormal
+}
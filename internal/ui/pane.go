@@ -1,6 +1,7 @@
 package ui
 
 import (
+	"bytes"
 	"crypto/md5"
 	"fmt"
 	"os"
@@ -8,7 +9,10 @@ import (
 	"strings"
 	"time"
 
+	"github.com/TimelordUK/mless/internal/command"
 	"github.com/TimelordUK/mless/internal/config"
+	"github.com/TimelordUK/mless/internal/index"
+	mlessio "github.com/TimelordUK/mless/internal/io"
 	"github.com/TimelordUK/mless/internal/render"
 	"github.com/TimelordUK/mless/internal/slice"
 	"github.com/TimelordUK/mless/internal/source"
@@ -32,6 +36,13 @@ type Pane struct {
 	// Follow mode
 	following bool
 
+	// Lines seen at the last SyncIndex, to notice background indexing progress
+	indexedLines int
+
+	// Command-backed pane: the process whose output this pane shows (nil for
+	// ordinary files). Killed when the pane closes.
+	runner *command.Runner
+
 	// Slice state
 	slicer     *slice.Slicer
 	sliceStack []*slice.Info
@@ -44,13 +55,40 @@ type Pane struct {
 	expanded map[int]bool
 
 	// Search state
-	searchTerm    string
+	search        *source.Matcher // nil if no search
 	searchResults []int
 	searchIndex   int
 
 	// Filter state
 	filterTerm string
 
+	// Time window as typed (for the status bar), and for :last its length,
+	// which the window slides to keep as lines arrive (0 if not :last)
+	sinceText, untilText string
+	lastWindow           time.Duration
+	lastText             string
+
+	// Peek (see StartPeek): the filtered view's top line and highlighted
+	// line to go back to, while the viewport shows the whole file
+	peeking       bool
+	peekTop       int
+	peekHighlight int
+
+	// A jump made while the filter was being rebuilt in the background, to
+	// follow up as more matches are found (see GotoOriginal)
+	settling *pendingJump
+
+	// JSON log lines shown formatted rather than as written
+	jsonView bool
+
+	// [[formats]] profile picked from the file's first lines (nil if none fits)
+	profile *logformat.Profile
+
+	// Date of the file's first line, which timestamps without a date are
+	// dated from, and where it came from (see detectFormat)
+	baseDate time.Time
+	dateFrom string
+
 	// Visual selection (original line indices, -1 means no selection)
 	visualAnchor int // Starting line of visual selection (original index)
 
@@ -59,6 +97,21 @@ type Pane struct {
 	cursorOffset int
 }
 
+// pendingJump is where GotoOriginal was asked to go, and the top line it
+// has moved the view to so far
+type pendingJump struct {
+	line      int
+	at        int
+	highlight bool
+}
+
+// headSampleLines and headSampleBytes bound how much of the start of a file
+// is looked at to pick its format profile and the date of its first line
+const (
+	headSampleLines = 200
+	headSampleBytes = 256 << 10
+)
+
 // NewPane creates a new pane for a file
 func NewPane(filePath string, cfg *config.Config, cacheFile bool) (*Pane, error) {
 	var actualPath string
@@ -85,7 +138,8 @@ func NewPane(filePath string, cfg *config.Config, cacheFile bool) (*Pane, error)
 		actualPath = filePath
 	}
 
-	src, err := source.NewFileSource(actualPath)
+	profile, baseDate, dateFrom := detectFormat(cfg, actualPath, filePath)
+	src, err := source.NewFileSourceWithOptions(actualPath, indexOptions(cfg, profile, baseDate))
 	if err != nil {
 		// Clean up cache file if we created one
 		if cachePath != "" {
@@ -94,24 +148,17 @@ func NewPane(filePath string, cfg *config.Config, cacheFile bool) (*Pane, error)
 		return nil, err
 	}
 
+	src.SetRecords(cfg.Display.Records)
+
 	// Set up level detector and filtered provider
-	detector := logformat.NewLevelDetector(&cfg.LogLevels)
+	detector := newLevelDetector(cfg, profile)
 	filtered := source.NewFilteredProvider(src, detector.Detect)
 
 	viewport := view.NewViewport(80, 24)
 	viewport.SetProvider(filtered)
 	viewport.SetShowLineNumbers(cfg.Display.ShowLineNumbers)
 
-	// Set up renderer based on file type
-	var renderer render.Renderer
-	if render.IsSyntaxHighlightable(filePath) {
-		renderer = render.NewSyntaxRenderer(filePath)
-	} else {
-		renderer = render.NewLogLevelRenderer(cfg)
-	}
-	viewport.SetRenderer(renderer)
-
-	return &Pane{
+	pane := &Pane{
 		viewport:       viewport,
 		source:         src,
 		filteredSource: filtered,
@@ -124,7 +171,59 @@ func NewPane(filePath string, cfg *config.Config, cacheFile bool) (*Pane, error)
 		marks:          make(map[rune]int),
 		expanded:       make(map[int]bool),
 		visualAnchor:   -1, // No selection
-	}, nil
+		jsonView:       cfg.JSON.Formatted && !render.IsSyntaxHighlightable(filePath),
+		profile:        profile,
+		baseDate:       baseDate,
+		dateFrom:       dateFrom,
+	}
+
+	// Set up renderer based on file type
+	viewport.SetRenderer(pane.renderer())
+	return pane, nil
+}
+
+// NewCommandPane runs command (through the shell) and opens a pane that follows
+// its output. stderr lines are tagged with Source.Path "stderr". With restart
+// set the command is run again whenever it exits.
+func NewCommandPane(cmdline string, restart bool, cfg *config.Config) (*Pane, error) {
+	runner, err := command.NewRunner(cmdline, restart)
+	if err != nil {
+		return nil, err
+	}
+	runner.Start()
+
+	pane, err := NewPane(runner.OutputPath(), cfg, false)
+	if err != nil {
+		runner.Close()
+		return nil, err
+	}
+	pane.runner = runner
+	pane.filename = cmdline
+	pane.source.SetLineSource(runner.LineSource)
+	pane.SetFollowing(true)
+	return pane, nil
+}
+
+// SyncIndex picks up lines the background indexer has added since the last
+// call, rebuilding filters to include them. Returns whether indexing continues.
+func (p *Pane) SyncIndex() bool {
+	if count := p.source.LineCount(); count != p.indexedLines {
+		if count > p.indexedLines {
+			p.filteredSource.MarkGrown()
+		} else {
+			p.filteredSource.MarkDirty()
+		}
+		p.indexedLines = count
+		if p.following {
+			p.viewport.GotoBottom()
+		}
+	}
+	return p.source.Indexing()
+}
+
+// Runner returns the process behind a command-backed pane, or nil
+func (p *Pane) Runner() *command.Runner {
+	return p.runner
 }
 
 // SetSize sets the viewport size
@@ -164,6 +263,7 @@ func (p *Pane) Render() string {
 func (p *Pane) Close() error {
 	var err error
 	if p.source != nil {
+		p.filteredSource.Stop()
 		err = p.source.Close()
 	}
 
@@ -172,6 +272,11 @@ func (p *Pane) Close() error {
 		os.Remove(p.cachePath)
 	}
 
+	// Kill the command and remove its output file
+	if p.runner != nil {
+		p.runner.Close()
+	}
+
 	return err
 }
 
@@ -217,13 +322,30 @@ func (p *Pane) HasExpanded() bool {
 func (p *Pane) ToggleWrap() bool {
 	wrapping := p.viewport.ToggleWrap()
 	if hl := p.viewport.HighlightedLine(); hl >= 0 {
-		if fi := p.filteredSource.FilteredIndexFor(hl); fi >= 0 {
-			p.viewport.GotoLine(fi)
-		}
+		p.GotoOriginal(hl, false)
 	}
 	return wrapping
 }
 
+// ToggleJSONView switches JSON log lines between the raw object and the
+// formatted "time LEVEL msg key=val" view. Returns whether it is formatted.
+func (p *Pane) ToggleJSONView() bool {
+	p.jsonView = !p.jsonView
+	p.viewport.SetRenderer(p.renderer())
+	return p.jsonView
+}
+
+// renderer returns the renderer for the pane's file type and view
+func (p *Pane) renderer() render.Renderer {
+	switch {
+	case p.jsonView:
+		return render.NewJSONRenderer(p.config)
+	case render.IsSyntaxHighlightable(p.sourcePath):
+		return render.NewSyntaxRenderer(p.sourcePath)
+	}
+	return render.NewLogfmtRenderer(p.config)
+}
+
 // Source returns the pane's file source
 func (p *Pane) Source() *source.FileSource {
 	return p.source
@@ -257,7 +379,15 @@ func (p *Pane) ToggleFollowing() bool {
 
 // SearchTerm returns the current search term
 func (p *Pane) SearchTerm() string {
-	return p.searchTerm
+	if p.search == nil {
+		return ""
+	}
+	return p.search.Term()
+}
+
+// Search returns the current search (nil if none)
+func (p *Pane) Search() *source.Matcher {
+	return p.search
 }
 
 // SearchResults returns the search results
@@ -288,25 +418,20 @@ func (p *Pane) IsCached() bool {
 	return p.isCached
 }
 
-// PerformSearch executes a search
-func (p *Pane) PerformSearch(term string) {
-	p.searchTerm = term
+// PerformSearch executes a search. An invalid regex leaves the current
+// search as it was and returns the error.
+func (p *Pane) PerformSearch(term string, opts source.MatchOptions) error {
 	if term == "" {
+		p.search = nil
 		p.searchResults = nil
-		return
+		return nil
 	}
-
-	// Simple search - find all lines containing the term
-	p.searchResults = nil
-	for i := 0; i < p.source.LineCount(); i++ {
-		line, err := p.source.GetLine(i)
-		if err != nil {
-			continue
-		}
-		if strings.Contains(string(line.Content), term) {
-			p.searchResults = append(p.searchResults, i)
-		}
+	search, err := source.NewMatcher(term, opts)
+	if err != nil {
+		return err
 	}
+	p.search = search
+	p.searchResults = p.findMatches(search)
 
 	// Jump to first result
 	if len(p.searchResults) > 0 {
@@ -316,6 +441,27 @@ func (p *Pane) PerformSearch(term string) {
 	} else {
 		p.viewport.ClearHighlight()
 	}
+	return nil
+}
+
+// findMatches finds all lines search matches. With records on, only the
+// first match in each record counts, so n/N step a record at a time.
+func (p *Pane) findMatches(search *source.Matcher) []int {
+	var matches []int
+	for i := 0; i < p.source.LineCount(); i++ {
+		line, err := p.source.GetLine(i)
+		if err != nil {
+			continue
+		}
+		if search.Match(line.Content) {
+			matches = append(matches, i)
+			if p.source.RecordsEnabled() {
+				_, end := p.source.RecordBounds(i)
+				i = end - 1
+			}
+		}
+	}
+	return matches
 }
 
 // NextSearchResult jumps to next search result
@@ -343,7 +489,7 @@ func (p *Pane) PrevSearchResult() {
 
 // ClearSearch clears search state
 func (p *Pane) ClearSearch() {
-	p.searchTerm = ""
+	p.search = nil
 	p.searchResults = nil
 	p.searchIndex = 0
 	p.viewport.ClearHighlight()
@@ -365,15 +511,47 @@ func (p *Pane) JumpToMark(char rune) bool {
 		return false
 	}
 
+	p.GotoOriginal(originalLine, true)
+	return true
+}
+
+// GotoOriginal scrolls to an original line, or the nearest one after it still
+// shown, highlighting it if highlight; false if nothing is shown. While the
+// filter is rebuilt in the background that's the nearest match found so far,
+// so Settle moves on to a better one as they're found.
+func (p *Pane) GotoOriginal(originalLine int, highlight bool) bool {
+	p.settling = nil
 	filteredIndex := p.filteredSource.FilteredIndexFor(originalLine)
 	if filteredIndex >= 0 {
 		p.viewport.GotoLine(filteredIndex)
-		actualOriginal := p.filteredSource.OriginalLineNumber(filteredIndex)
-		if actualOriginal >= 0 {
-			p.viewport.SetHighlightedLine(actualOriginal)
+		if highlight {
+			if actualOriginal := p.filteredSource.OriginalLineNumber(filteredIndex); actualOriginal >= 0 {
+				p.viewport.SetHighlightedLine(actualOriginal)
+			}
 		}
 	}
-	return true
+	if p.filteredSource.Building() {
+		if filteredIndex < 0 {
+			p.viewport.GotoTop()
+		}
+		p.settling = &pendingJump{line: originalLine, at: p.viewport.CurrentLine(), highlight: highlight}
+	}
+	return filteredIndex >= 0
+}
+
+// Settle follows up a GotoOriginal made while the filter was being rebuilt,
+// going to the line it was after as the matches before it are found. It gives
+// up if the view has been moved since.
+func (p *Pane) Settle() {
+	jump := p.settling
+	if jump == nil {
+		return
+	}
+	if p.viewport.CurrentLine() != jump.at {
+		p.settling = nil
+		return
+	}
+	p.GotoOriginal(jump.line, jump.highlight)
 }
 
 // ClearMarks clears all marks
@@ -410,14 +588,7 @@ func (p *Pane) NextMark() {
 	}
 
 	if nextLine >= 0 {
-		filteredIndex := p.filteredSource.FilteredIndexFor(nextLine)
-		if filteredIndex >= 0 {
-			p.viewport.GotoLine(filteredIndex)
-			actualOriginal := p.filteredSource.OriginalLineNumber(filteredIndex)
-			if actualOriginal >= 0 {
-				p.viewport.SetHighlightedLine(actualOriginal)
-			}
-		}
+		p.GotoOriginal(nextLine, true)
 	}
 }
 
@@ -449,29 +620,53 @@ func (p *Pane) PrevMark() {
 	}
 
 	if prevLine >= 0 {
-		filteredIndex := p.filteredSource.FilteredIndexFor(prevLine)
-		if filteredIndex >= 0 {
-			p.viewport.GotoLine(filteredIndex)
-			actualOriginal := p.filteredSource.OriginalLineNumber(filteredIndex)
-			if actualOriginal >= 0 {
-				p.viewport.SetHighlightedLine(actualOriginal)
-			}
-		}
+		p.GotoOriginal(prevLine, true)
 	}
 }
 
-// CheckForNewLines checks if file has grown and updates view
-func (p *Pane) CheckForNewLines() error {
-	newLines, err := p.source.Refresh()
+// CheckForNewLines checks if the file has grown or rotated and updates view.
+// Returns true if the file was rotated (and re-indexed from scratch).
+func (p *Pane) CheckForNewLines() (bool, error) {
+	result, err := p.refresh()
+	return result.Rotated, err
+}
+
+// refresh refreshes the pane's source, takes in what changed and goes to
+// the bottom, and returns what changed for other panes showing the source
+func (p *Pane) refresh() (source.RefreshResult, error) {
+	// A background filter rebuild may be reading the source meanwhile: it
+	// takes in new lines once it's done, and starts over after a rotation
+	result, err := p.source.Refresh()
 	if err != nil {
-		return err
+		return source.RefreshResult{}, err
 	}
+	p.takeRefresh(result, true)
+	return result, nil
+}
 
-	if newLines > 0 {
+// takeRefresh brings the pane up to date with what a refresh of its source
+// found, by this pane or another sharing the source, going to the bottom
+// for new lines if follow
+func (p *Pane) takeRefresh(result source.RefreshResult, follow bool) {
+	if result.Rotated {
+		// Search hits and expansions are line numbers into the old content
+		p.ClearSearch()
+		p.ClearExpanded()
 		p.filteredSource.MarkDirty()
-		p.viewport.GotoBottom()
+	} else if result.Grown {
+		// Only the new lines (and the last old one, if it was finished)
+		// need filtering
+		p.filteredSource.MarkGrown()
+	}
+
+	if result.NewLines > 0 || result.Rotated {
+		if p.lastWindow > 0 {
+			p.slideWindow()
+		}
+		if follow && !p.peeking {
+			p.viewport.GotoBottom()
+		}
 	}
-	return nil
 }
 
 // ResyncFromSource re-copies the source file to cache and reloads
@@ -481,6 +676,7 @@ func (p *Pane) ResyncFromSource() error {
 	}
 
 	// Close current source
+	p.filteredSource.Stop()
 	p.source.Close()
 
 	// Re-copy from source
@@ -489,16 +685,17 @@ func (p *Pane) ResyncFromSource() error {
 	}
 
 	// Reopen the cached file
-	src, err := source.NewFileSource(p.cachePath)
+	src, err := source.NewFileSourceWithOptions(p.cachePath, indexOptions(p.config, p.profile, p.baseDate))
 	if err != nil {
 		return err
 	}
 
 	// Update the source
+	src.SetRecords(p.source.RecordsEnabled())
 	p.source = src
 
 	// Recreate filtered provider
-	detector := logformat.NewLevelDetector(&p.config.LogLevels)
+	detector := newLevelDetector(p.config, p.profile)
 	p.filteredSource = source.NewFilteredProvider(src, detector.Detect)
 	p.viewport.SetProvider(p.filteredSource)
 
@@ -659,12 +856,19 @@ func (p *Pane) SliceFromCurrent() error {
 	return p.PerformSlice(originalLine, p.source.LineCount())
 }
 
-// PerformSlice executes a slice operation and switches to the sliced file
+// PerformSlice executes a slice operation and switches to the sliced file.
+// With records on, the range grows to whole records at both ends.
 func (p *Pane) PerformSlice(start, end int) error {
+	if end > start {
+		start, end = p.RecordRange(start, end-1)
+		end++
+	}
+
 	info, cachePath, err := p.slicer.SliceRange(p.source, start, end)
 	if err != nil {
 		return err
 	}
+	info.BaseDate = p.sliceBaseDate(start, end)
 
 	// Track parent slice info
 	if len(p.sliceStack) > 0 {
@@ -673,21 +877,23 @@ func (p *Pane) PerformSlice(start, end int) error {
 	p.sliceStack = append(p.sliceStack, info)
 
 	// Close current source
+	p.filteredSource.Stop()
 	p.source.Close()
 
 	// Open sliced file
-	src, err := source.NewFileSource(cachePath)
+	src, err := source.NewFileSourceWithOptions(cachePath, indexOptions(p.config, p.profile, info.BaseDate))
 	if err != nil {
 		p.sliceStack = p.sliceStack[:len(p.sliceStack)-1]
 		return err
 	}
 
 	// Update source
+	src.SetRecords(p.source.RecordsEnabled())
 	p.source = src
 	p.isCached = true
 
 	// Recreate filtered provider
-	detector := logformat.NewLevelDetector(&p.config.LogLevels)
+	detector := newLevelDetector(p.config, p.profile)
 	p.filteredSource = source.NewFilteredProvider(src, detector.Detect)
 	p.viewport.SetProvider(p.filteredSource)
 
@@ -701,6 +907,29 @@ func (p *Pane) PerformSlice(start, end int) error {
 	return nil
 }
 
+// BaseDate returns the date the pane's time-only and year-less timestamps are
+// dated from, and where it came from
+func (p *Pane) BaseDate() (time.Time, string) {
+	if len(p.sliceStack) > 0 {
+		return p.sliceStack[len(p.sliceStack)-1].BaseDate, "slice start"
+	}
+	return p.baseDate, p.dateFrom
+}
+
+// sliceBaseDate returns the date of the first timestamp in lines [start,
+// end) of the current source, which the slice taking them is dated from
+// (the current base date if none is found near the start)
+func (p *Pane) sliceBaseDate(start, end int) time.Time {
+	base, _ := p.BaseDate()
+	for i := start; i < end && i < start+headSampleLines; i++ {
+		if ts := p.source.GetTimestamp(i); ts != nil {
+			year, month, day := ts.Date()
+			return time.Date(year, month, day, 0, 0, 0, 0, time.Local)
+		}
+	}
+	return base
+}
+
 // RevertSlice returns to the parent file/slice
 func (p *Pane) RevertSlice() error {
 	if len(p.sliceStack) == 0 {
@@ -715,28 +944,35 @@ func (p *Pane) RevertSlice() error {
 	p.slicer.Cleanup(current)
 
 	// Close current source
+	p.filteredSource.Stop()
 	p.source.Close()
 
 	// Determine which file to open
 	var pathToOpen string
+	baseDate := p.baseDate
 	if len(p.sliceStack) > 0 {
 		pathToOpen = p.sliceStack[len(p.sliceStack)-1].CachePath
+		baseDate = p.sliceStack[len(p.sliceStack)-1].BaseDate
 	} else {
 		pathToOpen = p.sourcePath
 		p.isCached = false
 	}
 
 	// Open the file
-	src, err := source.NewFileSource(pathToOpen)
+	src, err := source.NewFileSourceWithOptions(pathToOpen, indexOptions(p.config, p.profile, baseDate))
 	if err != nil {
 		return err
 	}
 
 	// Update source
+	src.SetRecords(p.source.RecordsEnabled())
 	p.source = src
+	if len(p.sliceStack) == 0 && p.runner != nil {
+		src.SetLineSource(p.runner.LineSource)
+	}
 
 	// Recreate filtered provider
-	detector := logformat.NewLevelDetector(&p.config.LogLevels)
+	detector := newLevelDetector(p.config, p.profile)
 	p.filteredSource = source.NewFilteredProvider(src, detector.Detect)
 	p.viewport.SetProvider(p.filteredSource)
 
@@ -749,6 +985,33 @@ func (p *Pane) RevertSlice() error {
 	return nil
 }
 
+// Records reports whether the pane groups lines into multi-line records
+func (p *Pane) Records() bool {
+	return p.source.RecordsEnabled()
+}
+
+// SetRecords turns record grouping on or off for the pane's source, and
+// redoes the filter and search, which work on whole records while it is on
+func (p *Pane) SetRecords(on bool) {
+	p.source.SetRecords(on)
+	p.filteredSource.MarkDirty()
+	if p.search != nil {
+		p.searchResults = p.findMatches(p.search)
+		p.searchIndex = 0
+	}
+}
+
+// RecordRange widens the original line range [start, end] to the whole
+// records at each end. Without records it is returned unchanged.
+func (p *Pane) RecordRange(start, end int) (int, int) {
+	if !p.source.RecordsEnabled() || start < 0 || end < 0 {
+		return start, end
+	}
+	start, _ = p.source.RecordBounds(start)
+	_, last := p.source.RecordBounds(end)
+	return start, last - 1
+}
+
 // GotoTimeResult holds the result of a time navigation
 type GotoTimeResult struct {
 	Target   *time.Time // The resolved target time
@@ -772,15 +1035,155 @@ func (p *Pane) GotoTime(timeStr string) GotoTimeResult {
 	// Get the actual timestamp we landed on
 	actualTs := p.source.GetTimestamp(originalLine)
 
-	filteredIndex := p.filteredSource.FilteredIndexFor(originalLine)
-	if filteredIndex >= 0 {
-		p.viewport.GotoLine(filteredIndex)
-		actualOriginal := p.filteredSource.OriginalLineNumber(filteredIndex)
-		if actualOriginal >= 0 {
-			p.viewport.SetHighlightedLine(actualOriginal)
+	p.GotoOriginal(originalLine, true)
+	return GotoTimeResult{target, actualTs, true}
+}
+
+// SetSince shows only lines from the first at or after input (a time as
+// for GotoTime); "" removes the bound
+func (p *Pane) SetSince(input string) error {
+	since, err := p.windowBound(input)
+	if err != nil {
+		return err
+	}
+	_, until := p.filteredSource.GetTimeWindow()
+	p.filteredSource.SetTimeWindow(since, until)
+	p.sinceText = strings.TrimSpace(input)
+	p.lastWindow, p.lastText = 0, ""
+	return nil
+}
+
+// SetUntil shows only lines before the first at or after input; "" removes
+// the bound
+func (p *Pane) SetUntil(input string) error {
+	until, err := p.windowBound(input)
+	if err != nil {
+		return err
+	}
+	since, _ := p.filteredSource.GetTimeWindow()
+	if p.lastWindow > 0 {
+		// A :last window slides; a fixed end replaces it
+		since = nil
+		p.lastWindow, p.lastText = 0, ""
+	}
+	p.filteredSource.SetTimeWindow(since, until)
+	p.untilText = strings.TrimSpace(input)
+	return nil
+}
+
+// SetLast shows only the last input (a duration like "15m") of the file,
+// going back from its newest timestamp. The window slides as lines arrive,
+// so in follow mode it always covers the latest stretch. "" removes it.
+func (p *Pane) SetLast(input string) error {
+	input = strings.TrimSpace(input)
+	if input == "" {
+		p.ClearTimeWindow()
+		return nil
+	}
+	d, err := time.ParseDuration(input)
+	if err != nil || d <= 0 {
+		return fmt.Errorf("can't read %q as a duration (like 30s, 15m, 2h)", input)
+	}
+	if p.newestTimestamp() == nil {
+		return fmt.Errorf("no timestamps to measure from")
+	}
+	p.lastWindow, p.lastText = d, input
+	p.sinceText, p.untilText = "", ""
+	p.slideWindow()
+	return nil
+}
+
+// ClearTimeWindow removes the time window
+func (p *Pane) ClearTimeWindow() {
+	p.filteredSource.SetTimeWindow(nil, nil)
+	p.sinceText, p.untilText = "", ""
+	p.lastWindow, p.lastText = 0, ""
+}
+
+// TimeWindow describes the time window for the status bar ("" if none)
+func (p *Pane) TimeWindow() string {
+	switch {
+	case p.lastWindow > 0:
+		return "last " + p.lastText
+	case p.sinceText != "" && p.untilText != "":
+		return p.sinceText + "–" + p.untilText
+	case p.sinceText != "":
+		return "since " + p.sinceText
+	case p.untilText != "":
+		return "until " + p.untilText
+	}
+	return ""
+}
+
+// windowBound parses a :since or :until time, nil for ""
+func (p *Pane) windowBound(input string) (*time.Time, error) {
+	input = strings.TrimSpace(input)
+	if input == "" {
+		return nil, nil
+	}
+	t := p.parseTimeInput(input)
+	if t == nil {
+		return nil, fmt.Errorf("can't read %q as a time (HH:MM[:SS] or YYYY-MM-DD HH:MM[:SS])", input)
+	}
+	return t, nil
+}
+
+// slideWindow moves the :last window to end at the newest timestamp. That's
+// the file's clock rather than the wall clock, which a log's timestamps
+// (often without a zone) needn't agree with.
+func (p *Pane) slideWindow() {
+	newest := p.newestTimestamp()
+	if newest == nil {
+		// Nothing to measure from (the file was rotated, say): drop it
+		p.ClearTimeWindow()
+		return
+	}
+	since := newest.Add(-p.lastWindow)
+	p.filteredSource.SetTimeWindow(&since, nil)
+}
+
+// newestTimestamp returns the timestamp of the last line that has one,
+// looking back a bounded way from the end
+func (p *Pane) newestTimestamp() *time.Time {
+	const maxLookback = 10000
+	total := p.source.LineCount()
+	for i := total - 1; i >= 0 && i >= total-maxLookback; i-- {
+		if ts := p.source.GetTimestamp(i); ts != nil {
+			return ts
 		}
 	}
-	return GotoTimeResult{target, actualTs, true}
+	return nil
+}
+
+// StartPeek shows the whole file around the current line, highlighted,
+// until EndPeek goes back to the filtered view. It does nothing if the view
+// isn't filtered.
+func (p *Pane) StartPeek() bool {
+	if p.peeking || !p.filteredSource.IsFiltered() {
+		return false
+	}
+	line := p.filteredSource.OriginalLineNumber(p.viewport.CurrentLine())
+	if line < 0 {
+		return false
+	}
+	p.peeking = true
+	p.peekTop = p.viewport.CurrentLine()
+	p.peekHighlight = p.viewport.HighlightedLine()
+	p.viewport.SetProvider(p.source)
+	p.viewport.GotoLine(max(line-p.viewport.Height()/2, 0))
+	p.viewport.SetHighlightedLine(line)
+	return true
+}
+
+// EndPeek goes back to the filtered view, where it was before StartPeek
+func (p *Pane) EndPeek() {
+	if !p.peeking {
+		return
+	}
+	p.peeking = false
+	p.viewport.SetProvider(p.filteredSource)
+	p.viewport.GotoLine(p.peekTop)
+	p.viewport.SetHighlightedLine(p.peekHighlight)
 }
 
 // FilterTerm returns the current filter term
@@ -793,6 +1196,111 @@ func (p *Pane) SetFilterTerm(term string) {
 	p.filterTerm = term
 }
 
+// SetWhere filters the pane to structured lines by a field (a JSON or logfmt
+// field, or a group of the pane's format profile): "key=value", "key!=value"
+// (every line key=value doesn't match), or "key" for lines that have the
+// field. Values compare case-insensitively; an empty condition removes the
+// filter.
+func (p *Pane) SetWhere(cond string) error {
+	cond = strings.TrimSpace(cond)
+	if cond == "" {
+		p.filteredSource.SetFieldFilter("", nil)
+		return nil
+	}
+
+	key, value, op := cond, "", ""
+	if i := strings.Index(cond, "!="); i >= 0 {
+		key, value, op = cond[:i], cond[i+2:], "!="
+	} else if i := strings.IndexByte(cond, '='); i >= 0 {
+		key, value, op = cond[:i], cond[i+1:], "="
+	}
+	key = strings.TrimSpace(key)
+	value = strings.TrimSpace(value)
+	if len(value) >= 2 && value[0] == '"' && value[len(value)-1] == '"' {
+		value = value[1 : len(value)-1]
+	}
+	if key == "" || strings.ContainsAny(key, " \t\"") {
+		return fmt.Errorf("usage: where key[=value|!=value]")
+	}
+
+	profile := p.profile
+	p.filteredSource.SetFieldFilter(cond, func(content []byte) bool {
+		v, ok := logformat.FieldValue(content, key)
+		if profile != nil && !ok {
+			v, ok = profile.Field(content, key)
+		}
+		switch op {
+		case "=":
+			return ok && strings.EqualFold(v, value)
+		case "!=":
+			return !ok || !strings.EqualFold(v, value)
+		}
+		return ok
+	})
+	return nil
+}
+
+// SetExpr filters the pane by a filter expression (see source.ParseExpr),
+// e.g. "level>=warn && latency>200ms"; "" removes it. An expression that
+// doesn't parse leaves the filter as it was and returns a
+// *source.ExprError.
+func (p *Pane) SetExpr(src string) error {
+	if strings.TrimSpace(src) == "" {
+		p.filteredSource.SetExprFilter("", nil)
+		return nil
+	}
+	expr, err := source.ParseExpr(src)
+	if err != nil {
+		return err
+	}
+	fields := p.exprFields()
+	usesTime := expr.UsesTime()
+	file := p.source // filters can run in a background rebuild: don't read p there
+	p.filteredSource.SetExprFilter(src, func(line *source.Line) bool {
+		if usesTime && line.Timestamp == nil {
+			line.Timestamp = file.GetTimestamp(line.OriginalIndex)
+		}
+		return expr.Match(line, fields)
+	})
+	return nil
+}
+
+// exprFields gives filter expressions the fields of a line: message and
+// logger (or msg) from a JSON or logfmt line's configured keys, then any
+// JSON or logfmt key, then the groups of the pane's format profile
+func (p *Pane) exprFields() source.FieldFunc {
+	json := logformat.NewJSONFormat(&p.config.JSON)
+	logfmt := logformat.NewLogfmtFormat(&p.config.Logfmt)
+	profile := p.profile
+	return func(content []byte, name string) (string, bool) {
+		if name == "message" || name == "msg" || name == "logger" {
+			rec, ok := json.Parse(content)
+			if !ok {
+				rec, ok = logfmt.Parse(content)
+			}
+			if ok {
+				if name == "logger" && rec.Logger != "" {
+					return rec.Logger, true
+				}
+				if name != "logger" && rec.Message != "" {
+					return rec.Message, true
+				}
+			}
+		}
+		if v, ok := logformat.FieldValue(content, name); ok {
+			return v, true
+		}
+		if profile != nil {
+			group := name
+			if group == "msg" {
+				group = "message"
+			}
+			return profile.Field(content, group)
+		}
+		return "", false
+	}
+}
+
 // StartVisualSelection starts visual selection at current line
 func (p *Pane) StartVisualSelection() {
 	currentFiltered := p.viewport.CurrentLine()
@@ -854,6 +1362,93 @@ func (p *Pane) ResetCursorOffset() {
 	p.cursorOffset = 0
 }
 
+// indexOptions returns how panes build line indexes: through the persistent
+// index cache unless it is disabled, sampling levels with the configured
+// patterns and format profile, and dating timestamps without a date from base
+func indexOptions(cfg *config.Config, profile *logformat.Profile, base time.Time) index.Options {
+	detector := newLevelDetector(cfg, profile)
+	timestamps := newTimestampParser(cfg, profile)
+	timestamps.SetBaseDate(base)
+	profileName := ""
+	if profile != nil {
+		profileName = profile.Name
+	}
+	settings := fmt.Sprintf("%q %q %q %q %q %q %q %q", cfg.LogLevels, cfg.Levels, cfg.JSON.LevelKeys, cfg.JSON.TimeKeys,
+		cfg.Logfmt.LevelKeys, cfg.Logfmt.TimeKeys, cfg.Formats, profileName)
+	opts := index.Options{
+		DetectLevel: detector.Detect,
+		Timestamps:  timestamps,
+		LevelKey:    fmt.Sprintf("%x", md5Sum([]byte(settings))),
+	}
+	if cfg.IndexCache.Enabled {
+		if dir, err := index.DefaultCacheDir(); err == nil {
+			opts.Cache = index.NewCache(dir, int64(cfg.IndexCache.MaxSizeMB)<<20)
+		}
+	}
+	return opts
+}
+
+// newLevelDetector returns a detector for the configured level patterns that
+// reads JSON and logfmt lines' level field, and that of lines matching
+// profile (if not nil)
+func newLevelDetector(cfg *config.Config, profile *logformat.Profile) *logformat.LevelDetector {
+	detector := logformat.NewLevelDetector()
+	detector.SetJSON(logformat.NewJSONFormat(&cfg.JSON))
+	detector.SetLogfmt(logformat.NewLogfmtFormat(&cfg.Logfmt))
+	detector.SetProfile(profile)
+	return detector
+}
+
+// newTimestampParser returns a parser for the built-in timestamp formats that
+// reads JSON and logfmt lines' time field, and that of lines matching profile
+// (if not nil)
+func newTimestampParser(cfg *config.Config, profile *logformat.Profile) *logformat.TimestampParser {
+	timestamps := logformat.NewTimestampParser()
+	timestamps.SetJSON(logformat.NewJSONFormat(&cfg.JSON))
+	timestamps.SetLogfmt(logformat.NewLogfmtFormat(&cfg.Logfmt))
+	timestamps.SetProfile(profile)
+	return timestamps
+}
+
+// detectFormat looks at the first lines of path, the file opened as name, to
+// pick its [[formats]] profile (nil if none is configured or none fits) and
+// the date of its first line, which timestamps without a date are dated
+// from. A --date for the file wins over what the file suggests; from says
+// where the date came from.
+func detectFormat(cfg *config.Config, path, name string) (profile *logformat.Profile, base time.Time, from string) {
+	var lines [][]byte
+	if head, err := mlessio.ReadHead(path, headSampleBytes); err == nil && len(head) > 0 {
+		lines = bytes.Split(bytes.TrimSuffix(head, []byte("\n")), []byte("\n"))
+		if len(head) == headSampleBytes && len(lines) > 1 {
+			lines = lines[:len(lines)-1] // cut short
+		}
+		if len(lines) > headSampleLines {
+			lines = lines[:headSampleLines]
+		}
+	}
+
+	if len(cfg.Formats) > 0 {
+		if profiles, err := logformat.NewProfiles(cfg.Formats); err == nil {
+			profile = logformat.DetectProfile(profiles, lines)
+		}
+	}
+
+	if abs, err := filepath.Abs(name); err == nil {
+		if d, ok := cfg.BaseDates[abs]; ok {
+			return profile, d, "--date"
+		}
+	}
+	if d, ok := cfg.BaseDates[""]; ok {
+		return profile, d, "--date"
+	}
+	var mtime time.Time
+	if info, err := os.Stat(name); err == nil {
+		mtime = info.ModTime()
+	}
+	base, from = newTimestampParser(cfg, profile).InferBaseDate(lines, name, mtime)
+	return profile, base, from
+}
+
 // md5Sum helper for cache file naming
 func md5Sum(data []byte) [16]byte {
 	return md5.Sum(data)
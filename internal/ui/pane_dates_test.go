@@ -0,0 +1,121 @@
+package ui
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/TimelordUK/mless/internal/config"
+)
+
+// writeDatedLog writes lines to a file called name, modified at mtime.
+func writeDatedLog(t *testing.T, name string, lines []string, mtime time.Time) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(strings.Join(lines, "\n")+"\n"), 0o644); err != nil {
+		t.Fatalf("write temp log: %v", err)
+	}
+	if err := os.Chtimes(path, mtime, mtime); err != nil {
+		t.Fatalf("chtimes: %v", err)
+	}
+	return path
+}
+
+// TestInferredDates checks timestamps without a date, or a year, are dated
+// from the file's content, name or modification time, or --date, and move on
+// a day (a year) where the clock wraps.
+func TestInferredDates(t *testing.T) {
+	local := func(y int, mo time.Month, d, h, mi, s int) time.Time {
+		return time.Date(y, mo, d, h, mi, s, 0, time.Local)
+	}
+	overnight := []string{
+		"23:59:58 INFO closing batch",
+		"23:59:59 INFO batch closed",
+		"00:00:01 INFO opening batch",
+	}
+
+	tests := []struct {
+		name     string
+		file     string
+		lines    []string
+		mtime    time.Time
+		override map[string]time.Time
+		from     string
+		want     []time.Time
+	}{
+		{
+			name:  "time only over midnight",
+			file:  "app.log",
+			lines: overnight,
+			mtime: local(2024, 3, 10, 0, 5, 0),
+			from:  "modification time",
+			want:  []time.Time{local(2024, 3, 9, 23, 59, 58), local(2024, 3, 10, 0, 0, 1)},
+		},
+		{
+			name:     "date flag",
+			file:     "app.log",
+			lines:    overnight,
+			mtime:    local(2024, 3, 10, 0, 5, 0),
+			override: map[string]time.Time{"": local(2023, 7, 1, 0, 0, 0)},
+			from:     "--date",
+			want:     []time.Time{local(2023, 7, 1, 23, 59, 58), local(2023, 7, 2, 0, 0, 1)},
+		},
+		{
+			name:  "date in file name",
+			file:  "app-2024-02-01.log",
+			lines: overnight,
+			mtime: local(2024, 3, 10, 0, 5, 0),
+			from:  "file name",
+			want:  []time.Time{local(2024, 2, 1, 23, 59, 58), local(2024, 2, 2, 0, 0, 1)},
+		},
+		{
+			name:  "date in header",
+			file:  "app.log",
+			lines: append([]string{"Log opened 2024-01-15"}, overnight...),
+			mtime: local(2024, 3, 10, 0, 5, 0),
+			from:  "file content",
+			want:  []time.Time{local(2024, 1, 15, 23, 59, 58), local(2024, 1, 16, 0, 0, 1)},
+		},
+		{
+			name: "syslog over new year",
+			file: "messages",
+			lines: []string{
+				"Dec 31 23:59:59 host cron[1]: INFO tick",
+				"Jan 1 00:00:01 host cron[1]: INFO tock",
+			},
+			mtime: local(2025, 1, 1, 0, 5, 0),
+			from:  "modification time",
+			want:  []time.Time{local(2024, 12, 31, 23, 59, 59), local(2025, 1, 1, 0, 0, 1)},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := config.DefaultConfig()
+			cfg.IndexCache.Enabled = false
+			cfg.BaseDates = tt.override
+			pane, err := NewPane(writeDatedLog(t, tt.file, tt.lines, tt.mtime), cfg, false)
+			if err != nil {
+				t.Fatalf("NewPane: %v", err)
+			}
+			defer pane.Close()
+
+			if _, from := pane.BaseDate(); from != tt.from {
+				t.Fatalf("base date from %q, want %q", from, tt.from)
+			}
+			firstTS := pane.Source().GetTimestamp(0)
+			if firstTS == nil { // a header line
+				firstTS = pane.Source().GetTimestamp(1)
+			}
+			lastTS := pane.Source().GetTimestamp(len(tt.lines) - 1)
+			if firstTS == nil || !firstTS.Equal(tt.want[0]) {
+				t.Fatalf("first timestamp: got %v, want %v", firstTS, tt.want[0])
+			}
+			if lastTS == nil || !lastTS.Equal(tt.want[1]) {
+				t.Fatalf("last timestamp: got %v, want %v", lastTS, tt.want[1])
+			}
+		})
+	}
+}
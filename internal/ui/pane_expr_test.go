@@ -0,0 +1,102 @@
+package ui
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	tea "github.com/charmbracelet/bubbletea"
+
+	"github.com/TimelordUK/mless/internal/source"
+)
+
+// TestFilterExpressions covers ?= expressions over the level, time and
+// fields of JSON, logfmt and plain lines.
+func TestFilterExpressions(t *testing.T) {
+	m := newTabModel(t,
+		`{"time":"2024-01-15T10:00:00Z","level":"warn","logger":"Database","msg":"slow query","latency":"250ms"}`,
+		`{"time":"2024-01-15T10:00:01Z","level":"error","logger":"Database","msg":"retry query","latency":"900ms"}`,
+		`{"time":"2024-01-15T10:00:02Z","level":"info","logger":"Database","msg":"query","latency":"1.2s"}`,
+		`time=2024-01-15T10:00:03Z level=error logger=Http msg="bad gateway" latency=300 status=502`,
+		`2024-01-15 10:00:04 ERROR plain text line`,
+	)
+	defer m.Close()
+	pane := m.currentPane()
+
+	for _, c := range []struct {
+		expr string
+		want []int
+	}{
+		{`level>=warn && logger=Database && latency>200ms && !msg~"retry"`, []int{0}},
+		{`level>=error`, []int{1, 3, 4}},
+		{`level=warn || status>=500`, []int{0, 3}},
+		{`latency>=1s`, []int{2}},
+		{`latency>200ms && latency<500ms`, []int{0, 3}},
+		{`logger=database && (msg=query || msg~"^slow")`, []int{0, 2}},
+		{`status`, []int{3}},
+		{`!status && level!=info`, []int{0, 1, 4}},
+		{`time>=10:00:02 && time<"2024-01-15 10:00:04"`, []int{2, 3}},
+		{`message~gateway`, []int{3}},
+	} {
+		if err := pane.SetExpr(c.expr); err != nil {
+			t.Fatalf("%s: %v", c.expr, err)
+		}
+		var got []int
+		for i := 0; i < pane.FilteredSource().LineCount(); i++ {
+			got = append(got, pane.FilteredSource().OriginalLineNumber(i))
+		}
+		if fmt.Sprint(got) != fmt.Sprint(c.want) {
+			t.Errorf("%s: lines %v, want %v", c.expr, got, c.want)
+		}
+	}
+}
+
+// TestFilterExpressionErrors checks parse errors point at what's wrong, and
+// that the ? prompt shows a caret there.
+func TestFilterExpressionErrors(t *testing.T) {
+	for _, c := range []struct {
+		expr string
+		col  int
+		msg  string
+	}{
+		{`level>=`, 7, "expected a value"},
+		{`level>=loud`, 7, "unknown level"},
+		{`a=1 &&`, 6, "expected a field"},
+		{`(a=1 || b=2`, 0, "unclosed ("},
+		{`a=1 b=2`, 4, "unexpected 'b'"},
+		{`msg~"(retry"`, 4, "regex: missing closing )"},
+		{`msg="open`, 4, "unterminated string"},
+		{`time>noon`, 5, "can't read"},
+		{`a=1 , b`, 4, "unexpected ','"},
+	} {
+		_, err := source.ParseExpr(c.expr)
+		var e *source.ExprError
+		if !errors.As(err, &e) {
+			t.Fatalf("%s: error %v", c.expr, err)
+		}
+		if e.Column(c.expr) != c.col || !strings.Contains(e.Msg, c.msg) {
+			t.Errorf("%s: col %d %q, want col %d %q", c.expr, e.Column(c.expr), e.Msg, c.col, c.msg)
+		}
+	}
+
+	m := newTabModel(t, "level=info msg=hello", "level=warn msg=bye")
+	defer m.Close()
+	typeKeys(m, "?=level>=warn")
+	if n := m.currentPane().FilteredSource().LineCount(); n != 1 {
+		t.Fatalf("?=level>=warn: %d lines, want 1", n)
+	}
+	typeKeys(m, " &&")
+	lines := strings.Split(m.View(), "\n")
+	caret := lines[len(lines)-1]
+	if !strings.Contains(caret, "^ expected a field") {
+		t.Fatalf("no caret line: %q", caret)
+	}
+	if n := m.currentPane().FilteredSource().LineCount(); n != 1 {
+		t.Fatalf("a bad expression should keep the last good one: %d lines", n)
+	}
+	m.handleKey(tea.KeyMsg{Type: tea.KeyEsc})
+	if m.currentPane().FilteredSource().IsFiltered() {
+		t.Fatal("esc should clear the expression")
+	}
+}
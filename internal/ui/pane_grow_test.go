@@ -0,0 +1,206 @@
+package ui
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/TimelordUK/mless/internal/source"
+)
+
+// TestFilterFollowsGrowth checks that when a followed file grows, the
+// filter looks at just the new lines (and the old last one, which may have
+// been partly written), and ends up where a rebuild from scratch would.
+func TestFilterFollowsGrowth(t *testing.T) {
+	m := newTabModel(t,
+		"10:00:00 INFO user=1 start",
+		"10:00:01 ERROR user=2 failed",
+		"    at db.go:10",
+		"10:00:02 INFO user=2 retry",
+	)
+	defer m.Close()
+	pane := m.currentPane()
+	if err := pane.Source().WaitIndexed(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	pane.SyncIndex()
+	filter := pane.FilteredSource()
+
+	looked := 0
+	filter.SetFieldFilter("user=2", func(content []byte) bool {
+		looked++
+		return true
+	})
+	matcher, _ := source.NewMatcher("user=2", source.MatchOptions{})
+	filter.SetTextFilter(matcher)
+
+	shown := func() string {
+		var got []int
+		for i := 0; i < filter.LineCount(); i++ {
+			got = append(got, filter.OriginalLineNumber(i))
+		}
+		return fmt.Sprint(got)
+	}
+	grow := func(text string) {
+		t.Helper()
+		f, err := os.OpenFile(pane.Source().Path(), os.O_APPEND|os.O_WRONLY, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		fmt.Fprint(f, text)
+		f.Close()
+		looked = 0
+		if _, err := pane.CheckForNewLines(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// check compares the index with one rebuilt from scratch, and how many
+	// lines the field filter has looked at since the last change
+	check := func(what, want string, lines int) {
+		t.Helper()
+		if got := shown(); got != want {
+			t.Fatalf("%s: shown %s, want %s", what, got, want)
+		}
+		if looked != lines {
+			t.Fatalf("%s: looked at %d lines, want %d", what, looked, lines)
+		}
+		filter.MarkDirty()
+		if got := shown(); got != want {
+			t.Fatalf("%s: rebuilt, shown %s, want %s", what, got, want)
+		}
+	}
+
+	check("before", "[1 3]", 2)
+
+	grow("10:00:03 WARN user=2 slow\n10:00:04 INFO user=3 ok\n10:00:05 INFO user=")
+	check("grown", "[1 3 4]", 2)
+
+	// The partly written line is looked at again once it's finished
+	grow("2 done\n")
+	check("finished a line", "[1 3 4 6]", 1)
+
+	// With records on, the last record is looked at again, so gaining a
+	// matching continuation line brings it in whole
+	looked = 0
+	pane.SetRecords(true)
+	check("records", "[1 2 3 4 6]", 6)
+	grow("10:00:06 ERROR user=4 failed\n")
+	check("new record", "[1 2 3 4 6]", 2)
+	grow("    caused by user=2\n")
+	check("continuation", "[1 2 3 4 6 7 8]", 1)
+}
+
+// TestLastWindowFollowsGrowth checks that a :last window moving on as a
+// followed file grows drops the lines it has left behind and looks at just
+// the new ones, rather than filtering the whole window again, and that the
+// rule counts come out as a rebuild from scratch would have them.
+func TestLastWindowFollowsGrowth(t *testing.T) {
+	var content []string
+	for i := 0; i < 10; i++ {
+		content = append(content, fmt.Sprintf("2024-01-15 10:00:%02d INFO user=%d", i, i%3))
+	}
+	m := newTabModel(t, content...)
+	defer m.Close()
+	pane := m.currentPane()
+	if err := pane.Source().WaitIndexed(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	pane.SyncIndex()
+	filter := pane.FilteredSource()
+
+	looked := 0
+	filter.SetFieldFilter("user!=0", func(content []byte) bool {
+		looked++
+		return !strings.HasSuffix(string(content), "user=0")
+	})
+	if err := pane.SetLast("5s"); err != nil {
+		t.Fatal(err)
+	}
+
+	next := 10
+	grow := func(lines int) {
+		t.Helper()
+		f, err := os.OpenFile(pane.Source().Path(), os.O_APPEND|os.O_WRONLY, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for ; lines > 0; lines-- {
+			fmt.Fprintf(f, "2024-01-15 10:00:%02d INFO user=%d\n", next, next%3)
+			next++
+		}
+		f.Close()
+		looked = 0
+		if _, err := pane.CheckForNewLines(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	check := func(what, want string, lines int) {
+		t.Helper()
+		var got []int
+		for i := 0; i < filter.LineCount(); i++ {
+			got = append(got, filter.OriginalLineNumber(i))
+		}
+		counts := fmt.Sprint(filter.RuleCounts())
+		if fmt.Sprint(got) != want || looked != lines {
+			t.Fatalf("%s: shown %v after looking at %d lines, want %s after %d", what, got, looked, want, lines)
+		}
+		filter.MarkDirty()
+		if rebuilt := fmt.Sprint(filter.RuleCounts()); rebuilt != counts {
+			t.Fatalf("%s: rule counts %s, rebuilt %s", what, counts, rebuilt)
+		}
+	}
+
+	// 10:00:04 to 10:00:09, less user=0
+	check(":last 5s", "[4 5 7 8]", 6)
+
+	// Three lines on, the window starts at 10:00:07: the last old line is
+	// looked at again, with the new ones
+	grow(3)
+	check("grown", "[7 8 10 11]", 4)
+
+	// Rule counts are kept for the whole window, so the lines it moves past
+	// are looked at again to take them out, and then the new ones
+	matcher, _ := source.NewMatcher("user=2", source.MatchOptions{})
+	looked = 0
+	filter.AddRule(source.Rule{Matcher: matcher, Exclude: true, Enabled: true})
+	check("with a rule", "[7 10]", 6)
+	grow(2)
+	check("grown with a rule", "[10 13]", 2+3)
+}
+
+// TestLastWindowWithoutTimestamps checks that :last refuses a file with no
+// timestamps to measure from, and that a window whose file is rotated to one
+// without them is dropped, status bar and all.
+func TestLastWindowWithoutTimestamps(t *testing.T) {
+	m := newTabModel(t, "no time here", "nor here")
+	defer m.Close()
+	pane := m.currentPane()
+	if err := pane.Source().WaitIndexed(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if err := pane.SetLast("5m"); err == nil || err.Error() != "no timestamps to measure from" {
+		t.Fatalf("SetLast without timestamps: %v", err)
+	}
+	if pane.TimeWindow() != "" {
+		t.Fatalf("window %q set anyway", pane.TimeWindow())
+	}
+
+	if err := os.WriteFile(pane.Source().Path(), []byte("2024-01-15 10:00:00 INFO up\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	pane.CheckForNewLines()
+	if err := pane.SetLast("5m"); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(pane.Source().Path(), []byte("rotated, no time\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if rotated, err := pane.CheckForNewLines(); err != nil || !rotated {
+		t.Fatalf("rotation not seen: %v", err)
+	}
+	if pane.TimeWindow() != "" || pane.FilteredSource().IsFiltered() {
+		t.Fatalf("window %q kept with nothing to measure from", pane.TimeWindow())
+	}
+}
@@ -0,0 +1,62 @@
+package ui
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/TimelordUK/mless/internal/config"
+	"github.com/TimelordUK/mless/internal/source"
+)
+
+// TestJSONLogLines checks JSON lines take their level and time from their
+// fields, not from words elsewhere in the object, and that J formats them.
+func TestJSONLogLines(t *testing.T) {
+	lines := []string{
+		`{"ts":"2024-01-15T10:00:00.5Z","level":"info","msg":"no error here","user":42}`,
+		`{"created":"2020-01-01T00:00:00Z","time":1705312801,"lvl":"ERROR","msg":"boom","logger":"db","err":{"code":5}}`,
+		`{"level":40,"time":1705312802123,"msg":"pino style"}`,
+		`WARN plain line`,
+	}
+	pane, err := NewPane(writeTempLog(t, lines), config.DefaultConfig(), false)
+	if err != nil {
+		t.Fatalf("NewPane: %v", err)
+	}
+	defer pane.Close()
+	pane.SetSize(120, 10)
+
+	levels := []source.LogLevel{source.LevelInfo, source.LevelError, source.LevelWarn, source.LevelWarn}
+	for i, want := range levels {
+		line, err := pane.Source().GetLine(i)
+		if err != nil || !line.LevelKnown || line.Level != want {
+			t.Fatalf("line %d: level %v, want %v", i, line.Level, want)
+		}
+	}
+
+	times := []time.Time{
+		time.Date(2024, 1, 15, 10, 0, 0, 5e8, time.UTC),
+		time.Unix(1705312801, 0),
+		time.UnixMilli(1705312802123),
+	}
+	for i, want := range times {
+		if ts := pane.Source().GetTimestamp(i); ts == nil || !ts.Equal(want) {
+			t.Fatalf("line %d: timestamp %v, want %v", i, ts, want)
+		}
+	}
+
+	if got := pane.Render(); !strings.Contains(got, `{"ts"`) {
+		t.Fatalf("raw view should show the object:\n%s", got)
+	}
+	if !pane.ToggleJSONView() {
+		t.Fatal("J should turn the formatted view on")
+	}
+	got := pane.Render()
+	for _, want := range []string{"no error here", "user=42", "[db]", `err={"code":5}`, "WARN plain line"} {
+		if !strings.Contains(got, want) {
+			t.Fatalf("formatted view missing %q:\n%s", want, got)
+		}
+	}
+	if strings.Contains(got, `{"ts"`) {
+		t.Fatalf("formatted view still shows the raw object:\n%s", got)
+	}
+}
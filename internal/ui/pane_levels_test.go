@@ -0,0 +1,86 @@
+package ui
+
+import (
+	"testing"
+
+	"github.com/charmbracelet/bubbles/textinput"
+
+	"github.com/TimelordUK/mless/internal/config"
+	"github.com/TimelordUK/mless/internal/source"
+	"github.com/TimelordUK/mless/pkg/logformat"
+)
+
+// useLevels registers the levels cfg defines for the rest of the test.
+func useLevels(t *testing.T, cfg *config.Config) {
+	old := logformat.Registered()
+	logformat.SetLevels(logformat.NewLevels(cfg))
+	t.Cleanup(func() { logformat.SetLevels(old) })
+}
+
+// TestConfiguredLevels checks levels from config are detected by word,
+// positional regex, level field value and number, and that level-and-above
+// filters go by rank.
+func TestConfiguredLevels(t *testing.T) {
+	cfg := config.DefaultConfig()
+	cfg.Levels = []config.LevelConfig{
+		{Name: "NOTICE", Rank: 35, Color: "110", Patterns: []string{"NOTICE"}, Number: 35},
+		{Name: "AUDIT", Short: "AUD", Rank: 45, Patterns: []string{"AUDIT"}, Values: []string{"security"}},
+		{Name: "info", Regexes: []string{`^I\d{4} `}},
+	}
+	useLevels(t, cfg)
+	notice, _ := logformat.Registered().Lookup("notice")
+	audit, _ := logformat.Registered().Lookup("audit")
+
+	lines := []string{
+		"2024-01-15 10:00:00 NOTICE disk mounted",
+		"2024-01-15 10:00:01 AUDIT password changed",
+		`{"level":50,"msg":"boom"}`,
+		`{"level":"notice","msg":"rotated"}`,
+		`{"level":37,"msg":"pino notice"}`,
+		"I0115 10:30:45.123456    1234 server.go:42] started",
+		"2024-01-15 10:00:02 INFO ok",
+		"2024-01-15 10:00:03 WARN slow",
+		`time=2024-01-15T10:00:04Z level=security msg="sudo used"`,
+	}
+	want := []source.LogLevel{
+		notice, audit, source.LevelError, notice, notice, source.LevelInfo, source.LevelInfo, source.LevelWarn, audit,
+	}
+	pane, err := NewPane(writeTempLog(t, lines), cfg, false)
+	if err != nil {
+		t.Fatalf("NewPane: %v", err)
+	}
+	defer pane.Close()
+
+	for i, w := range want {
+		line, err := pane.Source().GetLine(i)
+		if err != nil || line.Level != w {
+			t.Fatalf("line %d %q: level %v, want %v", i, lines[i], line.Level, w)
+		}
+	}
+
+	pane.FilteredSource().SetLevelAndAbove(notice)
+	if n := pane.FilteredSource().LineCount(); n != 7 {
+		t.Fatalf("notice and above: %d lines, want 7", n)
+	}
+
+	m := &Model{
+		tabs:        []*Tab{newTab([]*Pane{pane}, SplitNone, cfg)},
+		searchInput: textinput.New(),
+		config:      cfg,
+		width:       80,
+		height:      24,
+	}
+	m.setLevelFilter("")
+	m.setLevelFilter("audit")
+	if n := pane.FilteredSource().LineCount(); n != 2 {
+		t.Fatalf(":level audit: %d lines, want 2", n)
+	}
+	m.setLevelFilter("error+")
+	if n := pane.FilteredSource().LineCount(); n != 1 {
+		t.Fatalf(":level error+: %d lines, want 1", n)
+	}
+	m.setLevelFilter("bogus")
+	if m.message == "" {
+		t.Fatal(":level bogus: no error")
+	}
+}
@@ -0,0 +1,86 @@
+package ui
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/TimelordUK/mless/internal/config"
+	"github.com/TimelordUK/mless/internal/source"
+)
+
+// TestLogfmtLines checks logfmt lines take their level and time from their
+// fields, render as written, and can be filtered by field with :where.
+func TestLogfmtLines(t *testing.T) {
+	lines := []string{
+		`ts=2024-01-15T10:00:00.123456789Z level=info msg="retrying after error" user=42`,
+		`ts="2024-01-15T10:00:01+01:00" lvl=warn msg=slow user=7 caller=db.go:12`,
+		`time=1705312802 level=error msg="disk \"sda\" full" user=42`,
+		`ERROR plain line user=42`,
+	}
+	pane, err := NewPane(writeTempLog(t, lines), config.DefaultConfig(), false)
+	if err != nil {
+		t.Fatalf("NewPane: %v", err)
+	}
+	defer pane.Close()
+	pane.SetSize(120, 10)
+
+	levels := []source.LogLevel{source.LevelInfo, source.LevelWarn, source.LevelError, source.LevelError}
+	for i, want := range levels {
+		line, err := pane.Source().GetLine(i)
+		if err != nil || !line.LevelKnown || line.Level != want {
+			t.Fatalf("line %d: level %v, want %v", i, line.Level, want)
+		}
+	}
+
+	times := []time.Time{
+		time.Date(2024, 1, 15, 10, 0, 0, 123456789, time.UTC),
+		time.Date(2024, 1, 15, 9, 0, 1, 0, time.UTC),
+		time.Unix(1705312802, 0),
+	}
+	for i, want := range times {
+		if ts := pane.Source().GetTimestamp(i); ts == nil || !ts.Equal(want) {
+			t.Fatalf("line %d: timestamp %v, want %v", i, ts, want)
+		}
+	}
+
+	got := pane.Render()
+	for _, want := range []string{`msg="retrying after error" user=42`, `caller=db.go:12`, "ERROR plain line"} {
+		if !strings.Contains(got, want) {
+			t.Fatalf("view missing %q:\n%s", want, got)
+		}
+	}
+
+	where := []struct {
+		cond string
+		want []int
+	}{
+		{"user=42", []int{0, 2}},
+		{"user!=42", []int{1, 3}},
+		{"caller", []int{1}},
+		{"level=ERROR", []int{2}},
+		{`msg="disk "sda" full"`, []int{2}},
+		{"", []int{0, 1, 2, 3}},
+	}
+	for _, tc := range where {
+		if err := pane.SetWhere(tc.cond); err != nil {
+			t.Fatalf("where %s: %v", tc.cond, err)
+		}
+		fs := pane.FilteredSource()
+		var shown []int
+		for i := 0; i < fs.LineCount(); i++ {
+			shown = append(shown, fs.OriginalLineNumber(i))
+		}
+		if len(shown) != len(tc.want) {
+			t.Fatalf("where %s: lines %v, want %v", tc.cond, shown, tc.want)
+		}
+		for i := range shown {
+			if shown[i] != tc.want[i] {
+				t.Fatalf("where %s: lines %v, want %v", tc.cond, shown, tc.want)
+			}
+		}
+	}
+	if err := pane.SetWhere("=x"); err == nil {
+		t.Fatal("where without a key should fail")
+	}
+}
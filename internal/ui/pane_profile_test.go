@@ -0,0 +1,110 @@
+package ui
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/charmbracelet/bubbles/textinput"
+
+	"github.com/TimelordUK/mless/internal/config"
+	"github.com/TimelordUK/mless/internal/source"
+)
+
+// profileConfig returns the default config plus klog and an in-house format.
+func profileConfig() *config.Config {
+	cfg := config.DefaultConfig()
+	cfg.Formats = []config.FormatConfig{
+		{
+			Name:       "svc",
+			Pattern:    `^\[(?P<logger>\w+)\|(?P<thread>[\w-]+)\|(?P<timestamp>[^\]]+)\] (?P<level>\w+) (?P<message>.*)`,
+			TimeLayout: "2006-01-02 15:04:05,000",
+		},
+		{
+			Name:       "klog",
+			Pattern:    `^(?P<level>[IWEF])(?P<timestamp>\d{4} \d{2}:\d{2}:\d{2}\.\d{6})\s+(?P<thread>\d+) (?P<logger>[^\]]+)\] (?P<message>.*)`,
+			TimeLayout: "0102 15:04:05.000000",
+			Levels:     map[string]string{"I": "info", "W": "warn", "E": "error", "F": "fatal"},
+		},
+	}
+	return cfg
+}
+
+// TestFormatProfiles checks the profile that fits a file is picked, drives
+// levels, timestamps and :where, and is named in the file info.
+func TestFormatProfiles(t *testing.T) {
+	cfg := profileConfig()
+	klog := []string{
+		"I0115 10:30:45.123456    1234 server.go:42] Starting server",
+		"W0115 10:30:46.000001    1234 server.go:58] Slow handshake (no error yet)",
+		"E0115 10:30:47.500000    5678 db.go:12] Query failed",
+		"    goroutine 1 [running]:",
+	}
+	pane, err := NewPane(writeTempLog(t, klog), cfg, false)
+	if err != nil {
+		t.Fatalf("NewPane: %v", err)
+	}
+	defer pane.Close()
+
+	if pane.profile == nil || pane.profile.Name != "klog" {
+		t.Fatalf("profile: got %v, want klog", pane.profile)
+	}
+	levels := []source.LogLevel{source.LevelInfo, source.LevelWarn, source.LevelError}
+	for i, want := range levels {
+		line, err := pane.Source().GetLine(i)
+		if err != nil || line.Level != want {
+			t.Fatalf("line %d: level %v, want %v", i, line.Level, want)
+		}
+	}
+	want := time.Date(time.Now().Year(), 1, 15, 10, 30, 47, 5e8, time.Local)
+	if ts := pane.Source().GetTimestamp(2); ts == nil || !ts.Equal(want) {
+		t.Fatalf("timestamp: got %v, want %v", ts, want)
+	}
+
+	if err := pane.SetWhere("thread=1234"); err != nil {
+		t.Fatal(err)
+	}
+	if n := pane.FilteredSource().LineCount(); n != 2 {
+		t.Fatalf("where thread=1234: %d lines, want 2", n)
+	}
+
+	m := &Model{
+		tabs:        []*Tab{newTab([]*Pane{pane}, SplitNone, cfg)},
+		searchInput: textinput.New(),
+		config:      cfg,
+		width:       80,
+		height:      24,
+	}
+	if info := m.renderFileInfo(); !strings.Contains(info, "klog") {
+		t.Fatalf("file info doesn't name the profile:\n%s", info)
+	}
+
+	svc := []string{
+		"[billing|thread-7|2024-01-15 10:30:45,123] WARN retry 1",
+		"[billing|thread-7|2024-01-15 10:30:46,001] INFO done",
+	}
+	other, err := NewPane(writeTempLog(t, svc), cfg, false)
+	if err != nil {
+		t.Fatalf("NewPane: %v", err)
+	}
+	defer other.Close()
+	if other.profile == nil || other.profile.Name != "svc" {
+		t.Fatalf("profile: got %v, want svc", other.profile)
+	}
+	want = time.Date(2024, 1, 15, 10, 30, 45, 123e6, time.UTC)
+	if ts := other.Source().GetTimestamp(0); ts == nil || !ts.Equal(want) {
+		t.Fatalf("timestamp: got %v, want %v", ts, want)
+	}
+	if line, _ := other.Source().GetLine(0); line.Level != source.LevelWarn {
+		t.Fatalf("level: got %v, want WARN", line.Level)
+	}
+
+	plain, err := NewPane(writeTempLog(t, []string{"2024-01-15 10:30:45 INFO plain"}), cfg, false)
+	if err != nil {
+		t.Fatalf("NewPane: %v", err)
+	}
+	defer plain.Close()
+	if plain.profile != nil {
+		t.Fatalf("plain log matched profile %s", plain.profile.Name)
+	}
+}
@@ -0,0 +1,61 @@
+package ui
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/TimelordUK/mless/internal/config"
+	"github.com/TimelordUK/mless/internal/source"
+)
+
+// TestRecordsKeepStackTraces checks that with records on, "error and above"
+// keeps an exception's stack trace, continuation lines take the record's
+// level and timestamp, and search and ranges work a record at a time.
+func TestRecordsKeepStackTraces(t *testing.T) {
+	lines := []string{
+		"2024-01-15 10:00:00 INFO starting",
+		"2024-01-15 10:00:01 ERROR request failed",
+		"java.lang.IllegalStateException: boom",
+		"    at com.example.Main.run(Main.java:10)",
+		"2024-01-15 10:00:02 INFO retry ok",
+		"    at com.example.Main.retry(Main.java:20)",
+	}
+	pane, err := NewPane(writeTempLog(t, lines), config.DefaultConfig(), false)
+	if err != nil {
+		t.Fatalf("NewPane: %v", err)
+	}
+	defer pane.Close()
+
+	filtered := pane.FilteredSource()
+	filtered.SetLevelAndAbove(source.LevelError)
+	if got := filtered.LineCount(); got != 1 {
+		t.Fatalf("line by line: %d lines pass, want just the header", got)
+	}
+
+	pane.SetRecords(true)
+	var got []int
+	for i := 0; i < filtered.LineCount(); i++ {
+		got = append(got, filtered.OriginalLineNumber(i))
+	}
+	if want := []int{1, 2, 3}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("records: lines %v pass, want %v", got, want)
+	}
+
+	line, err := pane.Source().GetLine(3)
+	if err != nil || line.Level != source.LevelError {
+		t.Fatalf("continuation line level = %v, want error", line.Level)
+	}
+	if ts := pane.Source().GetTimestamp(3); ts == nil || ts.Second() != 1 {
+		t.Fatalf("continuation line timestamp = %v, want the record's", ts)
+	}
+
+	filtered.ClearFilter()
+	pane.PerformSearch("Main", source.MatchOptions{})
+	if want := []int{3, 5}; !reflect.DeepEqual(pane.SearchResults(), want) {
+		t.Fatalf("search hits %v, want one per record %v", pane.SearchResults(), want)
+	}
+
+	if start, end := pane.RecordRange(3, 4); start != 1 || end != 5 {
+		t.Fatalf("RecordRange(3, 4) = %d, %d, want 1, 5", start, end)
+	}
+}
@@ -0,0 +1,96 @@
+package ui
+
+import (
+	"strings"
+	"testing"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// typeKeys sends s to the model a rune at a time, as if typed.
+func typeKeys(m *Model, s string) {
+	for _, r := range s {
+		m.handleKey(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune{r}})
+	}
+}
+
+// TestRegexAndSmartCase covers the regex and smart-case modes of the ? filter
+// and / search, and invalid regexes being reported in the prompt.
+func TestRegexAndSmartCase(t *testing.T) {
+	m := newTabModel(t,
+		"connection timeout",
+		"Connection refused",
+		"login user_42",
+		"login user_x",
+		"all fine",
+	)
+	defer m.Close()
+	pane := m.currentPane()
+
+	// Substrings match case by default, and regex syntax is literal
+	typeKeys(m, "?timeout|refused")
+	if n := pane.FilteredSource().LineCount(); n != 0 {
+		t.Fatalf("literal filter: %d lines, want 0", n)
+	}
+
+	// ctrl+r makes the term a regex
+	m.handleKey(tea.KeyMsg{Type: tea.KeyCtrlR})
+	if n := pane.FilteredSource().LineCount(); n != 2 {
+		t.Fatalf("regex filter: %d lines, want 2", n)
+	}
+	if !strings.Contains(m.View(), "[regex]") {
+		t.Fatal("prompt should show regex mode")
+	}
+
+	// An unclosed group is reported and the last good filter stays
+	typeKeys(m, "(")
+	if m.promptErr == "" || !strings.Contains(m.View(), "missing closing )") {
+		t.Fatalf("invalid regex not reported: %q", m.promptErr)
+	}
+	if n := pane.FilteredSource().LineCount(); n != 2 {
+		t.Fatalf("invalid regex changed the filter: %d lines, want 2", n)
+	}
+	m.handleKey(tea.KeyMsg{Type: tea.KeyEnter})
+	if m.message == "" {
+		t.Fatal("enter on an invalid regex should say why")
+	}
+	if got := pane.FilteredSource().GetTextFilter().Label(0); got != "/timeout|refused/" {
+		t.Fatalf("kept filter %s", got)
+	}
+
+	// Smart-case: lower case ignores case, a capital matches it
+	m.handleKey(tea.KeyMsg{Type: tea.KeyEsc})
+	typeKeys(m, "?connection")
+	m.handleKey(tea.KeyMsg{Type: tea.KeyCtrlS})
+	if n := pane.FilteredSource().LineCount(); n != 2 {
+		t.Fatalf("smart-case lower: %d lines, want 2", n)
+	}
+	m.handleKey(tea.KeyMsg{Type: tea.KeyEsc})
+	typeKeys(m, "?Connection")
+	if n := pane.FilteredSource().LineCount(); n != 1 {
+		t.Fatalf("smart-case upper: %d lines, want 1", n)
+	}
+	m.handleKey(tea.KeyMsg{Type: tea.KeyEsc})
+
+	// Search shares the modes; \d doesn't count as a capital
+	typeKeys(m, `/USER_\d+`)
+	m.handleKey(tea.KeyMsg{Type: tea.KeyEnter})
+	if got := pane.SearchResults(); len(got) != 0 {
+		t.Fatalf("upper case search matched %v", got)
+	}
+	typeKeys(m, `/user_\d+`)
+	m.handleKey(tea.KeyMsg{Type: tea.KeyEnter})
+	if got := pane.SearchResults(); len(got) != 1 || got[0] != 2 {
+		t.Fatalf("regex search: %v, want [2]", got)
+	}
+	if !strings.Contains(m.View(), `[1 matches /user_\d+/i]`) {
+		t.Fatal("status bar should show the search's modes")
+	}
+
+	// An invalid search keeps the last one
+	typeKeys(m, "/[")
+	m.handleKey(tea.KeyMsg{Type: tea.KeyEnter})
+	if m.message == "" || pane.SearchTerm() != `user_\d+` {
+		t.Fatalf("invalid search: message %q, term %q", m.message, pane.SearchTerm())
+	}
+}
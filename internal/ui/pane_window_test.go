@@ -0,0 +1,86 @@
+package ui
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/TimelordUK/mless/internal/source"
+)
+
+// TestTimeWindow covers :since, :until and :last: the window composes with
+// the level filter, keeps continuation lines with their entry, and :last
+// slides as the file grows.
+func TestTimeWindow(t *testing.T) {
+	m := newTabModel(t,
+		"2024-01-15 12:50:00 INFO starting",
+		"2024-01-15 13:00:00 ERROR first failure",
+		"    at handler.go:12",
+		"2024-01-15 13:30:00 INFO recovered",
+		"2024-01-15 14:00:00 ERROR second failure",
+		"2024-01-15 14:10:00 INFO done",
+	)
+	defer m.Close()
+	pane := m.currentPane()
+	if err := pane.Source().WaitIndexed(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	pane.SyncIndex()
+	filter := pane.FilteredSource()
+
+	check := func(what string, want ...int) {
+		t.Helper()
+		var got []int
+		for i := 0; i < filter.LineCount(); i++ {
+			got = append(got, filter.OriginalLineNumber(i))
+		}
+		if fmt.Sprint(got) != fmt.Sprint(want) {
+			t.Fatalf("%s: shown %v, want %v (message %q)", what, got, want, m.message)
+		}
+	}
+
+	m.runCommand("since 13:00")
+	check(":since 13:00", 1, 2, 3, 4, 5)
+	m.runCommand("until 14:00")
+	check(":until 14:00", 1, 2, 3)
+	if !strings.Contains(m.View(), "13:00–14:00") {
+		t.Fatal("status bar should show the window")
+	}
+
+	filter.SetLevelAndAbove(source.LevelError)
+	check("and ERROR", 1)
+	filter.ClearFilter()
+
+	m.runCommand("since")
+	check(":since cleared", 0, 1, 2, 3)
+
+	m.runCommand("since noon")
+	if !strings.Contains(m.message, "can't read") {
+		t.Fatalf("bad time should be reported, got %q", m.message)
+	}
+
+	m.runCommand("last 15m")
+	check(":last 15m", 4, 5)
+	if pane.TimeWindow() != "last 15m" {
+		t.Fatalf("window %q", pane.TimeWindow())
+	}
+
+	// New lines slide the window along
+	f, err := os.OpenFile(pane.Source().Path(), os.O_APPEND|os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fmt.Fprintln(f, "2024-01-15 14:20:00 INFO later")
+	f.Close()
+	if _, err := pane.CheckForNewLines(); err != nil {
+		t.Fatal(err)
+	}
+	check(":last 15m after a new line", 5, 6)
+
+	m.runCommand("last")
+	if filter.IsFiltered() {
+		t.Fatal(":last with no duration should remove the window")
+	}
+}
@@ -7,6 +7,7 @@ import (
 	"testing"
 
 	"github.com/TimelordUK/mless/internal/config"
+	"github.com/TimelordUK/mless/internal/source"
 )
 
 // writeTempLog writes lines to a temp file and returns its path.
@@ -47,7 +48,7 @@ func TestToggleWrapReanchorsMatchNearEOF(t *testing.T) {
 	defer pane.Close()
 	pane.SetSize(width, height)
 
-	pane.PerformSearch(marker)
+	pane.PerformSearch(marker, source.MatchOptions{})
 	if got := pane.Render(); !strings.Contains(got, marker) {
 		t.Fatalf("match not visible after search (non-wrapped):\n%s", got)
 	}
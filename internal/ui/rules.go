@@ -0,0 +1,258 @@
+package ui
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/charmbracelet/bubbles/textinput"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+
+	"github.com/TimelordUK/mless/internal/source"
+)
+
+// The filter rule panel (|) lists the current pane's rule stack over the
+// bottom of the content, with how many lines each rule matches, and edits
+// it. The stack applies as it's edited, so the content above shows the
+// result.
+
+// maxRulePanelLines caps how many rules the panel shows at once
+const maxRulePanelLines = 8
+
+// openRules shows the rule panel
+func (m *Model) openRules() {
+	m.mode = ModeRules
+	rules := m.currentPane().FilteredSource().Rules()
+	if m.ruleCursor >= len(rules) {
+		m.ruleCursor = max(len(rules)-1, 0)
+	}
+}
+
+func (m *Model) handleRulesKey(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+	pane := m.currentPane()
+	filter := pane.FilteredSource()
+	rules := filter.Rules()
+
+	switch msg.String() {
+	case "esc", "q", "|":
+		m.mode = ModeNormal
+
+	case "j", "down":
+		if m.ruleCursor < len(rules)-1 {
+			m.ruleCursor++
+		}
+	case "k", "up":
+		if m.ruleCursor > 0 {
+			m.ruleCursor--
+		}
+
+	case "+", "a":
+		return m, m.editRule(-1, false)
+	case "-":
+		return m, m.editRule(-1, true)
+	case "e", "enter":
+		if len(rules) > 0 {
+			return m, m.editRule(m.ruleCursor, rules[m.ruleCursor].Exclude)
+		}
+
+	case " ":
+		if len(rules) > 0 {
+			m.refilter(pane, func() { filter.ToggleRule(m.ruleCursor) })
+		}
+	case "i": // include <-> exclude
+		if len(rules) > 0 {
+			r := rules[m.ruleCursor]
+			r.Exclude = !r.Exclude
+			m.refilter(pane, func() { filter.SetRule(m.ruleCursor, r) })
+		}
+	case "d", "x":
+		if len(rules) > 0 {
+			m.refilter(pane, func() { filter.RemoveRule(m.ruleCursor) })
+			if m.ruleCursor >= len(rules)-1 && m.ruleCursor > 0 {
+				m.ruleCursor--
+			}
+		}
+	case "K": // move the rule up the stack
+		if m.ruleCursor > 0 {
+			m.refilter(pane, func() { filter.MoveRule(m.ruleCursor, m.ruleCursor-1) })
+			m.ruleCursor--
+		}
+	case "J": // and down
+		if m.ruleCursor < len(rules)-1 {
+			m.refilter(pane, func() { filter.MoveRule(m.ruleCursor, m.ruleCursor+1) })
+			m.ruleCursor++
+		}
+	}
+	return m, nil
+}
+
+// editRule prompts for the term of rule i, or of a new rule if i is -1
+func (m *Model) editRule(i int, exclude bool) tea.Cmd {
+	m.mode = ModeRuleEdit
+	m.ruleEdit = i
+	m.ruleExclude = exclude
+	m.searchOpts = m.matchOpts
+	m.promptErr = ""
+	m.searchInput.SetValue("")
+	m.searchInput.Placeholder = "Rule (ctrl+r regex, ctrl+s smart-case)..."
+	if i >= 0 {
+		r := m.currentPane().FilteredSource().Rules()[i]
+		m.matchOpts = r.Matcher.Options()
+		m.searchInput.SetValue(r.Matcher.Term())
+		m.searchInput.CursorEnd()
+	}
+	m.searchInput.Focus()
+	return textinput.Blink
+}
+
+func (m *Model) handleRuleEditKey(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+	switch msg.String() {
+	case "enter":
+		term := m.searchInput.Value()
+		matcher, err := source.NewMatcher(term, m.matchOpts)
+		if err != nil {
+			m.promptErr = err.Error()
+			return m, nil
+		}
+		pane := m.currentPane()
+		filter := pane.FilteredSource()
+		switch {
+		case term == "" && m.ruleEdit >= 0:
+			m.refilter(pane, func() { filter.RemoveRule(m.ruleEdit) })
+		case term == "":
+		case m.ruleEdit >= 0:
+			r := filter.Rules()[m.ruleEdit]
+			r.Matcher = matcher
+			m.refilter(pane, func() { filter.SetRule(m.ruleEdit, r) })
+		default:
+			m.refilter(pane, func() {
+				filter.AddRule(source.Rule{Matcher: matcher, Exclude: m.ruleExclude, Enabled: true})
+			})
+			m.ruleCursor = len(filter.Rules()) - 1
+		}
+		m.closeRuleEdit()
+		return m, nil
+
+	case "esc":
+		m.closeRuleEdit()
+		return m, nil
+
+	case "ctrl+r", "ctrl+s":
+		m.toggleMatchOption(msg.String())
+		m.checkPrompt()
+		return m, nil
+	}
+
+	var cmd tea.Cmd
+	m.searchInput, cmd = m.searchInput.Update(msg)
+	m.checkPrompt()
+	return m, cmd
+}
+
+// closeRuleEdit goes back from the rule prompt to the panel, and / and ?
+// back to their own match options
+func (m *Model) closeRuleEdit() {
+	m.openRules()
+	m.matchOpts = m.searchOpts
+	m.promptErr = ""
+	m.searchInput.Blur()
+	m.searchInput.Placeholder = "Search..."
+}
+
+// refilter applies change to pane's filters, staying on the same original
+// line, or the nearest one after it still shown
+func (m *Model) refilter(pane *Pane, change func()) {
+	originalLine := pane.FilteredSource().OriginalLineNumber(pane.Viewport().CurrentLine())
+	change()
+	if originalLine < 0 || !pane.GotoOriginal(originalLine, false) {
+		pane.Viewport().GotoTop()
+	}
+}
+
+// renderRules renders the rule panel, a line per rule with the selected one
+// marked
+func (m *Model) renderRules() []string {
+	panelStyle := lipgloss.NewStyle().
+		Background(lipgloss.Color("236")).
+		Foreground(lipgloss.Color("252")).
+		Width(m.width)
+	titleStyle := panelStyle.Foreground(lipgloss.Color("214")).Bold(true)
+	offStyle := panelStyle.Foreground(lipgloss.Color("243"))
+	cursorStyle := panelStyle.Background(lipgloss.Color("24"))
+	row := func(style lipgloss.Style, s string) string {
+		return style.Render(truncateString(s, m.width))
+	}
+
+	filter := m.currentPane().FilteredSource()
+	rules := filter.Rules()
+	counts := filter.RuleCounts()
+
+	lines := []string{row(titleStyle, " Filter rules: the last enabled rule a line matches decides; lines none match are shown if the first is an exclude")}
+	if len(rules) == 0 {
+		lines = append(lines, row(offStyle, " no rules yet: + adds an include rule, - an exclude rule"))
+		return lines
+	}
+
+	// Scroll to keep the cursor in view
+	first := 0
+	if m.ruleCursor >= maxRulePanelLines {
+		first = m.ruleCursor - maxRulePanelLines + 1
+	}
+	for i := first; i < len(rules) && i < first+maxRulePanelLines; i++ {
+		r := rules[i]
+		cursor, check, kind := " ", " ", "include"
+		if i == m.ruleCursor {
+			cursor = ">"
+		}
+		if r.Enabled {
+			check = "x"
+		}
+		if r.Exclude {
+			kind = "exclude"
+		}
+		line := fmt.Sprintf(" %s [%s] %s  %-40s %10d", cursor, check, kind, r.Matcher.Label(38), counts[i])
+		switch {
+		case i == m.ruleCursor:
+			lines = append(lines, row(cursorStyle, line))
+		case !r.Enabled:
+			lines = append(lines, row(offStyle, line))
+		default:
+			lines = append(lines, row(panelStyle, line))
+		}
+	}
+	return lines
+}
+
+// rulesStatus is the status bar while the rule panel is open
+func (m *Model) rulesStatus() string {
+	if m.mode == ModeRuleEdit {
+		kind := "+"
+		if m.ruleExclude {
+			kind = "-"
+		}
+		return kind + m.searchInput.View() + m.matchInfo()
+	}
+	return " rules  j/k:select  space:on/off  +/-:add include/exclude  e:edit  i:invert  J/K:move  d:delete  esc:close"
+}
+
+// overlayBottom replaces the last lines of content, which ends in a newline,
+// with panel
+func overlayBottom(content string, panel []string) string {
+	lines := strings.Split(strings.TrimSuffix(content, "\n"), "\n")
+	if len(panel) > len(lines) {
+		panel = panel[len(panel)-len(lines):]
+	}
+	copy(lines[len(lines)-len(panel):], panel)
+	return strings.Join(lines, "\n") + "\n"
+}
+
+// enabledRules counts the rules of filter that are on
+func enabledRules(filter *source.FilteredProvider) int {
+	n := 0
+	for _, r := range filter.Rules() {
+		if r.Enabled {
+			n++
+		}
+	}
+	return n
+}
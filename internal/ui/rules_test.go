@@ -0,0 +1,117 @@
+package ui
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	tea "github.com/charmbracelet/bubbletea"
+
+	"github.com/TimelordUK/mless/internal/source"
+)
+
+// TestFilterRules builds "ERROR and above, but not HealthCheck, unless it's
+// about payment" in the rule panel and checks what's shown, the counts, and
+// toggling, inverting and reordering rules.
+func TestFilterRules(t *testing.T) {
+	m := newTabModel(t,
+		"10:00:00 ERROR HealthCheck failed",
+		"10:00:01 INFO HealthCheck ok",
+		"10:00:02 ERROR payment HealthCheck timed out",
+		"10:00:03 ERROR db down",
+		"10:00:04 INFO payment accepted",
+	)
+	defer m.Close()
+	pane := m.currentPane()
+	filter := pane.FilteredSource()
+	filter.SetLevelAndAbove(source.LevelError)
+
+	shown := func() []int {
+		var got []int
+		for i := 0; i < filter.LineCount(); i++ {
+			got = append(got, filter.OriginalLineNumber(i))
+		}
+		return got
+	}
+	check := func(what string, want ...int) {
+		t.Helper()
+		if got := shown(); fmt.Sprint(got) != fmt.Sprint(want) {
+			t.Fatalf("%s: shown %v, want %v", what, got, want)
+		}
+	}
+
+	m.handleKey(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune{'|'}})
+	if m.mode != ModeRules || !strings.Contains(m.View(), "no rules yet") {
+		t.Fatal("| should open an empty rule panel")
+	}
+	typeKeys(m, "-HealthCheck")
+	m.handleKey(tea.KeyMsg{Type: tea.KeyEnter})
+	check("exclude HealthCheck", 3)
+	typeKeys(m, "+payment")
+	m.handleKey(tea.KeyMsg{Type: tea.KeyEnter})
+	check("then include payment", 2, 3)
+
+	if got := filter.RuleCounts(); len(got) != 2 || got[0] != 2 || got[1] != 1 {
+		t.Fatalf("rule counts %v, want [2 1]", got)
+	}
+	if view := m.View(); !strings.Contains(view, `exclude  "HealthCheck"`) || !strings.Contains(view, `include  "payment"`) {
+		t.Fatal("panel should list the rules")
+	}
+
+	// The cursor is on the new rule: turn it off, then back on
+	typeKeys(m, " ")
+	check("payment off", 3)
+	typeKeys(m, " ")
+
+	// Moving the include first makes the stack pick lines out
+	typeKeys(m, "K")
+	if rules := filter.Rules(); rules[0].Matcher.Term() != "payment" {
+		t.Fatalf("K should move the rule up: %v", rules[0].Matcher.Term())
+	}
+	check("include payment, then exclude HealthCheck")
+
+	// Inverting the exclude: payment or HealthCheck
+	typeKeys(m, "ji")
+	check("include both", 0, 2)
+
+	typeKeys(m, "dd")
+	if len(filter.Rules()) != 0 {
+		t.Fatalf("d should delete rules, %d left", len(filter.Rules()))
+	}
+	check("no rules", 0, 2, 3)
+	m.handleKey(tea.KeyMsg{Type: tea.KeyEsc})
+	if m.mode != ModeNormal {
+		t.Fatal("esc should close the panel")
+	}
+}
+
+// TestRuleEditKeepsSearchOptions edits a regex rule with / set to match
+// substrings: the prompt takes the rule's options while it's open, and /
+// has its own back once it closes.
+func TestRuleEditKeepsSearchOptions(t *testing.T) {
+	m := newTabModel(t, "10:00:00 INFO a", "10:00:01 ERROR b")
+	defer m.Close()
+	filter := m.currentPane().FilteredSource()
+	matcher, _ := source.NewMatcher("ERR.R", source.MatchOptions{Regex: true})
+	filter.AddRule(source.Rule{Matcher: matcher, Enabled: true})
+
+	typeKeys(m, "|e")
+	if m.mode != ModeRuleEdit || !m.matchOpts.Regex {
+		t.Fatal("editing a regex rule should turn regex on in the prompt")
+	}
+	m.handleKey(tea.KeyMsg{Type: tea.KeyEnter})
+	if rules := filter.Rules(); !rules[0].Matcher.Options().Regex {
+		t.Fatal("the rule should stay a regex")
+	}
+	if m.matchOpts.Regex {
+		t.Fatal("the rule's regex option should not stick for / and ?")
+	}
+
+	// Likewise leaving with esc, after toggling
+	typeKeys(m, "e")
+	m.handleKey(tea.KeyMsg{Type: tea.KeyCtrlS})
+	m.handleKey(tea.KeyMsg{Type: tea.KeyEsc})
+	if m.matchOpts != (source.MatchOptions{}) {
+		t.Fatalf("options %+v after esc, want those from before", m.matchOpts)
+	}
+}
@@ -4,10 +4,8 @@ import (
 	"strings"
 
 	"github.com/TimelordUK/mless/internal/config"
-	"github.com/TimelordUK/mless/internal/render"
 	"github.com/TimelordUK/mless/internal/source"
 	"github.com/TimelordUK/mless/internal/view"
-	"github.com/TimelordUK/mless/pkg/logformat"
 )
 
 // Tab is a single workspace: one or two panes plus their split layout and zoom
@@ -116,7 +114,7 @@ func (t *Tab) splitVertical() {
 	current := t.currentPane()
 
 	// Create new pane sharing the same source
-	detector := logformat.NewLevelDetector(&t.config.LogLevels)
+	detector := newLevelDetector(t.config, current.profile)
 	newPane := &Pane{
 		viewport:       view.NewViewport(80, 24),
 		source:         current.source, // Shared source
@@ -126,11 +124,16 @@ func (t *Tab) splitVertical() {
 		sourcePath:     current.sourcePath,
 		cachePath:      current.cachePath,
 		isCached:       current.isCached,
+		runner:         current.runner,
 		marks:          make(map[rune]int),
 		visualAnchor:   -1,
+		jsonView:       current.jsonView,
+		profile:        current.profile,
+		baseDate:       current.baseDate,
+		dateFrom:       current.dateFrom,
 	}
 	newPane.viewport.SetProvider(newPane.filteredSource)
-	newPane.viewport.SetRenderer(render.NewLogLevelRenderer(t.config))
+	newPane.viewport.SetRenderer(newPane.renderer())
 	newPane.viewport.GotoLine(current.viewport.CurrentLine())
 
 	t.panes = append(t.panes, newPane)
@@ -146,7 +149,7 @@ func (t *Tab) splitHorizontal() {
 
 	current := t.currentPane()
 
-	detector := logformat.NewLevelDetector(&t.config.LogLevels)
+	detector := newLevelDetector(t.config, current.profile)
 	newPane := &Pane{
 		viewport:       view.NewViewport(80, 24),
 		source:         current.source,
@@ -156,11 +159,16 @@ func (t *Tab) splitHorizontal() {
 		sourcePath:     current.sourcePath,
 		cachePath:      current.cachePath,
 		isCached:       current.isCached,
+		runner:         current.runner,
 		marks:          make(map[rune]int),
 		visualAnchor:   -1,
+		jsonView:       current.jsonView,
+		profile:        current.profile,
+		baseDate:       current.baseDate,
+		dateFrom:       current.dateFrom,
 	}
 	newPane.viewport.SetProvider(newPane.filteredSource)
-	newPane.viewport.SetRenderer(render.NewLogLevelRenderer(t.config))
+	newPane.viewport.SetRenderer(newPane.renderer())
 	newPane.viewport.GotoLine(current.viewport.CurrentLine())
 
 	t.panes = append(t.panes, newPane)
@@ -198,8 +206,11 @@ func (t *Tab) closeCurrentPane() {
 		t.zoomed = false
 	}
 
-	// Close the pane (but not the shared source)
-	if !sharedSource {
+	// Close the pane (but not the shared source). Its filter may be reading
+	// the source in the background either way, so that stops regardless.
+	if sharedSource {
+		closingPane.filteredSource.Stop()
+	} else {
 		closingPane.Close()
 	}
 
@@ -211,6 +222,10 @@ func (t *Tab) closeCurrentPane() {
 func (t *Tab) Close() error {
 	var err error
 	closed := make(map[*source.FileSource]bool)
+	for _, p := range t.panes {
+		// Every pane's background filtering stops before any source closes
+		p.filteredSource.Stop()
+	}
 	for _, p := range t.panes {
 		// Skip panes whose source a sibling already closed; their cache path is
 		// shared too, so there's nothing left to release.
@@ -1,8 +1,10 @@
 package ui
 
 import (
+	"runtime"
 	"strings"
 	"testing"
+	"time"
 )
 
 // newTabModel builds a single-tab Model from one temp file, sized and laid out.
@@ -175,3 +177,56 @@ func TestMaxTabs(t *testing.T) {
 		t.Fatalf("cap exceeded: %d tabs", len(m.tabs))
 	}
 }
+
+// TestCommandTab covers ":r !cmd": the output opens in a following tab, stderr
+// can be isolated with ":stream", and closing the tab kills the command.
+func TestCommandTab(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("uses sh")
+	}
+	m := newTabModel(t, "aaaa")
+	defer m.Close()
+
+	m.runCommand("r !echo to-stdout; echo to-stderr >&2")
+	if len(m.tabs) != 2 {
+		t.Fatalf(":r should open a tab, got %d (message %q)", len(m.tabs), m.message)
+	}
+	pane := m.currentPane()
+	if pane.Runner() == nil || !pane.IsFollowing() {
+		t.Fatal("command tab should be backed by a runner and following")
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for pane.Source().LineCount() < 2 || !strings.HasPrefix(pane.Runner().Status(), "exited") {
+		if time.Now().After(deadline) {
+			t.Fatalf("command output never arrived (status %q)", pane.Runner().Status())
+		}
+		time.Sleep(10 * time.Millisecond)
+		pane.CheckForNewLines()
+	}
+
+	m.runCommand("stream stderr")
+	if got := pane.FilteredSource().LineCount(); got != 1 {
+		t.Fatalf(":stream stderr should leave 1 line, got %d", got)
+	}
+	line, _ := pane.FilteredSource().GetLine(0)
+	if string(line.Content) != "to-stderr" {
+		t.Fatalf("stderr filter kept %q", line.Content)
+	}
+
+	m.runCommand("stream all")
+	if pane.FilteredSource().IsFiltered() {
+		t.Fatal(":stream all should clear the stream filter")
+	}
+
+	m.message = ""
+	m.runCommand("r") // missing command
+	if m.message == "" {
+		t.Fatal(":r without a command should report usage")
+	}
+
+	m.closeTab()
+	if len(m.tabs) != 1 {
+		t.Fatalf("expected the command tab to close, got %d tabs", len(m.tabs))
+	}
+}
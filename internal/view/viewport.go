@@ -27,6 +27,7 @@ type Viewport struct {
 	lineNumberStyle lipgloss.Style
 	contentStyle    lipgloss.Style
 	highlightStyle  lipgloss.Style
+	contextStyle    lipgloss.Style // uncolored context lines around filter matches, and the -- between groups
 
 	// Options
 	showLineNumbers bool
@@ -63,6 +64,7 @@ func NewViewport(width, height int) *Viewport {
 		lineNumberStyle: lipgloss.NewStyle().Foreground(lipgloss.Color("240")),
 		contentStyle:    lipgloss.NewStyle(),
 		highlightStyle:  lipgloss.NewStyle().Foreground(lipgloss.Color("226")).Bold(true),
+		contextStyle:    lipgloss.NewStyle().Foreground(lipgloss.Color("243")),
 		renderer:        render.NewPlainRenderer(),
 		highlightedLine: -1,
 		visualStart:     -1,
@@ -173,14 +175,63 @@ func (v *Viewport) ScrollUp(n int) {
 	v.clampScroll()
 }
 
-// PageDown scrolls down by one page
+// PageDown scrolls down by one page: the last line starting on screen
+// becomes the top one. Pages are counted in rows, as Render draws them, so
+// wrapped lines and the "--" rows between groups of context lines don't make
+// it skip lines.
 func (v *Viewport) PageDown() {
-	v.ScrollDown(v.height - 1)
+	if v.provider == nil {
+		return
+	}
+	n := v.provider.LineCount()
+	last := v.scrollOffset
+	used := 0
+	for i := v.scrollOffset; i < n && used < v.height; i++ {
+		line, err := v.provider.GetLine(i)
+		if i > v.scrollOffset && err == nil && line != nil && line.Break {
+			used++ // the "--" above it
+			if used >= v.height {
+				break
+			}
+		}
+		last = i
+		used += v.lineRows(line, err)
+	}
+	v.ScrollDown(max(last-v.scrollOffset, 1))
 }
 
-// PageUp scrolls up by one page
+// PageUp scrolls up by one page: the top line becomes the last one starting
+// on screen, counting rows as PageDown does
 func (v *Viewport) PageUp() {
-	v.ScrollUp(v.height - 1)
+	if v.provider == nil {
+		return
+	}
+	top := v.scrollOffset
+	used := 1 // the first row of the old top line
+	for i := v.scrollOffset - 1; i >= 0; i-- {
+		below, err := v.provider.GetLine(i + 1)
+		rows := 0
+		if err == nil && below != nil && below.Break {
+			rows++ // the "--" above the line below, now that it's not the top
+		}
+		line, err := v.provider.GetLine(i)
+		rows += v.lineRows(line, err)
+		if used+rows > v.height {
+			break
+		}
+		used += rows
+		top = i
+	}
+	v.ScrollUp(max(v.scrollOffset-top, 1))
+}
+
+// lineRows returns how many rows a line takes on screen: more than one only
+// when it wraps
+func (v *Viewport) lineRows(line *source.Line, err error) int {
+	if err != nil || line == nil || !v.wrapLines {
+		return 1
+	}
+	return len(v.wrapContentRows(v.renderer.Render(line), v.contentWidth()))
 }
 
 // GotoTop scrolls to the beginning
@@ -257,40 +308,34 @@ func (v *Viewport) maxScrollOffset() int {
 // row of a full screen — the destination for GotoBottom (G). Unlike
 // maxScrollOffset it keeps the screen full rather than scrolling into "~".
 //
-// In non-wrap mode every line is one physical row, so this is the familiar
-// LineCount-height. In wrap mode the trailing lines can each occupy several
-// rows, so fewer of them fill the final screen and the offset is higher.
+// In non-wrap mode every line is one physical row, so this is about the
+// familiar LineCount-height (less the "--" rows between groups of context
+// lines). In wrap mode the trailing lines can each occupy several rows, so
+// fewer of them fill the final screen and the offset is higher.
 func (v *Viewport) bottomAnchorOffset() int {
 	if v.provider == nil {
 		return 0
 	}
 	n := v.provider.LineCount()
 
-	if !v.wrapLines {
-		maxScroll := n - v.height
-		if maxScroll < 0 {
-			maxScroll = 0
-		}
-		return maxScroll
-	}
-
 	// Walk backwards from the last line, accumulating physical rows. The top
 	// line can't be partially scrolled, so we keep the largest run of trailing
 	// lines whose rows still fit in the height budget: stop before the line that
 	// would overflow it. This keeps EOF visible at the bottom.
-	availableWidth := v.contentWidth()
 	used := 0
+	separator := false // the line below starts a group, so has a "--" row above it unless it's the top
 	for i := n - 1; i >= 0; i-- {
 		line, err := v.provider.GetLine(i)
-		rows := 1
-		if err == nil {
-			rows = len(v.wrapContentRows(v.renderer.Render(line), availableWidth))
+		rows := v.lineRows(line, err)
+		if separator {
+			rows++
 		}
 		if used+rows > v.height && used > 0 {
 			// Including line i would push EOF off-screen; top is the line below.
 			return i + 1
 		}
 		used += rows
+		separator = err == nil && line != nil && line.Break
 	}
 	return 0
 }
@@ -329,8 +374,19 @@ func (v *Viewport) Render() string {
 			break
 		}
 
+		// A gap between groups of context lines, marked like grep does
+		if line.Break && i > 0 {
+			rows = append(rows, v.contextStyle.Render("--"))
+			if len(rows) >= v.height {
+				break
+			}
+		}
+
 		gutter := v.renderGutter(line, i, lineNumWidth)
 		content := v.renderer.Render(line)
+		if line.Context {
+			content = v.dim(content)
+		}
 
 		// A line wraps if global wrap is on, or it is individually expanded.
 		expand := v.wrapLines || v.expandedLines[line.OriginalIndex]
@@ -373,6 +429,19 @@ func (v *Viewport) Render() string {
 	return builder.String()
 }
 
+// dim fades a rendered context line. Plain text takes the context style;
+// colored text keeps its colors and is made faint, again after each reset
+// in it, so a context line still reads as the kind of line it is.
+func (v *Viewport) dim(content string) string {
+	if !strings.Contains(content, "\x1b[") {
+		return v.contextStyle.Render(content)
+	}
+	const faint = "\x1b[2m"
+	content = strings.ReplaceAll(content, "\x1b[0m", "\x1b[0m"+faint)
+	content = strings.ReplaceAll(content, "\x1b[m", "\x1b[m"+faint)
+	return faint + content + "\x1b[0m"
+}
+
 // renderGutter builds the line-number / mark / visual-selection gutter for a
 // logical line. Returns "" when line numbers are disabled. The index i is the
 // line's position within the fetched window (used as a fallback for the
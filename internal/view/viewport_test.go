@@ -1,6 +1,7 @@
 package view
 
 import (
+	"fmt"
 	"strings"
 	"testing"
 
@@ -175,6 +176,53 @@ func TestGotoBottomReachesEOFWhenWrapping(t *testing.T) {
 	}
 }
 
+// groupedProvider is a fakeProvider whose lines come in groups of three, as
+// in a filtered view with context lines: the first of each group after the
+// first has Break set, so a "--" row goes above it.
+type groupedProvider struct {
+	fakeProvider
+}
+
+func (g *groupedProvider) GetLine(index int) (*source.Line, error) {
+	line, err := g.fakeProvider.GetLine(index)
+	line.Break = index > 0 && index%3 == 0
+	return line, err
+}
+
+func (g *groupedProvider) GetLines(start, count int) ([]*source.Line, error) {
+	var out []*source.Line
+	for i := start; i < start+count && i < len(g.lines); i++ {
+		line, _ := g.GetLine(i)
+		out = append(out, line)
+	}
+	return out, nil
+}
+
+// TestGotoBottomCountsSeparators verifies that the "--" rows between groups
+// of context lines count against the height, so GotoBottom still shows the
+// last line, and that they're only drawn between lines, not above the top.
+func TestGotoBottomCountsSeparators(t *testing.T) {
+	const width, height = 40, 10
+
+	lines := manyLines(30, 10)
+	lines[29] = "LAST-LINE-MARKER"
+
+	v := NewViewport(width, height)
+	v.SetProvider(&groupedProvider{fakeProvider{lines: lines}})
+	v.GotoBottom()
+
+	got := v.Render()
+	if !strings.Contains(got, "LAST-LINE-MARKER") {
+		t.Fatalf("GotoBottom did not reach EOF past the separators:\n%s", got)
+	}
+	if countRows(got) != height {
+		t.Fatalf("expected %d rows, got %d", height, countRows(got))
+	}
+	if strings.HasPrefix(got, "--") || strings.Count(got, "--") != 2 {
+		t.Fatalf("expected 2 separators, none at the top:\n%s", got)
+	}
+}
+
 // TestScrollLastLineToTop verifies the vim-style relaxed scroll bound: the
 // final screenful is reachable, i.e. the last line can be scrolled all the way
 // to the top row. Classic less pins it to the bottom and forbids going further,
@@ -217,3 +265,83 @@ func TestFileFittingOnScreenDoesNotScroll(t *testing.T) {
 		t.Fatalf("short file scrolled to line %d, expected to stay at 0", v.CurrentLine())
 	}
 }
+
+// TestPageCountsSeparators verifies that paging goes by rows on screen, the
+// "--" rows between groups of context lines included: PageDown puts the last
+// line shown at the top, rather than skipping past lines never shown, and
+// PageUp puts the top line back at the bottom.
+func TestPageCountsSeparators(t *testing.T) {
+	const width, height = 40, 10
+
+	lines := make([]string, 30)
+	for i := range lines {
+		lines[i] = fmt.Sprintf("line-%d", i)
+	}
+
+	v := NewViewport(width, height)
+	v.SetShowLineNumbers(false)
+	v.SetProvider(&groupedProvider{fakeProvider{lines: lines}})
+
+	// The first and last lines shown, leaving out the "--" rows
+	shown := func() (first, last string) {
+		var rows []string
+		for _, row := range strings.Split(v.Render(), "\n") {
+			if !strings.HasPrefix(row, "--") {
+				rows = append(rows, row)
+			}
+		}
+		return rows[0], rows[len(rows)-1]
+	}
+
+	for page := 0; page < 3; page++ {
+		_, bottom := shown()
+		v.PageDown()
+		top, _ := shown()
+		if top != bottom {
+			t.Fatalf("page %d: PageDown put %q at the top, want %q, the last line shown", page, top, bottom)
+		}
+		v.PageUp()
+		if _, last := shown(); last != top {
+			t.Fatalf("page %d: PageUp put %q at the bottom, want %q", page, last, top)
+		}
+		v.PageDown()
+	}
+}
+
+// redRenderer colors every line, as a level renderer colors error lines
+type redRenderer struct{}
+
+func (redRenderer) Render(line *source.Line) string {
+	return "\x1b[31m" + string(line.Content) + "\x1b[0m"
+}
+
+// contextProvider is a fakeProvider whose odd lines are context lines
+type contextProvider struct {
+	fakeProvider
+}
+
+func (c *contextProvider) GetLines(start, count int) ([]*source.Line, error) {
+	out, err := c.fakeProvider.GetLines(start, count)
+	for _, line := range out {
+		line.Context = line.OriginalIndex%2 == 1
+	}
+	return out, err
+}
+
+// TestContextLinesKeepRendering verifies that context lines go through the
+// renderer like matches do, and are dimmed on top of its colors rather than
+// drawn as raw text.
+func TestContextLinesKeepRendering(t *testing.T) {
+	v := NewViewport(40, 2)
+	v.SetShowLineNumbers(false)
+	v.SetRenderer(redRenderer{})
+	v.SetProvider(&contextProvider{fakeProvider{lines: []string{"match", "context"}}})
+
+	rows := strings.Split(v.Render(), "\n")
+	if strings.Contains(rows[0], "\x1b[2m") {
+		t.Fatalf("match dimmed: %q", rows[0])
+	}
+	if !strings.Contains(rows[1], "\x1b[31m") || !strings.Contains(rows[1], "\x1b[2m") {
+		t.Fatalf("context line should keep the renderer's color, dimmed: %q", rows[1])
+	}
+}
@@ -0,0 +1,74 @@
+package logformat
+
+import (
+	"strconv"
+	"time"
+)
+
+// Field is a key and value of a structured (JSON or logfmt) log line
+type Field struct {
+	Key   string
+	Value string // strings unquoted, anything else as written
+}
+
+// Record is a parsed structured log line
+type Record struct {
+	Level   LogLevel
+	Time    *time.Time
+	Message string
+	Logger  string
+	Fields  []Field // the other fields, in line order
+}
+
+// FieldValue returns the value of the field key in a JSON or logfmt line.
+// ok is false if the line isn't structured or has no such field.
+func FieldValue(content []byte, key string) (value string, ok bool) {
+	if IsJSON(content) {
+		eachMember(content, func(k string, raw []byte) {
+			if !ok && k == key {
+				value, ok = jsonString(raw), true
+			}
+		})
+		return value, ok
+	}
+	if !eachPair(content, func(k, raw []byte) {
+		if !ok && string(k) == key {
+			value, ok = logfmtValue(raw), true
+		}
+	}) {
+		return "", false
+	}
+	return value, ok
+}
+
+// timeValue reads a time field: an RFC 3339 string, a unix time in seconds
+// (possibly fractional), milliseconds, microseconds or nanoseconds going by
+// its size, or anything times recognises
+func timeValue(times *TimestampParser, s string) *time.Time {
+	if t, err := time.Parse(time.RFC3339Nano, s); err == nil {
+		return &t
+	}
+	if n, err := strconv.ParseInt(s, 10, 64); err == nil {
+		t := unixTime(n)
+		return &t
+	}
+	if secs, err := strconv.ParseFloat(s, 64); err == nil {
+		t := time.Unix(0, int64(secs*1e9))
+		return &t
+	}
+	return times.Parse([]byte(s))
+}
+
+// unixTime interprets n as seconds, milliseconds, microseconds or nanoseconds
+// since the epoch, whichever puts it after 1973
+func unixTime(n int64) time.Time {
+	switch {
+	case n < 1e11:
+		return time.Unix(n, 0)
+	case n < 1e14:
+		return time.UnixMilli(n)
+	case n < 1e17:
+		return time.UnixMicro(n)
+	}
+	return time.Unix(0, n)
+}
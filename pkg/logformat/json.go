@@ -0,0 +1,225 @@
+package logformat
+
+import (
+	"bytes"
+	"encoding/json"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/TimelordUK/mless/internal/config"
+)
+
+// JSONFormat reads structured logs: one JSON object per line, with the level,
+// time, message and logger in configurable fields
+type JSONFormat struct {
+	levelKeys   []string
+	timeKeys    []string
+	messageKeys []string
+	loggerKeys  []string
+	times       *TimestampParser // time strings that aren't RFC 3339
+}
+
+// NewJSONFormat creates a JSON log reader with the configured key names
+func NewJSONFormat(cfg *config.JSONConfig) *JSONFormat {
+	return &JSONFormat{
+		levelKeys:   cfg.LevelKeys,
+		timeKeys:    cfg.TimeKeys,
+		messageKeys: cfg.MessageKeys,
+		loggerKeys:  cfg.LoggerKeys,
+		times:       NewTimestampParser(),
+	}
+}
+
+// IsJSON reports whether content looks like a JSON object
+func IsJSON(content []byte) bool {
+	content = bytes.TrimLeft(content, " \t")
+	return len(content) > 0 && content[0] == '{'
+}
+
+// Parse parses a JSON log line. ok is false if content isn't a JSON object;
+// a line cut short still gives the members before the cut.
+func (f *JSONFormat) Parse(content []byte) (rec *Record, ok bool) {
+	rec = &Record{}
+	level, ts, msg, logger := -1, -1, -1, -1
+	ok = eachMember(content, func(key string, raw []byte) {
+		switch {
+		case better(f.levelKeys, key, &level):
+			rec.Level = parseJSONLevel(raw)
+		case better(f.timeKeys, key, &ts):
+			rec.Time = f.parseTime(raw)
+		case better(f.messageKeys, key, &msg):
+			rec.Message = jsonString(raw)
+		case better(f.loggerKeys, key, &logger):
+			rec.Logger = jsonString(raw)
+		default:
+			rec.Fields = append(rec.Fields, Field{Key: key, Value: jsonString(raw)})
+		}
+	})
+	if !ok {
+		return nil, false
+	}
+	return rec, true
+}
+
+// Level returns the level of a JSON log line (LevelUnknown if it has no level
+// field). ok is false if content isn't a JSON object.
+func (f *JSONFormat) Level(content []byte) (level LogLevel, ok bool) {
+	best := -1
+	ok = eachMember(content, func(key string, raw []byte) {
+		if better(f.levelKeys, key, &best) {
+			level = parseJSONLevel(raw)
+		}
+	})
+	return level, ok
+}
+
+// Time returns the timestamp of a JSON log line (nil if it has no time
+// field). ok is false if content isn't a JSON object.
+func (f *JSONFormat) Time(content []byte) (ts *time.Time, ok bool) {
+	best := -1
+	ok = eachMember(content, func(key string, raw []byte) {
+		if better(f.timeKeys, key, &best) {
+			ts = f.parseTime(raw)
+		}
+	})
+	return ts, ok
+}
+
+// better reports whether key is one of keys and comes before the best one
+// seen so far (*best, -1 if none), updating *best if so: when a line has
+// more than one of the keys, the one listed first wins
+func better(keys []string, key string, best *int) bool {
+	i := slices.Index(keys, key)
+	if i < 0 || (*best >= 0 && i >= *best) {
+		return false
+	}
+	*best = i
+	return true
+}
+
+// parseJSONLevel reads a level name, or a numeric level as used by pino and
+// bunyan (10 trace, 20 debug, 30 info, 40 warn, 50 error, 60 fatal)
+func parseJSONLevel(raw []byte) LogLevel {
+	return LevelFromName(jsonString(raw))
+}
+
+// parseTime reads a time string or a unix time
+func (f *JSONFormat) parseTime(raw []byte) *time.Time {
+	return timeValue(f.times, jsonString(raw))
+}
+
+// jsonString returns a raw JSON value as text: strings unquoted, anything
+// else as written
+func jsonString(raw []byte) string {
+	if len(raw) < 2 || raw[0] != '"' {
+		return string(raw)
+	}
+	if bytes.IndexByte(raw, '\\') < 0 {
+		return string(raw[1 : len(raw)-1])
+	}
+	var s string
+	if err := json.Unmarshal(raw, &s); err != nil {
+		return string(raw[1 : len(raw)-1])
+	}
+	return s
+}
+
+// eachMember calls fn with the key and raw value of each top-level member of
+// the JSON object in content, in order. It reports whether content is an
+// object: it must start with one and its first member must be well formed,
+// but it may be cut short after that (fn sees the members before the cut).
+func eachMember(content []byte, fn func(key string, raw []byte)) bool {
+	b := bytes.TrimSpace(content)
+	if len(b) < 2 || b[0] != '{' {
+		return false
+	}
+	i := skipSpace(b, 1)
+	if b[i] == '}' {
+		return true
+	}
+	for n := 0; ; n++ {
+		i = skipSpace(b, i)
+		keyEnd, ok := scanString(b, i)
+		if !ok {
+			return n > 0
+		}
+		key := jsonString(b[i:keyEnd])
+		i = skipSpace(b, keyEnd)
+		if i >= len(b) || b[i] != ':' {
+			return n > 0
+		}
+		i = skipSpace(b, i+1)
+		valueEnd, ok := scanValue(b, i)
+		if !ok {
+			return n > 0
+		}
+		fn(key, b[i:valueEnd])
+
+		i = skipSpace(b, valueEnd)
+		if i >= len(b) || b[i] != ',' {
+			return true
+		}
+		i++
+	}
+}
+
+func skipSpace(b []byte, i int) int {
+	for i < len(b) && strings.IndexByte(" \t\r\n", b[i]) >= 0 {
+		i++
+	}
+	return i
+}
+
+// scanString returns the end of the JSON string starting at b[i]
+func scanString(b []byte, i int) (int, bool) {
+	if i >= len(b) || b[i] != '"' {
+		return 0, false
+	}
+	for j := i + 1; j < len(b); j++ {
+		switch b[j] {
+		case '\\':
+			j++
+		case '"':
+			return j + 1, true
+		}
+	}
+	return 0, false
+}
+
+// scanValue returns the end of the JSON value starting at b[i]. Nested
+// objects and arrays are matched up but not checked further.
+func scanValue(b []byte, i int) (int, bool) {
+	if i >= len(b) {
+		return 0, false
+	}
+	switch b[i] {
+	case '"':
+		return scanString(b, i)
+	case '{', '[':
+		depth := 0
+		for j := i; j < len(b); j++ {
+			switch b[j] {
+			case '"':
+				end, ok := scanString(b, j)
+				if !ok {
+					return 0, false
+				}
+				j = end - 1
+			case '{', '[':
+				depth++
+			case '}', ']':
+				depth--
+				if depth == 0 {
+					return j + 1, true
+				}
+			}
+		}
+		return 0, false
+	}
+	j := i
+	for j < len(b) && strings.IndexByte(",}] \t\r\n", b[j]) < 0 {
+		j++
+	}
+	return j, j > i
+}
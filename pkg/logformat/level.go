@@ -1,13 +1,8 @@
 package logformat
 
-import (
-	"strings"
-	"unicode"
-
-	"github.com/TimelordUK/mless/internal/config"
-)
-
-// LogLevel represents a log severity level
+// LogLevel represents a log severity level. The built-in six have fixed
+// values; levels defined in config ([[levels]]) follow them. How severe a
+// level is comes from its Rank, not its value.
 type LogLevel int
 
 const (
@@ -20,100 +15,72 @@ const (
 	LevelFatal
 )
 
+// levelPrefixLen is how much of the start of a line is looked at for a
+// level word
+const levelPrefixLen = 150
+
 // LevelDetector detects log levels from line content
 type LevelDetector struct {
-	patterns map[LogLevel][]string
+	levels  *Levels
+	json    *JSONFormat   // if set, JSON lines are classified by their level field
+	logfmt  *LogfmtFormat // likewise logfmt lines
+	profile *Profile      // if set, lines it matches are classified by its level group
 }
 
-// NewLevelDetector creates a detector from config
-func NewLevelDetector(cfg *config.LogLevelConfig) *LevelDetector {
-	return &LevelDetector{
-		patterns: map[LogLevel][]string{
-			LevelTrace: cfg.TracePatterns,
-			LevelDebug: cfg.DebugPatterns,
-			LevelInfo:  cfg.InfoPatterns,
-			LevelWarn:  cfg.WarnPatterns,
-			LevelError: cfg.ErrorPatterns,
-			LevelFatal: cfg.FatalPatterns,
-		},
-	}
+// NewLevelDetector creates a detector for the registered levels (see
+// SetLevels)
+func NewLevelDetector() *LevelDetector {
+	return &LevelDetector{levels: Registered()}
 }
 
-// Detect returns the log level for a line
-func (d *LevelDetector) Detect(content []byte) LogLevel {
-	line := string(content)
-
-	// Only look at the prefix of the line (first 150 chars) for level detection
-	// Log levels typically appear near the start, after timestamp
-	prefix := line
-	if len(prefix) > 150 {
-		prefix = prefix[:150]
-	}
-
-	// Check in order of severity (most specific first)
-	if d.matchLevel(prefix, LevelFatal) {
-		return LevelFatal
-	}
-	if d.matchLevel(prefix, LevelError) {
-		return LevelError
-	}
-	if d.matchLevel(prefix, LevelWarn) {
-		return LevelWarn
-	}
-	if d.matchLevel(prefix, LevelInfo) {
-		return LevelInfo
-	}
-	if d.matchLevel(prefix, LevelDebug) {
-		return LevelDebug
-	}
-	if d.matchLevel(prefix, LevelTrace) {
-		return LevelTrace
-	}
+// SetJSON makes the detector read the level field of JSON log lines instead
+// of matching patterns against them, which could pick up a level word from a
+// message or another field
+func (d *LevelDetector) SetJSON(f *JSONFormat) {
+	d.json = f
+}
 
-	return LevelUnknown
+// SetLogfmt makes the detector read the level field of logfmt lines, so
+// msg="retrying after error" level=info is INFO
+func (d *LevelDetector) SetLogfmt(f *LogfmtFormat) {
+	d.logfmt = f
 }
 
-// matchLevel checks if any pattern for the level matches in the prefix
-func (d *LevelDetector) matchLevel(prefix string, level LogLevel) bool {
-	for _, pattern := range d.patterns[level] {
-		if matchPattern(prefix, pattern) {
-			return true
-		}
-	}
-	return false
+// SetProfile makes the detector take the level of lines that match a format
+// profile from its level group
+func (d *LevelDetector) SetProfile(p *Profile) {
+	d.profile = p
 }
 
-// matchPattern checks if a pattern matches with appropriate boundaries
-// Bracketed patterns like [ERROR] match anywhere
-// Bare patterns like ERROR require word boundaries
-func matchPattern(text, pattern string) bool {
-	// Bracketed patterns are precise - match anywhere
-	if strings.HasPrefix(pattern, "[") && strings.HasSuffix(pattern, "]") {
-		return strings.Contains(text, pattern)
+// Detect returns the log level for a line
+func (d *LevelDetector) Detect(content []byte) LogLevel {
+	if d.profile != nil {
+		if level, ok := d.profile.Level(content); ok {
+			return level
+		}
 	}
-
-	// For bare patterns, require word boundaries
-	idx := strings.Index(text, pattern)
-	if idx == -1 {
-		return false
+	if d.json != nil && IsJSON(content) {
+		if level, ok := d.json.Level(content); ok {
+			return level
+		}
 	}
-
-	// Check character before pattern (should be non-alphanumeric or start of string)
-	if idx > 0 {
-		before := rune(text[idx-1])
-		if unicode.IsLetter(before) || unicode.IsDigit(before) || before == '_' {
-			return false
+	if d.logfmt != nil {
+		if level, ok := d.logfmt.Level(content); ok {
+			return level
 		}
 	}
 
-	// Check character after pattern (should be non-alphanumeric or end of string)
-	endIdx := idx + len(pattern)
-	if endIdx < len(text) {
-		after := rune(text[endIdx])
-		if unicode.IsLetter(after) || unicode.IsDigit(after) || after == '_' {
-			return false
-		}
+	// Only look at the prefix of the line (first 150 chars) for level detection
+	// Log levels typically appear near the start, after timestamp
+	if len(content) > levelPrefixLen {
+		content = content[:levelPrefixLen]
 	}
+	return d.levels.Detect(content)
+}
 
-	return true
+// LevelFromName maps a level name as structured loggers write it ("warn",
+// "WARNING", "err", "critical", ...), or a numeric level (pino and bunyan's
+// 40), to one of the registered levels
+func LevelFromName(name string) LogLevel {
+	return Registered().FromName(name)
 }
@@ -0,0 +1,165 @@
+package logformat
+
+import (
+	"bytes"
+	"os"
+	"strings"
+	"testing"
+	"unicode"
+
+	"github.com/TimelordUK/mless/internal/config"
+)
+
+// naiveDetect is level detection as it was before the automaton: a string
+// per line, and strings.Index for every pattern of every level in turn.
+func naiveDetect(levels *Levels, content []byte) LogLevel {
+	if len(content) > levelPrefixLen {
+		content = content[:levelPrefixLen]
+	}
+	prefix := string(content)
+	for _, def := range levels.bySeverity {
+		for _, pattern := range def.patterns {
+			if naiveMatch(prefix, pattern) {
+				return def.Level
+			}
+		}
+	}
+	return LevelUnknown
+}
+
+func naiveMatch(text, pattern string) bool {
+	if strings.HasPrefix(pattern, "[") && strings.HasSuffix(pattern, "]") {
+		return strings.Contains(text, pattern)
+	}
+	idx := strings.Index(text, pattern)
+	if idx == -1 {
+		return false
+	}
+	if idx > 0 {
+		before := rune(text[idx-1])
+		if unicode.IsLetter(before) || unicode.IsDigit(before) || before == '_' {
+			return false
+		}
+	}
+	endIdx := idx + len(pattern)
+	if endIdx < len(text) {
+		after := rune(text[endIdx])
+		if unicode.IsLetter(after) || unicode.IsDigit(after) || after == '_' {
+			return false
+		}
+	}
+	return true
+}
+
+// testLogLines returns the lines of the repo's test.log.
+func testLogLines(tb testing.TB) [][]byte {
+	tb.Helper()
+	data, err := os.ReadFile("../../test.log")
+	if err != nil {
+		tb.Skip("test.log:", err)
+	}
+	return bytes.Split(bytes.TrimSuffix(data, []byte("\n")), []byte("\n"))
+}
+
+func TestDetectMatchesNaive(t *testing.T) {
+	levels := NewLevels(config.DefaultConfig())
+	lines := append(testLogLines(t),
+		[]byte("2024-01-15 10:30:45 ERROR boom"),
+		[]byte("[WRN] then ERROR later: the most severe wins"),
+		[]byte("INFORMATION is not INFO_x or xINFO"),
+		[]byte("TERRORS and DEBUGGER aren't levels"),
+		[]byte("[ERROR]suffix is bracketed so it counts"),
+		[]byte("FATAL"),
+		[]byte(""),
+		[]byte(strings.Repeat("x ", 80)+"ERROR past the prefix"),
+		[]byte(strings.Repeat("x", levelPrefixLen-3)+"ERRx"),
+		[]byte("caf\xe9ERROR: Latin-1 letters are word bytes"),
+	)
+	for _, line := range lines {
+		if got, want := levels.Detect(truncate(line)), naiveDetect(levels, line); got != want {
+			t.Fatalf("%q: got %v, want %v", line, got, want)
+		}
+	}
+}
+
+// TestDetectLaterOccurrence covers a pattern whose first occurrence is inside
+// a word: the naive matcher only looked at the first.
+func TestDetectLaterOccurrence(t *testing.T) {
+	levels := NewLevels(config.DefaultConfig())
+	if got := levels.Detect([]byte("ERRORS: 1 ERROR")); got != LevelError {
+		t.Fatalf("got %v, want ERROR", got)
+	}
+}
+
+func TestDetectCustomLevels(t *testing.T) {
+	cfg := config.DefaultConfig()
+	cfg.Levels = []config.LevelConfig{
+		{Name: "NOTICE", Rank: 35, Patterns: []string{"NOTICE"}},
+		{Name: "ALERT", Rank: 65, Patterns: []string{"ALERT"}},
+		{Name: "info", Regexes: []string{`^I\d{4} `}},
+		{Name: "warn", Regexes: []string{`^W\d{4} `}},
+	}
+	levels := NewLevels(cfg)
+	notice, _ := levels.Lookup("notice")
+	alert, _ := levels.Lookup("alert")
+	for line, want := range map[string]LogLevel{
+		"NOTICE mounted":                 notice,
+		"NOTICE then WARN":               LevelWarn,
+		"ERROR then ALERT":               alert,
+		"I0115 10:30:45 NOTICE":          notice,
+		"I0115 10:30:45 started":         LevelInfo,
+		"W0115 10:30:45 DEBUG slow":      LevelWarn,
+		"W0115 10:30:45 ERROR failed":    LevelError,
+		"nothing to see":                 LevelUnknown,
+		"INFO before a regex-only level": LevelInfo,
+	} {
+		if got := levels.Detect([]byte(line)); got != want {
+			t.Errorf("%q: got %v, want %v", line, got, want)
+		}
+	}
+}
+
+func TestDetectDoesNotAllocate(t *testing.T) {
+	d := NewLevelDetector()
+	line := []byte("2025-11-21 22:45:22.782 [INF] Metrics: User user_9412 authenticated successfully")
+	if n := testing.AllocsPerRun(100, func() { d.Detect(line) }); n != 0 {
+		t.Fatalf("Detect allocates %v times per line", n)
+	}
+}
+
+func truncate(line []byte) []byte {
+	if len(line) > levelPrefixLen {
+		return line[:levelPrefixLen]
+	}
+	return line
+}
+
+// BenchmarkDetect compares detection over test.log with the automaton and
+// with the per-pattern search it replaced.
+func BenchmarkDetect(b *testing.B) {
+	lines := testLogLines(b)
+	levels := NewLevels(config.DefaultConfig())
+	var size int64
+	for _, line := range lines {
+		size += int64(len(line))
+	}
+
+	b.Run("automaton", func(b *testing.B) {
+		b.SetBytes(size)
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			for _, line := range lines {
+				levels.Detect(truncate(line))
+			}
+		}
+	})
+	b.Run("naive", func(b *testing.B) {
+		b.SetBytes(size)
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			for _, line := range lines {
+				naiveDetect(levels, line)
+			}
+		}
+	})
+}
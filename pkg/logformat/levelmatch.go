@@ -0,0 +1,178 @@
+package logformat
+
+import "unicode"
+
+// levelMatcher finds the most severe level whose patterns appear in a line
+// prefix, in one pass over its bytes: an Aho-Corasick automaton over every
+// level's patterns, turned into a DFA so each byte is one table lookup.
+// Bytes that appear in no pattern share a column of the table, which keeps
+// it small enough to stay in cache, and states are numbered so that those
+// where a pattern ends come last: the loop only stops to look at matches
+// once it's past firstFinal.
+type levelMatcher struct {
+	class      [256]int32 // column of each byte (0 = bytes in no pattern)
+	delta      []int32    // delta[row+class[b]] is the row of the state after reading b
+	firstFinal int32      // row of the first state where a pattern ends
+	out        [][]int32  // patterns ending at the state of each row, by row/columns
+	columns    int32
+	patterns   []levelPattern
+}
+
+// levelPattern is one level pattern as the automaton reports it
+type levelPattern struct {
+	length   int
+	severity int  // index of its level in Levels.bySeverity: 0 is most severe
+	anywhere bool // bracketed: matches anywhere, not only on word boundaries
+}
+
+// wordByte marks the bytes a bare pattern may not touch on either side:
+// letters, digits and '_' (bytes above 0x7f taken as Latin-1, as before)
+var wordByte = func() (t [256]bool) {
+	for b := range t {
+		r := rune(b)
+		t[b] = unicode.IsLetter(r) || unicode.IsDigit(r) || r == '_'
+	}
+	return t
+}()
+
+// newLevelMatcher compiles the patterns of levels, given most severe first
+func newLevelMatcher(levels []*LevelDef) *levelMatcher {
+	m := &levelMatcher{out: make([][]int32, 1)}
+
+	// The trie, with -1 for missing edges; next[state][b] is the state after
+	// reading b
+	newState := func() [256]int32 {
+		var row [256]int32
+		for b := range row {
+			row[b] = -1
+		}
+		return row
+	}
+	next := [][256]int32{newState()}
+	var used [256]bool // bytes in some pattern
+	for severity, def := range levels {
+		for _, p := range def.patterns {
+			if p == "" {
+				continue
+			}
+			state := int32(0)
+			for i := 0; i < len(p); i++ {
+				used[p[i]] = true
+				if next[state][p[i]] < 0 {
+					next = append(next, newState())
+					m.out = append(m.out, nil)
+					next[state][p[i]] = int32(len(next) - 1)
+				}
+				state = next[state][p[i]]
+			}
+			m.out[state] = append(m.out[state], int32(len(m.patterns)))
+			m.patterns = append(m.patterns, levelPattern{
+				length:   len(p),
+				severity: severity,
+				anywhere: p[0] == '[' && p[len(p)-1] == ']',
+			})
+		}
+	}
+
+	// Breadth first, fill in missing edges from each state's failure state
+	// (the longest proper suffix that is also in the trie) and inherit its
+	// outputs
+	fail := make([]int32, len(next))
+	queue := make([]int32, 0, len(next))
+	for b := range next[0] {
+		if s := next[0][b]; s < 0 {
+			next[0][b] = 0
+		} else {
+			queue = append(queue, s)
+		}
+	}
+	for len(queue) > 0 {
+		state := queue[0]
+		queue = queue[1:]
+		m.out[state] = append(m.out[state], m.out[fail[state]]...)
+		for b := range next[state] {
+			s := next[state][b]
+			if s < 0 {
+				next[state][b] = next[fail[state]][b]
+				continue
+			}
+			fail[s] = next[fail[state]][b]
+			queue = append(queue, s)
+		}
+	}
+
+	// One column per byte used in a pattern, and column 0 for the rest: a
+	// byte in no pattern leads back to the root from every state
+	rep := []int{-1} // the byte of each column
+	for b := range used {
+		if used[b] {
+			m.class[b] = int32(len(rep))
+			rep = append(rep, b)
+		}
+	}
+	m.columns = int32(len(rep))
+
+	// Renumber the states, those with no pattern ending first, and store
+	// each transition as the row of its state in the table
+	order := make([]int32, 0, len(next)) // states in their new order
+	for _, final := range []bool{false, true} {
+		for state := range next {
+			if (len(m.out[state]) > 0) == final {
+				if final && m.firstFinal == 0 {
+					m.firstFinal = int32(len(order)) * m.columns
+				}
+				order = append(order, int32(state))
+			}
+		}
+	}
+	if m.firstFinal == 0 {
+		m.firstFinal = int32(len(order)) * m.columns // no patterns at all
+	}
+	row := make([]int32, len(next)) // row of each old state
+	for i, state := range order {
+		row[state] = int32(i) * m.columns
+	}
+	out := make([][]int32, len(next))
+	m.delta = make([]int32, len(next)*len(rep))
+	for i, state := range order {
+		for c, b := range rep {
+			if b >= 0 {
+				m.delta[i*len(rep)+c] = row[next[state][b]]
+			}
+		}
+		out[i] = m.out[state]
+	}
+	m.out = out
+	return m
+}
+
+// severity returns the severity index of the most severe level with a
+// pattern in prefix, or -1 if none has. Bare patterns only count on word
+// boundaries (the ends of prefix are boundaries).
+func (m *levelMatcher) severity(prefix []byte) int {
+	best := -1
+	row := int32(0)
+	for i, b := range prefix {
+		row = m.delta[row+m.class[b]]
+		if row < m.firstFinal {
+			continue
+		}
+		for _, id := range m.out[row/m.columns] {
+			p := &m.patterns[id]
+			if best >= 0 && p.severity >= best {
+				continue
+			}
+			if !p.anywhere {
+				start := i + 1 - p.length
+				if (start > 0 && wordByte[prefix[start-1]]) || (i+1 < len(prefix) && wordByte[prefix[i+1]]) {
+					continue
+				}
+			}
+			best = p.severity
+			if best == 0 {
+				return 0
+			}
+		}
+	}
+	return best
+}
@@ -0,0 +1,274 @@
+package logformat
+
+import (
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+
+	"github.com/TimelordUK/mless/internal/config"
+)
+
+// maxLevels bounds how many levels there can be: the index keeps a line's
+// level in a byte
+const maxLevels = 256
+
+// LevelDef describes a log level: how it's shown, how severe it is, and how
+// lines are recognised as it
+type LevelDef struct {
+	Level  LogLevel
+	Name   string // shown in full, e.g. "WARN"
+	Short  string // three-letter label, e.g. "WRN"
+	Rank   int    // severity: higher is more severe (trace 10 ... fatal 60)
+	Color  string // terminal colour ("" = default)
+	Number int    // lowest numeric level value that is this level (bunyan's 40); 0 = none
+
+	patterns []string         // words, bracketed or on word boundaries
+	regexes  []*regexp.Regexp // rules on the start of the line, e.g. ^I\d{4}
+	values   []string         // level field values, lower case
+}
+
+// Levels is the set of levels lines are classified into: the built-in six,
+// with their patterns and colours from config, plus any [[levels]]
+type Levels struct {
+	defs       []*LevelDef // by Level; defs[0] is LevelUnknown
+	bySeverity []*LevelDef // known levels, most severe first
+	byNumber   []*LevelDef // levels with a Number, highest first
+	values     map[string]LogLevel
+	matcher    *levelMatcher // every level's patterns, by severity
+	regexed    []int         // bySeverity indexes of the levels with regexes
+}
+
+// builtinLevels gives the built-in levels' names, ranks, numbers (as pino
+// and bunyan number them) and the level field values structured loggers
+// write for them
+var builtinLevels = []struct {
+	name, short string
+	rank        int
+	values      []string
+}{
+	LevelTrace: {"TRACE", "TRC", 10, []string{"trace", "trc", "verbose", "finest"}},
+	LevelDebug: {"DEBUG", "DBG", 20, []string{"debug", "dbg", "fine", "finer"}},
+	LevelInfo:  {"INFO", "INF", 30, []string{"info", "inf", "information", "notice"}},
+	LevelWarn:  {"WARN", "WRN", 40, []string{"warn", "wrn", "warning"}},
+	LevelError: {"ERROR", "ERR", 50, []string{"error", "err", "severe"}},
+	LevelFatal: {"FATAL", "FTL", 60, []string{"fatal", "ftl", "critical", "crit", "panic", "dpanic", "alert", "emerg", "emergency"}},
+}
+
+// NewLevels builds the levels config defines. A [[levels]] entry named after
+// a built-in level changes it; others add levels, in order. Invalid regexes
+// are skipped (config.Load reports them).
+func NewLevels(cfg *config.Config) *Levels {
+	l := &Levels{values: make(map[string]LogLevel)}
+	l.defs = append(l.defs, &LevelDef{Level: LevelUnknown})
+
+	patterns := [][]string{
+		LevelTrace: cfg.LogLevels.TracePatterns,
+		LevelDebug: cfg.LogLevels.DebugPatterns,
+		LevelInfo:  cfg.LogLevels.InfoPatterns,
+		LevelWarn:  cfg.LogLevels.WarnPatterns,
+		LevelError: cfg.LogLevels.ErrorPatterns,
+		LevelFatal: cfg.LogLevels.FatalPatterns,
+	}
+	colors := []string{
+		LevelTrace: cfg.Theme.Levels.Trace,
+		LevelDebug: cfg.Theme.Levels.Debug,
+		LevelInfo:  cfg.Theme.Levels.Info,
+		LevelWarn:  cfg.Theme.Levels.Warn,
+		LevelError: cfg.Theme.Levels.Error,
+		LevelFatal: cfg.Theme.Levels.Fatal,
+	}
+	for level := LevelTrace; level <= LevelFatal; level++ {
+		b := builtinLevels[level]
+		l.defs = append(l.defs, &LevelDef{
+			Level:    level,
+			Name:     b.name,
+			Short:    b.short,
+			Rank:     b.rank,
+			Color:    colors[level],
+			Number:   b.rank,
+			patterns: patterns[level],
+			values:   b.values,
+		})
+	}
+
+	for i := range cfg.Levels {
+		lc := &cfg.Levels[i]
+		def := l.byName(lc.Name)
+		if def == nil {
+			if len(l.defs) >= maxLevels {
+				break
+			}
+			def = &LevelDef{
+				Level: LogLevel(len(l.defs)),
+				Name:  strings.ToUpper(lc.Name),
+				Short: strings.ToUpper(lc.Name),
+			}
+			if len(def.Short) > 3 {
+				def.Short = def.Short[:3]
+			}
+			def.values = []string{strings.ToLower(lc.Name)}
+			l.defs = append(l.defs, def)
+		}
+		if lc.Short != "" {
+			def.Short = lc.Short
+		}
+		if lc.Rank != 0 {
+			def.Rank = lc.Rank
+		}
+		if lc.Color != "" {
+			def.Color = lc.Color
+		}
+		if lc.Number != 0 {
+			def.Number = lc.Number
+		}
+		def.patterns = append(def.patterns[:len(def.patterns):len(def.patterns)], lc.Patterns...)
+		for _, expr := range lc.Regexes {
+			if re, err := regexp.Compile(expr); err == nil {
+				def.regexes = append(def.regexes, re)
+			}
+		}
+		for _, v := range lc.Values {
+			def.values = append(def.values[:len(def.values):len(def.values)], strings.ToLower(v))
+		}
+	}
+
+	// Later definitions win a level field value
+	for _, def := range l.defs[1:] {
+		for _, v := range def.values {
+			l.values[v] = def.Level
+		}
+	}
+	l.bySeverity = append([]*LevelDef(nil), l.defs[1:]...)
+	sort.SliceStable(l.bySeverity, func(i, j int) bool {
+		return l.bySeverity[i].Rank > l.bySeverity[j].Rank
+	})
+	l.matcher = newLevelMatcher(l.bySeverity)
+	for i, def := range l.bySeverity {
+		if len(def.regexes) > 0 {
+			l.regexed = append(l.regexed, i)
+		}
+	}
+	for _, def := range l.defs[1:] {
+		if def.Number > 0 {
+			l.byNumber = append(l.byNumber, def)
+		}
+	}
+	sort.SliceStable(l.byNumber, func(i, j int) bool {
+		return l.byNumber[i].Number > l.byNumber[j].Number
+	})
+	return l
+}
+
+// byName returns the level called name, ignoring case (nil if none)
+func (l *Levels) byName(name string) *LevelDef {
+	for _, def := range l.defs[1:] {
+		if strings.EqualFold(def.Name, name) {
+			return def
+		}
+	}
+	return nil
+}
+
+// Def returns the definition of level (that of LevelUnknown if there is no
+// such level)
+func (l *Levels) Def(level LogLevel) *LevelDef {
+	if level <= LevelUnknown || int(level) >= len(l.defs) {
+		return l.defs[0]
+	}
+	return l.defs[level]
+}
+
+// All returns every level but LevelUnknown, in Level order
+func (l *Levels) All() []*LevelDef {
+	return l.defs[1:]
+}
+
+// BySeverity returns every level but LevelUnknown, most severe first
+func (l *Levels) BySeverity() []*LevelDef {
+	return l.bySeverity
+}
+
+// Lookup returns the level called name, ignoring case. ok is false if there
+// is none.
+func (l *Levels) Lookup(name string) (level LogLevel, ok bool) {
+	if def := l.byName(name); def != nil {
+		return def.Level, true
+	}
+	return LevelUnknown, false
+}
+
+// FromName maps a level field value to a level: one of the values a level
+// lists, or a number, which is the level with the highest Number not above
+// it
+func (l *Levels) FromName(name string) LogLevel {
+	name = strings.ToLower(strings.TrimSpace(name))
+	if level, ok := l.values[name]; ok {
+		return level
+	}
+	if n, err := strconv.Atoi(name); err == nil {
+		for _, def := range l.byNumber {
+			if n >= def.Number {
+				return def.Level
+			}
+		}
+	}
+	return LevelUnknown
+}
+
+// Detect returns the most severe level that prefix, the start of a line,
+// shows: a level with one of its patterns in it, or a regex matching it
+func (l *Levels) Detect(prefix []byte) LogLevel {
+	best := l.matcher.severity(prefix)
+	for _, i := range l.regexed {
+		if best >= 0 && i >= best {
+			break
+		}
+		for _, re := range l.bySeverity[i].regexes {
+			if re.Match(prefix) {
+				return l.bySeverity[i].Level
+			}
+		}
+	}
+	if best < 0 {
+		return LevelUnknown
+	}
+	return l.bySeverity[best].Level
+}
+
+// registered holds the levels in use; see SetLevels
+var registered atomic.Pointer[Levels]
+
+func init() {
+	registered.Store(NewLevels(config.DefaultConfig()))
+}
+
+// SetLevels makes levels the ones lines are classified into and filtered,
+// named and coloured by. There is one set per process, as a LogLevel must
+// mean the same to the index, filters and renderers; until SetLevels is
+// called it is the built-in six with the default patterns.
+func SetLevels(levels *Levels) {
+	registered.Store(levels)
+}
+
+// Registered returns the levels in use
+func Registered() *Levels {
+	return registered.Load()
+}
+
+// Name returns the level's name, e.g. "WARN" ("" for LevelUnknown)
+func (level LogLevel) Name() string {
+	return Registered().Def(level).Name
+}
+
+// Short returns the level's three-letter label, e.g. "WRN"
+func (level LogLevel) Short() string {
+	return Registered().Def(level).Short
+}
+
+// Rank returns how severe the level is: higher is more severe, 0 for
+// LevelUnknown
+func (level LogLevel) Rank() int {
+	return Registered().Def(level).Rank
+}
@@ -0,0 +1,209 @@
+package logformat
+
+import (
+	"bytes"
+	"strconv"
+	"time"
+
+	"github.com/TimelordUK/mless/internal/config"
+)
+
+// LogfmtFormat reads logfmt logs: key=value pairs separated by spaces, values
+// quoted when they contain spaces (ts=... level=warn msg="disk full" user=42),
+// with the level, time, message and logger in configurable fields
+type LogfmtFormat struct {
+	levelKeys   []string
+	timeKeys    []string
+	messageKeys []string
+	loggerKeys  []string
+	times       *TimestampParser // time values that aren't RFC 3339
+}
+
+// FieldRole says which part of a structured log line a field holds
+type FieldRole int
+
+const (
+	RoleOther FieldRole = iota
+	RoleLevel
+	RoleTime
+	RoleMessage
+	RoleLogger
+)
+
+// LogfmtPair is one key=value pair of a logfmt line, as written
+type LogfmtPair struct {
+	Key   string
+	Raw   string // the value as written, quotes included
+	Role  FieldRole
+	Start int // offset of the key in the line
+	End   int // offset just past the value
+}
+
+// NewLogfmtFormat creates a logfmt reader with the configured key names
+func NewLogfmtFormat(cfg *config.LogfmtConfig) *LogfmtFormat {
+	return &LogfmtFormat{
+		levelKeys:   cfg.LevelKeys,
+		timeKeys:    cfg.TimeKeys,
+		messageKeys: cfg.MessageKeys,
+		loggerKeys:  cfg.LoggerKeys,
+		times:       NewTimestampParser(),
+	}
+}
+
+// IsLogfmt reports whether content is a logfmt line: at least two key=value
+// pairs and nothing else
+func IsLogfmt(content []byte) bool {
+	return eachPair(content, func(key, raw []byte) {})
+}
+
+// Parse parses a logfmt log line. ok is false if content isn't logfmt.
+func (f *LogfmtFormat) Parse(content []byte) (rec *Record, ok bool) {
+	rec = &Record{}
+	level, ts, msg, logger := -1, -1, -1, -1
+	ok = eachPair(content, func(k, raw []byte) {
+		key := string(k)
+		switch {
+		case better(f.levelKeys, key, &level):
+			rec.Level = LevelFromName(logfmtValue(raw))
+		case better(f.timeKeys, key, &ts):
+			rec.Time = timeValue(f.times, logfmtValue(raw))
+		case better(f.messageKeys, key, &msg):
+			rec.Message = logfmtValue(raw)
+		case better(f.loggerKeys, key, &logger):
+			rec.Logger = logfmtValue(raw)
+		default:
+			rec.Fields = append(rec.Fields, Field{Key: key, Value: logfmtValue(raw)})
+		}
+	})
+	if !ok {
+		return nil, false
+	}
+	return rec, true
+}
+
+// Pairs splits a logfmt line into its pairs, marking the ones that hold the
+// level, time, message and logger, and returns the line's level. ok is false
+// if content isn't logfmt.
+func (f *LogfmtFormat) Pairs(content []byte) (pairs []LogfmtPair, level LogLevel, ok bool) {
+	best := map[FieldRole]int{RoleLevel: -1, RoleTime: -1, RoleMessage: -1, RoleLogger: -1}
+	keys := map[FieldRole][]string{
+		RoleLevel:   f.levelKeys,
+		RoleTime:    f.timeKeys,
+		RoleMessage: f.messageKeys,
+		RoleLogger:  f.loggerKeys,
+	}
+	owner := map[FieldRole]int{} // role -> index of the pair holding it
+	ok = scanPairs(content, func(start, eq, end int) {
+		key := string(content[start:eq])
+		for role := RoleLevel; role <= RoleLogger; role++ {
+			b := best[role]
+			if better(keys[role], key, &b) {
+				best[role] = b
+				owner[role] = len(pairs)
+				break
+			}
+		}
+		pairs = append(pairs, LogfmtPair{Key: key, Raw: string(content[eq+1 : end]), Start: start, End: end})
+	})
+	if !ok {
+		return nil, LevelUnknown, false
+	}
+	for role, i := range owner {
+		pairs[i].Role = role
+	}
+	if i, found := owner[RoleLevel]; found {
+		level = LevelFromName(logfmtValue([]byte(pairs[i].Raw)))
+	}
+	return pairs, level, true
+}
+
+// Level returns the level of a logfmt line (LevelUnknown if it has no level
+// field). ok is false if content isn't logfmt.
+func (f *LogfmtFormat) Level(content []byte) (level LogLevel, ok bool) {
+	best := -1
+	ok = eachPair(content, func(key, raw []byte) {
+		if better(f.levelKeys, string(key), &best) {
+			level = LevelFromName(logfmtValue(raw))
+		}
+	})
+	return level, ok
+}
+
+// Time returns the timestamp of a logfmt line (nil if it has no time field).
+// ok is false if content isn't logfmt.
+func (f *LogfmtFormat) Time(content []byte) (ts *time.Time, ok bool) {
+	best := -1
+	ok = eachPair(content, func(key, raw []byte) {
+		if better(f.timeKeys, string(key), &best) {
+			ts = timeValue(f.times, logfmtValue(raw))
+		}
+	})
+	if !ok {
+		return nil, false
+	}
+	return ts, true
+}
+
+// logfmtValue returns a raw logfmt value as text, unquoted
+func logfmtValue(raw []byte) string {
+	if len(raw) < 2 || raw[0] != '"' {
+		return string(raw)
+	}
+	if bytes.IndexByte(raw, '\\') < 0 {
+		return string(raw[1 : len(raw)-1])
+	}
+	if s, err := strconv.Unquote(string(raw)); err == nil {
+		return s
+	}
+	return jsonString(raw)
+}
+
+// eachPair calls fn with the key and raw value of each pair of a logfmt line,
+// in order, and reports whether the line is logfmt. fn may already have been
+// called when a line turns out not to be, so callers must check the result.
+func eachPair(content []byte, fn func(key, raw []byte)) bool {
+	return scanPairs(content, func(start, eq, end int) {
+		fn(content[start:eq], content[eq+1:end])
+	})
+}
+
+// scanPairs finds the key=value pairs of a logfmt line, calling fn with the
+// offsets of each key, its '=' and the end of its value. Keys may not contain
+// spaces, quotes or '='; values are a quoted string or run to the next space.
+// A line cut short inside a quoted value still counts if two pairs came
+// before the cut.
+func scanPairs(content []byte, fn func(start, eq, end int)) bool {
+	pairs := 0
+	i := 0
+	for {
+		i = skipSpace(content, i)
+		if i >= len(content) {
+			return pairs >= 2
+		}
+		start := i
+		for i < len(content) && content[i] > ' ' && content[i] != '=' && content[i] != '"' {
+			i++
+		}
+		if i == start || i >= len(content) || content[i] != '=' {
+			return false
+		}
+		eq := i
+		i++
+		if i < len(content) && content[i] == '"' {
+			end, ok := scanString(content, i)
+			if !ok {
+				return pairs >= 2
+			}
+			i = end
+		} else {
+			for i < len(content) && content[i] > ' ' && content[i] != '"' {
+				i++
+			}
+		}
+		if i < len(content) && content[i] > ' ' {
+			return false
+		}
+		fn(start, eq, i)
+		pairs++
+	}
+}
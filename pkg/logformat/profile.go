@@ -0,0 +1,165 @@
+package logformat
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"time"
+
+	"github.com/TimelordUK/mless/internal/config"
+)
+
+// Profile is a user-defined log format from a [[formats]] table: a regex
+// whose named groups (timestamp, level, logger, thread, message) pick out the
+// parts of a line, and the Go layout of its timestamp
+type Profile struct {
+	Name   string
+	regex  *regexp.Regexp
+	layout string
+	levels map[string]LogLevel // level group values with a configured level
+	dated  bool                // layout includes the month and day
+	yeared bool                // layout includes the year
+	times  *TimestampParser    // timestamps when no layout is given
+}
+
+// NewProfile compiles a format profile
+func NewProfile(cfg *config.FormatConfig) (*Profile, error) {
+	re, err := regexp.Compile(cfg.Pattern)
+	if err != nil {
+		return nil, fmt.Errorf("format %q: %w", cfg.Name, err)
+	}
+	p := &Profile{
+		Name:   cfg.Name,
+		regex:  re,
+		layout: cfg.TimeLayout,
+		levels: make(map[string]LogLevel),
+		times:  NewTimestampParser(),
+	}
+	for value, name := range cfg.Levels {
+		p.levels[value] = LevelFromName(name)
+	}
+	if p.layout != "" {
+		// A layout shows whether it has a date by formatting two that differ
+		jan1 := time.Date(2001, 1, 1, 0, 0, 0, 0, time.UTC)
+		p.dated = jan1.Format(p.layout) != time.Date(2001, 2, 3, 0, 0, 0, 0, time.UTC).Format(p.layout)
+		p.yeared = jan1.Format(p.layout) != time.Date(2002, 1, 1, 0, 0, 0, 0, time.UTC).Format(p.layout)
+	}
+	return p, nil
+}
+
+// NewProfiles compiles every configured profile, in order
+func NewProfiles(cfgs []config.FormatConfig) ([]*Profile, error) {
+	profiles := make([]*Profile, 0, len(cfgs))
+	for i := range cfgs {
+		p, err := NewProfile(&cfgs[i])
+		if err != nil {
+			return nil, err
+		}
+		profiles = append(profiles, p)
+	}
+	return profiles, nil
+}
+
+// DetectProfile returns the profile that matches the most of lines (ties go
+// to the one listed first), or nil if none matches at least a quarter of the
+// non-blank ones. A match only counts if its timestamp, when it has one,
+// parses.
+func DetectProfile(profiles []*Profile, lines [][]byte) *Profile {
+	nonBlank := 0
+	for _, line := range lines {
+		if len(bytes.TrimSpace(line)) > 0 {
+			nonBlank++
+		}
+	}
+
+	var best *Profile
+	bestCount := 0
+	for _, p := range profiles {
+		count := 0
+		for _, line := range lines {
+			if _, ok := p.match(line); ok {
+				count++
+			}
+		}
+		if count > bestCount {
+			best, bestCount = p, count
+		}
+	}
+	if bestCount == 0 || bestCount*4 < nonBlank {
+		return nil
+	}
+	return best
+}
+
+// Field returns the named group of a line matching the profile. ok is false
+// if the line doesn't match or the group didn't take part in the match.
+func (p *Profile) Field(content []byte, group string) (value string, ok bool) {
+	m := p.regex.FindSubmatchIndex(content)
+	i := p.regex.SubexpIndex(group)
+	if m == nil || i < 0 || m[2*i] < 0 {
+		return "", false
+	}
+	return string(content[m[2*i]:m[2*i+1]]), true
+}
+
+// Level returns the level of a line matching the profile, from its level
+// group: a value listed in the profile's levels, or a level name. ok is false
+// if the line doesn't match or has no level group.
+func (p *Profile) Level(content []byte) (level LogLevel, ok bool) {
+	value, ok := p.Field(content, "level")
+	if !ok {
+		return LevelUnknown, false
+	}
+	if level, found := p.levels[value]; found {
+		return level, true
+	}
+	return LevelFromName(value), true
+}
+
+// Time returns the timestamp of a line matching the profile, and which part
+// of its date the layout lacks (left for the caller to fill in). ok is false
+// if the line doesn't match or has no timestamp group; ts is nil if the
+// group doesn't parse.
+func (p *Profile) Time(content []byte) (ts *time.Time, inferred Inferred, ok bool) {
+	value, ok := p.Field(content, "timestamp")
+	if !ok {
+		return nil, InferredNone, false
+	}
+	ts, inferred = p.parseTime(value)
+	return ts, inferred, true
+}
+
+// match reports whether content matches the profile, with a timestamp that
+// parses if the profile has one
+func (p *Profile) match(content []byte) (ts *time.Time, ok bool) {
+	if !p.regex.Match(content) {
+		return nil, false
+	}
+	ts, _, hasTime := p.Time(content)
+	return ts, !hasTime || ts != nil
+}
+
+// parseTime parses a timestamp group with the profile's layout, saying
+// whether the layout lacks the year or the whole date
+func (p *Profile) parseTime(s string) (*time.Time, Inferred) {
+	if p.layout == "" {
+		if t, err := time.Parse(time.RFC3339Nano, s); err == nil {
+			return &t, InferredNone
+		}
+		if ts, inferred := p.times.ParseInferred([]byte(s)); ts != nil {
+			return ts, inferred
+		}
+		return timeValue(p.times, s), InferredNone
+	}
+	t, err := time.Parse(p.layout, s)
+	if err != nil {
+		return nil, InferredNone
+	}
+	switch {
+	case !p.dated:
+		return &t, InferredDate
+	case !p.yeared:
+		return &t, InferredYear
+	}
+	return &t, InferredNone
+}
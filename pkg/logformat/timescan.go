@@ -0,0 +1,210 @@
+package logformat
+
+import (
+	"bytes"
+	"regexp"
+	"sync"
+	"time"
+)
+
+// zones holds the fixed zones seen in timestamps, by offset in seconds, so
+// parsing a line doesn't allocate one
+var zones sync.Map
+
+// timestampFormat finds and parses a timestamp in a format the parser knows
+// in line, saying which part of the date the line lacks
+type timestampFormat func(line []byte) (t time.Time, inferred Inferred, ok bool)
+
+// timestampFormats returns the built-in formats in the order they're tried:
+// hand-written scanners for the common ones, then regexes
+func timestampFormats() []timestampFormat {
+	return []timestampFormat{
+		// ISO 8601 / RFC 3339 and the common log layouts, anywhere in the line
+		// 2024-01-15T10:30:45.123Z, 2024-01-15T10:30:45.123456789+01:00
+		// 2024-01-15 10:30:45.123, 2024-01-15 10:30:45,123 (log4j)
+		// [2024-01-15 10:30:45]
+		scanISO,
+		// Syslog format
+		// Jan 15 10:30:45, Jan  5 10:30:45
+		regexFormat(`[A-Z][a-z]{2} {1,2}\d{1,2} \d{2}:\d{2}:\d{2}`, "Jan _2 15:04:05", InferredYear),
+		// Apache/nginx common log format
+		// 15/Jan/2024:10:30:45 +0000
+		regexFormat(`\d{2}/[A-Z][a-z]{2}/\d{4}:\d{2}:\d{2}:\d{2} [+-]\d{4}`, "02/Jan/2006:15:04:05 -0700", InferredNone),
+		// Unix time at the start of the line: seconds, possibly fractional,
+		// or milliseconds
+		// 1705315845, 1705315845.123456, 1705315845123
+		scanEpoch,
+		// Time only at the start of the line (dated from the base date)
+		// 10:30:45, 10:30:45.123, 10:30:45,123456
+		scanTimeOfDay,
+	}
+}
+
+// regexFormat is a format found by a regex and parsed with a layout. Lines
+// without a ':' are skipped without running the regex, as every such format
+// has a time of day.
+func regexFormat(pattern, layout string, inferred Inferred) timestampFormat {
+	re := regexp.MustCompile(pattern)
+	return func(line []byte) (time.Time, Inferred, bool) {
+		if bytes.IndexByte(line, ':') < 0 {
+			return time.Time{}, InferredNone, false
+		}
+		m := re.Find(line)
+		if m == nil {
+			return time.Time{}, InferredNone, false
+		}
+		t, err := time.Parse(layout, string(m))
+		return t, inferred, err == nil
+	}
+}
+
+// scanISO finds the first yyyy-mm-dd date followed by 'T' or a space and an
+// hh:mm:ss time, with an optional fraction after '.' or ',' and an optional
+// zone (Z, ±hh:mm or ±hhmm; UTC if none)
+func scanISO(line []byte) (time.Time, Inferred, bool) {
+	for i := 0; i+19 <= len(line); i++ {
+		b := line[i:]
+		if b[4] != '-' || b[7] != '-' || (b[10] != 'T' && b[10] != ' ') || b[13] != ':' || b[16] != ':' {
+			continue
+		}
+		year, ok1 := atoi(b[0:4])
+		month, ok2 := atoi(b[5:7])
+		day, ok3 := atoi(b[8:10])
+		if !ok1 || !ok2 || !ok3 {
+			continue
+		}
+		clock, n, ok := scanClock(b[11:])
+		if !ok || month < 1 || month > 12 || day < 1 || day > daysIn(time.Month(month), year) {
+			continue
+		}
+		loc, _ := scanZone(b[11+n:])
+		return time.Date(year, time.Month(month), day, 0, 0, 0, 0, loc).Add(clock), InferredNone, true
+	}
+	return time.Time{}, InferredNone, false
+}
+
+// scanTimeOfDay parses an hh:mm:ss time with an optional fraction at the
+// start of line
+func scanTimeOfDay(line []byte) (time.Time, Inferred, bool) {
+	clock, _, ok := scanClock(line)
+	if !ok {
+		return time.Time{}, InferredNone, false
+	}
+	return time.Date(0, 1, 1, 0, 0, 0, 0, time.UTC).Add(clock), InferredDate, true
+}
+
+// scanEpoch parses a unix time at the start of line: 10 digits of seconds,
+// possibly with a fraction, or 13 of milliseconds, not followed by a digit
+func scanEpoch(line []byte) (time.Time, Inferred, bool) {
+	n := 0
+	for n < len(line) && isDigit(line[n]) {
+		n++
+	}
+	switch n {
+	case 10:
+		secs, _ := atoi(line[:n])
+		nanos := 0
+		if n+1 < len(line) && line[n] == '.' && isDigit(line[n+1]) {
+			nanos, _ = scanFraction(line[n+1:])
+		}
+		return time.Unix(int64(secs), int64(nanos)), InferredNone, true
+	case 13:
+		ms, _ := atoi(line[:n])
+		return time.UnixMilli(int64(ms)), InferredNone, true
+	}
+	return time.Time{}, InferredNone, false
+}
+
+// scanClock parses hh:mm:ss with an optional fraction after '.' or ',' at
+// the start of b, returning it as time since midnight and its length
+func scanClock(b []byte) (clock time.Duration, n int, ok bool) {
+	if len(b) < 8 || b[2] != ':' || b[5] != ':' {
+		return 0, 0, false
+	}
+	hour, ok1 := atoi(b[0:2])
+	minute, ok2 := atoi(b[3:5])
+	sec, ok3 := atoi(b[6:8])
+	if !ok1 || !ok2 || !ok3 || hour > 23 || minute > 59 || sec > 59 {
+		return 0, 0, false
+	}
+	n = 8
+	nanos := 0
+	if len(b) > 9 && (b[8] == '.' || b[8] == ',') && isDigit(b[9]) {
+		var digits int
+		nanos, digits = scanFraction(b[9:])
+		n += 1 + digits
+	}
+	clock = time.Duration(hour)*time.Hour + time.Duration(minute)*time.Minute +
+		time.Duration(sec)*time.Second + time.Duration(nanos)
+	return clock, n, true
+}
+
+// scanFraction reads the digits of a fraction of a second as nanoseconds
+// (digits past the ninth are skipped), returning how many digits it took
+func scanFraction(b []byte) (nanos, n int) {
+	scale := int(time.Second)
+	for n < len(b) && isDigit(b[n]) {
+		if scale > 1 {
+			scale /= 10
+			nanos += int(b[n]-'0') * scale
+		}
+		n++
+	}
+	return nanos, n
+}
+
+// scanZone reads a zone at the start of b: Z, ±hh:mm or ±hhmm. Anything else
+// is UTC, ok false.
+func scanZone(b []byte) (loc *time.Location, ok bool) {
+	if len(b) > 0 && b[0] == 'Z' {
+		return time.UTC, true
+	}
+	if len(b) < 5 || (b[0] != '+' && b[0] != '-') {
+		return time.UTC, false
+	}
+	hh, ok1 := atoi(b[1:3])
+	mmAt := 3
+	if b[3] == ':' {
+		mmAt = 4
+	}
+	if len(b) < mmAt+2 {
+		return time.UTC, false
+	}
+	mm, ok2 := atoi(b[mmAt : mmAt+2])
+	if !ok1 || !ok2 || hh > 23 || mm > 59 {
+		return time.UTC, false
+	}
+	offset := hh*3600 + mm*60
+	if b[0] == '-' {
+		offset = -offset
+	}
+	if offset == 0 {
+		return time.UTC, true
+	}
+	if z, found := zones.Load(offset); found {
+		return z.(*time.Location), true
+	}
+	z, _ := zones.LoadOrStore(offset, time.FixedZone("", offset))
+	return z.(*time.Location), true
+}
+
+// atoi parses b, which must be all digits
+func atoi(b []byte) (int, bool) {
+	n := 0
+	for _, c := range b {
+		if !isDigit(c) {
+			return 0, false
+		}
+		n = n*10 + int(c-'0')
+	}
+	return n, len(b) > 0
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+// daysIn returns the number of days in month of year
+func daysIn(month time.Month, year int) int {
+	return time.Date(year, month+1, 0, 0, 0, 0, 0, time.UTC).Day()
+}
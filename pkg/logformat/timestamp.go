@@ -1,157 +1,195 @@
 package logformat
 
 import (
+	"path/filepath"
 	"regexp"
+	"sync/atomic"
 	"time"
 )
 
 // TimestampParser detects and parses timestamps from log lines
 type TimestampParser struct {
-	patterns []timestampPattern
+	formats []timestampFormat
+	pinned  atomic.Int32  // index of the format the last timestamp found was in, tried first
+	json    *JSONFormat   // if set, JSON lines are dated by their time field
+	logfmt  *LogfmtFormat // likewise logfmt lines
+	profile *Profile      // if set, lines it matches are dated by its timestamp group
+	base    time.Time     // date for timestamps without one (zero = today)
 }
 
-type timestampPattern struct {
-	regex  *regexp.Regexp
-	layout string
-}
+// Inferred says which part of a timestamp's date the parser filled in from
+// its base date because the line didn't have it
+type Inferred int
+
+const (
+	InferredNone Inferred = iota // the line had a full date
+	InferredYear                 // the line had no year (syslog's "Jan 15 10:30:45")
+	InferredDate                 // the line had only a time of day
+)
 
 // NewTimestampParser creates a parser with common timestamp formats
 func NewTimestampParser() *TimestampParser {
-	return &TimestampParser{
-		patterns: []timestampPattern{
-			// ISO 8601 / RFC 3339 variants
-			// 2024-01-15T10:30:45.123Z
-			// 2024-01-15T10:30:45.123+00:00
-			{
-				regex:  regexp.MustCompile(`(\d{4}-\d{2}-\d{2}T\d{2}:\d{2}:\d{2}(?:\.\d{3})?(?:Z|[+-]\d{2}:\d{2})?)`),
-				layout: time.RFC3339,
-			},
-			// Common log format with milliseconds
-			// 2024-01-15 10:30:45.123
-			{
-				regex:  regexp.MustCompile(`(\d{4}-\d{2}-\d{2} \d{2}:\d{2}:\d{2}\.\d{3})`),
-				layout: "2006-01-02 15:04:05.000",
-			},
-			// Common log format without milliseconds
-			// 2024-01-15 10:30:45
-			{
-				regex:  regexp.MustCompile(`(\d{4}-\d{2}-\d{2} \d{2}:\d{2}:\d{2})`),
-				layout: "2006-01-02 15:04:05",
-			},
-			// Syslog format
-			// Jan 15 10:30:45
-			{
-				regex:  regexp.MustCompile(`([A-Z][a-z]{2} \d{1,2} \d{2}:\d{2}:\d{2})`),
-				layout: "Jan 2 15:04:05",
-			},
-			// Apache/nginx common log format
-			// 15/Jan/2024:10:30:45 +0000
-			{
-				regex:  regexp.MustCompile(`(\d{2}/[A-Z][a-z]{2}/\d{4}:\d{2}:\d{2}:\d{2} [+-]\d{4})`),
-				layout: "02/Jan/2006:15:04:05 -0700",
-			},
-			// Unix timestamp (seconds)
-			// 1705315845
-			{
-				regex:  regexp.MustCompile(`^(\d{10})(?:\D|$)`),
-				layout: "unix",
-			},
-			// Unix timestamp with milliseconds
-			// 1705315845123
-			{
-				regex:  regexp.MustCompile(`^(\d{13})(?:\D|$)`),
-				layout: "unix_ms",
-			},
-			// Bracket format common in many loggers
-			// [2024-01-15 10:30:45.123]
-			{
-				regex:  regexp.MustCompile(`\[(\d{4}-\d{2}-\d{2} \d{2}:\d{2}:\d{2}(?:\.\d{3})?)\]`),
-				layout: "2006-01-02 15:04:05.000",
-			},
-			// Time only (assume today)
-			// 10:30:45.123
-			{
-				regex:  regexp.MustCompile(`^(\d{2}:\d{2}:\d{2}(?:\.\d{3})?)`),
-				layout: "15:04:05.000",
-			},
-		},
+	return &TimestampParser{formats: timestampFormats()}
+}
+
+// SetJSON makes the parser read the time field of JSON log lines, rather than
+// the first thing that looks like a timestamp (or missing unix times)
+func (p *TimestampParser) SetJSON(f *JSONFormat) {
+	p.json = f
+}
+
+// SetLogfmt makes the parser read the time field of logfmt lines, which may
+// be quoted or in a form the patterns don't know
+func (p *TimestampParser) SetLogfmt(f *LogfmtFormat) {
+	p.logfmt = f
+}
+
+// SetProfile makes the parser read the timestamp group of lines that match a
+// format profile, with the profile's layout
+func (p *TimestampParser) SetProfile(profile *Profile) {
+	p.profile = profile
+}
+
+// SetBaseDate sets the date given to timestamps that have only a time of
+// day, and the year given to those without one: the date of the file's first
+// line. Later dates are worked out by the index as the clock wraps.
+func (p *TimestampParser) SetBaseDate(date time.Time) {
+	p.base = date
+}
+
+// BaseDate returns the date timestamps without one are given (today unless
+// SetBaseDate was called)
+func (p *TimestampParser) BaseDate() time.Time {
+	if p.base.IsZero() {
+		return dateOf(time.Now())
 	}
+	return dateOf(p.base)
 }
 
 // Parse attempts to extract a timestamp from a log line
 func (p *TimestampParser) Parse(content []byte) *time.Time {
-	line := string(content)
+	ts, _ := p.ParseInferred(content)
+	return ts
+}
 
-	for _, pattern := range p.patterns {
-		matches := pattern.regex.FindStringSubmatch(line)
-		if len(matches) < 2 {
-			continue
+// ParseInferred is Parse, also saying which part of the timestamp's date
+// came from the base date rather than the line
+func (p *TimestampParser) ParseInferred(content []byte) (*time.Time, Inferred) {
+	if p.profile != nil {
+		if ts, inferred, ok := p.profile.Time(content); ok && ts != nil {
+			return p.onBase(*ts, inferred), inferred
 		}
+	}
+	if p.json != nil && IsJSON(content) {
+		if ts, ok := p.json.Time(content); ok {
+			return ts, InferredNone
+		}
+	}
+	if p.logfmt != nil {
+		if ts, ok := p.logfmt.Time(content); ok {
+			return ts, InferredNone
+		}
+	}
 
-		timeStr := matches[1]
-
-		// Handle unix timestamps specially
-		if pattern.layout == "unix" {
-			var ts int64
-			if _, err := parseUnixTimestamp(timeStr, &ts); err == nil {
-				t := time.Unix(ts, 0)
-				return &t
-			}
+	// A log's lines are almost always in one format, so the one the last
+	// timestamp was found in is tried first
+	pinned := int(p.pinned.Load())
+	if t, inferred, ok := p.formats[pinned](content); ok {
+		return p.onBase(t, inferred), inferred
+	}
+	for i, scan := range p.formats {
+		if i == pinned {
 			continue
 		}
+		if t, inferred, ok := scan(content); ok {
+			p.pinned.Store(int32(i))
+			return p.onBase(t, inferred), inferred
+		}
+	}
+	return nil, InferredNone
+}
 
-		if pattern.layout == "unix_ms" {
-			var ts int64
-			if _, err := parseUnixTimestamp(timeStr, &ts); err == nil {
-				t := time.UnixMilli(ts)
-				return &t
-			}
-			continue
+// onBase completes t from the base date: its year if inferred is
+// InferredYear, its whole date if InferredDate. Such times are local.
+func (p *TimestampParser) onBase(t time.Time, inferred Inferred) *time.Time {
+	if inferred != InferredNone {
+		base := p.BaseDate()
+		year, month, day := base.Year(), t.Month(), t.Day()
+		if inferred == InferredDate {
+			month, day = base.Month(), base.Day()
 		}
+		t = time.Date(year, month, day, t.Hour(), t.Minute(), t.Second(), t.Nanosecond(), time.Local)
+	}
+	return &t
+}
 
-		// Try parsing with the pattern's layout
-		// Try with milliseconds first, then without
-		layouts := []string{pattern.layout}
-		if pattern.layout == "2006-01-02 15:04:05.000" {
-			layouts = append(layouts, "2006-01-02 15:04:05")
+// dateInText finds a yyyy-mm-dd date in a file name or a header line
+var dateInText = regexp.MustCompile(`(?:^|\D)(\d{4}-\d{2}-\d{2})(?:\D|$)`)
+
+// InferBaseDate works out the date of a file's first line, for timestamps
+// without a date or a year. In order of preference: the first date in lines
+// (the file's first lines), a date in the file's name, or the file's
+// modification time. That dates the last line, so if the first timestamp is
+// later in the day (or the year) the file is taken to start the day (or the
+// year) before. from says which was used.
+func (p *TimestampParser) InferBaseDate(lines [][]byte, name string, mtime time.Time) (base time.Time, from string) {
+	var first *time.Time // the first timestamp that needs a base date
+	var firstInferred Inferred
+	for _, line := range lines {
+		ts, inferred := p.ParseInferred(line)
+		if ts != nil && inferred == InferredNone {
+			return dateOf(*ts), "file content"
+		}
+		if d, ok := findDate(line); ok {
+			return d, "file content"
 		}
-		if pattern.layout == "15:04:05.000" {
-			layouts = append(layouts, "15:04:05")
+		if ts != nil && first == nil {
+			first, firstInferred = ts, inferred
 		}
+	}
+	if d, ok := findDate([]byte(filepath.Base(name))); ok {
+		return d, "file name"
+	}
+	if mtime.IsZero() {
+		return dateOf(time.Now()), "today"
+	}
 
-		for _, layout := range layouts {
-			t, err := time.Parse(layout, timeStr)
-			if err == nil {
-				// For time-only formats, use today's date
-				if layout == "15:04:05" || layout == "15:04:05.000" {
-					now := time.Now()
-					t = time.Date(now.Year(), now.Month(), now.Day(),
-						t.Hour(), t.Minute(), t.Second(), t.Nanosecond(), time.Local)
-				}
-				// For syslog format without year, use current year
-				if layout == "Jan 2 15:04:05" {
-					t = time.Date(time.Now().Year(), t.Month(), t.Day(),
-						t.Hour(), t.Minute(), t.Second(), 0, time.Local)
-				}
-				return &t
+	mtime = mtime.Local()
+	base = dateOf(mtime)
+	if first != nil {
+		switch firstInferred {
+		case InferredDate:
+			if timeOfDay(*first) > timeOfDay(mtime) {
+				base = base.AddDate(0, 0, -1)
+			}
+		case InferredYear:
+			if first.Month() > mtime.Month() || (first.Month() == mtime.Month() && first.Day() > mtime.Day()) {
+				base = base.AddDate(-1, 0, 0)
 			}
 		}
 	}
-
-	return nil
+	return base, "modification time"
 }
 
-// parseUnixTimestamp parses a string as a unix timestamp
-func parseUnixTimestamp(s string, result *int64) (int, error) {
-	var n int64
-	for _, c := range s {
-		if c < '0' || c > '9' {
-			break
-		}
-		n = n*10 + int64(c-'0')
+// findDate returns the first yyyy-mm-dd date in b
+func findDate(b []byte) (time.Time, bool) {
+	m := dateInText.FindSubmatch(b)
+	if m == nil {
+		return time.Time{}, false
 	}
-	*result = n
-	return 0, nil
+	d, err := time.ParseInLocation("2006-01-02", string(m[1]), time.Local)
+	return d, err == nil
+}
+
+// timeOfDay returns how far into its day t is
+func timeOfDay(t time.Time) time.Duration {
+	return t.Sub(dateOf(t))
+}
+
+// dateOf returns midnight (local time) at the start of t's day
+func dateOf(t time.Time) time.Time {
+	year, month, day := t.Date()
+	return time.Date(year, month, day, 0, 0, 0, 0, time.Local)
 }
 
 // FormatTime formats a timestamp for display